@@ -1,7 +1,8 @@
 package handlers
 
 import (
-	"fmt"
+	"math"
+	"net/http"
 	"strconv"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"gorm.io/gorm"
 
 	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
 	"jd-task-platform-go/pkg/response"
 )
 
@@ -33,54 +35,51 @@ func NewAdminDashboardHandler(db *gorm.DB) *AdminDashboardHandler {
 // @Failure 401 {object} response.Response
 // @Router /admin/dashboard/today-tasks [get]
 func (h *AdminDashboardHandler) GetTodayTaskStats(c *gin.Context) {
-	now := time.Now()
-	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-	todayEnd := todayStart.Add(24 * time.Hour)
-
 	// 获取查询参数
 	statMode := c.DefaultQuery("stat_mode", "execute") // count 或 execute
 	taskType := c.Query("task_type")
 
-	// 构建基础查询条件
-	baseQuery := func() *gorm.DB {
-		query := h.db.Model(&models.Task{}).Where("start_time >= ? AND start_time < ?", todayStart, todayEnd)
-		if taskType != "" {
-			query = query.Where("task_type = ?", taskType)
-		}
-		return query
+	data, err := services.GetStatsCache().Get("today_tasks:"+statMode+":"+taskType, services.DashboardCacheTTL, func() (interface{}, error) {
+		return h.todayTaskStats(statMode, taskType)
+	})
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "统计今日任务失败")
+		return
+	}
+
+	response.Success(c, data)
+}
+
+// todayTaskStats 在一次分组查询中统计今日任务
+func (h *AdminDashboardHandler) todayTaskStats(statMode, taskType string) (gin.H, error) {
+	now := time.Now()
+	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	todayEnd := todayStart.Add(24 * time.Hour)
+
+	query := h.db.Model(&models.Task{}).Where("start_time >= ? AND start_time < ?", todayStart, todayEnd)
+	if taskType != "" {
+		query = query.Where("task_type = ?", taskType)
+	}
+	summary, err := services.SummarizeTasksByStatus(query)
+	if err != nil {
+		return nil, err
 	}
 
 	var pendingValue, runningValue, completedValue, totalValue int64
 
 	if statMode == "count" {
 		// 按任务数量统计
-		baseQuery().Where("status = ?", "waiting").Count(&pendingValue)
-		baseQuery().Where("status = ?", "running").Count(&runningValue)
-		baseQuery().Where("status = ?", "completed").Count(&completedValue)
-		totalValue = pendingValue + runningValue + completedValue
+		pendingValue = summary["waiting"].Tasks
+		runningValue = summary["running"].Tasks
+		completedValue = summary["completed"].Tasks
 	} else {
-		// 按执行次数统计
-		type ExecuteStats struct {
-			TotalCount    int64
-			ExecutedCount int64
-		}
-		var pendingStats, runningStats, completedStats ExecuteStats
-
-		baseQuery().Where("status = ?", "waiting").
-			Select("COALESCE(SUM(execute_count), 0) as total_count, 0 as executed_count").
-			Scan(&pendingStats)
-		baseQuery().Where("status = ?", "running").
-			Select("COALESCE(SUM(execute_count), 0) as total_count, COALESCE(SUM(executed_count), 0) as executed_count").
-			Scan(&runningStats)
-		baseQuery().Where("status = ?", "completed").
-			Select("COALESCE(SUM(execute_count), 0) as total_count, COALESCE(SUM(executed_count), 0) as executed_count").
-			Scan(&completedStats)
-
-		pendingValue = pendingStats.TotalCount + (runningStats.TotalCount - runningStats.ExecutedCount)
-		runningValue = runningStats.ExecutedCount
-		completedValue = completedStats.TotalCount
-		totalValue = pendingValue + runningValue + completedValue
+		// 按执行次数统计：执行中任务的剩余次数计入待执行
+		waiting, running, completed := summary["waiting"], summary["running"], summary["completed"]
+		pendingValue = waiting.ExecuteCount + (running.ExecuteCount - running.ExecutedCount)
+		runningValue = running.ExecutedCount
+		completedValue = completed.ExecuteCount
 	}
+	totalValue = pendingValue + runningValue + completedValue
 
 	// 计算百分比
 	var pendingPercent, completedPercent, runningPercent float64
@@ -90,21 +89,17 @@ func (h *AdminDashboardHandler) GetTodayTaskStats(c *gin.Context) {
 		runningPercent = float64(runningValue) / float64(totalValue) * 100
 	}
 
-	// 任务数量统计
-	var totalTasks int64
-	baseQuery().Count(&totalTasks)
-
 	// 统计单位
 	unit := "次"
 	if statMode == "count" {
 		unit = "个"
 	}
 
-	response.Success(c, gin.H{
+	return gin.H{
 		"stat_mode":         statMode,
 		"task_type":         taskType,
 		"unit":              unit,
-		"total_tasks":       totalTasks,
+		"total_tasks":       summary.Tasks(),
 		"total_value":       totalValue,
 		"pending_value":     pendingValue,
 		"completed_value":   completedValue,
@@ -112,7 +107,7 @@ func (h *AdminDashboardHandler) GetTodayTaskStats(c *gin.Context) {
 		"pending_percent":   pendingPercent,
 		"completed_percent": completedPercent,
 		"running_percent":   runningPercent,
-	})
+	}, nil
 }
 
 // GetTaskPressure 获取任务执行压力统计
@@ -128,92 +123,80 @@ func (h *AdminDashboardHandler) GetTodayTaskStats(c *gin.Context) {
 // @Failure 401 {object} response.Response
 // @Router /admin/dashboard/task-pressure [get]
 func (h *AdminDashboardHandler) GetTaskPressure(c *gin.Context) {
-	now := time.Now()
-	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
-
 	// 获取查询参数
 	statMode := c.DefaultQuery("stat_mode", "execute")
 	taskType := c.Query("task_type")
 
-	// 构建基础查询条件
-	baseQueryWithTime := func(start, end time.Time) *gorm.DB {
-		query := h.db.Model(&models.Task{}).Where("start_time >= ? AND start_time < ?", start, end)
-		if taskType != "" {
-			query = query.Where("task_type = ?", taskType)
-		}
-		return query
+	data, err := services.GetStatsCache().Get("task_pressure:"+statMode+":"+taskType, services.DashboardCacheTTL, func() (interface{}, error) {
+		return h.taskPressure(statMode, taskType)
+	})
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "统计任务压力失败")
+		return
 	}
 
-	baseQueryFromTime := func(start time.Time) *gorm.DB {
-		query := h.db.Model(&models.Task{}).Where("start_time >= ?", start)
-		if taskType != "" {
-			query = query.Where("task_type = ?", taskType)
-		}
-		return query
-	}
+	response.Success(c, data)
+}
 
-	// 未杧7天待执行的任务（按天分组）
+// taskPressure 统计未来7天的待执行量和近期完成量
+func (h *AdminDashboardHandler) taskPressure(statMode, taskType string) (gin.H, error) {
+	now := time.Now()
+	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	// 未来7天待执行的任务（按天分组）
+	// 任务在 [开始时间, 截止时间] 内均可执行，剩余次数按时间比例分摊到各天
 	type DayCount struct {
 		Day   string `json:"day"`
 		Count int64  `json:"count"`
 	}
 	var futureTasks []DayCount
 
+	windows := pendingTaskWindows(h.db, now, taskType)
 	for i := 0; i < 7; i++ {
 		dayStart := todayStart.Add(time.Duration(i) * 24 * time.Hour)
 		dayEnd := dayStart.Add(24 * time.Hour)
-		var count int64
 
-		if statMode == "count" {
-			baseQueryWithTime(dayStart, dayEnd).Where("status IN ?", []string{"waiting", "running"}).Count(&count)
-		} else {
-			// 统计待执行次数 = waiting的execute_count + running的(execute_count - executed_count)
-			var waitingCount, runningPending int64
-			baseQueryWithTime(dayStart, dayEnd).Where("status = ?", "waiting").
-				Select("COALESCE(SUM(execute_count), 0)").Scan(&waitingCount)
-			baseQueryWithTime(dayStart, dayEnd).Where("status = ?", "running").
-				Select("COALESCE(SUM(execute_count - executed_count), 0)").Scan(&runningPending)
-			count = waitingCount + runningPending
+		var value float64
+		for _, w := range windows {
+			share := w.share(dayStart, dayEnd)
+			if share == 0 {
+				continue
+			}
+			if statMode == "count" {
+				value++
+			} else {
+				value += float64(w.pending()) * share
+			}
 		}
 		futureTasks = append(futureTasks, DayCount{
 			Day:   dayStart.Format("2006-01-02"),
-			Count: count,
+			Count: int64(math.Round(value)),
 		})
 	}
 
-	// 未来总待执行
+	// 未来总待执行（所有未过期的待执行任务）
 	var totalFuturePending int64
-	if statMode == "count" {
-		baseQueryFromTime(todayStart).Where("status IN ?", []string{"waiting", "running"}).Count(&totalFuturePending)
-	} else {
-		var waitingCount, runningPending int64
-		baseQueryFromTime(todayStart).Where("status = ?", "waiting").
-			Select("COALESCE(SUM(execute_count), 0)").Scan(&waitingCount)
-		baseQueryFromTime(todayStart).Where("status = ?", "running").
-			Select("COALESCE(SUM(execute_count - executed_count), 0)").Scan(&runningPending)
-		totalFuturePending = waitingCount + runningPending
+	for _, w := range windows {
+		if statMode == "count" {
+			totalFuturePending++
+		} else {
+			totalFuturePending += int64(w.pending())
+		}
 	}
 
-	// 昨日完成
-	yesterdayStart := todayStart.Add(-24 * time.Hour)
-	var yesterdayCompleted int64
-	if statMode == "count" {
-		baseQueryWithTime(yesterdayStart, todayStart).Where("status = ?", "completed").Count(&yesterdayCompleted)
-	} else {
-		baseQueryWithTime(yesterdayStart, todayStart).Where("status = ?", "completed").
-			Select("COALESCE(SUM(executed_count), 0)").Scan(&yesterdayCompleted)
+	// 昨日完成和过去3天平均完成
+	query := h.db.Model(&models.Task{})
+	if taskType != "" {
+		query = query.Where("task_type = ?", taskType)
 	}
-
-	// 过去3天平均完成
+	yesterdayStart := todayStart.Add(-24 * time.Hour)
 	threeDaysAgo := todayStart.Add(-72 * time.Hour)
-	var last3DaysCompleted int64
-	if statMode == "count" {
-		baseQueryWithTime(threeDaysAgo, todayStart).Where("status = ?", "completed").Count(&last3DaysCompleted)
-	} else {
-		baseQueryWithTime(threeDaysAgo, todayStart).Where("status = ?", "completed").
-			Select("COALESCE(SUM(executed_count), 0)").Scan(&last3DaysCompleted)
+	completed, err := services.SumCompletedTasks(query, threeDaysAgo, yesterdayStart, todayStart, statMode == "count")
+	if err != nil {
+		return nil, err
 	}
-	avgCompleted := float64(last3DaysCompleted) / 3.0
+	yesterdayCompleted := completed.Recent
+	avgCompleted := float64(completed.Total) / 3.0
 
 	// 计算执行压力水平
 	var pressureLevel string
@@ -245,7 +228,7 @@ func (h *AdminDashboardHandler) GetTaskPressure(c *gin.Context) {
 		unit = "个"
 	}
 
-	response.Success(c, gin.H{
+	return gin.H{
 		"stat_mode":            statMode,
 		"task_type":            taskType,
 		"unit":                 unit,
@@ -255,7 +238,7 @@ func (h *AdminDashboardHandler) GetTaskPressure(c *gin.Context) {
 		"avg_3days_completed":  avgCompleted,
 		"pressure_level":       pressureLevel,
 		"pressure_value":       pressureValue,
-	})
+	}, nil
 }
 
 // GetFinanceStats 获取财务统计
@@ -336,7 +319,7 @@ func (h *AdminDashboardHandler) GetFinanceStats(c *gin.Context) {
 
 // TriggerExpiredTaskCheck 手动触发过期任务检查
 // @Summary 手动触发过期任务检查
-// @Description 手动触发过期任务检查和退款处理（仅管理员）
+// @Description 提交过期任务检查和退款处理作业，立即返回作业ID，处理结果在作业详情中查看（仅管理员）
 // @Tags 管理员仪表板
 // @Accept json
 // @Produce json
@@ -345,130 +328,12 @@ func (h *AdminDashboardHandler) GetFinanceStats(c *gin.Context) {
 // @Failure 401 {object} response.Response
 // @Router /admin/dashboard/trigger-expire-check [post]
 func (h *AdminDashboardHandler) TriggerExpiredTaskCheck(c *gin.Context) {
-	// 计算24小时前的时间点
-	expireThreshold := time.Now().Add(-24 * time.Hour)
-
-	// 查找过期任务
-	var expiredTasks []models.Task
-	if err := h.db.Where(
-		"start_time < ? AND status IN (?, ?) AND executed_count < execute_count",
-		expireThreshold, "waiting", "running",
-	).Find(&expiredTasks).Error; err != nil {
-		response.Error(c, 500, "查询过期任务失败: "+err.Error())
-		return
-	}
-
-	if len(expiredTasks) == 0 {
-		response.Success(c, gin.H{
-			"message":         "没有发现过期任务",
-			"processed_count": 0,
-		})
-		return
-	}
-
-	processedCount := 0
-	var processedTasks []gin.H
-
-	for _, task := range expiredTasks {
-		result := h.processExpiredTask(&task)
-		if result != nil {
-			processedTasks = append(processedTasks, result)
-			processedCount++
-		}
-	}
-
-	response.Success(c, gin.H{
-		"message":         fmt.Sprintf("已处理 %d 个过期任务", processedCount),
-		"processed_count": processedCount,
-		"processed_tasks": processedTasks,
-	})
-}
-
-// processExpiredTask 处理单个过期任务
-func (h *AdminDashboardHandler) processExpiredTask(task *models.Task) gin.H {
-	tx := h.db.Begin()
-
-	// 获取任务类型价格
-	var taskType models.TaskType
-	if err := tx.Where("type_code = ?", task.TaskType).First(&taskType).Error; err != nil {
-		tx.Rollback()
-		return nil
-	}
-
-	// 计算退款金额
-	remainingCount := task.ExecuteCount - task.ExecutedCount
-	refundAmount := 0
-
-	if task.ConsumeJingdou > 0 && task.ExecuteCount > 0 {
-		refundAmount = task.ConsumeJingdou * remainingCount / task.ExecuteCount
-	}
-
-	// 获取用户
-	var user models.User
-	if err := tx.First(&user, task.UserID).Error; err != nil {
-		tx.Rollback()
-		return nil
-	}
-
-	// 更新用户余额
-	if refundAmount > 0 {
-		user.JingdouBalance += refundAmount
-		tx.Save(&user)
-
-		// 创建京豆日志
-		jingdouLog := models.JingdouLog{
-			UserID:        user.ID,
-			Amount:        refundAmount,
-			Balance:       user.JingdouBalance,
-			OperationType: "refund",
-			RelatedID:     &task.ID,
-			Remark:        fmt.Sprintf("任务过期自动退款 - SKU:%s (完成%d/%d)", task.SKU, task.ExecutedCount, task.ExecuteCount),
-			CreatedAt:     time.Now(),
-		}
-		tx.Create(&jingdouLog)
-	}
-
-	// 更新任务状态
-	oldStatus := task.Status
-	task.Status = "partial_completed"
-	task.UpdatedAt = time.Now()
-
-	expireRemark := fmt.Sprintf("【系统自动处理】任务过期，完成%d/%d次", task.ExecutedCount, task.ExecuteCount)
-	if refundAmount > 0 {
-		expireRemark += fmt.Sprintf("，退还%d京豆", refundAmount)
-	}
-	if task.Remark != "" {
-		task.Remark = task.Remark + " | " + expireRemark
-	} else {
-		task.Remark = expireRemark
-	}
-	tx.Save(task)
-
-	// 创建任务日志
-	taskLog := models.TaskLog{
-		TaskID:    task.ID,
-		Status:    "partial_completed",
-		Message:   expireRemark,
-		CreatedAt: time.Now(),
-	}
-	tx.Create(&taskLog)
-
-	tx.Commit()
-
-	return gin.H{
-		"task_id":        task.ID,
-		"sku":            task.SKU,
-		"old_status":     oldStatus,
-		"new_status":     "partial_completed",
-		"executed":       task.ExecutedCount,
-		"total":          task.ExecuteCount,
-		"refund_jingdou": refundAmount,
-	}
+	submitJob(c, h.db, services.JobExpireTasks, nil)
 }
 
 // TriggerDataCleanup 手动触发数据清理
 // @Summary 手动触发数据清理
-// @Description 手动触发清理过期数据，默认60天保留期（仅管理员）
+// @Description 提交清理过期数据的作业，默认60天保留期，立即返回作业ID，清理结果在作业详情中查看（仅管理员）
 // @Tags 管理员仪表板
 // @Accept json
 // @Produce json
@@ -486,47 +351,5 @@ func (h *AdminDashboardHandler) TriggerDataCleanup(c *gin.Context) {
 		}
 	}
 
-	// 计算清理阈值时间
-	threshold := time.Now().AddDate(0, 0, -retentionDays)
-
-	// 统计清理结果
-	var tasksDeleted, taskLogsDeleted, deviceHistoryDeleted, apiLogsDeleted int64
-
-	// 只清理已完成/已取消/部分完成/失败的任务
-	safeStatuses := []string{"completed", "cancelled", "partial_completed", "failed"}
-
-	// 1. 查询要删除的任务ID（用于删除关联日志）
-	var taskIDs []uint
-	h.db.Model(&models.Task{}).
-		Where("created_at < ? AND status IN ?", threshold, safeStatuses).
-		Pluck("id", &taskIDs)
-
-	// 2. 删除任务日志
-	if len(taskIDs) > 0 {
-		result := h.db.Where("task_id IN ?", taskIDs).Delete(&models.TaskLog{})
-		taskLogsDeleted = result.RowsAffected
-	}
-
-	// 3. 删除任务
-	result := h.db.Where("created_at < ? AND status IN ?", threshold, safeStatuses).Delete(&models.Task{})
-	tasksDeleted = result.RowsAffected
-
-	// 4. 删除设备任务历史
-	result = h.db.Where("execute_time < ?", threshold).Delete(&models.DeviceTaskHistory{})
-	deviceHistoryDeleted = result.RowsAffected
-
-	// 5. 删除API日志
-	result = h.db.Where("created_at < ?", threshold).Delete(&models.APILog{})
-	apiLogsDeleted = result.RowsAffected
-
-	response.Success(c, gin.H{
-		"message":                fmt.Sprintf("已清理 %s 之前的数据", threshold.Format("2006-01-02")),
-		"retention_days":         retentionDays,
-		"threshold_date":         threshold.Format("2006-01-02"),
-		"tasks_deleted":          tasksDeleted,
-		"task_logs_deleted":      taskLogsDeleted,
-		"device_history_deleted": deviceHistoryDeleted,
-		"api_logs_deleted":       apiLogsDeleted,
-		"total_deleted":          tasksDeleted + taskLogsDeleted + deviceHistoryDeleted + apiLogsDeleted,
-	})
+	submitJob(c, h.db, services.JobDataCleanup, gin.H{"retention_days": retentionDays})
 }
@@ -4,7 +4,6 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -189,6 +188,7 @@ func (h *APIKeyHandler) DeleteAPIKey(c *gin.Context) {
 // @Security BearerAuth
 // @Param page query int false "页码" default(1)
 // @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
 // @Param endpoint query string false "接口端点"
 // @Param status query string false "状态" Enums(success,failed)
 // @Param start_date query string false "开始日期" format(date)
@@ -198,17 +198,10 @@ func (h *APIKeyHandler) DeleteAPIKey(c *gin.Context) {
 func (h *APIKeyHandler) GetAPILogs(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 
-	page := 1
-	pageSize := 20
-	if p, ok := c.GetQuery("page"); ok {
-		if pInt, err := strconv.Atoi(p); err == nil && pInt > 0 {
-			page = pInt
-		}
-	}
-	if ps, ok := c.GetQuery("page_size"); ok {
-		if psInt, err := strconv.Atoi(ps); err == nil && psInt > 0 {
-			pageSize = psInt
-		}
+	pagination, err := response.ParsePagination(c, "page_size", response.NoPageSizeLimit)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	query := h.db.Model(&models.APILog{}).Where("user_id = ?", userID)
@@ -233,11 +226,13 @@ func (h *APIKeyHandler) GetAPILogs(c *gin.Context) {
 		query = query.Where("DATE(created_at) <= ?", endDate)
 	}
 
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	var logs []models.APILog
-	query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs)
+	if err := response.Find(pagination, query, "created_at", &logs, apiLogCursorKey); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
 	items := make([]gin.H, 0)
 	for _, log := range logs {
@@ -258,13 +253,9 @@ func (h *APIKeyHandler) GetAPILogs(c *gin.Context) {
 		})
 	}
 
-	response.Success(c, gin.H{
-		"items":    items,
-		"page":     page,
-		"per_page": pageSize,
-		"total":    total,
-		"pages":    (int(total) + pageSize - 1) / pageSize,
-	})
+	data := pagination.Meta("per_page")
+	data["items"] = items
+	response.Success(c, data)
 }
 
 // GetAPILogsByAPIKey 获取API调用记录（API Key认证）
@@ -276,31 +267,27 @@ func (h *APIKeyHandler) GetAPILogs(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Param page query int false "页码" default(1)
 // @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
 // @Success 200 {object} response.Response{data=object}
 // @Router /logs/apikey [get]
 func (h *APIKeyHandler) GetAPILogsByAPIKey(c *gin.Context) {
 	apiKey, _ := c.Get("api_key")
 
-	page := 1
-	pageSize := 20
-	if p, ok := c.GetQuery("page"); ok {
-		if pInt, err := strconv.Atoi(p); err == nil && pInt > 0 {
-			page = pInt
-		}
-	}
-	if ps, ok := c.GetQuery("page_size"); ok {
-		if psInt, err := strconv.Atoi(ps); err == nil && psInt > 0 {
-			pageSize = psInt
-		}
+	pagination, err := response.ParsePagination(c, "page_size", response.NoPageSizeLimit)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	query := h.db.Model(&models.APILog{}).Where("api_key = ?", apiKey)
 
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	var logs []models.APILog
-	query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs)
+	if err := response.Find(pagination, query, "created_at", &logs, apiLogCursorKey); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
 	items := make([]gin.H, 0)
 	for _, log := range logs {
@@ -320,11 +307,12 @@ func (h *APIKeyHandler) GetAPILogsByAPIKey(c *gin.Context) {
 		})
 	}
 
-	response.Success(c, gin.H{
-		"items":    items,
-		"page":     page,
-		"per_page": pageSize,
-		"total":    total,
-		"pages":    (int(total) + pageSize - 1) / pageSize,
-	})
+	data := pagination.Meta("per_page")
+	data["items"] = items
+	response.Success(c, data)
+}
+
+// apiLogCursorKey API调用记录按创建时间分页的游标位置
+func apiLogCursorKey(log *models.APILog) (time.Time, uint) {
+	return log.CreatedAt, log.ID
 }
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"math"
 	"time"
 
 	"jd-task-platform-go/internal/models"
@@ -148,7 +149,7 @@ func (h *DashboardHandler) GetDetailedStatistics(c *gin.Context) {
 
 // GetFutureTrends 获取未来趋势数据
 // @Summary 获取未来趋势数据
-// @Description 获取未来7天的任务和京豆消耗预测
+// @Description 获取未来7天的任务和京豆消耗预测，任务按有效期（开始时间至截止时间）计入各天
 // @Tags 仪表板模块
 // @Accept json
 // @Produce json
@@ -160,20 +161,26 @@ func (h *DashboardHandler) GetFutureTrends(c *gin.Context) {
 	futureTasks := make([]int64, 7)
 	jingdouConsumption := make([]int, 7)
 
-	for i := 0; i < 7; i++ {
-		date := time.Now().AddDate(0, 0, i)
-		dates[i] = date.Format("01-02")
-		dateStr := date.Format("2006-01-02")
+	now := time.Now()
+	todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	windows := pendingTaskWindows(h.db, now, "")
 
-		// 统计该日期的待执行任务
-		var taskCount int64
-		h.db.Model(&models.Task{}).Where("DATE(start_time) = ? AND status IN (?)", dateStr, []string{"waiting", "running"}).Count(&taskCount)
-		futureTasks[i] = taskCount
-
-		// 计算预估京豆消耗
-		var totalConsume int
-		h.db.Model(&models.Task{}).Where("DATE(start_time) = ? AND status IN (?)", dateStr, []string{"waiting", "running"}).Select("SUM(consume_jingdou)").Row().Scan(&totalConsume)
-		jingdouConsumption[i] = totalConsume
+	for i := 0; i < 7; i++ {
+		dayStart := todayStart.AddDate(0, 0, i)
+		dayEnd := dayStart.AddDate(0, 0, 1)
+		dates[i] = dayStart.Format("01-02")
+
+		// 统计该日期可执行的任务（有效期与当天有重叠），京豆消耗按剩余次数和时间比例分摊
+		var consume float64
+		for _, w := range windows {
+			share := w.share(dayStart, dayEnd)
+			if share == 0 {
+				continue
+			}
+			futureTasks[i]++
+			consume += float64(w.task.ConsumeJingdou) * float64(w.pending()) / float64(w.task.ExecuteCount) * share
+		}
+		jingdouConsumption[i] = int(math.Round(consume))
 	}
 
 	response.Success(c, gin.H{
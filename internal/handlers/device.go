@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -29,27 +28,40 @@ func NewDeviceHandler(db *gorm.DB) *DeviceHandler {
 // @Security BearerAuth
 // @Param page query int false "页码" default(1)
 // @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
+// @Param group_name query string false "设备分组"
 // @Success 200 {object} response.Response{data=object}
 // @Failure 401 {object} response.Response
 // @Failure 403 {object} response.Response
 // @Router /devices [get]
 func (h *DeviceHandler) GetDevices(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
-
-	if page < 1 {
-		page = 1
+	pagination, err := response.ParsePagination(c, "page_size", response.NoPageSizeLimit)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
 	}
-	if pageSize < 1 {
-		pageSize = 20
+
+	query := h.db.Model(&models.Device{})
+	if groupName := c.Query("group_name"); groupName != "" {
+		query = query.Where("group_name = ?", groupName)
 	}
 
-	var total int64
-	h.db.Model(&models.Device{}).Count(&total)
+	pagination.Count(query)
 
 	var devices []models.Device
-	offset := (page - 1) * pageSize
-	h.db.Offset(offset).Limit(pageSize).Order("created_at DESC").Find(&devices)
+	if err := response.Find(pagination, query, "created_at", &devices, func(device *models.Device) (time.Time, uint) {
+		return device.CreatedAt, device.ID
+	}); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
+
+	// 批量查询本页设备的遥测走势
+	deviceIDs := make([]string, 0, len(devices))
+	for _, device := range devices {
+		deviceIDs = append(deviceIDs, device.DeviceID)
+	}
+	history := h.telemetryHistory(deviceIDs, listTelemetryBucket)
 
 	items := make([]gin.H, 0)
 	for _, device := range devices {
@@ -74,14 +86,26 @@ func (h *DeviceHandler) GetDevices(c *gin.Context) {
 			"app_version":    device.AppVersion,   // 应用版本
 			"ip":             device.IP,
 			"location":       device.Location,
+			"group_name":     device.GroupName,
 			"os_info":        device.OSInfo,       // 兼容旧字段
 			"version":        device.Version,      // 兼容旧字段
 			"status":         device.Status,
 			"is_blocked":     device.IsBlocked,
+			"is_paused":      device.IsPaused,
 			"task_count":     device.TaskCount,
 			"hourly_rate":    hourlyRate,     // 每小时任务执行数
 			"daily_estimate": dailyEstimate,  // 预估每天可完成数
 			"created_at":     device.CreatedAt.Format(time.RFC3339),
+			// 最近一次遥测
+			"battery":           device.Battery,
+			"network_type":      device.NetworkType,
+			"app_state":         device.AppState,
+			"free_storage":      device.FreeStorage,
+			"proxy_ip":          device.ProxyIP,
+			"telemetry_history": history[device.DeviceID],
+		}
+		if device.LastTelemetryAt != nil {
+			item["last_telemetry_at"] = device.LastTelemetryAt.Format(time.RFC3339)
 		}
 		if device.LastHeartbeat != nil {
 			item["last_heartbeat"] = device.LastHeartbeat.Format(time.RFC3339)
@@ -95,15 +119,9 @@ func (h *DeviceHandler) GetDevices(c *gin.Context) {
 		items = append(items, item)
 	}
 
-	pages := int((total + int64(pageSize) - 1) / int64(pageSize))
-
-	response.Success(c, gin.H{
-		"items":    items,
-		"page":     page,
-		"per_page": pageSize,
-		"total":    total,
-		"pages":    pages,
-	})
+	data := pagination.Meta("per_page")
+	data["items"] = items
+	response.Success(c, data)
 }
 
 // GetDeviceByID 获取设备详情
@@ -128,7 +146,13 @@ func (h *DeviceHandler) GetDeviceByID(c *gin.Context) {
 		return
 	}
 
-	response.Success(c, device)
+	response.Success(c, struct {
+		models.Device
+		TelemetryHistory []telemetryPoint `json:"telemetry_history"`
+	}{
+		Device:           device,
+		TelemetryHistory: h.telemetryHistory([]string{device.DeviceID}, detailTelemetryBucket)[device.DeviceID],
+	})
 }
 
 // UpdateDeviceStatus 更新设备状态
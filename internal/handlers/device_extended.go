@@ -1,15 +1,20 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
 	"strings"
 	"time"
 
 	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/internal/taskstate"
 	"jd-task-platform-go/pkg/response"
 	"jd-task-platform-go/pkg/utils"
 
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 // GetDeviceStatistics 获取设备统计信息
@@ -22,43 +27,99 @@ import (
 // @Success 200 {object} response.Response{data=object}
 // @Router /devices/statistics [get]
 func (h *DeviceHandler) GetDeviceStatistics(c *gin.Context) {
-	var stats struct {
-		TotalDevices   int64 `json:"total_devices"`
-		OnlineDevices  int64 `json:"online_devices"`
-		OfflineDevices int64 `json:"offline_devices"`
-		WorkingDevices int64 `json:"working_devices"`
-		IdleDevices    int64 `json:"idle_devices"`
-	}
+	stats, err := services.GetStatsCache().Get("device_statistics", services.DeviceStatsTTL, func() (interface{}, error) {
+		counts, err := services.CountDevicesByStatus(h.db)
+		if err != nil {
+			return nil, err
+		}
 
-	h.db.Model(&models.Device{}).Count(&stats.TotalDevices)
-	h.db.Model(&models.Device{}).Where("status = ?", "online").Count(&stats.OnlineDevices)
-	h.db.Model(&models.Device{}).Where("status = ?", "offline").Count(&stats.OfflineDevices)
-	h.db.Model(&models.Device{}).Where("status = ?", "working").Count(&stats.WorkingDevices)
-	h.db.Model(&models.Device{}).Where("status = ?", "idle").Count(&stats.IdleDevices)
+		var total int64
+		for _, count := range counts {
+			total += count
+		}
+		return gin.H{
+			"total_devices":   total,
+			"online_devices":  counts["online"],
+			"offline_devices": counts["offline"],
+			"working_devices": counts["working"],
+			"idle_devices":    counts["idle"],
+		}, nil
+	})
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "统计设备失败")
+		return
+	}
 
 	response.Success(c, stats)
 }
 
 // ClearAllDevices 清空所有设备
 // @Summary 清空所有设备
-// @Description 清空所有设备记录（仅管理员）
+// @Description 提交分批清空所有设备记录的作业，立即返回作业ID（仅管理员）
 // @Tags 设备模块
 // @Accept json
 // @Produce json
 // @Security BearerAuth
-// @Success 200 {object} response.Response
+// @Success 200 {object} response.Response{data=object}
 // @Router /devices/clear-all [post]
 func (h *DeviceHandler) ClearAllDevices(c *gin.Context) {
-	result := h.db.Exec("DELETE FROM devices")
+	submitJob(c, h.db, services.JobClearDevices, nil)
+}
 
-	response.SuccessWithMsg(c, "设备已清空", gin.H{
-		"deleted_count": result.RowsAffected,
-	})
+// UpdateDeviceGroup 设置设备分组
+// @Summary 设置设备分组
+// @Description 批量设置设备分组，group_name 为空表示移出分组。代理订阅按设备分组筛选代理（仅管理员）
+// @Tags 设备模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body object{ids=[]int,group_name=string} true "设备ID和分组"
+// @Success 200 {object} response.Response
+// @Router /devices/group [put]
+func (h *DeviceHandler) UpdateDeviceGroup(c *gin.Context) {
+	var req struct {
+		IDs       []uint `json:"ids" binding:"required,min=1"`
+		GroupName string `json:"group_name" binding:"max=64"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	result := h.db.Model(&models.Device{}).Where("id IN ?", req.IDs).
+		Update("group_name", strings.TrimSpace(req.GroupName))
+	if result.Error != nil {
+		response.Error(c, http.StatusInternalServerError, "设置分组失败")
+		return
+	}
+
+	response.SuccessWithDataAndMsgf(c, gin.H{"updated": result.RowsAffected}, "已设置 %d 个设备的分组", result.RowsAffected)
+}
+
+// deviceInfoRequest 设备上报的基础信息
+type deviceInfoRequest struct {
+	DeviceID    string `json:"device_id" binding:"required"`
+	DeviceName  string `json:"device_name"`
+	DeviceType  string `json:"device_type"`  // android 或 ios
+	DeviceModel string `json:"device_model"` // 设备型号
+	OSVersion   string `json:"os_version"`   // 系统版本
+	AppVersion  string `json:"app_version"`  // 应用版本
+	// 兼容旧字段
+	OSInfo  string `json:"os_info"`
+	Version string `json:"version"`
+}
+
+// taskFeedbackRequest 任务执行反馈
+type taskFeedbackRequest struct {
+	DeviceID string `json:"device_id" binding:"required"`
+	TaskID   uint   `json:"task_id" binding:"required"`
+	Status   string `json:"status" binding:"required"` // success, failed
+	Message  string `json:"message"`
 }
 
 // RequestTask 设备请求任务
 // @Summary 设备请求任务
-// @Description 设备请求待执行的任务
+// @Description 设备请求待执行的任务，设备的代理达到轮换条件时响应中附带 proxy_rotate 指令
 // @Tags 设备模块
 // @Accept json
 // @Produce json
@@ -67,23 +128,47 @@ func (h *DeviceHandler) ClearAllDevices(c *gin.Context) {
 // @Success 200 {object} response.Response{data=object}
 // @Router /devices/request-task [post]
 func (h *DeviceHandler) RequestTask(c *gin.Context) {
-	var req struct {
-		DeviceID    string `json:"device_id" binding:"required"`
-		DeviceName  string `json:"device_name"`
-		DeviceType  string `json:"device_type"`  // android 或 ios
-		DeviceModel string `json:"device_model"` // 设备型号
-		OSVersion   string `json:"os_version"`   // 系统版本
-		AppVersion  string `json:"app_version"`  // 应用版本
-		// 兼容旧字段
-		OSInfo  string `json:"os_info"`
-		Version string `json:"version"`
-	}
-
+	var req deviceInfoRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		response.Error(c, http.StatusBadRequest, "请求参数错误")
 		return
 	}
 
+	device := h.touchDevice(c, req)
+
+	// 旧版本应用通过轮询获取任务，控制指令随响应一并下发
+	messages := services.GetDeviceHub().PopMessages(device.DeviceID)
+
+	task, message := h.dispatchTask(&device)
+	if task == nil {
+		data := gin.H{
+			"has_task": false,
+			"message":  message,
+		}
+		if len(messages) > 0 {
+			data["messages"] = messages
+		}
+		response.Success(c, h.withProxyRotation(data, device.DeviceID))
+		return
+	}
+
+	data := taskAssignment(task)
+	if len(messages) > 0 {
+		data["messages"] = messages
+	}
+	response.Success(c, h.withProxyRotation(data, device.DeviceID))
+}
+
+// withProxyRotation 设备的代理达到轮换条件时在响应中附带 proxy_rotate 指令，设备应重新申请代理
+func (h *DeviceHandler) withProxyRotation(data gin.H, deviceID string) gin.H {
+	if instruction := services.DeviceProxyRotation(h.db, deviceID); instruction != nil {
+		data["proxy_rotate"] = instruction
+	}
+	return data
+}
+
+// touchDevice 更新或创建设备，并刷新心跳时间
+func (h *DeviceHandler) touchDevice(c *gin.Context, req deviceInfoRequest) models.Device {
 	// 获取客户端IP
 	clientIP := c.ClientIP()
 	if clientIP == "" {
@@ -122,6 +207,7 @@ func (h *DeviceHandler) RequestTask(c *gin.Context) {
 			CreatedAt:     time.Now(),
 		}
 		h.db.Create(&device)
+		services.PublishDeviceStatus(device.DeviceID, true)
 	} else {
 		// 更新设备信息
 		now := time.Now()
@@ -151,72 +237,93 @@ func (h *DeviceHandler) RequestTask(c *gin.Context) {
 		if req.Version != "" {
 			device.Version = req.Version
 		}
-		if device.Status == "offline" {
+		wasOffline := device.Status == "offline"
+		if wasOffline {
 			device.Status = "idle"
 		}
 		h.db.Save(&device)
+		if wasOffline {
+			services.PublishDeviceStatus(device.DeviceID, true)
+		}
 	}
 
+	return device
+}
+
+// dispatchTask 为设备挑选一个可执行的任务并标记为执行中
+// 没有可执行任务时返回 nil 和原因
+func (h *DeviceHandler) dispatchTask(device *models.Device) (*models.Task, string) {
 	// 检查设备是否被封禁
 	if device.IsBlocked {
-		response.Success(c, gin.H{
-			"has_task": false,
-			"message":  "设备已被封禁",
-		})
-		return
+		return nil, "设备已被封禁"
+	}
+	// 暂停的设备在恢复前不下发任务
+	if device.IsPaused {
+		return nil, "设备已暂停"
 	}
 
-	// 查找可执行的任务，按优先级和创建时间排序
-	// 包含条件：
-	// 1. waiting 或 running 状态的任务（只要未达到完成数量即可继续下发）
-	// 2. 未完成的任务（executed_count < execute_count）
-	// 3. 未过期的任务（start_time + 24小时 > 当前时间）
-	expireThreshold := time.Now().Add(-24 * time.Hour)
-	var task models.Task
-	if err := h.db.Where(
-		"status IN (?, ?) AND executed_count < execute_count AND (start_time IS NULL OR start_time <= ?) AND (start_time IS NULL OR start_time > ?)",
-		"waiting", "running", time.Now(), expireThreshold,
-	).Order("priority DESC, created_at ASC").First(&task).Error; err != nil {
-		// 没有可执行任务
-		response.Success(c, gin.H{
-			"has_task": false,
-			"message":  "暂无待执行任务",
-		})
-		return
+	// 优先下发该设备已完成上一步的任务链后续步骤
+	task, ok := h.nextChainStep(device)
+	if !ok {
+		// 查找可执行的任务，按优先级和创建时间排序
+		// 包含条件：
+		// 1. waiting 或 running 状态的任务（只要未达到完成数量即可继续下发）
+		// 2. 未完成的任务（executed_count < execute_count）
+		// 3. 未过期的任务（end_time > 当前时间）
+		// 4. 普通任务或任务链的第一步（后续步骤只下发给完成上一步的设备）
+		now := time.Now()
+		if err := h.db.Where(
+			"status IN (?, ?) AND executed_count < execute_count AND (start_time IS NULL OR start_time <= ?) AND (end_time IS NULL OR end_time > ?) AND chain_step <= 1",
+			"waiting", "running", now, now,
+		).Order("priority DESC, created_at ASC").First(&task).Error; err != nil {
+			// 没有可执行任务
+			return nil, "暂无待执行任务"
+		}
 	}
 
 	// 检查该设备是否最近执行过同样的SKU（仅针对特定任务类型）
 	// 需要防重复的任务类型：加购、店铺关注、商品关注
+	// 任务链后续步骤本身就针对同一SKU，不做此检查
 	needCheckDuplicate := task.TaskType == "add_to_cart" ||
 		task.TaskType == "follow_shop" ||
 		task.TaskType == "follow_product"
 
-	if needCheckDuplicate {
+	if needCheckDuplicate && !ok {
 		var history models.DeviceTaskHistory
 		recentTime := time.Now().Add(-24 * time.Hour)
 		if err := h.db.Where("device_id = ? AND sku = ? AND execute_time > ?",
-			req.DeviceID, task.SKU, recentTime).First(&history).Error; err == nil {
+			device.DeviceID, task.SKU, recentTime).First(&history).Error; err == nil {
 			// 24小时内执行过同样的SKU，跳过
-			response.Success(c, gin.H{
-				"has_task": false,
-				"message":  "24小时内已执行过相同SKU任务",
-			})
-			return
+			return nil, "24小时内已执行过相同SKU任务"
 		}
 	}
 
-	// 更新任务状态
-	task.Status = "running"
-	task.UpdatedAt = time.Now()
-	h.db.Save(&task)
+	// 更新任务状态（执行中的任务可以同时下发给多台设备）
+	if task.Status != taskstate.Running {
+		if _, err := taskstate.Transition(h.db, &task, taskstate.Change{
+			To:     taskstate.Running,
+			Actor:  taskstate.ActorDevice,
+			Reason: "任务已下发给设备 " + device.DeviceID,
+		}); err != nil {
+			// 并发下发时可能已被其他设备领取，只要仍在执行中即可继续下发
+			if h.db.First(&task, task.ID).Error != nil || task.Status != taskstate.Running {
+				return nil, "暂无待执行任务"
+			}
+		}
+	}
 
 	// 更新设备状态
 	device.Status = "working"
 	now := time.Now()
 	device.LastActive = &now
-	h.db.Save(&device)
+	h.db.Save(device)
 
-	response.Success(c, gin.H{
+	return &task, ""
+}
+
+// taskAssignment 构造下发给设备的任务数据
+func taskAssignment(task *models.Task) gin.H {
+	return gin.H{
 		"has_task":  true,
 		"task_id":   task.ID,
 		"task_type": task.TaskType,
@@ -224,7 +331,7 @@ func (h *DeviceHandler) RequestTask(c *gin.Context) {
 		"shop_name": task.ShopName,
 		"keyword":   task.Keyword,
 		"remark":    task.Remark,
-	})
+	}
 }
 
 // TaskFeedback 任务反馈
@@ -238,23 +345,26 @@ func (h *DeviceHandler) RequestTask(c *gin.Context) {
 // @Success 200 {object} response.Response
 // @Router /devices/task-feedback [post]
 func (h *DeviceHandler) TaskFeedback(c *gin.Context) {
-	var req struct {
-		DeviceID string `json:"device_id" binding:"required"`
-		TaskID   uint   `json:"task_id" binding:"required"`
-		Status   string `json:"status" binding:"required"` // success, failed
-		Message  string `json:"message"`
-	}
-
+	var req taskFeedbackRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		response.Error(c, http.StatusBadRequest, "请求参数错误")
 		return
 	}
 
+	if err := h.saveTaskFeedback(req); err != nil {
+		response.Error(c, http.StatusNotFound, "任务不存在")
+		return
+	}
+
+	response.SuccessWithMsg(c, "任务反馈已记录", nil)
+}
+
+// saveTaskFeedback 记录任务执行结果并更新任务进度和设备状态
+func (h *DeviceHandler) saveTaskFeedback(req taskFeedbackRequest) error {
 	// 查找任务
 	var task models.Task
 	if err := h.db.First(&task, req.TaskID).Error; err != nil {
-		response.Error(c, http.StatusNotFound, "任务不存在")
-		return
+		return err
 	}
 
 	// 获取任务类型的执行倍数
@@ -270,19 +380,41 @@ func (h *DeviceHandler) TaskFeedback(c *gin.Context) {
 
 	// 任务下发后，无论设备是否成功执行，直接认为本次任务已完成
 	// 根据任务类型设置的任务倍数来提交到数据库已完成次数
+	tx.Model(&models.Task{}).Where("id = ?", task.ID).
+		Update("executed_count", gorm.Expr("executed_count + ?", multiplier))
 	task.ExecutedCount += multiplier
+
+	// 达到执行次数时完成，否则回到等待状态
+	// 执行期间被暂停的任务保持暂停，已取消或已过期的任务不再变更状态
+	var result *taskstate.Result
+	next := taskstate.Waiting
 	if task.ExecutedCount >= task.ExecuteCount {
-		task.Status = "completed"
-	} else {
-		task.Status = "waiting" // 还未完成，回到等待状态
+		next = taskstate.Completed
+	}
+	if task.Status != next && (next == taskstate.Completed || task.Status != taskstate.Paused) &&
+		taskstate.CanTransition(task.Status, next) {
+		var err error
+		result, err = taskstate.Apply(tx, &task, taskstate.Change{
+			To:     next,
+			Actor:  taskstate.ActorDevice,
+			Reason: "设备 " + req.DeviceID + " 反馈执行结果",
+		})
+		if err != nil && !errors.Is(err, taskstate.ErrConflict) {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	// 推送执行进度
+	if err := services.EnqueueTaskEvent(tx, services.EventTaskProgress, &task); err != nil {
+		log.Printf("写入Webhook事件失败 (task_id=%d): %v", task.ID, err)
 	}
-	task.UpdatedAt = time.Now()
-	tx.Save(&task)
 
-	// 记录任务日志
+	// 记录任务日志，关联设备执行时使用的代理
 	taskLog := models.TaskLog{
 		TaskID:    task.ID,
 		DeviceID:  req.DeviceID,
+		ProxyID:   services.DeviceProxyID(tx, req.DeviceID),
 		Status:    req.Status,
 		Message:   req.Message,
 		CreatedAt: time.Now(),
@@ -314,5 +446,15 @@ func (h *DeviceHandler) TaskFeedback(c *gin.Context) {
 
 	tx.Commit()
 
-	response.SuccessWithMsg(c, "任务反馈已记录", nil)
+	// 同步任务链进度，推送实时进度
+	if result != nil {
+		taskstate.Committed(h.db, result)
+	} else {
+		services.PublishTaskProgress(&task)
+		if task.ChainID != nil {
+			services.RefreshTaskChain(h.db, *task.ChainID)
+		}
+	}
+
+	return nil
 }
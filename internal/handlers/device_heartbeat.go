@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// DeviceHeartbeatRequest 设备心跳请求
+type DeviceHeartbeatRequest struct {
+	deviceInfoRequest
+	Battery     *int   `json:"battery"`      // 电量百分比 0-100
+	IsCharging  bool   `json:"is_charging"`  // 是否充电中
+	NetworkType string `json:"network_type"` // wifi, 4g, 5g
+	AppState    string `json:"app_state"`    // 应用状态：idle, running_task, paused, background
+	FreeStorage *int64 `json:"free_storage"` // 剩余存储（MB）
+	ProxyIP     string `json:"proxy_ip"`     // 当前使用的代理IP
+}
+
+// telemetryPoint 遥测走势图数据点
+type telemetryPoint struct {
+	Time        time.Time `json:"time"`
+	Battery     *float64  `json:"battery"`      // 区间平均电量
+	FreeStorage *int64    `json:"free_storage"` // 区间最小剩余存储
+	Samples     int64     `json:"samples"`      // 区间心跳次数
+}
+
+// 走势图时间范围与粒度
+const (
+	telemetryHistoryWindow = 24 * time.Hour
+	listTelemetryBucket    = 3600 // 列表：每小时一个点
+	detailTelemetryBucket  = 600  // 详情：每10分钟一个点
+)
+
+// Heartbeat 设备心跳
+// @Summary 设备心跳
+// @Description 设备定时上报心跳和遥测数据（电量、网络类型、应用状态、剩余存储、代理IP），空闲设备无需请求任务即可保持在线，待下发的控制指令随响应返回，代理达到轮换条件时附带 proxy_rotate 指令
+// @Tags 设备模块
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body DeviceHeartbeatRequest true "心跳信息"
+// @Success 200 {object} response.Response{data=object}
+// @Router /devices/heartbeat [post]
+func (h *DeviceHandler) Heartbeat(c *gin.Context) {
+	var req DeviceHeartbeatRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if req.Battery != nil && (*req.Battery < 0 || *req.Battery > 100) {
+		response.Error(c, http.StatusBadRequest, "电量必须在0-100之间")
+		return
+	}
+
+	device := h.touchDevice(c, req.deviceInfoRequest)
+
+	now := time.Now()
+	telemetry := models.DeviceTelemetry{
+		DeviceID:    device.DeviceID,
+		Battery:     req.Battery,
+		IsCharging:  req.IsCharging,
+		NetworkType: req.NetworkType,
+		AppState:    req.AppState,
+		FreeStorage: req.FreeStorage,
+		ProxyIP:     req.ProxyIP,
+		CreatedAt:   now,
+	}
+	if err := h.db.Create(&telemetry).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "心跳记录失败")
+		return
+	}
+
+	// 设备表保存最新一次遥测，列表查询无需关联时序表；本次未上报的字段保留上一次的值
+	updates := map[string]interface{}{"last_telemetry_at": now}
+	if req.Battery != nil {
+		updates["battery"] = *req.Battery
+	}
+	if req.FreeStorage != nil {
+		updates["free_storage"] = *req.FreeStorage
+	}
+	if req.NetworkType != "" {
+		updates["network_type"] = req.NetworkType
+	}
+	if req.AppState != "" {
+		updates["app_state"] = req.AppState
+	}
+	if req.ProxyIP != "" {
+		updates["proxy_ip"] = req.ProxyIP
+	}
+	h.db.Model(&models.Device{}).Where("id = ?", device.ID).Updates(updates)
+
+	data := gin.H{
+		"server_time": now.Format(time.RFC3339),
+		"is_blocked":  device.IsBlocked,
+		"is_paused":   device.IsPaused,
+	}
+	if messages := services.GetDeviceHub().PopMessages(device.DeviceID); len(messages) > 0 {
+		data["messages"] = messages
+	}
+	response.Success(c, h.withProxyRotation(data, device.DeviceID))
+}
+
+// telemetryHistory 按时间粒度汇总设备遥测数据，用于走势图展示
+func (h *DeviceHandler) telemetryHistory(deviceIDs []string, bucketSeconds int) map[string][]telemetryPoint {
+	history := make(map[string][]telemetryPoint, len(deviceIDs))
+	if len(deviceIDs) == 0 {
+		return history
+	}
+
+	var rows []struct {
+		DeviceID    string
+		Bucket      int64
+		Battery     *float64
+		FreeStorage *int64
+		Samples     int64
+	}
+	h.db.Model(&models.DeviceTelemetry{}).
+		Select("device_id, FLOOR(UNIX_TIMESTAMP(created_at) / ?) AS bucket, AVG(battery) AS battery, MIN(free_storage) AS free_storage, COUNT(*) AS samples", bucketSeconds).
+		Where("device_id IN ? AND created_at >= ?", deviceIDs, time.Now().Add(-telemetryHistoryWindow)).
+		Group("device_id, bucket").
+		Order("device_id, bucket").
+		Scan(&rows)
+
+	for _, row := range rows {
+		history[row.DeviceID] = append(history[row.DeviceID], telemetryPoint{
+			Time:        time.Unix(row.Bucket*int64(bucketSeconds), 0),
+			Battery:     row.Battery,
+			FreeStorage: row.FreeStorage,
+			Samples:     row.Samples,
+		})
+	}
+	return history
+}
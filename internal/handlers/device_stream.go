@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// 长轮询超时时间（秒）
+const (
+	defaultStreamTimeout = 30
+	maxStreamTimeout     = 60
+)
+
+// DeviceStreamRequest 设备长轮询请求
+type DeviceStreamRequest struct {
+	deviceInfoRequest
+	Timeout  int             `json:"timeout"`  // 最长等待秒数，默认30，最大60
+	Feedback *streamFeedback `json:"feedback"` // 可选，上一个任务的执行反馈
+}
+
+// streamFeedback 长轮询附带的任务反馈，设备ID取自请求本身
+type streamFeedback struct {
+	TaskID  uint   `json:"task_id" binding:"required"`
+	Status  string `json:"status" binding:"required"` // success, failed
+	Message string `json:"message"`
+}
+
+// StreamTask 设备长轮询获取任务推送
+// @Summary 设备长轮询获取任务推送
+// @Description 设备保持连接等待任务或控制指令，有可执行任务时立即返回，超时返回空结果。请求本身即视为心跳，可附带上一个任务的执行反馈，代理达到轮换条件时推送 rotate_proxy 指令并在响应中附带 proxy_rotate。旧版本应用可继续使用 /devices/request-task 轮询
+// @Tags 设备模块
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body DeviceStreamRequest true "设备信息"
+// @Success 200 {object} response.Response{data=object}
+// @Router /devices/stream [post]
+func (h *DeviceHandler) StreamTask(c *gin.Context) {
+	var req DeviceStreamRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	timeout := req.Timeout
+	if timeout <= 0 {
+		timeout = defaultStreamTimeout
+	}
+	if timeout > maxStreamTimeout {
+		timeout = maxStreamTimeout
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+
+	// 先处理随请求提交的任务反馈
+	feedbackAccepted := false
+	if req.Feedback != nil {
+		feedbackAccepted = h.saveTaskFeedback(taskFeedbackRequest{
+			DeviceID: req.DeviceID,
+			TaskID:   req.Feedback.TaskID,
+			Status:   req.Feedback.Status,
+			Message:  req.Feedback.Message,
+		}) == nil
+	}
+
+	hub := services.GetDeviceHub()
+	ctx := c.Request.Context()
+	device := h.touchDevice(c, req.deviceInfoRequest)
+
+	for {
+		// 控制指令优先下发
+		if messages := hub.PopMessages(device.DeviceID); len(messages) > 0 {
+			response.Success(c, h.withProxyRotation(gin.H{
+				"has_task":          false,
+				"messages":          messages,
+				"feedback_accepted": feedbackAccepted,
+			}, device.DeviceID))
+			return
+		}
+
+		task, message := h.dispatchTask(&device)
+		if task != nil {
+			data := taskAssignment(task)
+			data["feedback_accepted"] = feedbackAccepted
+			response.Success(c, h.withProxyRotation(data, device.DeviceID))
+			return
+		}
+
+		// 被封禁的设备无需继续等待，暂停的设备继续等待恢复指令
+		if device.IsBlocked || !hub.Wait(ctx, device.DeviceID, deadline) {
+			response.Success(c, h.withProxyRotation(gin.H{
+				"has_task":          false,
+				"message":           message,
+				"feedback_accepted": feedbackAccepted,
+			}, device.DeviceID))
+			return
+		}
+
+		// 刷新心跳，避免长时间等待的设备被判定离线，同时重新加载封禁和暂停状态
+		now := time.Now()
+		h.db.Model(&models.Device{}).Where("id = ?", device.ID).Update("last_heartbeat", now)
+		h.db.First(&device, device.ID)
+	}
+}
+
+// SendDeviceControl 向设备发送控制指令
+// @Summary 向设备发送控制指令
+// @Description 向设备推送控制指令（暂停、恢复、封禁、解封、更换代理、轮换代理），设备在下一次长轮询或请求任务时收到；暂停后到恢复前不再向设备下发任务（仅管理员）
+// @Tags 设备模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "设备ID"
+// @Param request body object{action=string,payload=object} true "控制指令，action: pause/resume/block/unblock/update_proxy/rotate_proxy"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /devices/{id}/control [post]
+func (h *DeviceHandler) SendDeviceControl(c *gin.Context) {
+	id := c.Param("id")
+
+	var req struct {
+		Action  string      `json:"action" binding:"required"`
+		Payload interface{} `json:"payload"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	switch req.Action {
+	case services.DeviceActionPause, services.DeviceActionResume,
+		services.DeviceActionBlock, services.DeviceActionUnblock,
+		services.DeviceActionUpdateProxy, services.DeviceActionRotateProxy:
+	default:
+		response.Error(c, http.StatusBadRequest, "不支持的控制指令")
+		return
+	}
+
+	var device models.Device
+	if err := h.db.First(&device, id).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "设备不存在")
+		return
+	}
+
+	// 封禁/解封同步更新设备状态，保证轮询接口的行为一致
+	if req.Action == services.DeviceActionBlock || req.Action == services.DeviceActionUnblock {
+		device.IsBlocked = req.Action == services.DeviceActionBlock
+		h.db.Save(&device)
+	}
+
+	// 暂停/恢复同样记录在设备上，暂停期间请求任务和长轮询都不再下发任务
+	if req.Action == services.DeviceActionPause || req.Action == services.DeviceActionResume {
+		paused := req.Action == services.DeviceActionPause
+		if err := h.db.Model(&models.Device{}).Where("id = ?", device.ID).Update("is_paused", paused).Error; err != nil {
+			response.Error(c, http.StatusInternalServerError, "更新设备状态失败")
+			return
+		}
+		device.IsPaused = paused
+	}
+
+	// 轮换代理记录在租约上，设备重新申请时不再分配原代理
+	payload := req.Payload
+	if req.Action == services.DeviceActionRotateProxy {
+		instruction, err := services.RequestProxyRotation(h.db, device.DeviceID)
+		if err != nil {
+			response.Error(c, http.StatusInternalServerError, "记录代理轮换失败")
+			return
+		}
+		if payload == nil && instruction != nil {
+			payload = instruction
+		}
+	}
+
+	hub := services.GetDeviceHub()
+	hub.SendControl(device.DeviceID, req.Action, payload)
+
+	response.SuccessWithMsg(c, "控制指令已发送", gin.H{
+		"device_id": device.DeviceID,
+		"action":    req.Action,
+		"connected": hub.IsConnected(device.DeviceID),
+	})
+}
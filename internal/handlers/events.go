@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// SSE 心跳间隔，防止代理因空闲断开连接
+const eventStreamKeepAlive = 25 * time.Second
+
+// EventHandler 实时事件推送处理器
+type EventHandler struct {
+	db *gorm.DB
+}
+
+// NewEventHandler 创建实时事件推送处理器
+func NewEventHandler(db *gorm.DB) *EventHandler {
+	return &EventHandler{db: db}
+}
+
+// Stream 实时事件流
+// @Summary 实时事件流（SSE）
+// @Description 以 Server-Sent Events 推送任务进度（task.progress）、设备上线/离线（device.status，仅管理员）和京豆余额变化（balance.changed）。普通用户只接收自己的任务和余额事件。浏览器 EventSource 无法设置请求头时可通过 token 查询参数传递令牌。事件ID连续递增，出现间断说明有事件因客户端处理过慢被丢弃，应重新拉取看板数据
+// @Tags 实时事件
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Param token query string false "JWT令牌（无法设置 Authorization 请求头时使用）"
+// @Success 200 {string} string "事件流"
+// @Failure 401 {object} response.Response
+// @Router /events [get]
+func (h *EventHandler) Stream(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		response.Error(c, http.StatusInternalServerError, "当前连接不支持事件流")
+		return
+	}
+
+	bus := services.GetEventBus()
+	sub := bus.Subscribe(userID.(uint), role == "admin")
+	defer bus.Unsubscribe(sub)
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no") // 关闭 Nginx 缓冲
+	c.Status(http.StatusOK)
+
+	// 断线后浏览器3秒重连，连接建立后先发送 ready 事件
+	fmt.Fprintf(c.Writer, "retry: 3000\n")
+	writeStreamEvent(c.Writer, 0, "ready", gin.H{
+		"user_id":     userID,
+		"role":        role,
+		"server_time": time.Now().Format(time.RFC3339),
+	})
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventStreamKeepAlive)
+	defer keepAlive.Stop()
+	ctx := c.Request.Context()
+
+	for {
+		select {
+		case event := <-sub.C:
+			writeStreamEvent(c.Writer, event.ID, event.Type, event)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprintf(c.Writer, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// writeStreamEvent 按 SSE 格式写入一条事件
+func writeStreamEvent(w gin.ResponseWriter, id uint64, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	if id > 0 {
+		fmt.Fprintf(w, "id: %d\n", id)
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload)
+}
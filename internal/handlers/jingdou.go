@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -29,6 +28,7 @@ func NewJingdouHandler(db *gorm.DB) *JingdouHandler {
 // @Security BearerAuth
 // @Param page query int false "页码" default(1)
 // @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
 // @Param operation_type query string false "操作类型" Enums(task, recharge, refund, deduct)
 // @Param start_date query string false "开始日期" format(date)
 // @Param end_date query string false "结束日期" format(date)
@@ -38,17 +38,10 @@ func (h *JingdouHandler) GetJingdouLogs(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	role, _ := c.Get("role")
 
-	page := 1
-	pageSize := 20
-	if p, ok := c.GetQuery("page"); ok {
-		if pInt, err := strconv.Atoi(p); err == nil && pInt > 0 {
-			page = pInt
-		}
-	}
-	if ps, ok := c.GetQuery("page_size"); ok {
-		if psInt, err := strconv.Atoi(ps); err == nil && psInt > 0 {
-			pageSize = psInt
-		}
+	pagination, err := response.ParsePagination(c, "page_size", response.NoPageSizeLimit)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	query := h.db.Model(&models.JingdouLog{})
@@ -74,11 +67,13 @@ func (h *JingdouHandler) GetJingdouLogs(c *gin.Context) {
 		query = query.Where("DATE(created_at) <= ?", endDate)
 	}
 
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	var logs []models.JingdouLog
-	query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs)
+	if err := response.Find(pagination, query, "created_at", &logs, jingdouLogCursorKey); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
 	items := make([]gin.H, 0)
 	for _, log := range logs {
@@ -94,13 +89,9 @@ func (h *JingdouHandler) GetJingdouLogs(c *gin.Context) {
 		})
 	}
 
-	response.Success(c, gin.H{
-		"items":    items,
-		"page":     page,
-		"per_page": pageSize,
-		"total":    total,
-		"pages":    (int(total) + pageSize - 1) / pageSize,
-	})
+	data := pagination.Meta("per_page")
+	data["items"] = items
+	response.Success(c, data)
 }
 
 // GetJingdouBalance 获取京豆余额（JWT认证）
@@ -135,6 +126,7 @@ func (h *JingdouHandler) GetJingdouBalance(c *gin.Context) {
 // @Security BearerAuth
 // @Param page query int false "页码" default(1)
 // @Param per_page query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
 // @Param type query string false "类型" Enums(task_consume, task_refund, recharge, withdraw, admin_adjust)
 // @Param start_date query string false "开始日期" format(date)
 // @Param end_date query string false "结束日期" format(date)
@@ -144,17 +136,10 @@ func (h *JingdouHandler) GetJingdouRecords(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 	role, _ := c.Get("role")
 
-	page := 1
-	perPage := 20
-	if p, ok := c.GetQuery("page"); ok {
-		if pInt, err := strconv.Atoi(p); err == nil && pInt > 0 {
-			page = pInt
-		}
-	}
-	if ps, ok := c.GetQuery("per_page"); ok {
-		if psInt, err := strconv.Atoi(ps); err == nil && psInt > 0 {
-			perPage = psInt
-		}
+	pagination, err := response.ParsePagination(c, "per_page", response.NoPageSizeLimit)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	query := h.db.Model(&models.JingdouLog{})
@@ -201,11 +186,13 @@ func (h *JingdouHandler) GetJingdouRecords(c *gin.Context) {
 		query = query.Where("DATE(created_at) <= ?", endDate)
 	}
 
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	var logs []models.JingdouLog
-	query.Order("created_at DESC").Offset((page - 1) * perPage).Limit(perPage).Find(&logs)
+	if err := response.Find(pagination, query, "created_at", &logs, jingdouLogCursorKey); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
 	// 管理员查看时，获取用户信息
 	userMap := make(map[uint]models.User)
@@ -266,12 +253,14 @@ func (h *JingdouHandler) GetJingdouRecords(c *gin.Context) {
 		records = append(records, record)
 	}
 
-	response.Success(c, gin.H{
-		"records":  records,
-		"total":    total,
-		"page":     page,
-		"per_page": perPage,
-	})
+	data := pagination.Meta("per_page")
+	data["records"] = records
+	response.Success(c, data)
+}
+
+// jingdouLogCursorKey 京豆明细按创建时间分页的游标位置
+func jingdouLogCursorKey(log *models.JingdouLog) (time.Time, uint) {
+	return log.CreatedAt, log.ID
 }
 
 // GetJingdouBalanceByAPIKey 获取京豆余额（API Key认证）
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// JobHandler 后台作业处理器（仅管理员）
+type JobHandler struct {
+	db *gorm.DB
+}
+
+// NewJobHandler 创建后台作业处理器
+func NewJobHandler(db *gorm.DB) *JobHandler {
+	return &JobHandler{db: db}
+}
+
+// GetJobs 获取作业列表
+// @Summary 获取后台作业列表
+// @Description 获取后台作业列表及可提交的作业类型（仅管理员）
+// @Tags 后台作业
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param type query string false "作业类型"
+// @Param status query string false "状态：queued, running, succeeded, failed, cancelled"
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
+// @Success 200 {object} response.Response{data=object}
+// @Router /admin/jobs [get]
+func (h *JobHandler) GetJobs(c *gin.Context) {
+	pagination, err := response.ParsePagination(c, "page_size", response.MaxPageSize)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := h.db.Model(&models.Job{})
+	if typ := c.Query("type"); typ != "" {
+		query = query.Where("type = ?", typ)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	pagination.Count(query)
+
+	var jobs []models.Job
+	if err := response.Find(pagination, query, "created_at", &jobs, func(job *models.Job) (time.Time, uint) {
+		return job.CreatedAt, job.ID
+	}); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
+
+	items := make([]gin.H, 0, len(jobs))
+	for i := range jobs {
+		items = append(items, jobView(&jobs[i]))
+	}
+
+	data := pagination.Meta("page_size")
+	data["items"] = items
+	data["types"] = services.JobTypes()
+	response.Success(c, data)
+}
+
+// SubmitJob 提交作业
+// @Summary 提交后台作业
+// @Description 提交后台作业并立即返回作业ID，通过作业详情或实时事件（job.progress）查看进度（仅管理员）
+// @Tags 后台作业
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.SubmitJobRequest true "作业类型和参数"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Router /admin/jobs [post]
+func (h *JobHandler) SubmitJob(c *gin.Context) {
+	var req models.SubmitJobRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	submitJob(c, h.db, req.Type, req.Params)
+}
+
+// GetJob 获取作业详情
+// @Summary 获取后台作业详情
+// @Description 获取作业状态、进度和执行结果（仅管理员）
+// @Tags 后台作业
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "作业ID"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 404 {object} response.Response
+// @Router /admin/jobs/{id} [get]
+func (h *JobHandler) GetJob(c *gin.Context) {
+	var job models.Job
+	if err := h.db.First(&job, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "作业不存在")
+		return
+	}
+
+	response.Success(c, jobView(&job))
+}
+
+// CancelJob 取消作业
+// @Summary 取消后台作业
+// @Description 排队中的作业立即取消，执行中的作业在下一个检查点停止，已完成的部分不会回滚（仅管理员）
+// @Tags 后台作业
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "作业ID"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /admin/jobs/{id}/cancel [post]
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	var job models.Job
+	if err := h.db.First(&job, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "作业不存在")
+		return
+	}
+
+	if err := services.CancelJob(h.db, &job); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	msg := "作业已取消"
+	if job.Status == services.JobStatusRunning {
+		msg = "已请求取消，作业将在当前步骤完成后停止"
+	}
+	response.SuccessWithMsg(c, msg, jobView(&job))
+}
+
+// submitJob 提交作业并返回作业ID，管理操作触发接口共用
+func submitJob(c *gin.Context, db *gorm.DB, typ string, params interface{}) {
+	userID, _ := c.Get("user_id")
+	createdBy, _ := userID.(uint)
+
+	job, err := services.SubmitJob(db, typ, params, createdBy)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.SuccessWithMsg(c, "作业已提交", gin.H{
+		"job_id":  job.ID,
+		"type":    job.Type,
+		"status":  job.Status,
+		"job_url": fmt.Sprintf("/api/admin/jobs/%d", job.ID),
+	})
+}
+
+// jobView 作业详情，参数和结果按 JSON 对象返回
+func jobView(job *models.Job) gin.H {
+	return gin.H{
+		"id":               job.ID,
+		"type":             job.Type,
+		"params":           rawJSON(job.Params),
+		"status":           job.Status,
+		"progress":         job.Progress,
+		"message":          job.Message,
+		"result":           rawJSON(job.Result),
+		"error":            job.Error,
+		"cancel_requested": job.CancelRequested,
+		"created_by":       job.CreatedBy,
+		"started_at":       job.StartedAt,
+		"finished_at":      job.FinishedAt,
+		"created_at":       job.CreatedAt,
+	}
+}
+
+// rawJSON 将保存的 JSON 字符串原样输出，为空时输出 null
+func rawJSON(s string) json.RawMessage {
+	if s == "" || !json.Valid([]byte(s)) {
+		return nil
+	}
+	return json.RawMessage(s)
+}
@@ -9,6 +9,8 @@ import (
 	"gorm.io/gorm"
 
 	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/internal/taskstate"
 	"jd-task-platform-go/pkg/response"
 )
 
@@ -36,6 +38,7 @@ type OpenAPICreateTaskRequest struct {
 	ExecuteCount int       `json:"execute_count" binding:"required"` // 执行次数
 	Priority     int       `json:"priority"`                         // 优先级
 	Remark       string    `json:"remark"`                           // 备注
+	ExpireHours  int       `json:"expire_hours"`                     // 任务有效时长（小时），默认使用任务类型配置
 }
 
 // CreateTask 创建单个任务
@@ -56,140 +59,42 @@ func (h *OpenAPIHandler) CreateTask(c *gin.Context) {
 		return
 	}
 
-	userID, _ := c.Get("user_id")
-
-	var user models.User
-	if err := h.db.First(&user, userID).Error; err != nil {
-		response.Error(c, http.StatusNotFound, "用户不存在：请检查您的API Key是否有效")
-		return
-	}
-
-	// 查询任务类型配置
-	var taskType models.TaskType
-	if err := h.db.Where("type_code = ?", req.TaskType).First(&taskType).Error; err != nil {
-		response.Error(c, http.StatusBadRequest, "任务类型不存在：请使用有效的任务类型代码，可通过 GET /openapi/task-types 获取可用类型")
-		return
-	}
-
-	// 检查任务类型是否启用
-	if !taskType.IsActive {
-		response.Error(c, http.StatusBadRequest, "该任务类型已被禁用：当前任务类型暂时不可使用，请选择其他类型或联系管理员")
-		return
-	}
-
-	// 关键词搜索任务必须填写关键词
-	if req.TaskType == "search_browse" && req.Keyword == "" {
-		response.Error(c, http.StatusBadRequest, "参数错误：关键词搜索任务(search_browse)必须填写关键词(keyword)字段")
-		return
-	}
-	// 非关键词搜索任务不需要关键词
-	if req.TaskType != "search_browse" {
-		req.Keyword = ""
-	}
-
-	// 检查执行次数
-	if req.ExecuteCount <= 0 {
-		response.Error(c, http.StatusBadRequest, "参数错误：执行次数(execute_count)必须大于0")
-		return
-	}
-
-	// 检查时间段限制（使用API创建的任务也需要遵守时间限制）
-	if taskType.TimeSlot1Start != nil && taskType.TimeSlot1End != nil &&
-		*taskType.TimeSlot1Start != "" && *taskType.TimeSlot1End != "" {
-		now := time.Now()
-		currentTime := now.Format("15:04")
-
-		inTimeSlot := false
-		if currentTime >= *taskType.TimeSlot1Start && currentTime <= *taskType.TimeSlot1End {
-			inTimeSlot = true
-		}
-		if !inTimeSlot && taskType.TimeSlot2Start != nil && taskType.TimeSlot2End != nil &&
-			*taskType.TimeSlot2Start != "" && *taskType.TimeSlot2End != "" {
-			if currentTime >= *taskType.TimeSlot2Start && currentTime <= *taskType.TimeSlot2End {
-				inTimeSlot = true
-			}
-		}
-
-		if !inTimeSlot {
-			timeSlotInfo := *taskType.TimeSlot1Start + "-" + *taskType.TimeSlot1End
-			if taskType.TimeSlot2Start != nil && taskType.TimeSlot2End != nil &&
-				*taskType.TimeSlot2Start != "" && *taskType.TimeSlot2End != "" {
-				timeSlotInfo += ", " + *taskType.TimeSlot2Start + "-" + *taskType.TimeSlot2End
-			}
-			response.Error(c, http.StatusBadRequest, "创建失败：该任务类型仅在 "+timeSlotInfo+" 时段内允许创建任务")
-			return
-		}
-	}
-
-	// 计算京豆消耗
-	consumeJingdou := taskType.JingdouPrice * req.ExecuteCount
-
-	// 检查余额
-	if user.JingdouBalance < consumeJingdou {
-		response.Error(c, http.StatusBadRequest,
-			"京豆余额不足：创建此任务需要 "+strconv.Itoa(consumeJingdou)+" 京豆，您当前余额为 "+strconv.Itoa(user.JingdouBalance)+" 京豆，请先充值")
+	actor := services.TaskActor{UserID: c.GetUint("user_id"), Source: "API创建任务"}
+	result, err := services.NewTaskService(h.db).CreateTasks(c.Request.Context(), actor, []services.TaskSpec{req.spec()})
+	if err != nil {
+		status, msg := taskCreateError(err, false)
+		response.Error(c, status, msg)
 		return
 	}
 
-	// 创建任务
-	task := models.Task{
-		UserID:         user.ID,
-		TaskType:       req.TaskType,
-		SKU:            req.SKU,
-		ShopName:       req.ShopName,
-		Keyword:        req.Keyword,
-		StartTime:      req.StartTime,
-		ExecuteCount:   req.ExecuteCount,
-		ExecutedCount:  0,
-		Priority:       req.Priority,
-		Status:         "waiting",
-		ConsumeJingdou: consumeJingdou,
-		Remark:         req.Remark,
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
-	}
-
-	tx := h.db.Begin()
-
-	if err := tx.Create(&task).Error; err != nil {
-		tx.Rollback()
-		response.Error(c, http.StatusInternalServerError, "任务创建失败：系统内部错误，请稍后重试")
-		return
-	}
-
-	// 扣除京豆
-	user.JingdouBalance -= consumeJingdou
-	tx.Save(&user)
-
-	// 创建京豆日志
-	jingdouLog := models.JingdouLog{
-		UserID:        user.ID,
-		Amount:        -consumeJingdou,
-		Balance:       user.JingdouBalance,
-		OperationType: "task",
-		RelatedID:     &task.ID,
-		Remark:        "API创建任务扣除 - SKU:" + task.SKU,
-		CreatedAt:     time.Now(),
-	}
-	tx.Create(&jingdouLog)
-
-	// 更新模板
-	UpdateOrCreateTemplate(tx, user.ID, task.TaskType, task.SKU, task.ShopName, task.Keyword, task.ExecuteCount)
-
-	tx.Commit()
-
+	task := result.Tasks[0]
 	response.Success(c, gin.H{
 		"task_id":         task.ID,
 		"task_type":       task.TaskType,
 		"sku":             task.SKU,
 		"status":          task.Status,
-		"consume_jingdou": consumeJingdou,
-		"balance":         user.JingdouBalance,
+		"consume_jingdou": result.Consume,
+		"balance":         result.Balance,
 		"created_at":      task.CreatedAt.Format(time.RFC3339),
 		"message":         "任务创建成功",
 	})
 }
 
+// spec 转换为任务服务的参数
+func (req OpenAPICreateTaskRequest) spec() services.TaskSpec {
+	return services.TaskSpec{
+		TaskType:     req.TaskType,
+		SKU:          req.SKU,
+		ShopName:     req.ShopName,
+		Keyword:      req.Keyword,
+		StartTime:    req.StartTime,
+		ExecuteCount: req.ExecuteCount,
+		Priority:     req.Priority,
+		Remark:       req.Remark,
+		ExpireHours:  req.ExpireHours,
+	}
+}
+
 // BatchCreateTaskRequest 批量创建任务请求
 type OpenAPIBatchCreateRequest struct {
 	Tasks []OpenAPICreateTaskRequest `json:"tasks" binding:"required"`
@@ -223,142 +128,38 @@ func (h *OpenAPIHandler) BatchCreateTasks(c *gin.Context) {
 		return
 	}
 
-	userID, _ := c.Get("user_id")
-
-	var user models.User
-	if err := h.db.First(&user, userID).Error; err != nil {
-		response.Error(c, http.StatusNotFound, "用户不存在：请检查您的API Key是否有效")
-		return
-	}
-
-	// 预先验证所有任务并计算总消耗
-	totalConsume := 0
-	taskTypeCache := make(map[string]models.TaskType)
-
-	for i, taskReq := range req.Tasks {
-		// 查询任务类型
-		var taskType models.TaskType
-		if cached, ok := taskTypeCache[taskReq.TaskType]; ok {
-			taskType = cached
-		} else {
-			if err := h.db.Where("type_code = ? AND is_active = ?", taskReq.TaskType, true).First(&taskType).Error; err != nil {
-				response.Error(c, http.StatusBadRequest,
-					"第 "+strconv.Itoa(i+1)+" 个任务的任务类型无效："+taskReq.TaskType+" 不存在或已被禁用")
-				return
-			}
-			taskTypeCache[taskReq.TaskType] = taskType
-		}
-
-		// 验证关键词
-		if taskReq.TaskType == "search_browse" && taskReq.Keyword == "" {
-			response.Error(c, http.StatusBadRequest,
-				"第 "+strconv.Itoa(i+1)+" 个任务参数错误：关键词搜索任务必须填写关键词")
-			return
-		}
-
-		// 验证执行次数
-		if taskReq.ExecuteCount <= 0 {
-			response.Error(c, http.StatusBadRequest,
-				"第 "+strconv.Itoa(i+1)+" 个任务参数错误：执行次数必须大于0")
-			return
-		}
-
-		totalConsume += taskType.JingdouPrice * taskReq.ExecuteCount
+	specs := make([]services.TaskSpec, 0, len(req.Tasks))
+	for _, taskReq := range req.Tasks {
+		specs = append(specs, taskReq.spec())
 	}
 
-	// 检查余额
-	if user.JingdouBalance < totalConsume {
-		response.Error(c, http.StatusBadRequest,
-			"京豆余额不足：创建这 "+strconv.Itoa(len(req.Tasks))+" 个任务共需要 "+strconv.Itoa(totalConsume)+
-				" 京豆，您当前余额为 "+strconv.Itoa(user.JingdouBalance)+" 京豆，请先充值")
+	// 任务全部创建成功或全部失败
+	actor := services.TaskActor{UserID: c.GetUint("user_id"), Source: "API批量创建任务"}
+	result, err := services.NewTaskService(h.db).CreateTasks(c.Request.Context(), actor, specs)
+	if err != nil {
+		status, msg := taskCreateError(err, true)
+		response.Error(c, status, msg)
 		return
 	}
 
-	// 开始事务创建任务
-	tx := h.db.Begin()
-	createdTasks := make([]gin.H, 0)
-	failedTasks := make([]gin.H, 0)
-	successCount := 0
-	actualConsume := 0
-
-	for i, taskReq := range req.Tasks {
-		taskType := taskTypeCache[taskReq.TaskType]
-
-		// 非关键词搜索任务清空关键词
-		keyword := taskReq.Keyword
-		if taskReq.TaskType != "search_browse" {
-			keyword = ""
-		}
-
-		consumeJingdou := taskType.JingdouPrice * taskReq.ExecuteCount
-
-		task := models.Task{
-			UserID:         user.ID,
-			TaskType:       taskReq.TaskType,
-			SKU:            taskReq.SKU,
-			ShopName:       taskReq.ShopName,
-			Keyword:        keyword,
-			StartTime:      taskReq.StartTime,
-			ExecuteCount:   taskReq.ExecuteCount,
-			ExecutedCount:  0,
-			Priority:       taskReq.Priority,
-			Status:         "waiting",
-			ConsumeJingdou: consumeJingdou,
-			Remark:         taskReq.Remark,
-			CreatedAt:      time.Now(),
-			UpdatedAt:      time.Now(),
-		}
-
-		if err := tx.Create(&task).Error; err != nil {
-			failedTasks = append(failedTasks, gin.H{
-				"index":  i + 1,
-				"sku":    taskReq.SKU,
-				"reason": "创建失败：" + err.Error(),
-			})
-			continue
-		}
-
-		// 创建京豆日志
-		jingdouLog := models.JingdouLog{
-			UserID:        user.ID,
-			Amount:        -consumeJingdou,
-			Balance:       user.JingdouBalance - actualConsume - consumeJingdou,
-			OperationType: "task",
-			RelatedID:     &task.ID,
-			Remark:        "API批量创建任务扣除 - SKU:" + task.SKU,
-			CreatedAt:     time.Now(),
-		}
-		tx.Create(&jingdouLog)
-
-		// 更新模板
-		UpdateOrCreateTemplate(tx, user.ID, task.TaskType, task.SKU, task.ShopName, task.Keyword, task.ExecuteCount)
-
+	createdTasks := make([]gin.H, 0, len(result.Tasks))
+	for _, task := range result.Tasks {
 		createdTasks = append(createdTasks, gin.H{
 			"task_id":         task.ID,
 			"sku":             task.SKU,
-			"consume_jingdou": consumeJingdou,
+			"consume_jingdou": task.ConsumeJingdou,
 		})
-		successCount++
-		actualConsume += consumeJingdou
-	}
-
-	// 扣除京豆
-	if actualConsume > 0 {
-		user.JingdouBalance -= actualConsume
-		tx.Save(&user)
 	}
 
-	tx.Commit()
-
 	response.Success(c, gin.H{
 		"total_submitted": len(req.Tasks),
-		"success_count":   successCount,
-		"failed_count":    len(failedTasks),
-		"total_consume":   actualConsume,
-		"balance":         user.JingdouBalance,
+		"success_count":   len(result.Tasks),
+		"failed_count":    0,
+		"total_consume":   result.Consume,
+		"balance":         result.Balance,
 		"created_tasks":   createdTasks,
-		"failed_tasks":    failedTasks,
-		"message":         "批量创建完成：成功 " + strconv.Itoa(successCount) + " 个，失败 " + strconv.Itoa(len(failedTasks)) + " 个",
+		"failed_tasks":    []gin.H{},
+		"message":         "批量创建完成：成功 " + strconv.Itoa(len(result.Tasks)) + " 个，失败 0 个",
 	})
 }
 
@@ -375,6 +176,7 @@ func (h *OpenAPIHandler) BatchCreateTasks(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Param page query int false "页码" default(1)
 // @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
 // @Param status query string false "任务状态(waiting/running/completed/failed/cancelled)"
 // @Param task_type query string false "任务类型"
 // @Param sku query string false "商品SKU（模糊搜索）"
@@ -387,14 +189,10 @@ func (h *OpenAPIHandler) BatchCreateTasks(c *gin.Context) {
 func (h *OpenAPIHandler) GetTasks(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
-
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
+	pagination, err := response.ParsePagination(c, "page_size", response.MaxPageSize)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	query := h.db.Model(&models.Task{}).Where("user_id = ?", userID)
@@ -433,13 +231,14 @@ func (h *OpenAPIHandler) GetTasks(c *gin.Context) {
 	}
 
 	// 计算总数
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	// 分页查询
 	var tasks []models.Task
-	offset := (page - 1) * pageSize
-	query.Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&tasks)
+	if err := response.Find(pagination, query, "created_at", &tasks, taskCursorKey); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
 	// 构建响应
 	items := make([]gin.H, 0)
@@ -451,6 +250,7 @@ func (h *OpenAPIHandler) GetTasks(c *gin.Context) {
 			"shop_name":       task.ShopName,
 			"keyword":         task.Keyword,
 			"start_time":      task.StartTime.Format(time.RFC3339),
+			"end_time":        task.EndTime,
 			"execute_count":   task.ExecuteCount,
 			"executed_count":  task.ExecutedCount,
 			"priority":        task.Priority,
@@ -462,13 +262,9 @@ func (h *OpenAPIHandler) GetTasks(c *gin.Context) {
 		})
 	}
 
-	response.Success(c, gin.H{
-		"items":     items,
-		"page":      page,
-		"page_size": pageSize,
-		"total":     total,
-		"pages":     (int(total) + pageSize - 1) / pageSize,
-	})
+	data := pagination.Meta("page_size")
+	data["items"] = items
+	response.Success(c, data)
 }
 
 // GetTaskByID 查询单个任务详情
@@ -505,6 +301,7 @@ func (h *OpenAPIHandler) GetTaskByID(c *gin.Context) {
 		"shop_name":       task.ShopName,
 		"keyword":         task.Keyword,
 		"start_time":      task.StartTime.Format(time.RFC3339),
+		"end_time":        task.EndTime,
 		"execute_count":   task.ExecuteCount,
 		"executed_count":  task.ExecutedCount,
 		"priority":        task.Priority,
@@ -608,6 +405,7 @@ func (h *OpenAPIHandler) GetBalance(c *gin.Context) {
 // @Security ApiKeyAuth
 // @Param page query int false "页码" default(1)
 // @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
 // @Param type query string false "类型(task/recharge/refund/admin)"
 // @Param start_date query string false "开始日期(YYYY-MM-DD)"
 // @Param end_date query string false "结束日期(YYYY-MM-DD)"
@@ -616,14 +414,10 @@ func (h *OpenAPIHandler) GetBalance(c *gin.Context) {
 func (h *OpenAPIHandler) GetJingdouRecords(c *gin.Context) {
 	userID, _ := c.Get("user_id")
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
-
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
+	pagination, err := response.ParsePagination(c, "page_size", response.MaxPageSize)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	query := h.db.Model(&models.JingdouLog{}).Where("user_id = ?", userID)
@@ -641,11 +435,13 @@ func (h *OpenAPIHandler) GetJingdouRecords(c *gin.Context) {
 		query = query.Where("DATE(created_at) <= ?", endDate)
 	}
 
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	var logs []models.JingdouLog
-	query.Order("created_at DESC").Offset((page - 1) * pageSize).Limit(pageSize).Find(&logs)
+	if err := response.Find(pagination, query, "created_at", &logs, jingdouLogCursorKey); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
 	items := make([]gin.H, 0)
 	for _, log := range logs {
@@ -660,13 +456,9 @@ func (h *OpenAPIHandler) GetJingdouRecords(c *gin.Context) {
 		})
 	}
 
-	response.Success(c, gin.H{
-		"items":     items,
-		"page":      page,
-		"page_size": pageSize,
-		"total":     total,
-		"pages":     (int(total) + pageSize - 1) / pageSize,
-	})
+	data := pagination.Meta("page_size")
+	data["items"] = items
+	response.Success(c, data)
 }
 
 // =========================================
@@ -723,17 +515,8 @@ func (h *OpenAPIHandler) UpdateTask(c *gin.Context) {
 	}
 
 	// 只能修改等待中的任务
-	if task.Status != "waiting" {
-		statusMap := map[string]string{
-			"running":   "执行中",
-			"completed": "已完成",
-			"failed":    "已失败",
-			"cancelled": "已取消",
-		}
-		statusName := statusMap[task.Status]
-		if statusName == "" {
-			statusName = task.Status
-		}
+	if task.Status != taskstate.Waiting {
+		statusName := taskstate.Text(task.Status)
 		response.Error(c, http.StatusBadRequest, "无法修改：只能修改等待中的任务，当前任务状态为"+statusName)
 		return
 	}
@@ -799,58 +582,27 @@ func (h *OpenAPIHandler) CancelTask(c *gin.Context) {
 	}
 
 	// 只能取消等待中的任务
-	if task.Status != "waiting" {
-		statusMap := map[string]string{
-			"running":   "执行中",
-			"completed": "已完成",
-			"failed":    "已失败",
-			"cancelled": "已取消",
-		}
-		statusName := statusMap[task.Status]
-		if statusName == "" {
-			statusName = task.Status
-		}
+	if task.Status != taskstate.Waiting {
+		statusName := taskstate.Text(task.Status)
 		response.Error(c, http.StatusBadRequest, "无法取消：只能取消等待中的任务，当前任务状态为"+statusName)
 		return
 	}
 
-	// 获取用户
-	var user models.User
-	h.db.First(&user, task.UserID)
-
-	// 退款
-	refundAmount := task.ConsumeJingdou
-	user.JingdouBalance += refundAmount
-
-	tx := h.db.Begin()
-
-	// 更新任务状态
-	task.Status = "cancelled"
-	task.UpdatedAt = time.Now()
-	tx.Save(&task)
-
-	// 更新用户余额
-	tx.Save(&user)
-
-	// 记录京豆日志
-	if refundAmount > 0 {
-		jingdouLog := models.JingdouLog{
-			UserID:        user.ID,
-			Amount:        refundAmount,
-			Balance:       user.JingdouBalance,
-			OperationType: "refund",
-			RelatedID:     &task.ID,
-			Remark:        "API取消任务退款 - SKU:" + task.SKU,
-			CreatedAt:     time.Now(),
-		}
-		tx.Create(&jingdouLog)
+	result, err := taskstate.Transition(h.db, &task, taskstate.Change{
+		To:           taskstate.Cancelled,
+		Actor:        taskstate.ActorUser,
+		ActorID:      &task.UserID,
+		Reason:       "API取消任务",
+		RefundRemark: "API取消任务退款 - SKU:" + task.SKU,
+	})
+	if err != nil {
+		transitionError(c, err, "取消任务失败")
+		return
 	}
 
-	tx.Commit()
-
 	response.SuccessWithMsg(c, "任务取消成功，京豆已退还", gin.H{
 		"task_id":        task.ID,
-		"refund_jingdou": refundAmount,
-		"balance":        user.JingdouBalance,
+		"refund_jingdou": result.Refund,
+		"balance":        result.Balance,
 	})
 }
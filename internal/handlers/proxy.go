@@ -1,8 +1,9 @@
 package handlers
 
 import (
-	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -11,8 +12,10 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 
 	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
 	"jd-task-platform-go/pkg/response"
 	"jd-task-platform-go/pkg/utils"
 )
@@ -34,22 +37,6 @@ func generateClashQRCodeURL(proxyID uint, backendHost string) string {
 	return ""
 }
 
-// generateSocksShareURL 生成 SOCKS5 分享链接
-// 格式：socks://base64(用户名:密码@IP:端口)#节点名称
-func generateSocksShareURL(ip string, port int, username, password string) string {
-	// 构造认证信息：用户名:密码@IP:端口
-	authInfo := fmt.Sprintf("%s:%s@%s:%d", username, password, ip, port)
-
-	// Base64 编码
-	base64Auth := base64.StdEncoding.EncodeToString([]byte(authInfo))
-
-	// 构造节点名称
-	nodeName := fmt.Sprintf("SOCKS5-%s", ip)
-
-	// 构造最终的 socks:// URL
-	return fmt.Sprintf("socks://%s#%s", base64Auth, url.QueryEscape(nodeName))
-}
-
 // getBackendHost 获取后端服务器地址
 // 从环境变量或请求头中获取
 func getBackendHost(c *gin.Context) string {
@@ -69,7 +56,7 @@ func getBackendHost(c *gin.Context) string {
 
 // GetProxies 获取代理列表
 // @Summary 获取代理列表
-// @Description 获取SK5代理池列表（分页）
+// @Description 获取代理池列表（分页）
 // @Tags 代理管理
 // @Accept json
 // @Produce json
@@ -77,60 +64,46 @@ func getBackendHost(c *gin.Context) string {
 // @Param page query int false "页码" default(1)
 // @Param page_size query int false "每页数量" default(20)
 // @Param is_active query bool false "是否激活"
+// @Param health_status query string false "健康状态(unknown/healthy/unhealthy/quarantined)"
+// @Param protocol query string false "代理协议(socks5/http/https)"
 // @Param keyword query string false "关键词搜索(IP/备注)"
+// @Param province query string false "代理省份"
+// @Param isp query string false "代理运营商"
+// @Param group_name query string false "代理分组"
+// @Param tag query string false "标签"
+// @Param supplier_id query int false "供应商ID"
 // @Success 200 {object} response.Response{data=object}
 // @Router /proxies [get]
 func (h *ProxyHandler) GetProxies(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
-	isActiveStr := c.Query("is_active")
-	keyword := c.Query("keyword")
-
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
-	}
+	// 按使用次数排序，无法使用游标分页
+	pagination := response.ParsePage(c, "page_size", response.MaxPageSize)
 
-	offset := (page - 1) * pageSize
-
-	query := h.db.Model(&models.Proxy{})
-
-	// 激活状态过滤
-	if isActiveStr != "" {
-		isActive, _ := strconv.ParseBool(isActiveStr)
-		query = query.Where("is_active = ?", isActive)
-	}
-
-	// 关键词搜索
-	if keyword != "" {
-		query = query.Where("ip LIKE ? OR remark LIKE ?",
-			"%"+keyword+"%", "%"+keyword+"%")
-	}
+	query := services.ApplyProxyFilter(h.db.Model(&models.Proxy{}), proxyFilterFromQuery(c))
 
 	// 获取总数
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	// 获取列表
 	var proxies []models.Proxy
 	query.Order("usage_count ASC, id ASC").
-		Offset(offset).
-		Limit(pageSize).
+		Offset(pagination.Offset()).
+		Limit(pagination.PageSize).
 		Find(&proxies)
 
-	response.Success(c, gin.H{
-		"proxies":   proxies,
-		"total":     total,
-		"page":      page,
-		"page_size": pageSize,
-	})
+	items := make([]*models.Proxy, len(proxies))
+	for i := range proxies {
+		items[i] = &proxies[i]
+	}
+	h.setProxyQRCode(c, items...)
+
+	data := pagination.Meta("page_size")
+	data["proxies"] = proxies
+	response.Success(c, data)
 }
 
 // GetProxyByID 获取代理详情
 // @Summary 获取代理详情
-// @Description 根据ID获取代理详细信息
+// @Description 根据ID获取代理详细信息、最近的使用记录和最近7天的使用分析（outcome，没有使用记录时为空）
 // @Tags 代理管理
 // @Accept json
 // @Produce json
@@ -151,6 +124,8 @@ func (h *ProxyHandler) GetProxyByID(c *gin.Context) {
 		return
 	}
 
+	h.setProxyQRCode(c, &proxy)
+
 	// 查询使用记录
 	var usageLogs []models.ProxyUsageLog
 	h.db.Where("proxy_id = ?", proxy.ID).
@@ -158,16 +133,28 @@ func (h *ProxyHandler) GetProxyByID(c *gin.Context) {
 		Limit(10).
 		Find(&usageLogs)
 
+	// 最近7天的使用和任务执行情况
+	var outcome *models.ProxyOutcome
+	if report, err := services.ProxyOutcomes(h.db, time.Now().AddDate(0, 0, -defaultProxyAnalyticsDays)); err == nil {
+		for i := range report.Proxies {
+			if report.Proxies[i].ProxyID == proxy.ID {
+				outcome = &report.Proxies[i]
+				break
+			}
+		}
+	}
+
 	response.Success(c, gin.H{
 		"proxy":       proxy,
 		"usage_logs":  usageLogs,
 		"usage_count": len(usageLogs),
+		"outcome":     outcome,
 	})
 }
 
 // CreateProxy 创建代理
 // @Summary 创建代理
-// @Description 创建新的SK5代理
+// @Description 创建新的代理，协议为 socks5（默认）、http 或 https，无认证的代理用户名密码留空
 // @Tags 代理管理
 // @Accept json
 // @Produce json
@@ -189,36 +176,50 @@ func (h *ProxyHandler) CreateProxy(c *gin.Context) {
 		return
 	}
 
+	protocol := req.Protocol
+	if protocol == "" {
+		protocol = utils.ProxyProtocolSOCKS5
+	}
+	tags, err := services.NormalizeProxyTags(req.Tags)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	// 查询IP地理位置
 	location, _ := utils.QueryIPLocationWithFallback(req.IP)
 
-	// 创建代理（先保存以获取ID）
+	// 创建代理
 	proxy := models.Proxy{
 		IP:         req.IP,
 		Port:       req.Port,
+		Protocol:   protocol,
 		Username:   req.Username,
 		Password:   req.Password,
 		Province:   location.Province,
 		City:       location.City,
 		ISP:        location.ISP,
 		Remark:     req.Remark,
-		QRCodeURL:  "", // 先留空
+		GroupName:  strings.TrimSpace(req.GroupName),
+		Tags:       tags,
 		UsageCount: 0,
 		IsActive:   true,
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 	}
 
-	if err := h.db.Create(&proxy).Error; err != nil {
+	// 并发创建同一代理时由 IP+端口唯一索引兜底
+	res := h.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&proxy)
+	if res.Error != nil {
 		response.Error(c, http.StatusInternalServerError, "创建失败")
 		return
 	}
+	if res.RowsAffected == 0 {
+		response.Error(c, http.StatusBadRequest, "该代理已存在")
+		return
+	}
 
-	// 生成 SOCKS5 分享链接
-	proxy.QRCodeURL = generateSocksShareURL(proxy.IP, proxy.Port, proxy.Username, proxy.Password)
-
-	// 更新二维码 URL
-	h.db.Model(&proxy).Update("qr_code_url", proxy.QRCodeURL)
+	h.setProxyQRCode(c, &proxy)
 
 	response.SuccessWithMsg(c, "代理创建成功", proxy)
 }
@@ -249,11 +250,30 @@ func (h *ProxyHandler) UpdateProxy(c *gin.Context) {
 		return
 	}
 
+	// 修改 IP 或端口时不能与其他代理重复
+	if req.IP != nil || req.Port != nil {
+		ip, port := proxy.IP, proxy.Port
+		if req.IP != nil {
+			ip = *req.IP
+		}
+		if req.Port != nil {
+			port = *req.Port
+		}
+		var count int64
+		if err := h.db.Model(&models.Proxy{}).Where("ip = ? AND port = ? AND id <> ?", ip, port, proxy.ID).
+			Count(&count).Error; err != nil {
+			response.Error(c, http.StatusInternalServerError, "更新失败")
+			return
+		}
+		if count > 0 {
+			response.Error(c, http.StatusBadRequest, "已存在相同IP和端口的代理")
+			return
+		}
+	}
+
 	// 更新字段
-	needRegenerateQRCode := false
 	if req.IP != nil {
 		proxy.IP = *req.IP
-		needRegenerateQRCode = true
 		// 重新查询地理位置
 		location, _ := utils.QueryIPLocationWithFallback(*req.IP)
 		proxy.Province = location.Province
@@ -262,35 +282,50 @@ func (h *ProxyHandler) UpdateProxy(c *gin.Context) {
 	}
 	if req.Port != nil {
 		proxy.Port = *req.Port
-		needRegenerateQRCode = true
+	}
+	if req.Protocol != nil {
+		proxy.Protocol = *req.Protocol
 	}
 	if req.Username != nil {
 		proxy.Username = *req.Username
-		needRegenerateQRCode = true
 	}
 	if req.Password != nil {
 		proxy.Password = *req.Password
-		needRegenerateQRCode = true
 	}
 	if req.Remark != nil {
 		proxy.Remark = *req.Remark
 	}
+	if req.GroupName != nil {
+		proxy.GroupName = strings.TrimSpace(*req.GroupName)
+	}
+	if req.Tags != nil {
+		tags, err := services.NormalizeProxyTags(*req.Tags)
+		if err != nil {
+			response.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		proxy.Tags = tags
+	}
 	if req.IsActive != nil {
 		proxy.IsActive = *req.IsActive
-	}
-
-	// 如果代理信息变更，重新生成二维码 URL
-	if needRegenerateQRCode {
-		proxy.QRCodeURL = generateSocksShareURL(proxy.IP, proxy.Port, proxy.Username, proxy.Password)
+		// 手动启用或停用后解除隔离：手动启用的代理重新计算连续失败，手动停用的代理不再自动复查
+		if proxy.QuarantinedAt != nil {
+			proxy.QuarantinedAt = nil
+			proxy.ConsecutiveFailures = 0
+			proxy.HealthStatus = services.ProxyHealthUnknown
+		}
 	}
 
 	proxy.UpdatedAt = time.Now()
 
-	if err := h.db.Save(&proxy).Error; err != nil {
+	// 计数字段由分配和健康检查并发累加，不用查询时的旧值覆盖
+	if err := h.db.Omit("usage_count", "lease_count", "check_count", "success_count").Save(&proxy).Error; err != nil {
 		response.Error(c, http.StatusInternalServerError, "更新失败")
 		return
 	}
 
+	h.setProxyQRCode(c, &proxy)
+
 	response.SuccessWithMsg(c, "代理更新成功", proxy)
 }
 
@@ -313,8 +348,9 @@ func (h *ProxyHandler) DeleteProxy(c *gin.Context) {
 		return
 	}
 
-	// 删除使用记录
+	// 删除使用记录和租约
 	h.db.Where("proxy_id = ?", id).Delete(&models.ProxyUsageLog{})
+	h.db.Where("proxy_id = ?", id).Delete(&models.ProxyLease{})
 
 	// 删除代理
 	if err := h.db.Delete(&proxy).Error; err != nil {
@@ -347,8 +383,9 @@ func (h *ProxyHandler) BatchDeleteProxies(c *gin.Context) {
 		return
 	}
 
-	// 删除使用记录
+	// 删除使用记录和租约
 	h.db.Where("proxy_id IN ?", req.IDs).Delete(&models.ProxyUsageLog{})
+	h.db.Where("proxy_id IN ?", req.IDs).Delete(&models.ProxyLease{})
 
 	// 批量删除代理
 	result := h.db.Where("id IN ?", req.IDs).Delete(&models.Proxy{})
@@ -362,7 +399,11 @@ func (h *ProxyHandler) BatchDeleteProxies(c *gin.Context) {
 
 // BatchImportProxies 批量导入代理
 // @Summary 批量导入代理
-// @Description 批量导入SK5代理，格式：IP|Port|Username|Password (每行一条)
+// @Description 批量导入代理，每行一条，支持以下格式（用户名密码均可省略）：
+// @Description socks5://user:pass@IP:Port、http://user:pass@IP:Port、https://user:pass@IP:Port、user:pass@IP:Port、
+// @Description IP|Port|Username|Password、IP:Port:Username:Password、IP|Port、IP:Port。
+// @Description 未写协议的行使用 protocol 参数指定的协议（默认 socks5）。按 IP:端口去重：列表中重复的行只导入第一行，
+// @Description 已存在的代理在 mode=skip（默认）时跳过，mode=update 时更新协议和账号密码，并设置分组、追加标签
 // @Tags 代理管理
 // @Accept json
 // @Produce json
@@ -377,84 +418,144 @@ func (h *ProxyHandler) BatchImportProxies(c *gin.Context) {
 		return
 	}
 
+	defaultProtocol := req.Protocol
+	if defaultProtocol == "" {
+		defaultProtocol = utils.ProxyProtocolSOCKS5
+	}
+	tags, err := services.NormalizeProxyTags(req.Tags)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
 	lines := strings.Split(req.ProxyList, "\n")
-	successCount := 0
+	createdCount := 0
+	updatedCount := 0
+	skippedCount := 0
 	failCount := 0
 	var errors []string
+	seen := map[string]int{}
 
 	for i, line := range lines {
 		line = strings.TrimSpace(line)
 		if line == "" {
 			continue
 		}
+		lineNo := "第" + strconv.Itoa(i+1) + "行: "
 
 		// 解析代理行
-		ip, port, username, password, err := utils.ParseProxyLine(line)
+		parsed, err := utils.ParseProxyLine(line, defaultProtocol)
 		if err != nil {
 			failCount++
-			errors = append(errors, "第"+strconv.Itoa(i+1)+"行: "+err.Error())
+			errors = append(errors, lineNo+err.Error())
 			continue
 		}
 
-		// 检查是否已存在
-		var existing models.Proxy
-		if err := h.db.Where("ip = ? AND port = ?", ip, port).First(&existing).Error; err == nil {
-			failCount++
-			errors = append(errors, "第"+strconv.Itoa(i+1)+"行: 代理已存在")
+		// 列表内按 IP:端口去重
+		key := net.JoinHostPort(parsed.IP, strconv.Itoa(parsed.Port))
+		if first, ok := seen[key]; ok {
+			skippedCount++
+			errors = append(errors, lineNo+"与第"+strconv.Itoa(first)+"行重复，已跳过")
 			continue
 		}
+		seen[key] = i + 1
 
-		// 查询IP地理位置（不阻塞）
-		location, _ := utils.QueryIPLocationWithFallback(ip)
+		// 已存在的代理跳过或更新
+		var existing models.Proxy
+		found := h.db.Select("id", "tags").Where("ip = ? AND port = ?", parsed.IP, parsed.Port).First(&existing).Error == nil
+		if found && req.Mode != "update" {
+			skippedCount++
+			errors = append(errors, lineNo+"代理已存在，已跳过")
+			continue
+		}
 
-		// 创建代理（先保存以获取ID）
 		proxy := models.Proxy{
-			IP:         ip,
-			Port:       port,
-			Username:   username,
-			Password:   password,
-			Province:   location.Province,
-			City:       location.City,
-			ISP:        location.ISP,
-			QRCodeURL:  "", // 先留空
+			IP:         parsed.IP,
+			Port:       parsed.Port,
+			Protocol:   parsed.Protocol,
+			Username:   parsed.Username,
+			Password:   parsed.Password,
+			Tags:       tags,
 			UsageCount: 0,
 			IsActive:   true,
 			CreatedAt:  time.Now(),
 			UpdatedAt:  time.Now(),
 		}
+		if req.GroupName != nil {
+			proxy.GroupName = strings.TrimSpace(*req.GroupName)
+		}
+		if found {
+			// 已存在的代理合并标签
+			if proxy.Tags, err = services.NormalizeProxyTags(append(services.SplitProxyList(existing.Tags), tags)); err != nil {
+				failCount++
+				errors = append(errors, lineNo+err.Error())
+				continue
+			}
+		} else {
+			// 查询IP地理位置（不阻塞）
+			location, _ := utils.QueryIPLocationWithFallback(parsed.IP)
+			proxy.Province = location.Province
+			proxy.City = location.City
+			proxy.ISP = location.ISP
+		}
 
-		if err := h.db.Create(&proxy).Error; err != nil {
+		// 按 IP+端口唯一索引写入：跳过模式下已存在的不写入，更新模式下更新协议、账号密码、分组和标签
+		conflict := clause.OnConflict{DoNothing: true}
+		if req.Mode == "update" {
+			columns := []string{"protocol", "username", "password", "tags", "updated_at"}
+			if req.GroupName != nil {
+				columns = append(columns, "group_name")
+			}
+			conflict = clause.OnConflict{
+				Columns:   []clause.Column{{Name: "ip"}, {Name: "port"}},
+				DoUpdates: clause.AssignmentColumns(columns),
+			}
+		}
+		res := h.db.Clauses(conflict).Create(&proxy)
+		switch {
+		case res.Error != nil:
 			failCount++
-			errors = append(errors, "第"+strconv.Itoa(i+1)+"行: 创建失败")
-			continue
+			errors = append(errors, lineNo+"导入失败")
+		case res.RowsAffected == 0:
+			// 查询后被并发创建
+			skippedCount++
+			errors = append(errors, lineNo+"代理已存在，已跳过")
+		case found:
+			updatedCount++
+		default:
+			createdCount++
 		}
-
-		// 生成 SOCKS5 分享链接
-		proxy.QRCodeURL = generateSocksShareURL(proxy.IP, proxy.Port, proxy.Username, proxy.Password)
-
-		// 更新二维码 URL
-		h.db.Model(&proxy).Update("qr_code_url", proxy.QRCodeURL)
-
-		successCount++
 	}
 
 	response.Success(c, gin.H{
-		"success_count": successCount,
+		"success_count": createdCount + updatedCount,
+		"created_count": createdCount,
+		"updated_count": updatedCount,
+		"skipped_count": skippedCount,
 		"fail_count":    failCount,
 		"errors":        errors,
 		"message":       "批量导入完成",
 	})
 }
 
-// AssignProxy 为设备分配代理（均衡分配算法）
+// AssignProxy 为设备分配代理
 // @Summary 为设备分配代理
-// @Description 为设备分配SK5代理，采用最少使用次数优先的均衡分配算法
+// @Description 为设备分配代理。设备在租约有效期内重复申请时返回同一个代理，保证同一会话内IP不变；
+// @Description 新分配时选择租用设备最少、使用次数最少的代理。租约时长由 proxy_lease_minutes 配置（默认30分钟），
+// @Description 每个代理同时租给的设备数由 proxy_max_devices 配置（默认1，即独占，0 表示不限制）
+// @Description 新分配时按地区优先级选择：请求指定的省份/城市/运营商 → 任务类型的代理要求 → 设备所在城市 → 设备所在省份 → 不限地区，
+// @Description 任务类型设置为必须匹配时不降级，无匹配代理返回404。选择依据记录在 match_type 和 reason 中。
+// @Description 任务类型或设备所在的设备分组绑定了代理分组时（任务类型优先），只在该代理分组中分配，分组内无可用代理返回404。
+// @Description protocol 为客户端可使用的代理协议（socks5/http/https，any 表示不限），未填写时只分配 socks5 代理
+// @Description 轮换策略：proxy_max_daily_assignments 每个代理每天最多分配次数，proxy_max_distinct_devices 每个代理最多分配给的不同设备数，
+// @Description proxy_cooldown_minutes 代理释放后的冷却时间，达到上限或冷却中的代理不再分配；proxy_max_hold_minutes 设备连续使用同一代理的最长时间，
+// @Description 达到后设备在心跳、请求任务和长轮询时收到 rotate_proxy 指令，重新申请时分配其他代理（均默认0，不限制）
 // @Tags 代理管理
 // @Accept json
 // @Produce json
 // @Param request body models.ProxyAssignRequest true "设备信息"
 // @Success 200 {object} response.Response{data=models.ProxyAssignResponse}
-// @Router /proxies/assign [post]
+// @Router /proxy/assign [post]
 func (h *ProxyHandler) AssignProxy(c *gin.Context) {
 	var req models.ProxyAssignRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -462,51 +563,94 @@ func (h *ProxyHandler) AssignProxy(c *gin.Context) {
 		return
 	}
 
-	// 查找使用次数最少的激活代理（均衡分配）
-	var proxy models.Proxy
-	if err := h.db.Where("is_active = ?", true).
-		Order("usage_count ASC, id ASC").
-		First(&proxy).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			response.Error(c, http.StatusNotFound, "暂无可用代理")
+	lease, proxy, err := services.AssignProxyLease(h.db, req)
+	if err != nil {
+		if errors.Is(err, services.ErrNoProxyAvailable) || errors.Is(err, services.ErrNoMatchingProxy) ||
+			errors.Is(err, services.ErrNoGroupProxy) {
+			response.Error(c, http.StatusNotFound, err.Error())
 			return
 		}
-		response.Error(c, http.StatusInternalServerError, "查询失败")
+		response.Error(c, http.StatusInternalServerError, "代理分配失败")
 		return
 	}
 
-	// 更新使用次数
-	proxy.UsageCount++
-	proxy.UpdatedAt = time.Now()
-	h.db.Save(&proxy)
-
-	// 记录使用日志
-	usageLog := models.ProxyUsageLog{
-		ProxyID:    proxy.ID,
-		DeviceID:   req.DeviceID,
-		DeviceSN:   req.DeviceSN,
-		IP:         proxy.IP,
-		Port:       proxy.Port,
-		AssignedAt: time.Now(),
-		CreatedAt:  time.Now(),
+	response.Success(c, models.ProxyAssignResponse{
+		IP:             proxy.IP,
+		Port:           proxy.Port,
+		Protocol:       proxy.Protocol,
+		Username:       proxy.Username,
+		Password:       proxy.Password,
+		ProxyID:        proxy.ID,
+		LeaseExpiresAt: lease.ExpiresAt,
+		MatchType:      lease.MatchType,
+		Reason:         lease.Reason,
+	})
+}
+
+// RenewProxyLease 续租代理
+// @Summary 续租代理
+// @Description 将设备当前代理租约的到期时间从现在起顺延一个租约时长。租约已到期、代理已停用或代理达到轮换条件时
+// @Description 释放租约并返回404，设备需重新申请代理
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Param request body models.ProxyLeaseRequest true "设备信息"
+// @Success 200 {object} response.Response{data=object}
+// @Router /proxy/renew [post]
+func (h *ProxyHandler) RenewProxyLease(c *gin.Context) {
+	var req models.ProxyLeaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
 	}
-	h.db.Create(&usageLog)
 
-	// 返回代理信息
-	assignResp := models.ProxyAssignResponse{
-		IP:       proxy.IP,
-		Port:     proxy.Port,
-		Username: proxy.Username,
-		Password: proxy.Password,
-		ProxyID:  proxy.ID,
+	lease, err := services.RenewProxyLease(h.db, req.DeviceID)
+	if err != nil {
+		if errors.Is(err, services.ErrProxyLeaseNotFound) || errors.Is(err, services.ErrProxyRotate) {
+			response.Error(c, http.StatusNotFound, err.Error())
+			return
+		}
+		response.Error(c, http.StatusInternalServerError, "续租失败")
+		return
 	}
 
-	response.Success(c, assignResp)
+	response.SuccessWithMsg(c, "续租成功", gin.H{
+		"proxy_id":         lease.ProxyID,
+		"lease_expires_at": lease.ExpiresAt,
+	})
+}
+
+// ReleaseProxyLease 释放代理
+// @Summary 释放代理
+// @Description 设备结束会话后主动释放代理租约，代理可分配给其他设备
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Param request body models.ProxyLeaseRequest true "设备信息"
+// @Success 200 {object} response.Response
+// @Router /proxy/release [post]
+func (h *ProxyHandler) ReleaseProxyLease(c *gin.Context) {
+	var req models.ProxyLeaseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if err := services.ReleaseProxyLease(h.db, req.DeviceID); err != nil {
+		if errors.Is(err, services.ErrProxyLeaseNotFound) {
+			response.Error(c, http.StatusNotFound, err.Error())
+			return
+		}
+		response.Error(c, http.StatusInternalServerError, "释放失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "代理已释放", nil)
 }
 
 // GetProxyStatistics 获取代理统计信息
 // @Summary 获取代理统计信息
-// @Description 获取代理池的统计数据
+// @Description 获取代理池的统计数据，包括最近7天经代理执行的任务失败率和失败率异常的代理数
 // @Tags 代理管理
 // @Accept json
 // @Produce json
@@ -533,9 +677,67 @@ func (h *ProxyHandler) GetProxyStatistics(c *gin.Context) {
 		stats.AvgUsage = float64(stats.TotalUsage) / float64(stats.TotalCount)
 	}
 
+	// 健康状态分布
+	var health []struct {
+		HealthStatus string
+		Count        int64
+	}
+	h.db.Model(&models.Proxy{}).Select("health_status, COUNT(*) AS count").Group("health_status").Scan(&health)
+	for _, row := range health {
+		switch row.HealthStatus {
+		case services.ProxyHealthHealthy:
+			stats.HealthyCount = row.Count
+		case services.ProxyHealthUnhealthy:
+			stats.UnhealthyCount = row.Count
+		case services.ProxyHealthQuarantined:
+			stats.QuarantinedCount = row.Count
+		}
+	}
+
+	// 健康代理的平均延迟
+	h.db.Model(&models.Proxy{}).Where("health_status = ?", services.ProxyHealthHealthy).
+		Select("COALESCE(AVG(latency_ms), 0)").Scan(&stats.AvgLatencyMs)
+
+	// 最近7天的任务失败率和失败率异常的代理数
+	if report, err := services.ProxyOutcomes(h.db, time.Now().AddDate(0, 0, -defaultProxyAnalyticsDays)); err == nil {
+		stats.TaskFailureRate = report.FailureRate
+		stats.AnomalousCount = int64(report.AnomalousCount)
+	}
+
 	response.Success(c, stats)
 }
 
+// CheckProxy 立即检查代理
+// @Summary 立即检查代理
+// @Description 对代理执行一次健康检查（SOCKS5 握手和认证，配置了 proxy_check_url 时再通过代理访问该地址），
+// @Description 结果计入连续失败次数，达到阈值时自动停用；隔离中的代理检查成功后恢复启用
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "代理ID"
+// @Success 200 {object} response.Response{data=models.ProxyCheckResult}
+// @Router /proxies/{id}/check [post]
+func (h *ProxyHandler) CheckProxy(c *gin.Context) {
+	var proxy models.Proxy
+	if err := h.db.First(&proxy, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "代理不存在")
+		return
+	}
+
+	result, err := services.CheckAndRecordProxy(h.db, &proxy, services.ProxyCheckURL(h.db))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "记录检查结果失败")
+		return
+	}
+
+	msg := "代理可用"
+	if !result.OK {
+		msg = "代理不可用"
+	}
+	response.SuccessWithMsg(c, msg, result)
+}
+
 // GetProxyUsageLogs 获取代理使用记录
 // @Summary 获取代理使用记录
 // @Description 获取代理分配使用记录
@@ -545,25 +747,20 @@ func (h *ProxyHandler) GetProxyStatistics(c *gin.Context) {
 // @Security BearerAuth
 // @Param page query int false "页码" default(1)
 // @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
 // @Param proxy_id query int false "代理ID"
 // @Param device_id query int false "设备ID"
 // @Success 200 {object} response.Response{data=object}
 // @Router /proxies/usage-logs [get]
 func (h *ProxyHandler) GetProxyUsageLogs(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	pagination, err := response.ParsePagination(c, "page_size", response.MaxPageSize)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
 	proxyID := c.Query("proxy_id")
 	deviceID := c.Query("device_id")
 
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
-	}
-
-	offset := (page - 1) * pageSize
-
 	query := h.db.Model(&models.ProxyUsageLog{})
 
 	if proxyID != "" {
@@ -573,47 +770,220 @@ func (h *ProxyHandler) GetProxyUsageLogs(c *gin.Context) {
 		query = query.Where("device_id = ?", deviceID)
 	}
 
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	var logs []models.ProxyUsageLog
-	query.Order("assigned_at DESC").
-		Offset(offset).
-		Limit(pageSize).
-		Find(&logs)
+	if err := response.Find(pagination, query, "assigned_at", &logs, func(log *models.ProxyUsageLog) (time.Time, uint) {
+		return log.AssignedAt, log.ID
+	}); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
-	response.Success(c, gin.H{
-		"logs":      logs,
-		"total":     total,
-		"page":      page,
-		"page_size": pageSize,
-	})
+	data := pagination.Meta("page_size")
+	data["logs"] = logs
+	response.Success(c, data)
 }
 
-// GetV2rayConfig 获取代理的 v2rayN JSON 配置
-// @Summary 获取 v2rayN JSON 配置
-// @Description 返回指定代理的 v2rayN JSON 配置文件
+// GetProxyConfigLinks 生成代理配置下载链接
+// @Summary 生成代理配置下载链接
+// @Description 生成指定代理的 Clash/v2rayN 配置下载链接和二维码内容。链接使用签名令牌，
+// @Description 有效期由 minutes 参数指定，未指定时为系统设置 proxy_config_link_minutes（默认1440分钟）
 // @Tags 代理管理
 // @Produce json
+// @Security BearerAuth
 // @Param id path int true "代理ID"
-// @Success 200 {object} object "v2rayN JSON 配置"
-// @Router /proxies/{id}/v2ray-config [get]
-func (h *ProxyHandler) GetV2rayConfig(c *gin.Context) {
-	id := c.Param("id")
+// @Param minutes query int false "有效期（分钟）"
+// @Success 200 {object} response.Response{data=models.ProxyConfigLinks}
+// @Router /proxies/{id}/config-links [post]
+func (h *ProxyHandler) GetProxyConfigLinks(c *gin.Context) {
+	var proxy models.Proxy
+	if err := h.db.First(&proxy, c.Param("id")).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			response.Error(c, http.StatusNotFound, "代理不存在")
+			return
+		}
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
+
+	ttl := services.ProxyConfigLinkTTL(h.db)
+	if minutes, err := strconv.Atoi(c.Query("minutes")); err == nil && minutes > 0 {
+		ttl = time.Duration(minutes) * time.Minute
+	}
+
+	response.Success(c, proxyConfigLinks(c, &proxy, time.Now().Add(ttl)))
+}
+
+// GetProxyConfig 通过签名链接下载代理配置
+// @Summary 下载代理配置
+// @Description 通过配置下载链接获取代理的 Clash/Mihomo YAML 或 v2rayN JSON 配置，格式由链接决定。
+// @Description 链接由 POST /proxies/{id}/config-links 生成，每次访问（包括无效和过期的链接）都会记录
+// @Tags 代理管理
+// @Produce plain
+// @Produce json
+// @Param token path string true "配置链接令牌"
+// @Success 200 {string} string "配置文件"
+// @Failure 403 {string} string "链接无效或已过期"
+// @Router /proxy-config/{token} [get]
+func (h *ProxyHandler) GetProxyConfig(c *gin.Context) {
+	token, err := services.ParseProxyConfigToken(c.Param("token"))
+	if err != nil {
+		result := services.ProxyConfigAccessInvalid
+		if errors.Is(err, services.ErrProxyConfigTokenExpired) {
+			result = services.ProxyConfigAccessExpired
+		}
+		services.RecordProxyConfigAccess(h.db, token, result, c.ClientIP(), c.Request.UserAgent())
+		c.String(http.StatusForbidden, err.Error())
+		return
+	}
 
 	var proxy models.Proxy
-	if err := h.db.First(&proxy, id).Error; err != nil {
+	if err := h.db.First(&proxy, token.ProxyID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"error": "代理不存在"})
+			services.RecordProxyConfigAccess(h.db, token, services.ProxyConfigAccessNotFound, c.ClientIP(), c.Request.UserAgent())
+			c.String(http.StatusNotFound, "代理不存在")
 			return
 		}
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "查询失败"})
+		c.String(http.StatusInternalServerError, "查询失败")
 		return
 	}
+	services.RecordProxyConfigAccess(h.db, token, services.ProxyConfigAccessOK, c.ClientIP(), c.Request.UserAgent())
+
+	switch token.Format {
+	case services.ProxyConfigV2ray:
+		writeV2rayConfig(c, &proxy)
+	default:
+		writeClashConfig(c, &proxy)
+	}
+}
+
+// GetProxyConfigAccessLogs 获取配置下载链接的访问记录
+// @Summary 获取代理配置访问记录
+// @Description 获取代理配置下载链接的访问记录（分页），包括无效和过期链接的访问
+// @Tags 代理管理
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
+// @Param proxy_id query int false "代理ID"
+// @Param result query string false "访问结果(ok/invalid/expired/not_found)"
+// @Success 200 {object} response.Response{data=object}
+// @Router /proxies/config-access-logs [get]
+func (h *ProxyHandler) GetProxyConfigAccessLogs(c *gin.Context) {
+	pagination, err := response.ParsePagination(c, "page_size", response.MaxPageSize)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := h.db.Model(&models.ProxyConfigAccessLog{})
+	if proxyID := c.Query("proxy_id"); proxyID != "" {
+		query = query.Where("proxy_id = ?", proxyID)
+	}
+	if result := c.Query("result"); result != "" {
+		query = query.Where("result = ?", result)
+	}
 
+	pagination.Count(query)
+
+	var logs []models.ProxyConfigAccessLog
+	if err := response.Find(pagination, query, "created_at", &logs, func(log *models.ProxyConfigAccessLog) (time.Time, uint) {
+		return log.CreatedAt, log.ID
+	}); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
+
+	data := pagination.Meta("page_size")
+	data["logs"] = logs
+	response.Success(c, data)
+}
+
+// ReencryptProxyCredentials 用当前密钥重新加密代理凭据
+// @Summary 重新加密代理凭据
+// @Description 把未加密或使用旧密钥加密的代理账号密码用当前密钥重新加密。
+// @Description 轮换密钥时把新密钥加在 PROXY_CREDENTIAL_KEYS 最前面并重启（启动时也会自动重新加密），完成后即可移除旧密钥
+// @Tags 代理管理
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=object}
+// @Router /proxies/credentials/reencrypt [post]
+func (h *ProxyHandler) ReencryptProxyCredentials(c *gin.Context) {
+	if !utils.CredentialEncryptionEnabled() {
+		response.Error(c, http.StatusBadRequest, "未配置 "+utils.CredentialKeysEnv+"，无法加密代理凭据")
+		return
+	}
+
+	count, err := services.ReencryptProxyCredentials(h.db)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "重新加密失败: "+err.Error())
+		return
+	}
+
+	response.SuccessWithDataAndMsgf(c, gin.H{
+		"updated": count,
+		"key_id":  utils.CurrentCredentialKeyID(),
+	}, "已重新加密 %d 个代理的凭据", count)
+}
+
+// proxyConfigLinks 生成代理的配置下载链接，二维码内容为 Clash 导入链接
+func proxyConfigLinks(c *gin.Context, proxy *models.Proxy, expiresAt time.Time) models.ProxyConfigLinks {
+	base := getBackendHost(c) + "/api/proxy-config/"
+	clashURL := base + services.IssueProxyConfigToken(proxy.ID, services.ProxyConfigClash, expiresAt)
+	return models.ProxyConfigLinks{
+		ClashURL:  clashURL,
+		V2rayURL:  base + services.IssueProxyConfigToken(proxy.ID, services.ProxyConfigV2ray, expiresAt),
+		QRCodeURL: "clash://install-config?url=" + url.QueryEscape(clashURL),
+		ShareURL:  utils.ProxyShareURL(services.ProxyProtocol(proxy), proxy.IP, proxy.Port, proxy.Username, proxy.Password),
+		ExpiresAt: expiresAt,
+	}
+}
+
+// setProxyQRCode 填充代理的二维码内容（默认有效期的 Clash 导入链接）
+func (h *ProxyHandler) setProxyQRCode(c *gin.Context, proxies ...*models.Proxy) {
+	expiresAt := time.Now().Add(services.ProxyConfigLinkTTL(h.db))
+	for _, proxy := range proxies {
+		proxy.QRCodeURL = proxyConfigLinks(c, proxy, expiresAt).QRCodeURL
+	}
+}
+
+// writeV2rayConfig 输出代理的 v2rayN JSON 配置
+func writeV2rayConfig(c *gin.Context, proxy *models.Proxy) {
 	// 构造 v2rayN 支持的 JSON 配置
 	// 参考：https://github.com/2dust/v2rayN
-	// v2rayN 支持 HTTP/SOCKS 代理配置
+	// v2rayN 支持 HTTP/SOCKS 代理配置，HTTPS 代理为 http 出站加 TLS
+	server := gin.H{
+		"address": proxy.IP,
+		"port":    proxy.Port,
+	}
+	if proxy.Username != "" {
+		server["users"] = []gin.H{
+			{
+				"user": proxy.Username,
+				"pass": proxy.Password,
+			},
+		}
+	}
+
+	outbound := gin.H{
+		"protocol": "socks",
+		"settings": gin.H{
+			"servers": []gin.H{server},
+		},
+		"tag": services.ProxyNodeName(proxy),
+	}
+	switch proxy.Protocol {
+	case utils.ProxyProtocolHTTP:
+		outbound["protocol"] = "http"
+	case utils.ProxyProtocolHTTPS:
+		outbound["protocol"] = "http"
+		outbound["streamSettings"] = gin.H{
+			"security": "tls",
+		}
+	}
+
 	config := gin.H{
 		"log": gin.H{
 			"loglevel": "warning",
@@ -633,26 +1003,7 @@ func (h *ProxyHandler) GetV2rayConfig(c *gin.Context) {
 				"settings": gin.H{},
 			},
 		},
-		"outbounds": []gin.H{
-			{
-				"protocol": "socks",
-				"settings": gin.H{
-					"servers": []gin.H{
-						{
-							"address": proxy.IP,
-							"port":    proxy.Port,
-							"users": []gin.H{
-								{
-									"user": proxy.Username,
-									"pass": proxy.Password,
-								},
-							},
-						},
-					},
-				},
-				"tag": fmt.Sprintf("SOCKS5代理-%s", proxy.IP),
-			},
-		},
+		"outbounds": []gin.H{outbound},
 	}
 
 	// 返回 JSON 配置
@@ -661,50 +1012,13 @@ func (h *ProxyHandler) GetV2rayConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, config)
 }
 
-// GetClashConfig 获取代理的 Clash YAML 配置
-// @Summary 获取 Clash YAML 配置
-// @Description 返回指定代理的 Clash/Mihomo YAML 配置文件
-// @Tags 代理管理
-// @Produce plain
-// @Param id path int true "代理ID"
-// @Success 200 {string} string "YAML 配置文件"
-// @Router /proxies/{id}/clash-config [get]
-func (h *ProxyHandler) GetClashConfig(c *gin.Context) {
-	id := c.Param("id")
-
-	var proxy models.Proxy
-	if err := h.db.First(&proxy, id).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			c.String(http.StatusNotFound, "代理不存在")
-			return
-		}
-		c.String(http.StatusInternalServerError, "查询失败")
-		return
-	}
-
-	// 构造 Clash YAML 配置
+// writeClashConfig 输出代理的 Clash/Mihomo YAML 配置
+func writeClashConfig(c *gin.Context, proxy *models.Proxy) {
 	// 参考 Mihomo 文档: https://wiki.metacubex.one/en/config/proxy-providers/content/
-	yamlConfig := fmt.Sprintf(`# Clash/Mihomo SOCKS5 Proxy Configuration
-# Generated by JD Task Platform
-# Proxy: %s:%d
-
-proxies:
-  - name: "SOCKS5代理-%s"
-    type: socks5
-    server: %s
-    port: %d
-    username: %s
-    password: "%s"`,
-		proxy.IP, proxy.Port,
-		proxy.IP,
-		proxy.IP,
-		proxy.Port,
-		proxy.Username,
-		proxy.Password,
-	)
+	header := fmt.Sprintf("# Clash/Mihomo %s Proxy Configuration\n# Generated by JD Task Platform\n# Proxy: %s:%d\n\n",
+		strings.ToUpper(services.ProxyProtocol(proxy)), proxy.IP, proxy.Port)
 
 	// 返回 YAML 文件
-	c.Header("Content-Type", "text/yaml; charset=utf-8")
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"proxy-%s.yaml\"", proxy.IP))
-	c.String(http.StatusOK, yamlConfig)
+	c.Data(http.StatusOK, "text/yaml; charset=utf-8", append([]byte(header), services.RenderClashProvider([]models.Proxy{*proxy})...))
 }
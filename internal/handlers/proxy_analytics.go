@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// 代理使用分析的默认和最长统计天数
+const (
+	defaultProxyAnalyticsDays = 7
+	maxProxyAnalyticsDays     = 90
+)
+
+// GetProxyAnalytics 代理使用分析
+// @Summary 代理使用分析
+// @Description 按代理统计最近 days 天的任务执行结果（设备反馈时关联设备当时租用的代理）、失败率、执行的任务数、
+// @Description 租用过的设备数和租用时长，并与其余代理比较失败率：执行次数达到 proxy_anomaly_min_tasks（默认20）
+// @Description 且失败率高出其余代理 proxy_anomaly_z_score（默认3）个标准差的代理标记为异常。
+// @Description sort 为 anomaly（默认，异常的在前）、failure_rate、executions、devices、active_time
+// @Tags 代理管理
+// @Produce json
+// @Security BearerAuth
+// @Param days query int false "统计天数(1-90)" default(7)
+// @Param sort query string false "排序方式" default(anomaly)
+// @Param anomalous query bool false "只返回失败率异常的代理"
+// @Success 200 {object} response.Response{data=models.ProxyOutcomeReport}
+// @Failure 400 {object} response.Response
+// @Router /proxies/analytics [get]
+func (h *ProxyHandler) GetProxyAnalytics(c *gin.Context) {
+	days := defaultProxyAnalyticsDays
+	if value := c.Query("days"); value != "" {
+		d, err := strconv.Atoi(value)
+		if err != nil || d < 1 || d > maxProxyAnalyticsDays {
+			response.Error(c, http.StatusBadRequest, "统计天数必须为1-90")
+			return
+		}
+		days = d
+	}
+
+	report, err := services.ProxyOutcomes(h.db, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "统计代理使用情况失败")
+		return
+	}
+
+	if c.Query("anomalous") == "true" {
+		anomalous := make([]models.ProxyOutcome, 0, report.AnomalousCount)
+		for _, outcome := range report.Proxies {
+			if outcome.Anomalous {
+				anomalous = append(anomalous, outcome)
+			}
+		}
+		report.Proxies = anomalous
+	}
+	services.SortProxyOutcomes(report.Proxies, c.Query("sort"))
+
+	response.Success(c, report)
+}
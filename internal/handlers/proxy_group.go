@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+	"jd-task-platform-go/pkg/utils"
+)
+
+// GetProxyGroups 获取代理分组统计
+// @Summary 获取代理分组统计
+// @Description 按分组统计代理数、启用数、健康数、当前租用数、累计分配次数和最近7天的任务失败率、失败率异常的代理数。
+// @Description 包括有绑定配置的分组（id 不为0）和代理上设置过的分组，未分组的代理汇总在名称为空的一行
+// @Tags 代理管理
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=[]models.ProxyGroupStat}
+// @Router /proxies/groups [get]
+func (h *ProxyHandler) GetProxyGroups(c *gin.Context) {
+	stats, err := services.ProxyGroupStats(h.db)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "统计代理分组失败")
+		return
+	}
+	response.Success(c, stats)
+}
+
+// CreateProxyGroup 创建代理分组
+// @Summary 创建代理分组
+// @Description 创建代理分组并绑定设备分组和任务类型。执行绑定任务类型的任务、或属于绑定设备分组的设备申请代理时，
+// @Description 只从该分组中分配（任务类型优先）。同一设备分组或任务类型只能绑定一个代理分组
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ProxyGroupRequest true "分组信息"
+// @Success 200 {object} response.Response{data=models.ProxyGroup}
+// @Failure 400 {object} response.Response
+// @Router /proxies/groups [post]
+func (h *ProxyHandler) CreateProxyGroup(c *gin.Context) {
+	var req models.ProxyGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	group := models.ProxyGroup{CreatedAt: time.Now()}
+	if err := services.ApplyProxyGroupRequest(h.db, &group, req); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := h.db.Create(&group).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建分组失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "分组创建成功", group)
+}
+
+// UpdateProxyGroup 修改代理分组
+// @Summary 修改代理分组
+// @Description 修改代理分组的名称和绑定，修改名称时分组中的代理一并改为新名称
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "分组ID"
+// @Param request body models.ProxyGroupRequest true "分组信息"
+// @Success 200 {object} response.Response{data=models.ProxyGroup}
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /proxies/groups/{id} [put]
+func (h *ProxyHandler) UpdateProxyGroup(c *gin.Context) {
+	var group models.ProxyGroup
+	if err := h.db.First(&group, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "分组不存在")
+		return
+	}
+
+	var req models.ProxyGroupRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+	oldName := group.Name
+	if err := services.ApplyProxyGroupRequest(h.db, &group, req); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&group).Error; err != nil {
+			return err
+		}
+		if group.Name == oldName {
+			return nil
+		}
+		return tx.Model(&models.Proxy{}).Where("group_name = ?", oldName).
+			UpdateColumn("group_name", group.Name).Error
+	})
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "修改分组失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "分组修改成功", group)
+}
+
+// DeleteProxyGroup 删除代理分组
+// @Summary 删除代理分组
+// @Description 删除代理分组及其绑定，分组中的代理移出分组（代理本身不删除）
+// @Tags 代理管理
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "分组ID"
+// @Success 200 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /proxies/groups/{id} [delete]
+func (h *ProxyHandler) DeleteProxyGroup(c *gin.Context) {
+	var group models.ProxyGroup
+	if err := h.db.First(&group, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "分组不存在")
+		return
+	}
+
+	var ungrouped int64
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&group).Error; err != nil {
+			return err
+		}
+		result := tx.Model(&models.Proxy{}).Where("group_name = ?", group.Name).UpdateColumn("group_name", "")
+		ungrouped = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "删除失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, fmt.Sprintf("分组已删除，%d 个代理移出分组", ungrouped), nil)
+}
+
+// BatchUpdateProxies 批量修改代理
+// @Summary 批量修改代理
+// @Description 按代理ID或筛选条件批量修改代理（两者同时提供时取交集，至少提供一个）。action 为：
+// @Description activate/deactivate 启用/停用（同时解除隔离），set_group 设置分组（group_name 为空时移出分组），
+// @Description set_tags 替换标签，add_tags 追加标签，remove_tags 删除标签
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ProxyBatchUpdateRequest true "修改内容"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Router /proxies/batch-update [post]
+func (h *ProxyHandler) BatchUpdateProxies(c *gin.Context) {
+	var req models.ProxyBatchUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	affected, err := services.BatchUpdateProxies(h.db, req)
+	if err != nil {
+		if errors.Is(err, services.ErrProxyBatchNoScope) || errors.Is(err, services.ErrProxyTagsTooLong) {
+			response.Error(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		response.Error(c, http.StatusInternalServerError, "批量修改失败")
+		return
+	}
+
+	response.SuccessWithDataAndMsgf(c, gin.H{"affected": affected}, "成功修改 %d 个代理", affected)
+}
+
+// ExportProxies 导出代理
+// @Summary 导出代理
+// @Description 按筛选条件导出代理，每行一条，格式为 协议://用户名:密码@IP:端口（用户名密码按 URL 编码），可直接用于批量导入。
+// @Description 导出内容包含明文账号密码，每次导出都会记录日志
+// @Tags 代理管理
+// @Produce plain
+// @Security BearerAuth
+// @Param is_active query bool false "是否激活"
+// @Param health_status query string false "健康状态(unknown/healthy/unhealthy/quarantined)"
+// @Param protocol query string false "代理协议(socks5/http/https)"
+// @Param keyword query string false "关键词搜索(IP/备注)"
+// @Param province query string false "代理省份"
+// @Param isp query string false "代理运营商"
+// @Param group_name query string false "代理分组"
+// @Param tag query string false "标签"
+// @Param supplier_id query int false "供应商ID"
+// @Success 200 {string} string "代理列表"
+// @Router /proxies/export [get]
+func (h *ProxyHandler) ExportProxies(c *gin.Context) {
+	var proxies []models.Proxy
+	if err := services.ApplyProxyFilter(h.db.Model(&models.Proxy{}), proxyFilterFromQuery(c)).
+		Order("id ASC").Find(&proxies).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询代理失败")
+		return
+	}
+
+	var buf strings.Builder
+	for i := range proxies {
+		proxy := &proxies[i]
+		buf.WriteString(utils.FormatProxyLine(services.ProxyProtocol(proxy), proxy.IP, proxy.Port, proxy.Username, proxy.Password))
+		buf.WriteString("\n")
+	}
+
+	userID, _ := c.Get("user_id")
+	log.Printf("用户 %v 导出 %d 个代理 (ip=%s)", userID, len(proxies), c.ClientIP())
+
+	filename := "proxies-" + time.Now().Format("20060102150405") + ".txt"
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(buf.String()))
+}
+
+// proxyFilterFromQuery 从查询参数读取代理筛选条件
+func proxyFilterFromQuery(c *gin.Context) models.ProxyFilter {
+	filter := models.ProxyFilter{
+		HealthStatus: c.Query("health_status"),
+		Protocol:     c.Query("protocol"),
+		Keyword:      c.Query("keyword"),
+		Province:     c.Query("province"),
+		ISP:          c.Query("isp"),
+		GroupName:    c.Query("group_name"),
+		Tag:          c.Query("tag"),
+	}
+	if value := c.Query("is_active"); value != "" {
+		isActive, _ := strconv.ParseBool(value)
+		filter.IsActive = &isActive
+	}
+	if value := c.Query("supplier_id"); value != "" {
+		if id, err := strconv.ParseUint(value, 10, 64); err == nil {
+			supplierID := uint(id)
+			filter.SupplierID = &supplierID
+		}
+	}
+	return filter
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// GetProxySubscriptions 获取代理订阅列表
+// @Summary 获取代理订阅列表
+// @Description 获取代理订阅配置和每个订阅当前包含的代理数，订阅令牌不返回
+// @Tags 代理管理
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=object}
+// @Router /proxies/subscriptions [get]
+func (h *ProxyHandler) GetProxySubscriptions(c *gin.Context) {
+	var subs []models.ProxySubscription
+	h.db.Order("id ASC").Find(&subs)
+
+	items := make([]gin.H, 0, len(subs))
+	for i := range subs {
+		proxies, _ := services.SubscriptionProxies(h.db, &subs[i], "", "")
+		items = append(items, gin.H{
+			"subscription": subs[i],
+			"proxy_count":  len(proxies),
+		})
+	}
+
+	response.Success(c, gin.H{"subscriptions": items})
+}
+
+// CreateProxySubscription 创建代理订阅
+// @Summary 创建代理订阅
+// @Description 创建代理订阅，返回各格式的订阅链接（包含令牌，只在创建和重置令牌时返回）。
+// @Description 订阅包含已启用的代理；设置了 device_group 时只包含当前租给该分组设备的代理
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ProxySubscriptionRequest true "订阅信息"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Router /proxies/subscriptions [post]
+func (h *ProxyHandler) CreateProxySubscription(c *gin.Context) {
+	var req models.ProxySubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	token, err := services.GenerateSubscriptionToken()
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "生成订阅令牌失败")
+		return
+	}
+	sub := models.ProxySubscription{Token: token, IsActive: true, CreatedAt: time.Now()}
+	if !applyProxySubscriptionRequest(c, &sub, req) {
+		return
+	}
+
+	if err := h.db.Create(&sub).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建订阅失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "订阅创建成功", gin.H{
+		"subscription": sub,
+		"urls":         proxySubscriptionURLs(c, sub.Token),
+	})
+}
+
+// UpdateProxySubscription 修改代理订阅
+// @Summary 修改代理订阅
+// @Description 修改代理订阅的筛选条件，订阅链接不变
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "订阅ID"
+// @Param request body models.ProxySubscriptionRequest true "订阅信息"
+// @Success 200 {object} response.Response{data=models.ProxySubscription}
+// @Failure 404 {object} response.Response
+// @Router /proxies/subscriptions/{id} [put]
+func (h *ProxyHandler) UpdateProxySubscription(c *gin.Context) {
+	var sub models.ProxySubscription
+	if err := h.db.First(&sub, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "订阅不存在")
+		return
+	}
+
+	var req models.ProxySubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+	if !applyProxySubscriptionRequest(c, &sub, req) {
+		return
+	}
+
+	// 访问次数由订阅访问并发累加，不用查询时的旧值覆盖
+	h.db.Omit("access_count", "last_access_at").Save(&sub)
+
+	response.SuccessWithMsg(c, "订阅修改成功", sub)
+}
+
+// ResetProxySubscriptionToken 重置订阅令牌
+// @Summary 重置订阅令牌
+// @Description 重新生成订阅令牌，旧的订阅链接立即失效，返回新的订阅链接
+// @Tags 代理管理
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "订阅ID"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 404 {object} response.Response
+// @Router /proxies/subscriptions/{id}/reset-token [post]
+func (h *ProxyHandler) ResetProxySubscriptionToken(c *gin.Context) {
+	var sub models.ProxySubscription
+	if err := h.db.First(&sub, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "订阅不存在")
+		return
+	}
+
+	token, err := services.GenerateSubscriptionToken()
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "生成订阅令牌失败")
+		return
+	}
+	sub.Token = token
+	sub.UpdatedAt = time.Now()
+	if err := h.db.Model(&sub).UpdateColumns(map[string]interface{}{
+		"token":      sub.Token,
+		"updated_at": sub.UpdatedAt,
+	}).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "重置令牌失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "订阅令牌已重置", gin.H{
+		"subscription": sub,
+		"urls":         proxySubscriptionURLs(c, sub.Token),
+	})
+}
+
+// DeleteProxySubscription 删除代理订阅
+// @Summary 删除代理订阅
+// @Description 删除代理订阅，订阅链接立即失效
+// @Tags 代理管理
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "订阅ID"
+// @Success 200 {object} response.Response
+// @Router /proxies/subscriptions/{id} [delete]
+func (h *ProxyHandler) DeleteProxySubscription(c *gin.Context) {
+	result := h.db.Delete(&models.ProxySubscription{}, c.Param("id"))
+	if result.Error != nil {
+		response.Error(c, http.StatusInternalServerError, "删除失败")
+		return
+	}
+	if result.RowsAffected == 0 {
+		response.Error(c, http.StatusNotFound, "订阅不存在")
+		return
+	}
+
+	response.SuccessWithMsg(c, "订阅删除成功", nil)
+}
+
+// GetProxySubscription 获取代理订阅内容
+// @Summary 获取代理订阅内容
+// @Description 通过订阅链接获取代理池配置，每次访问都会记录。format 为：
+// @Description clash（默认，完整的 Clash/Mihomo 配置，含 url-test/fallback 代理组和规则）、
+// @Description provider（Clash proxy-provider，只含 proxies）、singbox（sing-box JSON 配置）、v2ray（base64 编码的 v2rayN 订阅）。
+// @Description province、isp 在订阅的筛选条件上再按省份、运营商筛选
+// @Tags 代理管理
+// @Produce plain
+// @Produce json
+// @Param token path string true "订阅令牌"
+// @Param format query string false "订阅格式(clash/provider/singbox/v2ray)" default(clash)
+// @Param province query string false "代理省份"
+// @Param isp query string false "代理运营商"
+// @Success 200 {string} string "订阅内容"
+// @Failure 403 {string} string "订阅链接无效"
+// @Router /proxy-subscription/{token} [get]
+func (h *ProxyHandler) GetProxySubscription(c *gin.Context) {
+	format := c.DefaultQuery("format", services.SubscriptionClash)
+	if !containsFormat(format) {
+		c.String(http.StatusBadRequest, "不支持的订阅格式: %s", format)
+		return
+	}
+
+	var sub models.ProxySubscription
+	if err := h.db.Where("token = ?", c.Param("token")).First(&sub).Error; err != nil || !sub.IsActive {
+		var found *models.ProxySubscription
+		if err == nil {
+			found = &sub
+		}
+		services.RecordProxySubscriptionAccess(h.db, found, format, services.ProxyConfigAccessInvalid, c.ClientIP(), c.Request.UserAgent())
+		c.String(http.StatusForbidden, "订阅链接无效")
+		return
+	}
+
+	proxies, err := services.SubscriptionProxies(h.db, &sub, c.Query("province"), c.Query("isp"))
+	if err != nil {
+		c.String(http.StatusInternalServerError, "查询代理失败")
+		return
+	}
+	services.RecordProxySubscriptionAccess(h.db, &sub, format, services.ProxyConfigAccessOK, c.ClientIP(), c.Request.UserAgent())
+
+	// Clash 客户端按该间隔（小时）自动更新订阅
+	c.Header("profile-update-interval", "1")
+	filename := "proxy-subscription-" + url.PathEscape(sub.Name)
+	switch format {
+	case services.SubscriptionSingBox:
+		data, err := services.RenderSingBoxConfig(proxies, sub.TestURL)
+		if err != nil {
+			c.String(http.StatusInternalServerError, "生成配置失败")
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s.json", filename))
+		c.Data(http.StatusOK, "application/json; charset=utf-8", data)
+	case services.SubscriptionV2ray:
+		c.Data(http.StatusOK, "text/plain; charset=utf-8", services.RenderV2raySubscription(proxies))
+	case services.SubscriptionProvider:
+		c.Data(http.StatusOK, "text/yaml; charset=utf-8", services.RenderClashProvider(proxies))
+	default:
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename*=UTF-8''%s.yaml", filename))
+		c.Data(http.StatusOK, "text/yaml; charset=utf-8", services.RenderClashConfig(sub.Name, proxies, sub.TestURL))
+	}
+}
+
+// proxySubscriptionURLs 各格式的订阅链接
+func proxySubscriptionURLs(c *gin.Context, token string) gin.H {
+	base := getBackendHost(c) + "/api/proxy-subscription/" + token
+	urls := gin.H{}
+	for _, format := range services.SubscriptionFormats {
+		urls[format] = base + "?format=" + format
+	}
+	return urls
+}
+
+func containsFormat(format string) bool {
+	for _, f := range services.SubscriptionFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// applyProxySubscriptionRequest 校验并写入订阅配置，校验失败时已写入错误响应
+func applyProxySubscriptionRequest(c *gin.Context, sub *models.ProxySubscription, req models.ProxySubscriptionRequest) bool {
+	if req.TestURL != "" {
+		u, err := url.Parse(req.TestURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			response.Error(c, http.StatusBadRequest, "测速地址必须是 http 或 https 地址")
+			return false
+		}
+	}
+
+	sub.Name = strings.TrimSpace(req.Name)
+	sub.DeviceGroup = strings.TrimSpace(req.DeviceGroup)
+	sub.Province = strings.TrimSpace(req.Province)
+	sub.ISP = strings.TrimSpace(req.ISP)
+	sub.Protocol = req.Protocol
+	sub.TestURL = req.TestURL
+	if req.IsActive != nil {
+		sub.IsActive = *req.IsActive
+	}
+	sub.UpdatedAt = time.Now()
+	return true
+}
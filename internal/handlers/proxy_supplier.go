@@ -0,0 +1,216 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+	"jd-task-platform-go/pkg/utils"
+)
+
+// GetProxySuppliers 获取代理供应商列表
+// @Summary 获取代理供应商列表
+// @Description 获取代理供应商配置、代理池目标数量和当前可用代理数
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=object}
+// @Router /proxies/suppliers [get]
+func (h *ProxyHandler) GetProxySuppliers(c *gin.Context) {
+	var suppliers []models.ProxySupplier
+	h.db.Order("priority DESC, id ASC").Find(&suppliers)
+
+	var setting models.Setting
+	target := ""
+	if err := h.db.Where("param_key = ?", services.SettingProxyPoolTarget).First(&setting).Error; err == nil {
+		target = setting.ParamValue
+	}
+
+	response.Success(c, gin.H{
+		"suppliers":       suppliers,
+		"pool_target":     target,
+		"available_count": services.CountAvailableProxies(h.db),
+	})
+}
+
+// CreateProxySupplier 创建代理供应商
+// @Summary 创建代理供应商
+// @Description 创建代理供应商。source_type 为 static（固定列表）、http（供应商接口）或 file（本地文件）；
+// @Description format 为 text 或 json，template 为对应的格式模板，见 services.ParseSuppliedProxies；
+// @Description protocol 为供应商未返回协议时代理的协议（socks5/http/https，默认 socks5）。
+// @Description 可用代理少于系统设置 proxy_pool_target_size 时按优先级从供应商补充
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.ProxySupplierRequest true "供应商信息"
+// @Success 200 {object} response.Response{data=models.ProxySupplier}
+// @Failure 400 {object} response.Response
+// @Router /proxies/suppliers [post]
+func (h *ProxyHandler) CreateProxySupplier(c *gin.Context) {
+	var req models.ProxySupplierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	supplier := models.ProxySupplier{IsActive: true, CreatedAt: time.Now()}
+	if !applyProxySupplierRequest(c, &supplier, req) {
+		return
+	}
+
+	if err := h.db.Create(&supplier).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建供应商失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "供应商创建成功", supplier)
+}
+
+// UpdateProxySupplier 修改代理供应商
+// @Summary 修改代理供应商
+// @Description 修改代理供应商配置
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "供应商ID"
+// @Param request body models.ProxySupplierRequest true "供应商信息"
+// @Success 200 {object} response.Response{data=models.ProxySupplier}
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /proxies/suppliers/{id} [put]
+func (h *ProxyHandler) UpdateProxySupplier(c *gin.Context) {
+	var supplier models.ProxySupplier
+	if err := h.db.First(&supplier, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "供应商不存在")
+		return
+	}
+
+	var req models.ProxySupplierRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+	if !applyProxySupplierRequest(c, &supplier, req) {
+		return
+	}
+
+	h.db.Save(&supplier)
+
+	response.SuccessWithMsg(c, "供应商修改成功", supplier)
+}
+
+// DeleteProxySupplier 删除代理供应商
+// @Summary 删除代理供应商
+// @Description 删除代理供应商，已补充的代理保留在代理池中
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "供应商ID"
+// @Success 200 {object} response.Response
+// @Router /proxies/suppliers/{id} [delete]
+func (h *ProxyHandler) DeleteProxySupplier(c *gin.Context) {
+	result := h.db.Delete(&models.ProxySupplier{}, c.Param("id"))
+	if result.Error != nil {
+		response.Error(c, http.StatusInternalServerError, "删除失败")
+		return
+	}
+	if result.RowsAffected == 0 {
+		response.Error(c, http.StatusNotFound, "供应商不存在")
+		return
+	}
+
+	response.SuccessWithMsg(c, "供应商删除成功", nil)
+}
+
+// ReplenishFromSupplier 立即从供应商补充代理
+// @Summary 立即从供应商补充代理
+// @Description 立即从供应商获取代理加入代理池，不受代理池目标数量限制，最多加入 count 个（默认为供应商每批数量）
+// @Tags 代理管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "供应商ID"
+// @Param count query int false "最多加入数量"
+// @Success 200 {object} response.Response{data=object}
+// @Router /proxies/suppliers/{id}/replenish [post]
+func (h *ProxyHandler) ReplenishFromSupplier(c *gin.Context) {
+	var supplier models.ProxySupplier
+	if err := h.db.First(&supplier, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "供应商不存在")
+		return
+	}
+
+	count := supplier.BatchSize
+	if n, err := strconv.Atoi(c.Query("count")); err == nil && n > 0 {
+		count = n
+	}
+
+	added, err := services.ReplenishFromSupplier(h.db, &supplier, count)
+	if err != nil {
+		response.Error(c, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	response.SuccessWithDataAndMsgf(c, gin.H{"added": added}, "已补充 %d 个代理", added)
+}
+
+// applyProxySupplierRequest 校验并写入供应商配置，校验失败时已写入错误响应
+func applyProxySupplierRequest(c *gin.Context, supplier *models.ProxySupplier, req models.ProxySupplierRequest) bool {
+	switch req.SourceType {
+	case services.ProxySourceHTTP:
+		u, err := url.Parse(req.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			response.Error(c, http.StatusBadRequest, "供应商接口地址必须是 http 或 https 地址")
+			return false
+		}
+	case services.ProxySourceFile:
+		if req.FilePath == "" {
+			response.Error(c, http.StatusBadRequest, "请填写代理列表文件路径")
+			return false
+		}
+	case services.ProxySourceStatic:
+		if req.StaticList == "" {
+			response.Error(c, http.StatusBadRequest, "请填写代理列表")
+			return false
+		}
+	}
+
+	format := req.Format
+	if format == "" {
+		format = services.ProxyFormatText
+	}
+	if err := services.ValidateProxyTemplate(format, req.Template); err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	supplier.Name = req.Name
+	supplier.SourceType = req.SourceType
+	supplier.URL = req.URL
+	supplier.FilePath = req.FilePath
+	supplier.StaticList = req.StaticList
+	supplier.Format = format
+	supplier.Protocol = req.Protocol
+	if supplier.Protocol == "" {
+		supplier.Protocol = utils.ProxyProtocolSOCKS5
+	}
+	supplier.Template = req.Template
+	supplier.TTLMinutes = req.TTLMinutes
+	supplier.BatchSize = req.BatchSize
+	supplier.Priority = req.Priority
+	if req.IsActive != nil {
+		supplier.IsActive = *req.IsActive
+	}
+	supplier.UpdatedAt = time.Now()
+	return true
+}
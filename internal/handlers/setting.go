@@ -229,6 +229,13 @@ func (h *SettingHandler) InitDefaultSettings(c *gin.Context) {
 			Description: "新用户默认京豆",
 			UpdatedAt:   time.Now(),
 		},
+		{
+			ParamKey:    "task_pause_extend_expiry",
+			ParamValue:  "true",
+			ParamType:   "boolean",
+			Description: "任务暂停期间是否顺延过期时间",
+			UpdatedAt:   time.Now(),
+		},
 		{
 			ParamKey:    "login_announcement",
 			ParamValue:  "",
@@ -2,7 +2,6 @@ package handlers
 
 import (
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -10,6 +9,8 @@ import (
 
 	"jd-task-platform-go/internal/constants"
 	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/internal/taskstate"
 	"jd-task-platform-go/pkg/response"
 )
 
@@ -30,24 +31,21 @@ func NewTaskHandler(db *gorm.DB) *TaskHandler {
 // @Security BearerAuth
 // @Param page query int false "页码" default(1)
 // @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
 // @Param status query string false "任务状态" Enums(waiting, running, completed, failed, cancelled)
 // @Param task_type query string false "任务类型"
 // @Success 200 {object} response.Response{data=object}
 // @Failure 401 {object} response.Response
 // @Router /tasks [get]
 func (h *TaskHandler) GetTasks(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	pagination, err := response.ParsePagination(c, "page_size", response.NoPageSizeLimit)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
 	status := c.Query("status")
 	taskType := c.Query("task_type")
 
-	if page < 1 {
-		page = 1
-	}
-	if pageSize < 1 {
-		pageSize = 20
-	}
-
 	query := h.db.Model(&models.Task{})
 
 	userID, _ := c.Get("user_id")
@@ -64,27 +62,32 @@ func (h *TaskHandler) GetTasks(c *gin.Context) {
 		query = query.Where("task_type = ?", taskType)
 	}
 
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	var tasks []models.Task
-	offset := (page - 1) * pageSize
-	query.Order("created_at DESC").Offset(offset).Limit(pageSize).Find(&tasks)
+	if err := response.Find(pagination, query, "created_at", &tasks, taskCursorKey); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
-	items := make([]gin.H, 0)
+	userIDs := make([]uint, 0, len(tasks))
 	for _, task := range tasks {
-		var user models.User
-		h.db.First(&user, task.UserID)
+		userIDs = append(userIDs, task.UserID)
+	}
+	usernames := services.Usernames(h.db, userIDs)
 
+	items := make([]gin.H, 0)
+	for _, task := range tasks {
 		items = append(items, gin.H{
 			"id":              task.ID,
 			"user_id":         task.UserID,
-			"username":        user.Username,
+			"username":        usernames[task.UserID],
 			"task_type":       task.TaskType,
 			"sku":             task.SKU,
 			"shop_name":       task.ShopName,
 			"keyword":         task.Keyword,
 			"start_time":      task.StartTime.Format(time.RFC3339),
+			"end_time":        task.EndTime,
 			"execute_count":   task.ExecuteCount,
 			"executed_count":  task.ExecutedCount,
 			"priority":        task.Priority,
@@ -96,15 +99,14 @@ func (h *TaskHandler) GetTasks(c *gin.Context) {
 		})
 	}
 
-	pages := int((total + int64(pageSize) - 1) / int64(pageSize))
+	data := pagination.Meta("per_page")
+	data["items"] = items
+	response.Success(c, data)
+}
 
-	response.Success(c, gin.H{
-		"items":    items,
-		"page":     page,
-		"per_page": pageSize,
-		"total":    total,
-		"pages":    pages,
-	})
+// taskCursorKey 任务列表按创建时间分页的游标位置
+func taskCursorKey(task *models.Task) (time.Time, uint) {
+	return task.CreatedAt, task.ID
 }
 
 // CreateTask 创建任务
@@ -126,140 +128,20 @@ func (h *TaskHandler) CreateTask(c *gin.Context) {
 		return
 	}
 
-	userID, _ := c.Get("user_id")
-	role, _ := c.Get("role")
-
-	var user models.User
-	if err := h.db.First(&user, userID).Error; err != nil {
-		response.Error(c, http.StatusNotFound, constants.MsgUserNotFound)
+	actor := services.TaskActor{UserID: c.GetUint("user_id"), Source: "创建任务"}
+	result, err := services.NewTaskService(h.db).CreateTasks(c.Request.Context(), actor, []services.TaskSpec{taskSpec(req)})
+	if err != nil {
+		status, msg := taskCreateError(err, false)
+		response.Error(c, status, msg)
 		return
 	}
 
-	// 查询任务类型配置
-	var taskType models.TaskType
-	if err := h.db.Where("type_code = ?", req.TaskType).First(&taskType).Error; err != nil {
-		response.Error(c, http.StatusBadRequest, constants.MsgTaskTypeInvalid)
-		return
-	}
-
-	// 检查任务类型是否启用
-	if !taskType.IsActive {
-		response.Error(c, http.StatusBadRequest, constants.MsgTaskTypeDisabled)
-		return
-	}
-
-	// 只有关键词搜索任务需要关键词参数
-	if req.TaskType == "search_browse" && req.Keyword == "" {
-		response.Error(c, http.StatusBadRequest, "关键词搜索任务必须填写关键词")
-		return
-	}
-	// 非关键词搜索任务不需要关键词，清空它
-	if req.TaskType != "search_browse" {
-		req.Keyword = ""
-	}
-
-	// 获取用户角色
-	isAdmin := role == "admin"
-
-	// 检查当前时间是否在允许的时间段内（管理员可以在任何时间创建任务）
-	if !isAdmin && taskType.TimeSlot1Start != nil && taskType.TimeSlot1End != nil &&
-		*taskType.TimeSlot1Start != "" && *taskType.TimeSlot1End != "" {
-		now := time.Now()
-		currentTime := now.Format("15:04")
-
-		inTimeSlot := false
-
-		// 检查时间段1
-		if currentTime >= *taskType.TimeSlot1Start && currentTime <= *taskType.TimeSlot1End {
-			inTimeSlot = true
-		}
-
-		// 检查时间段2（如果存在）
-		if !inTimeSlot && taskType.TimeSlot2Start != nil && taskType.TimeSlot2End != nil &&
-			*taskType.TimeSlot2Start != "" && *taskType.TimeSlot2End != "" {
-			if currentTime >= *taskType.TimeSlot2Start && currentTime <= *taskType.TimeSlot2End {
-				inTimeSlot = true
-			}
-		}
-
-		if !inTimeSlot {
-			timeSlotInfo := *taskType.TimeSlot1Start + "-" + *taskType.TimeSlot1End
-			if taskType.TimeSlot2Start != nil && taskType.TimeSlot2End != nil &&
-				*taskType.TimeSlot2Start != "" && *taskType.TimeSlot2End != "" {
-				timeSlotInfo += ", " + *taskType.TimeSlot2Start + "-" + *taskType.TimeSlot2End
-			}
-			response.Errorf(c, http.StatusBadRequest, constants.MsgTaskTimeSlotLimit, timeSlotInfo)
-			return
-		}
-	}
-
-	// 使用任务类型配置的价格
-	consumeJingdou := taskType.JingdouPrice * req.ExecuteCount
-
-	if isAdmin {
-		consumeJingdou = 0
-	} else {
-		if user.JingdouBalance < consumeJingdou {
-			response.Errorf(c, http.StatusBadRequest, constants.MsgTaskBalanceInsufficient, consumeJingdou, user.JingdouBalance)
-			return
-		}
-		user.JingdouBalance -= consumeJingdou
-	}
-
-	task := models.Task{
-		UserID:         user.ID,
-		TaskType:       req.TaskType,
-		SKU:            req.SKU,
-		ShopName:       req.ShopName,
-		Keyword:        req.Keyword,
-		StartTime:      req.StartTime,
-		ExecuteCount:   req.ExecuteCount,
-		ExecutedCount:  0,
-		Priority:       req.Priority,
-		Status:         "waiting",
-		ConsumeJingdou: consumeJingdou,
-		Remark:         req.Remark,
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
-	}
-
-	tx := h.db.Begin()
-	if err := tx.Create(&task).Error; err != nil {
-		tx.Rollback()
-		response.Error(c, http.StatusInternalServerError, constants.MsgTaskCreateFailed)
-		return
-	}
-
-	// 非管理员扣除京豆并记录日志
-	if !isAdmin && consumeJingdou > 0 {
-		tx.Save(&user)
-
-		// 创建京豆扣除日志
-		jingdouLog := models.JingdouLog{
-			UserID:        user.ID,
-			Amount:        -consumeJingdou, // 负数表示扣除
-			Balance:       user.JingdouBalance,
-			OperationType: "task",
-			RelatedID:     &task.ID,
-			Remark:        "创建任务扣除 - SKU:" + task.SKU,
-			CreatedAt:     time.Now(),
-		}
-		tx.Create(&jingdouLog)
-	}
-
-	// 更新或创建任务模板（仅普通用户）
-	if !isAdmin {
-		UpdateOrCreateTemplate(tx, user.ID, task.TaskType, task.SKU, task.ShopName, task.Keyword, task.ExecuteCount)
-	}
-
-	tx.Commit()
-
 	response.SuccessWithDataAndMsgf(c, gin.H{
-		"task_id":         task.ID,
-		"consume_jingdou": consumeJingdou,
-		"balance":         user.JingdouBalance,
-		"is_admin":        isAdmin,
-	}, constants.MsgTaskCreated, consumeJingdou)
+		"task_id":         result.Tasks[0].ID,
+		"consume_jingdou": result.Consume,
+		"balance":         result.Balance,
+		"is_admin":        result.IsAdmin,
+	}, constants.MsgTaskCreated, result.Consume)
 }
 
 // GetTaskByID 获取任务详情
@@ -303,6 +185,7 @@ func (h *TaskHandler) GetTaskByID(c *gin.Context) {
 		"shop_name":       task.ShopName,
 		"keyword":         task.Keyword,
 		"start_time":      task.StartTime.Format(time.RFC3339),
+		"end_time":        task.EndTime,
 		"execute_count":   task.ExecuteCount,
 		"executed_count":  task.ExecutedCount,
 		"priority":        task.Priority,
@@ -316,7 +199,7 @@ func (h *TaskHandler) GetTaskByID(c *gin.Context) {
 
 // UpdateTask 更新任务
 // @Summary 更新任务信息
-// @Description 更新任务的部分字段
+// @Description 更新任务的部分字段，状态变更按状态机校验并自动退款（普通用户只能取消自己的任务）
 // @Tags 任务模块
 // @Accept json
 // @Produce json
@@ -341,21 +224,49 @@ func (h *TaskHandler) UpdateTask(c *gin.Context) {
 		return
 	}
 
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+	if role != "admin" && task.UserID != userID.(uint) {
+		response.Error(c, http.StatusForbidden, "无权操作此任务")
+		return
+	}
+
+	updates := map[string]interface{}{}
 	if req.ShopName != nil {
-		task.ShopName = *req.ShopName
+		updates["shop_name"] = *req.ShopName
 	}
 	if req.Keyword != nil {
-		task.Keyword = *req.Keyword
+		updates["keyword"] = *req.Keyword
 	}
 	if req.Priority != nil {
-		task.Priority = *req.Priority
-	}
-	if req.Status != nil {
-		task.Status = *req.Status
+		updates["priority"] = *req.Priority
 	}
 
-	task.UpdatedAt = time.Now()
-	h.db.Save(&task)
+	// 状态变更走状态机（校验合法转换、退款、记录历史）
+	if req.Status != nil && *req.Status != task.Status {
+		if !taskstate.Valid(*req.Status) {
+			response.Error(c, http.StatusBadRequest, "无效的任务状态: "+*req.Status)
+			return
+		}
+		// 普通用户只能取消任务，其他状态变更由系统或管理员执行
+		if role != "admin" && *req.Status != taskstate.Cancelled {
+			response.Error(c, http.StatusForbidden, "无权修改任务状态")
+			return
+		}
+		if _, err := taskstate.Transition(h.db, &task, taskstate.Change{
+			To:      *req.Status,
+			Actor:   actorOf(role),
+			ActorID: actorID(userID),
+			Reason:  "修改任务状态",
+			Updates: updates,
+		}); err != nil {
+			transitionError(c, err, "更新任务失败")
+			return
+		}
+	} else if len(updates) > 0 {
+		updates["updated_at"] = time.Now()
+		h.db.Model(&task).Updates(updates)
+	}
 
 	response.SuccessWithMsg(c, constants.MsgTaskUpdated, nil)
 }
@@ -395,6 +306,12 @@ func (h *TaskHandler) GetTaskStats(c *gin.Context) {
 		query = query.Where("user_id = ?", userID)
 	}
 
+	summary, err := services.SummarizeTasksByStatus(query)
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "统计任务失败")
+		return
+	}
+
 	var stats struct {
 		TotalTasks     int64 `json:"total_tasks"`
 		RunningTasks   int64 `json:"running_tasks"`
@@ -402,12 +319,11 @@ func (h *TaskHandler) GetTaskStats(c *gin.Context) {
 		CompletedTasks int64 `json:"completed_tasks"`
 		FailedTasks    int64 `json:"failed_tasks"`
 	}
-
-	query.Count(&stats.TotalTasks)
-	h.db.Model(&models.Task{}).Where("status = ?", "running").Count(&stats.RunningTasks)
-	h.db.Model(&models.Task{}).Where("status = ?", "waiting").Count(&stats.WaitingTasks)
-	h.db.Model(&models.Task{}).Where("status = ?", "completed").Count(&stats.CompletedTasks)
-	h.db.Model(&models.Task{}).Where("status = ?", "failed").Count(&stats.FailedTasks)
+	stats.TotalTasks = summary.Tasks()
+	stats.RunningTasks = summary.Tasks("running")
+	stats.WaitingTasks = summary.Tasks("waiting")
+	stats.CompletedTasks = summary.Tasks("completed")
+	stats.FailedTasks = summary.Tasks("failed")
 
 	response.Success(c, stats)
 }
@@ -0,0 +1,337 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/internal/taskstate"
+	"jd-task-platform-go/pkg/response"
+)
+
+// 任务链限制
+const (
+	maxChainSteps        = 10
+	maxChainDelayMinutes = 24 * 60
+)
+
+// chainError 任务链操作失败原因
+type chainError struct {
+	status int
+	msg    string
+}
+
+func (e *chainError) Error() string {
+	return e.msg
+}
+
+// CreateTaskChain 创建任务链
+// @Summary 创建任务链
+// @Description 创建多步骤任务链（如先搜索浏览、再加购），每一步引用一个任务类型并可设置距上一步完成的等待时间。同一设备完成第N步后优先领取第N+1步，全部步骤的京豆在创建时一次性扣除
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.CreateTaskChainRequest true "任务链信息"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Router /tasks/chains [post]
+func (h *TaskHandler) CreateTaskChain(c *gin.Context) {
+	var req models.CreateTaskChainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误：任务链至少需要2个步骤")
+		return
+	}
+
+	chain, result, err := createTaskChain(c.Request.Context(), h.db, c.GetUint("user_id"), req, "创建任务链")
+	if err != nil {
+		response.Error(c, err.status, err.msg)
+		return
+	}
+
+	response.SuccessWithMsg(c, "任务链创建成功", taskChainResult(chain, result))
+}
+
+// GetTaskChain 获取任务链详情
+// @Summary 获取任务链详情
+// @Description 获取任务链及各步骤的执行进度
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "任务链ID"
+// @Success 200 {object} response.Response{data=models.TaskChain}
+// @Failure 404 {object} response.Response
+// @Router /tasks/chains/{id} [get]
+func (h *TaskHandler) GetTaskChain(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+
+	chain, err := findTaskChain(h.db, c.Param("id"), userID.(uint), role == "admin")
+	if err != nil {
+		response.Error(c, err.status, err.msg)
+		return
+	}
+
+	response.Success(c, chain)
+}
+
+// CancelTaskChain 取消任务链
+// @Summary 取消任务链
+// @Description 取消任务链中所有未完成的步骤，并按各步骤未完成次数退还京豆
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "任务链ID"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /tasks/chains/{id}/cancel [post]
+func (h *TaskHandler) CancelTaskChain(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+
+	chain, err := findTaskChain(h.db, c.Param("id"), userID.(uint), role == "admin")
+	if err != nil {
+		response.Error(c, err.status, err.msg)
+		return
+	}
+
+	// 逐个取消未结束的步骤，按各步骤未完成次数退款
+	refundAmount, balance := 0, 0
+	var results []*taskstate.Result
+	txErr := h.db.Transaction(func(tx *gorm.DB) error {
+		for i := range chain.Steps {
+			step := &chain.Steps[i]
+			if taskstate.IsTerminal(step.Status) {
+				continue
+			}
+			result, err := taskstate.Apply(tx, step, taskstate.Change{
+				To:           taskstate.Cancelled,
+				Actor:        actorOf(role),
+				ActorID:      actorID(userID),
+				Reason:       fmt.Sprintf("任务链#%d取消", chain.ID),
+				RefundRemark: fmt.Sprintf("取消任务链退款 - SKU:%s（任务链#%d 第%d步）", chain.SKU, chain.ID, step.ChainStep),
+			})
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+			refundAmount += result.Refund
+			balance = result.Balance
+		}
+		return nil
+	})
+	if txErr != nil {
+		transitionError(c, txErr, "取消任务链失败")
+		return
+	}
+	if len(results) == 0 {
+		response.Error(c, http.StatusBadRequest, "任务链没有可取消的步骤")
+		return
+	}
+
+	taskstate.Committed(h.db, results...)
+
+	response.Success(c, gin.H{
+		"chain_id":        chain.ID,
+		"cancelled_steps": len(results),
+		"refund_jingdou":  refundAmount,
+		"balance":         balance,
+	})
+}
+
+// CreateTaskChain 创建任务链
+// @Summary 创建任务链（API Key）
+// @Description 使用API Key创建多步骤任务链，同一设备完成第N步后优先领取第N+1步，全部步骤的京豆在创建时一次性扣除
+// @Tags 开放API-任务
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param request body models.CreateTaskChainRequest true "任务链信息"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Router /openapi/tasks/chains [post]
+func (h *OpenAPIHandler) CreateTaskChain(c *gin.Context) {
+	var req models.CreateTaskChainRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误：请检查商品SKU(sku)、开始时间(start_time)、执行次数(execute_count)，任务链至少需要2个步骤(steps)")
+		return
+	}
+
+	chain, created, err := createTaskChain(c.Request.Context(), h.db, c.GetUint("user_id"), req, "API创建任务链")
+	if err != nil {
+		response.Error(c, err.status, err.msg)
+		return
+	}
+
+	result := taskChainResult(chain, created)
+	result["message"] = "任务链创建成功"
+	response.Success(c, result)
+}
+
+// GetTaskChain 查询任务链详情
+// @Summary 查询任务链详情（API Key）
+// @Description 查询任务链及各步骤的执行进度
+// @Tags 开放API-任务
+// @Accept json
+// @Produce json
+// @Security ApiKeyAuth
+// @Param id path int true "任务链ID"
+// @Success 200 {object} response.Response{data=models.TaskChain}
+// @Failure 404 {object} response.Response
+// @Router /openapi/tasks/chains/{id} [get]
+func (h *OpenAPIHandler) GetTaskChain(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	chain, err := findTaskChain(h.db, c.Param("id"), userID.(uint), false)
+	if err != nil {
+		response.Error(c, err.status, err.msg)
+		return
+	}
+
+	response.Success(c, chain)
+}
+
+// createTaskChain 校验并创建任务链及其步骤任务，扣除全部步骤的京豆
+// 步骤任务按任务服务的统一规则校验和计费，任务链记录和步骤关联在同一事务中写入
+func createTaskChain(ctx context.Context, db *gorm.DB, userID uint, req models.CreateTaskChainRequest, source string) (*models.TaskChain, *services.CreateTasksResult, *chainError) {
+	if len(req.Steps) > maxChainSteps {
+		return nil, nil, &chainError{http.StatusBadRequest, fmt.Sprintf("任务链最多支持%d个步骤", maxChainSteps)}
+	}
+
+	// 后续步骤的开始时间按累计等待时间推算
+	specs := make([]services.TaskSpec, len(req.Steps))
+	startTime := req.StartTime
+	for i := range req.Steps {
+		step := &req.Steps[i]
+		if step.DelayMinutes < 0 || step.DelayMinutes > maxChainDelayMinutes {
+			return nil, nil, &chainError{http.StatusBadRequest, fmt.Sprintf("第%d步等待时间必须在0-%d分钟之间", i+1, maxChainDelayMinutes)}
+		}
+		if i == 0 {
+			step.DelayMinutes = 0
+		}
+		startTime = startTime.Add(time.Duration(step.DelayMinutes) * time.Minute)
+
+		remark := step.Remark
+		if remark == "" {
+			remark = req.Remark
+		}
+		specs[i] = services.TaskSpec{
+			TaskType:     step.TaskType,
+			SKU:          req.SKU,
+			ShopName:     req.ShopName,
+			Keyword:      step.Keyword,
+			StartTime:    startTime,
+			ExecuteCount: req.ExecuteCount,
+			Priority:     req.Priority,
+			Remark:       remark,
+		}
+	}
+
+	now := time.Now()
+	chain := models.TaskChain{
+		UserID:       userID,
+		Name:         req.Name,
+		SKU:          req.SKU,
+		ShopName:     req.ShopName,
+		StartTime:    req.StartTime,
+		ExecuteCount: req.ExecuteCount,
+		StepCount:    len(req.Steps),
+		Status:       "waiting",
+		Remark:       req.Remark,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	actor := services.TaskActor{UserID: userID, Source: source}
+	result, err := services.NewTaskService(db).CreateTasksWithOptions(ctx, actor, specs, services.CreateOptions{
+		SkipTemplate: true,
+		Prepare: func(tx *gorm.DB, tasks []models.Task) error {
+			for i := range tasks {
+				chain.ConsumeJingdou += tasks[i].ConsumeJingdou
+			}
+			if err := tx.Create(&chain).Error; err != nil {
+				return err
+			}
+			for i := range tasks {
+				tasks[i].ChainID = &chain.ID
+				tasks[i].ChainStep = i + 1
+				tasks[i].ChainDelay = req.Steps[i].DelayMinutes
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		status, msg := taskCreateError(err, false)
+		var specErr *services.TaskSpecError
+		if errors.As(err, &specErr) {
+			msg = fmt.Sprintf("第%d步：%s", specErr.Index+1, msg)
+		}
+		return nil, nil, &chainError{status, msg}
+	}
+
+	chain.Steps = result.Tasks
+	return &chain, result, nil
+}
+
+// findTaskChain 查询任务链及步骤，普通用户只能查看自己的任务链
+func findTaskChain(db *gorm.DB, id string, userID uint, isAdmin bool) (*models.TaskChain, *chainError) {
+	var chain models.TaskChain
+	query := db.Preload("Steps", func(db *gorm.DB) *gorm.DB {
+		return db.Order("chain_step ASC")
+	}).Where("id = ?", id)
+	if !isAdmin {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.First(&chain).Error; err != nil {
+		return nil, &chainError{http.StatusNotFound, "任务链不存在"}
+	}
+	return &chain, nil
+}
+
+// taskChainResult 构造任务链创建结果
+func taskChainResult(chain *models.TaskChain, result *services.CreateTasksResult) gin.H {
+	steps := make([]gin.H, 0, len(chain.Steps))
+	for _, step := range chain.Steps {
+		steps = append(steps, gin.H{
+			"task_id":         step.ID,
+			"step":            step.ChainStep,
+			"task_type":       step.TaskType,
+			"delay_minutes":   step.ChainDelay,
+			"consume_jingdou": step.ConsumeJingdou,
+		})
+	}
+	return gin.H{
+		"chain_id":        chain.ID,
+		"status":          chain.Status,
+		"steps":           steps,
+		"consume_jingdou": chain.ConsumeJingdou,
+		"balance":         result.Balance,
+	}
+}
+
+// nextChainStep 查找该设备可以继续执行的任务链步骤
+// 条件：设备已成功完成上一步且已过等待时间、本步骤未被该设备执行过、本步骤仍有剩余次数
+func (h *DeviceHandler) nextChainStep(device *models.Device) (models.Task, bool) {
+	now := time.Now()
+	var task models.Task
+	err := h.db.Table("tasks AS t").Select("t.*").
+		Joins("JOIN tasks AS prev ON prev.chain_id = t.chain_id AND prev.chain_step = t.chain_step - 1").
+		Joins("JOIN device_task_history AS dh ON dh.task_id = prev.id AND dh.device_id = ? AND dh.status = ?", device.DeviceID, "success").
+		Where("t.chain_id IS NOT NULL AND t.chain_step > 1 AND t.status IN ? AND t.executed_count < t.execute_count AND (t.end_time IS NULL OR t.end_time > ?)",
+			[]string{"waiting", "running"}, now).
+		Where("dh.execute_time <= DATE_SUB(?, INTERVAL t.chain_delay MINUTE) AND dh.execute_time > ?",
+			now, now.Add(-24*time.Hour)).
+		Where("NOT EXISTS (SELECT 1 FROM device_task_history AS dh2 WHERE dh2.task_id = t.id AND dh2.device_id = ?)", device.DeviceID).
+		Order("t.chain_step DESC, t.priority DESC, dh.execute_time ASC").
+		Take(&task).Error
+	return task, err == nil
+}
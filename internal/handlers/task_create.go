@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"jd-task-platform-go/internal/constants"
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+)
+
+// taskSpec 将创建任务请求转换为任务服务的参数
+func taskSpec(req models.CreateTaskRequest) services.TaskSpec {
+	return services.TaskSpec{
+		TaskType:     req.TaskType,
+		SKU:          req.SKU,
+		ShopName:     req.ShopName,
+		Keyword:      req.Keyword,
+		StartTime:    req.StartTime,
+		ExecuteCount: req.ExecuteCount,
+		Priority:     req.Priority,
+		Remark:       req.Remark,
+		ExpireHours:  req.ExpireHours,
+	}
+}
+
+// taskCreateError 将任务服务返回的错误转换为状态码和提示信息
+// batch 为 true 时在参数错误前加上任务序号
+func taskCreateError(err error, batch bool) (int, string) {
+	var (
+		slotErr    *services.TimeSlotError
+		balanceErr *services.InsufficientBalanceError
+		specErr    *services.TaskSpecError
+	)
+
+	var status int
+	var msg string
+	switch {
+	case errors.Is(err, services.ErrTaskUserNotFound):
+		return http.StatusNotFound, constants.MsgUserNotFound
+	case errors.Is(err, services.ErrTaskTemplateNotFound):
+		return http.StatusNotFound, "任务模板不存在"
+	case errors.As(err, &balanceErr):
+		return http.StatusBadRequest, fmt.Sprintf(constants.MsgTaskBalanceInsufficient, balanceErr.Need, balanceErr.Balance)
+	case errors.Is(err, services.ErrTaskTypeInvalid):
+		status, msg = http.StatusBadRequest, constants.MsgTaskTypeInvalid
+	case errors.Is(err, services.ErrTaskTypeDisabled):
+		status, msg = http.StatusBadRequest, constants.MsgTaskTypeDisabled
+	case errors.As(err, &slotErr):
+		status, msg = http.StatusBadRequest, fmt.Sprintf(constants.MsgTaskTimeSlotLimit, slotErr.Slots)
+	case errors.As(err, &specErr):
+		status, msg = http.StatusBadRequest, specErr.Err.Error()
+	default:
+		return http.StatusInternalServerError, constants.MsgTaskCreateFailed
+	}
+
+	if batch && errors.As(err, &specErr) {
+		msg = fmt.Sprintf("第 %d 个任务：%s", specErr.Index+1, msg)
+	}
+	return status, msg
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// ExportTasks 导出任务
+// @Summary 导出任务
+// @Description 按筛选条件流式导出任务，包含执行进度、消耗和退款京豆及各设备的执行明细，不分页。管理员导出全部用户的任务，可通过 user_id 指定用户
+// @Tags 任务模块
+// @Produce octet-stream
+// @Security BearerAuth
+// @Param format query string false "导出格式：csv, xlsx, ndjson" default(csv)
+// @Param user_id query int false "用户ID（仅管理员）"
+// @Param status query string false "任务状态，多个用逗号分隔"
+// @Param task_type query string false "任务类型"
+// @Param sku query string false "商品SKU（模糊搜索）"
+// @Param shop_name query string false "店铺名称（模糊搜索）"
+// @Param keyword query string false "关键词（模糊搜索）"
+// @Param start_date query string false "开始日期(YYYY-MM-DD)"
+// @Param end_date query string false "结束日期(YYYY-MM-DD)"
+// @Param date_field query string false "日期筛选字段：created_at, start_time" default(created_at)
+// @Success 200 {file} file "导出文件"
+// @Failure 400 {object} response.Response
+// @Router /tasks/export [get]
+func (h *TaskHandler) ExportTasks(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	role, _ := c.Get("role")
+
+	if role == "admin" {
+		userID = 0
+		if value := c.Query("user_id"); value != "" {
+			parsedID, err := strconv.ParseUint(value, 10, 32)
+			if err != nil {
+				response.Error(c, http.StatusBadRequest, "用户ID格式错误")
+				return
+			}
+			userID = uint(parsedID)
+		}
+	}
+
+	exportTasks(c, h.db, userID, "created_at")
+}
+
+// ExportUserTasks 导出用户任务
+// @Summary 导出用户任务
+// @Description 按筛选条件流式导出当前用户的任务，包含执行进度、消耗和退款京豆及各设备的执行明细，管理员可指定user_id
+// @Tags 用户任务管理
+// @Produce octet-stream
+// @Security BearerAuth
+// @Param format query string false "导出格式：csv, xlsx, ndjson" default(csv)
+// @Param user_id query int false "用户ID（仅管理员）"
+// @Param status query string false "任务状态，多个用逗号分隔"
+// @Param task_type query string false "任务类型"
+// @Param sku query string false "商品SKU（模糊搜索）"
+// @Param shop_name query string false "店铺名称（模糊搜索）"
+// @Param keyword query string false "关键词（模糊搜索）"
+// @Param start_date query string false "开始日期 YYYY-MM-DD"
+// @Param end_date query string false "结束日期 YYYY-MM-DD"
+// @Param date_field query string false "日期筛选字段：created_at, start_time" default(start_time)
+// @Success 200 {file} file "导出文件"
+// @Failure 400 {object} response.Response
+// @Router /user/tasks/export [get]
+func (h *UserTaskManageHandler) ExportUserTasks(c *gin.Context) {
+	userID := c.GetUint("user_id")
+	role, _ := c.Get("role")
+
+	// 与任务列表一致：管理员可指定user_id，默认导出自己的任务
+	if role == "admin" {
+		if value := c.Query("user_id"); value != "" {
+			if parsedID, err := strconv.ParseUint(value, 10, 32); err == nil {
+				userID = uint(parsedID)
+			}
+		}
+	}
+
+	// 用户任务列表按开始时间筛选日期
+	exportTasks(c, h.db, userID, "start_time")
+}
+
+// ExportTasks 导出任务
+// @Summary 导出任务（API Key）
+// @Description 使用API Key按筛选条件流式导出任务，包含执行进度、消耗和退款京豆及各设备的执行明细，不分页
+// @Tags 开放API-任务
+// @Produce octet-stream
+// @Security ApiKeyAuth
+// @Param format query string false "导出格式：csv, xlsx, ndjson" default(csv)
+// @Param status query string false "任务状态，多个用逗号分隔"
+// @Param task_type query string false "任务类型"
+// @Param sku query string false "商品SKU（模糊搜索）"
+// @Param shop_name query string false "店铺名称（模糊搜索）"
+// @Param keyword query string false "关键词（模糊搜索）"
+// @Param start_date query string false "开始日期(YYYY-MM-DD)"
+// @Param end_date query string false "结束日期(YYYY-MM-DD)"
+// @Param date_field query string false "日期筛选字段：created_at, start_time" default(created_at)
+// @Success 200 {file} file "导出文件"
+// @Failure 400 {object} response.Response
+// @Router /openapi/tasks/export [get]
+func (h *OpenAPIHandler) ExportTasks(c *gin.Context) {
+	exportTasks(c, h.db, c.GetUint("user_id"), "created_at")
+}
+
+// exportTasks 解析筛选条件并流式输出导出文件，userID 为0时导出全部用户
+func exportTasks(c *gin.Context, db *gorm.DB, userID uint, dateField string) {
+	format := strings.ToLower(c.DefaultQuery("format", services.ExportFormatCSV))
+	contentTypes := map[string]string{
+		services.ExportFormatCSV:    "text/csv; charset=utf-8",
+		services.ExportFormatXLSX:   "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		services.ExportFormatNDJSON: "application/x-ndjson",
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		response.Error(c, http.StatusBadRequest, "导出格式只支持 csv、xlsx 和 ndjson")
+		return
+	}
+
+	filter := services.TaskExportFilter{
+		UserID:    userID,
+		TaskType:  c.Query("task_type"),
+		SKU:       c.Query("sku"),
+		ShopName:  c.Query("shop_name"),
+		Keyword:   c.Query("keyword"),
+		DateField: c.DefaultQuery("date_field", dateField),
+	}
+	if status := c.Query("status"); status != "" {
+		filter.Statuses = strings.Split(status, ",")
+	}
+	for param, target := range map[string]**time.Time{"start_date": &filter.StartDate, "end_date": &filter.EndDate} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+		if err != nil {
+			response.Error(c, http.StatusBadRequest, "日期格式错误，应为 YYYY-MM-DD")
+			return
+		}
+		*target = &t
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"tasks-%s.%s\"", time.Now().Format("20060102150405"), format))
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	// 响应头已发送，导出中途出错只能中断输出并记录日志
+	if err := services.WriteTaskExport(db, c.Writer, format, filter); err != nil {
+		log.Printf("导出任务失败 (user_id=%d): %v", userID, err)
+	}
+}
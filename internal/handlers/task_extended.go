@@ -3,11 +3,14 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/internal/taskstate"
 	"jd-task-platform-go/pkg/response"
 )
 
@@ -40,49 +43,28 @@ func (h *TaskHandler) CancelTask(c *gin.Context) {
 		return
 	}
 
-	// 只有waiting状态的任务可以取消
-	if task.Status != "waiting" {
-		response.Error(c, http.StatusBadRequest, "只有等待中的任务可以取消")
+	// 只有waiting或paused状态的任务可以取消
+	if task.Status != taskstate.Waiting && task.Status != taskstate.Paused {
+		response.Error(c, http.StatusBadRequest, "只有等待中或已暂停的任务可以取消")
 		return
 	}
 
-	// 获取用户
-	var user models.User
-	h.db.First(&user, task.UserID)
-
-	// 退款
-	refundAmount := task.ConsumeJingdou
-	user.JingdouBalance += refundAmount
-
-	tx := h.db.Begin()
-
-	// 更新任务状态
-	task.Status = "cancelled"
-	tx.Save(&task)
-
-	// 更新用户余额
-	tx.Save(&user)
-
-	// 记录京豆日志
-	if refundAmount > 0 {
-		jingdouLog := models.JingdouLog{
-			UserID:        user.ID,
-			Amount:        refundAmount,
-			Balance:       user.JingdouBalance,
-			OperationType: "refund",
-			RelatedID:     &task.ID,
-			Remark:        "取消任务退款 - SKU:" + task.SKU,
-			CreatedAt:     time.Now(),
-		}
-		tx.Create(&jingdouLog)
+	result, err := taskstate.Transition(h.db, &task, taskstate.Change{
+		To:           taskstate.Cancelled,
+		Actor:        actorOf(role),
+		ActorID:      actorID(userID),
+		Reason:       "用户取消任务",
+		RefundRemark: "取消任务退款 - SKU:" + task.SKU,
+	})
+	if err != nil {
+		transitionError(c, err, "取消任务失败")
+		return
 	}
 
-	tx.Commit()
-
 	response.Success(c, gin.H{
 		"task_id":        task.ID,
-		"refund_jingdou": refundAmount,
-		"balance":        user.JingdouBalance,
+		"refund_jingdou": result.Refund,
+		"balance":        result.Balance,
 	})
 }
 
@@ -119,17 +101,21 @@ func (h *TaskHandler) GetTaskTypes(c *gin.Context) {
 			"jingdou_price":    tt.JingdouPrice,
 			"is_active":        tt.IsActive,
 			"is_system_preset": tt.IsSystemPreset,
+			"expire_hours":     expireHours(tt),
 			"created_at":       tt.CreatedAt.Format(time.RFC3339),
 			"updated_at":       tt.UpdatedAt.Format(time.RFC3339),
 		}
 
-		// 仅管理员可见执行倍数
+		// 仅管理员可见执行倍数和代理地区要求
 		if isAdmin {
 			multiplier := tt.ExecuteMultiplier
 			if multiplier < 1 {
 				multiplier = 1 // 默认倍数为1
 			}
 			item["execute_multiplier"] = multiplier
+			item["proxy_province"] = tt.ProxyProvince
+			item["proxy_isp"] = tt.ProxyISP
+			item["proxy_required"] = tt.ProxyRequired
 		}
 
 		// 添加时间段信息（如果配置了时间限制）
@@ -167,19 +153,69 @@ func (h *TaskHandler) GetTaskTypes(c *gin.Context) {
 	})
 }
 
+// expireHours 任务类型的有效时长，未配置时为默认值
+func expireHours(tt models.TaskType) int {
+	if tt.ExpireHours > 0 {
+		return tt.ExpireHours
+	}
+	return services.DefaultTaskExpireHours
+}
+
 // CreateTaskType 创建任务类型
 // @Summary 创建任务类型
-// @Description 创建新的任务类型（仅管理员，不允许创建系统预设类型）
+// @Description 创建新的任务类型（仅管理员，不允许创建系统预设类型），可同时设置任务有效时长
 // @Tags 任务模块
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param request body models.CreateTaskTypeRequest true "任务类型信息"
-// @Success 201 {object} response.Response{data=object}
+// @Success 200 {object} response.Response{data=models.TaskType}
+// @Failure 400 {object} response.Response
 // @Router /tasks/types [post]
 func (h *TaskHandler) CreateTaskType(c *gin.Context) {
-	// 禁止创建任务类型，系统已预设所有类型
-	response.Error(c, http.StatusForbidden, "系统已预设所有任务类型，不允许创建新类型")
+	var req models.CreateTaskTypeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if req.ExpireHours == 0 {
+		req.ExpireHours = services.DefaultTaskExpireHours
+	}
+	if req.ExpireHours < services.MinTaskExpireHours || req.ExpireHours > services.MaxTaskExpireHours {
+		response.Errorf(c, http.StatusBadRequest, "任务有效时长必须在%d-%d小时之间",
+			services.MinTaskExpireHours, services.MaxTaskExpireHours)
+		return
+	}
+
+	var count int64
+	h.db.Model(&models.TaskType{}).Where("type_code = ?", req.TypeCode).Count(&count)
+	if count > 0 {
+		response.Error(c, http.StatusBadRequest, "任务类型代码已存在")
+		return
+	}
+
+	now := time.Now()
+	taskType := models.TaskType{
+		TypeCode:          req.TypeCode,
+		TypeName:          req.TypeName,
+		JingdouPrice:      req.JingdouPrice,
+		IsActive:          req.IsActive == nil || *req.IsActive,
+		ExecuteMultiplier: 1,
+		ExpireHours:       req.ExpireHours,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+	}
+	if err := h.db.Create(&taskType).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建任务类型失败")
+		return
+	}
+	// is_active 列有默认值，创建时 false 会被忽略
+	if !taskType.IsActive {
+		h.db.Model(&taskType).UpdateColumn("is_active", false)
+	}
+
+	response.SuccessWithMsg(c, "任务类型创建成功", taskType)
 }
 
 // UpdateTaskType 更新任务类型
@@ -210,6 +246,13 @@ func (h *TaskHandler) UpdateTaskType(c *gin.Context) {
 		req.IsActive,
 		req.ExecuteMultiplier)
 
+	if req.ExpireHours != nil &&
+		(*req.ExpireHours < services.MinTaskExpireHours || *req.ExpireHours > services.MaxTaskExpireHours) {
+		response.Errorf(c, http.StatusBadRequest, "任务有效时长必须在%d-%d小时之间",
+			services.MinTaskExpireHours, services.MaxTaskExpireHours)
+		return
+	}
+
 	var taskType models.TaskType
 	if err := h.db.First(&taskType, id).Error; err != nil {
 		response.Error(c, http.StatusNotFound, "任务类型不存在")
@@ -231,6 +274,9 @@ func (h *TaskHandler) UpdateTaskType(c *gin.Context) {
 		if req.ExecuteMultiplier != nil && *req.ExecuteMultiplier >= 1 {
 			taskType.ExecuteMultiplier = *req.ExecuteMultiplier
 		}
+		if req.ExpireHours != nil {
+			taskType.ExpireHours = *req.ExpireHours
+		}
 		if req.TimeSlot1Start != nil {
 			taskType.TimeSlot1Start = req.TimeSlot1Start
 		}
@@ -257,6 +303,9 @@ func (h *TaskHandler) UpdateTaskType(c *gin.Context) {
 		if req.ExecuteMultiplier != nil && *req.ExecuteMultiplier >= 1 {
 			taskType.ExecuteMultiplier = *req.ExecuteMultiplier
 		}
+		if req.ExpireHours != nil {
+			taskType.ExpireHours = *req.ExpireHours
+		}
 		if req.TimeSlot1Start != nil {
 			taskType.TimeSlot1Start = req.TimeSlot1Start
 		}
@@ -271,6 +320,17 @@ func (h *TaskHandler) UpdateTaskType(c *gin.Context) {
 		}
 	}
 
+	// 代理地区要求，预设和非预设类型都可修改
+	if req.ProxyProvince != nil {
+		taskType.ProxyProvince = strings.TrimSpace(*req.ProxyProvince)
+	}
+	if req.ProxyISP != nil {
+		taskType.ProxyISP = strings.TrimSpace(*req.ProxyISP)
+	}
+	if req.ProxyRequired != nil {
+		taskType.ProxyRequired = *req.ProxyRequired
+	}
+
 	taskType.UpdatedAt = time.Now()
 	if err := h.db.Save(&taskType).Error; err != nil {
 		response.Error(c, http.StatusInternalServerError, "保存失败，请重试")
@@ -424,110 +484,31 @@ func (h *TaskHandler) BatchCreateTasks(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	if err := h.db.First(&user, userID).Error; err != nil {
-		response.Error(c, http.StatusNotFound, "用户不存在")
-		return
-	}
-
-	// 计算总消耗
-	totalConsume := 0
-	for i, taskReq := range req.Tasks {
-		var taskType models.TaskType
-		if err := h.db.Where("type_code = ? AND is_active = ?", taskReq.TaskType, true).First(&taskType).Error; err != nil {
-			response.Error(c, http.StatusBadRequest, "无效的任务类型: "+taskReq.TaskType)
-			return
-		}
-		// 只有关键词搜索任务需要关键词参数
-		if taskReq.TaskType == "search_browse" && taskReq.Keyword == "" {
-			response.Error(c, http.StatusBadRequest, "关键词搜索任务必须填写关键词")
-			return
-		}
-		// 非关键词搜索任务不需要关键词，清空它
-		if taskReq.TaskType != "search_browse" {
-			req.Tasks[i].Keyword = ""
-		}
-		totalConsume += taskType.JingdouPrice * taskReq.ExecuteCount
+	specs := make([]services.TaskSpec, 0, len(req.Tasks))
+	for _, taskReq := range req.Tasks {
+		specs = append(specs, taskSpec(taskReq))
 	}
 
-	// 检查余额
-	isAdmin := user.Role == "admin"
-	if !isAdmin && user.JingdouBalance < totalConsume {
-		response.Error(c, http.StatusBadRequest, "京豆余额不足")
+	actor := services.TaskActor{UserID: userID.(uint), Source: "批量创建任务"}
+	result, err := services.NewTaskService(h.db).CreateTasks(c.Request.Context(), actor, specs)
+	if err != nil {
+		status, msg := taskCreateError(err, true)
+		response.Error(c, status, msg)
 		return
 	}
 
-	// 开始事务
-	tx := h.db.Begin()
-	createdIDs := make([]uint, 0)
-	successCount := 0
-
-	for _, taskReq := range req.Tasks {
-		var taskType models.TaskType
-		tx.Where("type_code = ?", taskReq.TaskType).First(&taskType)
-
-		consumeJingdou := taskType.JingdouPrice * taskReq.ExecuteCount
-		if isAdmin {
-			consumeJingdou = 0
-		}
-
-		task := models.Task{
-			UserID:         user.ID,
-			TaskType:       taskReq.TaskType,
-			SKU:            taskReq.SKU,
-			ShopName:       taskReq.ShopName,
-			Keyword:        taskReq.Keyword,
-			StartTime:      taskReq.StartTime,
-			ExecuteCount:   taskReq.ExecuteCount,
-			ExecutedCount:  0,
-			Priority:       taskReq.Priority,
-			Status:         "waiting",
-			ConsumeJingdou: consumeJingdou,
-			Remark:         taskReq.Remark,
-			CreatedAt:      time.Now(),
-			UpdatedAt:      time.Now(),
-		}
-
-		if err := tx.Create(&task).Error; err == nil {
-			createdIDs = append(createdIDs, task.ID)
-			successCount++
-
-			// 非管理员记录每个任务的京豆扣除日志
-			if !isAdmin && consumeJingdou > 0 {
-				jingdouLog := models.JingdouLog{
-					UserID:        user.ID,
-					Amount:        -consumeJingdou,
-					Balance:       user.JingdouBalance - consumeJingdou, // 估算余额
-					OperationType: "task",
-					RelatedID:     &task.ID,
-					Remark:        "批量创建任务扣除 - SKU:" + task.SKU,
-					CreatedAt:     time.Now(),
-				}
-				tx.Create(&jingdouLog)
-			}
-
-			// 更新或创建任务模板（仅普通用户）
-			if !isAdmin {
-				UpdateOrCreateTemplate(tx, user.ID, task.TaskType, task.SKU, task.ShopName, task.Keyword, task.ExecuteCount)
-			}
-		}
+	createdIDs := make([]uint, 0, len(result.Tasks))
+	for _, task := range result.Tasks {
+		createdIDs = append(createdIDs, task.ID)
 	}
 
-	// 扣除京豆
-	if !isAdmin && totalConsume > 0 {
-		user.JingdouBalance -= totalConsume
-		tx.Save(&user)
-	}
-
-	tx.Commit()
-
 	response.Success(c, gin.H{
 		"total_tasks":           len(req.Tasks),
-		"successful_tasks":      successCount,
-		"failed_tasks":          len(req.Tasks) - successCount,
-		"total_consume_jingdou": totalConsume,
-		"balance":               user.JingdouBalance,
-		"is_admin":              isAdmin,
+		"successful_tasks":      len(result.Tasks),
+		"failed_tasks":          len(req.Tasks) - len(result.Tasks),
+		"total_consume_jingdou": result.Consume,
+		"balance":               result.Balance,
+		"is_admin":              result.IsAdmin,
 		"created_task_ids":      createdIDs,
 	})
 }
@@ -0,0 +1,312 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// 导入文件大小上限
+const maxImportFileSize = 10 << 20
+
+// 导入详情中返回的错误行数上限，完整结果请下载结果文件
+const importErrorPreview = 100
+
+// ImportTasks 导入任务
+// @Summary 从CSV/Excel导入任务
+// @Description 上传 CSV 或 XLSX 文件批量创建任务。第一行为表头，默认识别「任务类型、SKU、店铺名称、关键词、开始时间、执行次数、优先级、有效时长、备注」及对应英文字段名，也可通过 mapping 指定字段对应的列，通过 defaults 为缺失的列或空单元格提供默认值。dry_run=true 时只校验并返回每行错误和预计消耗的京豆，之后可调用提交接口导入。不超过100行的文件直接导入，更大的文件转为后台导入，可通过详情接口或实时事件（import.progress）查看进度
+// @Tags 任务模块
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param file formData file true "CSV 或 XLSX 文件（最大10MB，最多10000行）"
+// @Param mapping formData string false "列映射（JSON），如 {\"sku\":\"商品编号\",\"execute_count\":\"数量\"}"
+// @Param defaults formData string false "默认值（JSON），如 {\"task_type\":\"search_order\",\"execute_count\":\"10\"}"
+// @Param dry_run formData bool false "只校验不导入"
+// @Success 200 {object} response.Response{data=object}
+// @Success 202 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Router /tasks/import [post]
+func (h *TaskHandler) ImportTasks(c *gin.Context) {
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "请上传导入文件")
+		return
+	}
+	if fileHeader.Size > maxImportFileSize {
+		response.Error(c, http.StatusBadRequest, "导入文件不能超过10MB")
+		return
+	}
+
+	var mapping, defaults map[string]string
+	if value := c.PostForm("mapping"); value != "" {
+		if err := json.Unmarshal([]byte(value), &mapping); err != nil {
+			response.Error(c, http.StatusBadRequest, "列映射格式错误")
+			return
+		}
+	}
+	if value := c.PostForm("defaults"); value != "" {
+		if err := json.Unmarshal([]byte(value), &defaults); err != nil {
+			response.Error(c, http.StatusBadRequest, "默认值格式错误")
+			return
+		}
+	}
+	dryRun, _ := strconv.ParseBool(c.DefaultPostForm("dry_run", "false"))
+
+	userID, _ := c.Get("user_id")
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, "无法读取导入文件")
+		return
+	}
+	defer file.Close()
+
+	fileType, rows, err := services.ReadImportFile(fileHeader.Filename, file)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	imp, err := services.CreateTaskImport(h.db, &user, fileHeader.Filename, fileType, rows, mapping, defaults, dryRun)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	h.startTaskImport(c, imp, &user)
+}
+
+// CommitTaskImport 提交试运行的导入
+// @Summary 提交试运行的导入
+// @Description 将试运行（dry_run）校验通过的行导入为任务，京豆和任务类型按提交时的价格重新计算
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "导入ID"
+// @Success 200 {object} response.Response{data=object}
+// @Success 202 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /tasks/imports/{id}/commit [post]
+func (h *TaskHandler) CommitTaskImport(c *gin.Context) {
+	imp, ok := h.findTaskImport(c)
+	if !ok {
+		return
+	}
+	if imp.Status != services.ImportStatusValidated {
+		response.Error(c, http.StatusBadRequest, "只有试运行的导入可以提交")
+		return
+	}
+
+	res := h.db.Model(&models.TaskImport{}).
+		Where("id = ? AND status = ?", imp.ID, services.ImportStatusValidated).
+		Update("status", services.ImportStatusPending)
+	if res.RowsAffected == 0 {
+		response.Error(c, http.StatusConflict, "导入已提交")
+		return
+	}
+	imp.Status = services.ImportStatusPending
+
+	var user models.User
+	if err := h.db.First(&user, imp.UserID).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "用户不存在")
+		return
+	}
+
+	h.startTaskImport(c, &imp, &user)
+}
+
+// startTaskImport 试运行直接返回校验结果；行数较少时在请求内导入，否则提交后台导入作业
+func (h *TaskHandler) startTaskImport(c *gin.Context, imp *models.TaskImport, user *models.User) {
+	switch {
+	case imp.Status == services.ImportStatusValidated:
+		data := h.taskImportDetail(imp)
+		data["balance"] = user.JingdouBalance
+		data["balance_sufficient"] = user.JingdouBalance >= imp.TotalJingdou
+		response.SuccessWithMsg(c, "校验完成", data)
+		return
+
+	case imp.ValidRows <= services.ImportSyncRows:
+		// 客户端断开时继续导入，避免只导入一部分
+		if err := services.ProcessTaskImport(context.WithoutCancel(c.Request.Context()), h.db, imp.ID); err != nil {
+			response.Error(c, http.StatusInternalServerError, "导入任务失败")
+			return
+		}
+		h.db.First(imp, imp.ID)
+		response.SuccessWithMsg(c, fmt.Sprintf("导入完成：成功 %d 行，失败 %d 行", imp.SuccessRows, imp.FailedRows+imp.InvalidRows), h.taskImportDetail(imp))
+		return
+	}
+
+	if _, err := services.SubmitTaskImportJob(h.db, imp); err != nil {
+		h.db.Model(&models.TaskImport{}).Where("id = ?", imp.ID).
+			Updates(map[string]interface{}{"status": services.ImportStatusFailed, "error": "提交后台导入失败"})
+		response.Error(c, http.StatusInternalServerError, "提交后台导入失败")
+		return
+	}
+
+	c.JSON(http.StatusAccepted, response.Response{
+		Code: 0,
+		Msg:  "文件较大，已转为后台导入",
+		Data: h.taskImportDetail(imp),
+	})
+}
+
+// GetTaskImports 获取导入记录
+// @Summary 获取任务导入记录
+// @Description 获取当前用户的任务导入记录及可导入的字段
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
+// @Success 200 {object} response.Response{data=object}
+// @Router /tasks/imports [get]
+func (h *TaskHandler) GetTaskImports(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	pagination, err := response.ParsePagination(c, "page_size", response.MaxPageSize)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := h.db.Model(&models.TaskImport{}).Where("user_id = ?", userID)
+
+	pagination.Count(query)
+
+	var imports []models.TaskImport
+	if err := response.Find(pagination, query, "created_at", &imports, func(imp *models.TaskImport) (time.Time, uint) {
+		return imp.CreatedAt, imp.ID
+	}); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
+
+	data := pagination.Meta("page_size")
+	data["items"] = imports
+	data["fields"] = services.ImportFields
+	response.Success(c, data)
+}
+
+// GetTaskImport 获取导入详情
+// @Summary 获取任务导入详情
+// @Description 获取导入进度、京豆消耗和前100条错误行
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "导入ID"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 404 {object} response.Response
+// @Router /tasks/imports/{id} [get]
+func (h *TaskHandler) GetTaskImport(c *gin.Context) {
+	imp, ok := h.findTaskImport(c)
+	if !ok {
+		return
+	}
+	response.Success(c, h.taskImportDetail(&imp))
+}
+
+// DownloadTaskImportResult 下载导入结果文件
+// @Summary 下载任务导入结果
+// @Description 下载导入结果文件：原文件的每一行后追加导入结果、任务ID、消耗京豆和错误信息
+// @Tags 任务模块
+// @Produce octet-stream
+// @Security BearerAuth
+// @Param id path int true "导入ID"
+// @Param format query string false "文件格式：csv, xlsx（默认与上传文件相同）"
+// @Success 200 {file} file "结果文件"
+// @Failure 404 {object} response.Response
+// @Router /tasks/imports/{id}/result [get]
+func (h *TaskHandler) DownloadTaskImportResult(c *gin.Context) {
+	imp, ok := h.findTaskImport(c)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", imp.FileType)
+	if format != "csv" && format != "xlsx" {
+		response.Error(c, http.StatusBadRequest, "文件格式只支持 csv 和 xlsx")
+		return
+	}
+
+	var rows []models.TaskImportRow
+	h.db.Where("import_id = ?", imp.ID).Order("line ASC").Find(&rows)
+
+	contentType := "text/csv; charset=utf-8"
+	if format == "xlsx" {
+		contentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	name := strings.TrimSuffix(imp.FileName, "."+imp.FileType)
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"import-%d-result.%s\"; filename*=UTF-8''%s",
+		imp.ID, format, url.PathEscape(name+"-result."+format)))
+	c.Status(http.StatusOK)
+	services.WriteImportResult(c.Writer, format, &imp, rows)
+}
+
+// findTaskImport 按路径参数查询当前用户的导入记录（管理员可查看全部）
+func (h *TaskHandler) findTaskImport(c *gin.Context) (models.TaskImport, bool) {
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+
+	var imp models.TaskImport
+	query := h.db.Where("id = ?", c.Param("id"))
+	if role != "admin" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.First(&imp).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "导入记录不存在")
+		return imp, false
+	}
+	return imp, true
+}
+
+// taskImportDetail 导入详情：进度、京豆消耗和错误行预览
+func (h *TaskHandler) taskImportDetail(imp *models.TaskImport) gin.H {
+	var errorRows []models.TaskImportRow
+	h.db.Select("line", "status", "error").
+		Where("import_id = ? AND status IN ?", imp.ID, []string{services.ImportRowInvalid, services.ImportRowFailed}).
+		Order("line ASC").Limit(importErrorPreview).Find(&errorRows)
+
+	errs := make([]gin.H, 0, len(errorRows))
+	for _, row := range errorRows {
+		errs = append(errs, gin.H{
+			"line":   row.Line,
+			"status": row.Status,
+			"error":  row.Error,
+		})
+	}
+
+	progress := 100.0
+	if imp.ValidRows > 0 && imp.Status != services.ImportStatusValidated {
+		progress = float64(imp.ProcessedRows) / float64(imp.ValidRows) * 100
+	}
+
+	return gin.H{
+		"import":     imp,
+		"progress":   progress,
+		"errors":     errs,
+		"result_url": fmt.Sprintf("/api/tasks/imports/%d/result", imp.ID),
+	}
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/internal/taskstate"
+	"jd-task-platform-go/pkg/response"
+)
+
+// PauseTask 暂停任务
+// @Summary 暂停任务
+// @Description 暂停等待中或执行中的任务，暂停后不再下发给设备（任务所有者或管理员）
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "任务ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /tasks/{id}/pause [post]
+func (h *TaskHandler) PauseTask(c *gin.Context) {
+	task, pausedBy, ok := h.ownedTask(c)
+	if !ok {
+		return
+	}
+
+	if task.Status != "waiting" && task.Status != "running" {
+		response.Error(c, http.StatusBadRequest, "只有等待中或执行中的任务可以暂停")
+		return
+	}
+
+	count, err := services.PauseTasks(h.db, taskScope(task.ID), pausedBy,
+		fmt.Sprintf("任务已暂停（%s操作）", operatorText(pausedBy)))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "暂停任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "任务已暂停", gin.H{"task_id": task.ID, "affected": count})
+}
+
+// ResumeTask 恢复任务
+// @Summary 恢复任务
+// @Description 恢复已暂停的任务，普通用户不能恢复管理员暂停的任务（任务所有者或管理员）
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "任务ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /tasks/{id}/resume [post]
+func (h *TaskHandler) ResumeTask(c *gin.Context) {
+	task, resumedBy, ok := h.ownedTask(c)
+	if !ok {
+		return
+	}
+
+	if task.Status != taskstate.Paused {
+		response.Error(c, http.StatusBadRequest, "只有已暂停的任务可以恢复")
+		return
+	}
+	if resumedBy != services.PausedByAdmin && task.PausedBy == services.PausedByAdmin {
+		response.Error(c, http.StatusForbidden, "该任务由管理员暂停，无法自行恢复")
+		return
+	}
+
+	count, err := services.ResumeTasks(h.db, taskScope(task.ID), resumedBy,
+		fmt.Sprintf("任务已恢复（%s操作）", operatorText(resumedBy)))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "恢复任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "任务已恢复", gin.H{"task_id": task.ID, "affected": count})
+}
+
+// PauseUserTasks 暂停用户的所有任务
+// @Summary 暂停用户的所有任务
+// @Description 暂停指定用户所有等待中和执行中的任务（仅管理员）
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param user_id path int true "用户ID"
+// @Success 200 {object} response.Response
+// @Router /tasks/users/{user_id}/pause [post]
+func (h *TaskHandler) PauseUserTasks(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	count, err := services.PauseTasks(h.db, userScope(userID), services.PausedByAdmin,
+		"管理员暂停该用户全部任务")
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "暂停任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, fmt.Sprintf("已暂停 %d 个任务", count), gin.H{"affected": count})
+}
+
+// ResumeUserTasks 恢复用户的所有任务
+// @Summary 恢复用户的所有任务
+// @Description 恢复指定用户所有已暂停的任务（仅管理员）
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param user_id path int true "用户ID"
+// @Success 200 {object} response.Response
+// @Router /tasks/users/{user_id}/resume [post]
+func (h *TaskHandler) ResumeUserTasks(c *gin.Context) {
+	userID := c.Param("user_id")
+
+	count, err := services.ResumeTasks(h.db, userScope(userID), services.PausedByAdmin,
+		"管理员恢复该用户全部任务")
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "恢复任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, fmt.Sprintf("已恢复 %d 个任务", count), gin.H{"affected": count})
+}
+
+// PauseTaskType 暂停任务类型下的所有任务
+// @Summary 暂停任务类型下的所有任务
+// @Description 暂停指定任务类型所有等待中和执行中的任务，与停用任务类型不同，停用只阻止新建任务（仅管理员）
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "任务类型ID"
+// @Success 200 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /tasks/types/{id}/pause [post]
+func (h *TaskHandler) PauseTaskType(c *gin.Context) {
+	var taskType models.TaskType
+	if err := h.db.First(&taskType, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "任务类型不存在")
+		return
+	}
+
+	count, err := services.PauseTasks(h.db, taskTypeScope(taskType.TypeCode), services.PausedByAdmin,
+		fmt.Sprintf("管理员暂停任务类型「%s」全部任务", taskType.TypeName))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "暂停任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, fmt.Sprintf("已暂停 %d 个任务", count), gin.H{"affected": count})
+}
+
+// ResumeTaskType 恢复任务类型下的所有任务
+// @Summary 恢复任务类型下的所有任务
+// @Description 恢复指定任务类型所有已暂停的任务（仅管理员）
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "任务类型ID"
+// @Success 200 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /tasks/types/{id}/resume [post]
+func (h *TaskHandler) ResumeTaskType(c *gin.Context) {
+	var taskType models.TaskType
+	if err := h.db.First(&taskType, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "任务类型不存在")
+		return
+	}
+
+	count, err := services.ResumeTasks(h.db, taskTypeScope(taskType.TypeCode), services.PausedByAdmin,
+		fmt.Sprintf("管理员恢复任务类型「%s」全部任务", taskType.TypeName))
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "恢复任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, fmt.Sprintf("已恢复 %d 个任务", count), gin.H{"affected": count})
+}
+
+// ownedTask 查询任务并校验权限，返回操作来源（user 或 admin）
+func (h *TaskHandler) ownedTask(c *gin.Context) (models.Task, string, bool) {
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+
+	var task models.Task
+	if err := h.db.First(&task, c.Param("id")).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "任务不存在")
+		return task, "", false
+	}
+
+	if role == "admin" {
+		return task, services.PausedByAdmin, true
+	}
+	if task.UserID != userID.(uint) {
+		response.Error(c, http.StatusForbidden, "无权操作此任务")
+		return task, "", false
+	}
+	return task, services.PausedByUser, true
+}
+
+// taskScope 单个任务
+func taskScope(taskID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("id = ?", taskID)
+	}
+}
+
+// userScope 某用户的全部任务
+func userScope(userID interface{}) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", userID)
+	}
+}
+
+// taskTypeScope 某任务类型的全部任务
+func taskTypeScope(typeCode string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("task_type = ?", typeCode)
+	}
+}
+
+// operatorText 操作来源描述
+func operatorText(operator string) string {
+	if operator == services.PausedByAdmin {
+		return "管理员"
+	}
+	return "用户"
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// TaskScheduleHandler 周期任务处理器
+// 同时用于用户接口（JWT认证）和开放API（API Key认证）
+type TaskScheduleHandler struct {
+	db *gorm.DB
+}
+
+// NewTaskScheduleHandler 创建周期任务处理器
+func NewTaskScheduleHandler(db *gorm.DB) *TaskScheduleHandler {
+	return &TaskScheduleHandler{db: db}
+}
+
+// GetSchedules 获取周期任务列表
+// @Summary 获取周期任务列表
+// @Description 获取当前用户的周期任务，管理员可查看全部
+// @Tags 周期任务
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
+// @Param status query string false "状态：active, paused, completed"
+// @Success 200 {object} response.Response{data=object}
+// @Router /schedules [get]
+func (h *TaskScheduleHandler) GetSchedules(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+
+	pagination, err := response.ParsePagination(c, "page_size", response.MaxPageSize)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := h.db.Model(&models.TaskSchedule{})
+	if role != "admin" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	pagination.Count(query)
+
+	var schedules []models.TaskSchedule
+	if err := response.Find(pagination, query, "created_at", &schedules, func(s *models.TaskSchedule) (time.Time, uint) {
+		return s.CreatedAt, s.ID
+	}); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
+
+	data := pagination.Meta("page_size")
+	data["items"] = schedules
+	response.Success(c, data)
+}
+
+// GetSchedule 获取周期任务详情
+// @Summary 获取周期任务详情
+// @Description 获取周期任务详情及最近生成的任务
+// @Tags 周期任务
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "周期任务ID"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 404 {object} response.Response
+// @Router /schedules/{id} [get]
+func (h *TaskScheduleHandler) GetSchedule(c *gin.Context) {
+	schedule, ok := h.findSchedule(c)
+	if !ok {
+		return
+	}
+
+	// 最近生成的任务
+	var recentTasks []models.Task
+	h.db.Where("schedule_id = ?", schedule.ID).Order("start_time DESC").Limit(10).Find(&recentTasks)
+
+	response.Success(c, gin.H{
+		"schedule":     schedule,
+		"recent_tasks": recentTasks,
+	})
+}
+
+// CreateSchedule 创建周期任务
+// @Summary 创建周期任务
+// @Description 创建周期任务定义，支持cron表达式或每日/每周规则。调度服务提前生成具体任务并按正常流程扣除京豆，余额不足时自动暂停
+// @Tags 周期任务
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.TaskScheduleRequest true "周期任务信息"
+// @Success 200 {object} response.Response{data=models.TaskSchedule}
+// @Failure 400 {object} response.Response
+// @Router /schedules [post]
+func (h *TaskScheduleHandler) CreateSchedule(c *gin.Context) {
+	var req models.TaskScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	schedule := models.TaskSchedule{
+		UserID:    userID.(uint),
+		Status:    services.ScheduleStatusActive,
+		CreatedAt: time.Now(),
+	}
+	taskType, ok := h.applyRequest(c, &schedule, req)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Create(&schedule).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建周期任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "周期任务创建成功", gin.H{
+		"schedule":        schedule,
+		"jingdou_per_run": taskType.JingdouPrice * schedule.ExecuteCount,
+		"next_run_at":     schedule.NextRunAt,
+	})
+}
+
+// UpdateSchedule 修改周期任务
+// @Summary 修改周期任务
+// @Description 修改周期任务定义，下一次执行时间按新规则重新计算，已生成的任务不受影响
+// @Tags 周期任务
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "周期任务ID"
+// @Param request body models.TaskScheduleRequest true "周期任务信息"
+// @Success 200 {object} response.Response{data=models.TaskSchedule}
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /schedules/{id} [put]
+func (h *TaskScheduleHandler) UpdateSchedule(c *gin.Context) {
+	schedule, ok := h.findSchedule(c)
+	if !ok {
+		return
+	}
+
+	var req models.TaskScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	if schedule.Status == services.ScheduleStatusCompleted {
+		response.Error(c, http.StatusBadRequest, "周期任务已结束，无法修改")
+		return
+	}
+
+	status := schedule.Status
+	if _, ok := h.applyRequest(c, &schedule, req); !ok {
+		return
+	}
+
+	// 只更新请求中的字段，生成次数和已消耗京豆由调度器维护
+	updated, ok := h.updateSchedule(c, schedule.ID, status, map[string]interface{}{
+		"name":          schedule.Name,
+		"task_type":     schedule.TaskType,
+		"sku":           schedule.SKU,
+		"shop_name":     schedule.ShopName,
+		"keyword":       schedule.Keyword,
+		"execute_count": schedule.ExecuteCount,
+		"priority":      schedule.Priority,
+		"remark":        schedule.Remark,
+		"schedule_type": schedule.ScheduleType,
+		"cron_expr":     schedule.CronExpr,
+		"run_time":      schedule.RunTime,
+		"weekdays":      schedule.Weekdays,
+		"end_date":      schedule.EndDate,
+		"max_runs":      schedule.MaxRuns,
+		"budget_cap":    schedule.BudgetCap,
+		"next_run_at":   schedule.NextRunAt,
+		"updated_at":    schedule.UpdatedAt,
+	})
+	if !ok {
+		return
+	}
+
+	response.SuccessWithMsg(c, "周期任务修改成功", updated)
+}
+
+// DeleteSchedule 删除周期任务
+// @Summary 删除周期任务
+// @Description 删除周期任务定义，已生成的任务不受影响
+// @Tags 周期任务
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "周期任务ID"
+// @Success 200 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /schedules/{id} [delete]
+func (h *TaskScheduleHandler) DeleteSchedule(c *gin.Context) {
+	schedule, ok := h.findSchedule(c)
+	if !ok {
+		return
+	}
+
+	if err := h.db.Delete(&schedule).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "删除周期任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "周期任务已删除", nil)
+}
+
+// PauseSchedule 暂停周期任务
+// @Summary 暂停周期任务
+// @Description 暂停周期任务，暂停期间不再生成新任务
+// @Tags 周期任务
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "周期任务ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /schedules/{id}/pause [post]
+func (h *TaskScheduleHandler) PauseSchedule(c *gin.Context) {
+	schedule, ok := h.findSchedule(c)
+	if !ok {
+		return
+	}
+
+	if schedule.Status != services.ScheduleStatusActive {
+		response.Error(c, http.StatusBadRequest, "只有运行中的周期任务可以暂停")
+		return
+	}
+
+	updated, ok := h.updateSchedule(c, schedule.ID, services.ScheduleStatusActive, map[string]interface{}{
+		"status":       services.ScheduleStatusPaused,
+		"pause_reason": "用户手动暂停",
+		"updated_at":   time.Now(),
+	})
+	if !ok {
+		return
+	}
+
+	response.SuccessWithMsg(c, "周期任务已暂停", updated)
+}
+
+// ResumeSchedule 恢复周期任务
+// @Summary 恢复周期任务
+// @Description 恢复已暂停的周期任务，从当前时间起重新计算下一次执行时间（暂停期间错过的执行不会补生成）
+// @Tags 周期任务
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "周期任务ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /schedules/{id}/resume [post]
+func (h *TaskScheduleHandler) ResumeSchedule(c *gin.Context) {
+	schedule, ok := h.findSchedule(c)
+	if !ok {
+		return
+	}
+
+	if schedule.Status != services.ScheduleStatusPaused {
+		response.Error(c, http.StatusBadRequest, "只有已暂停的周期任务可以恢复")
+		return
+	}
+
+	nextRun, err := services.NextScheduleRun(&schedule, time.Now())
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if nextRun == nil {
+		response.Error(c, http.StatusBadRequest, "周期任务已超过截止日期，无法恢复")
+		return
+	}
+
+	updated, ok := h.updateSchedule(c, schedule.ID, services.ScheduleStatusPaused, map[string]interface{}{
+		"status":       services.ScheduleStatusActive,
+		"pause_reason": "",
+		"next_run_at":  nextRun,
+		"updated_at":   time.Now(),
+	})
+	if !ok {
+		return
+	}
+
+	response.SuccessWithMsg(c, "周期任务已恢复", updated)
+}
+
+// updateSchedule 在周期任务仍处于 status 状态时更新指定字段并返回最新的周期任务
+// 调度器可能同时在推进生成次数，不能整行保存读取时的副本
+func (h *TaskScheduleHandler) updateSchedule(c *gin.Context, id uint, status string, updates map[string]interface{}) (*models.TaskSchedule, bool) {
+	res := h.db.Model(&models.TaskSchedule{}).Where("id = ? AND status = ?", id, status).Updates(updates)
+	if res.Error != nil {
+		response.Error(c, http.StatusInternalServerError, "保存周期任务失败")
+		return nil, false
+	}
+	if res.RowsAffected == 0 {
+		response.Error(c, http.StatusConflict, "周期任务状态已变化，请刷新后重试")
+		return nil, false
+	}
+
+	var schedule models.TaskSchedule
+	if err := h.db.First(&schedule, id).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询周期任务失败")
+		return nil, false
+	}
+	return &schedule, true
+}
+
+// findSchedule 按路径参数查询周期任务，普通用户只能访问自己的周期任务
+func (h *TaskScheduleHandler) findSchedule(c *gin.Context) (models.TaskSchedule, bool) {
+	userID, _ := c.Get("user_id")
+	role, _ := c.Get("role")
+
+	var schedule models.TaskSchedule
+	query := h.db.Where("id = ?", c.Param("id"))
+	if role != "admin" {
+		query = query.Where("user_id = ?", userID)
+	}
+	if err := query.First(&schedule).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "周期任务不存在")
+		return schedule, false
+	}
+	return schedule, true
+}
+
+// applyRequest 校验请求并写入周期任务定义，同时计算下一次执行时间
+func (h *TaskScheduleHandler) applyRequest(c *gin.Context, schedule *models.TaskSchedule, req models.TaskScheduleRequest) (models.TaskType, bool) {
+	var taskType models.TaskType
+	if err := h.db.Where("type_code = ?", req.TaskType).First(&taskType).Error; err != nil {
+		response.Error(c, http.StatusBadRequest, "任务类型不存在")
+		return taskType, false
+	}
+	if !taskType.IsActive {
+		response.Error(c, http.StatusBadRequest, "任务类型已停用")
+		return taskType, false
+	}
+
+	if req.ExecuteCount <= 0 {
+		response.Error(c, http.StatusBadRequest, "执行次数必须大于0")
+		return taskType, false
+	}
+	if req.MaxRuns < 0 || req.BudgetCap < 0 {
+		response.Error(c, http.StatusBadRequest, "生成次数和预算上限不能为负数")
+		return taskType, false
+	}
+
+	// 与创建任务一致：只有关键词搜索任务需要关键词
+	if req.TaskType == "search_browse" && req.Keyword == "" {
+		response.Error(c, http.StatusBadRequest, "关键词搜索任务必须填写关键词")
+		return taskType, false
+	}
+	if req.TaskType != "search_browse" {
+		req.Keyword = ""
+	}
+
+	schedule.Name = req.Name
+	schedule.TaskType = req.TaskType
+	schedule.SKU = req.SKU
+	schedule.ShopName = req.ShopName
+	schedule.Keyword = req.Keyword
+	schedule.ExecuteCount = req.ExecuteCount
+	schedule.Priority = req.Priority
+	schedule.Remark = req.Remark
+	schedule.ScheduleType = req.ScheduleType
+	schedule.CronExpr = req.CronExpr
+	schedule.RunTime = req.RunTime
+	schedule.Weekdays = req.Weekdays
+	schedule.EndDate = req.EndDate
+	schedule.MaxRuns = req.MaxRuns
+	schedule.BudgetCap = req.BudgetCap
+	schedule.UpdatedAt = time.Now()
+
+	nextRun, err := services.NextScheduleRun(schedule, time.Now())
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return taskType, false
+	}
+	if nextRun == nil {
+		response.Error(c, http.StatusBadRequest, "截止日期早于首次执行时间")
+		return taskType, false
+	}
+	schedule.NextRunAt = nextRun
+
+	return taskType, true
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/taskstate"
+	"jd-task-platform-go/pkg/response"
+)
+
+// GetTaskStatusHistory 获取任务状态变更历史
+// @Summary 获取任务状态变更历史
+// @Description 获取任务每次状态变更的来源、说明和退款（任务所有者或管理员）
+// @Tags 任务模块
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "任务ID"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 403 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /tasks/{id}/history [get]
+func (h *TaskHandler) GetTaskStatusHistory(c *gin.Context) {
+	task, _, ok := h.ownedTask(c)
+	if !ok {
+		return
+	}
+
+	var history []models.TaskStatusHistory
+	h.db.Where("task_id = ?", task.ID).Order("id ASC").Find(&history)
+
+	items := make([]gin.H, 0, len(history))
+	for _, item := range history {
+		items = append(items, gin.H{
+			"id":          item.ID,
+			"from_status": item.FromStatus,
+			"from_text":   taskstate.Text(item.FromStatus),
+			"to_status":   item.ToStatus,
+			"to_text":     taskstate.Text(item.ToStatus),
+			"actor":       item.Actor,
+			"actor_id":    item.ActorID,
+			"reason":      item.Reason,
+			"refund":      item.Refund,
+			"created_at":  item.CreatedAt,
+		})
+	}
+
+	response.Success(c, gin.H{
+		"task_id": task.ID,
+		"status":  task.Status,
+		"items":   items,
+	})
+}
+
+// actorOf 根据角色确定状态变更的操作来源
+func actorOf(role interface{}) string {
+	if role == "admin" {
+		return taskstate.ActorAdmin
+	}
+	return taskstate.ActorUser
+}
+
+// actorID 状态历史中记录的操作用户ID
+func actorID(userID interface{}) *uint {
+	id, ok := userID.(uint)
+	if !ok {
+		return nil
+	}
+	return &id
+}
+
+// transitionError 输出状态变更失败的响应：非法转换返回400，并发冲突返回409
+func transitionError(c *gin.Context, err error, fallback string) {
+	var transitionErr *taskstate.TransitionError
+	switch {
+	case errors.As(err, &transitionErr):
+		response.Error(c, http.StatusBadRequest, transitionErr.Error())
+	case errors.Is(err, taskstate.ErrConflict):
+		response.Error(c, http.StatusConflict, err.Error())
+	default:
+		response.Error(c, http.StatusInternalServerError, fallback)
+	}
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+)
+
+// taskWindow 待执行任务的剩余执行窗口 [from, to)
+// 剩余执行次数按时间均匀分布在窗口内，用于未来趋势和压力预测
+type taskWindow struct {
+	task models.Task
+	from time.Time
+	to   time.Time
+}
+
+// pendingTaskWindows 查询在 now 之后仍可执行的等待中/执行中任务
+// taskType 为空表示全部任务类型
+func pendingTaskWindows(db *gorm.DB, now time.Time, taskType string) []taskWindow {
+	query := db.Model(&models.Task{}).
+		Where("status IN ? AND executed_count < execute_count", []string{"waiting", "running"}).
+		Where("end_time IS NULL OR end_time > ?", now)
+	if taskType != "" {
+		query = query.Where("task_type = ?", taskType)
+	}
+
+	// 只读取计算执行窗口需要的字段
+	var tasks []models.Task
+	query.Select("id", "start_time", "end_time", "execute_count", "executed_count", "consume_jingdou").Find(&tasks)
+
+	windows := make([]taskWindow, 0, len(tasks))
+	for _, task := range tasks {
+		from := task.StartTime
+		if from.Before(now) {
+			from = now
+		}
+		to := task.StartTime.Add(services.DefaultTaskExpireHours * time.Hour)
+		if task.EndTime != nil {
+			to = *task.EndTime
+		}
+		if !to.After(from) {
+			continue
+		}
+		windows = append(windows, taskWindow{task: task, from: from, to: to})
+	}
+	return windows
+}
+
+// pending 剩余执行次数
+func (w taskWindow) pending() int {
+	return w.task.ExecuteCount - w.task.ExecutedCount
+}
+
+// share 窗口落在 [start, end) 内的比例，0 表示不重叠
+func (w taskWindow) share(start, end time.Time) float64 {
+	if w.from.After(start) {
+		start = w.from
+	}
+	if w.to.Before(end) {
+		end = w.to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return float64(end.Sub(start)) / float64(w.to.Sub(w.from))
+}
@@ -4,7 +4,6 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"net/http"
-	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -152,33 +151,26 @@ func (h *UserHandler) GenerateAPIKey(c *gin.Context) {
 // @Produce json
 // @Security BearerAuth
 // @Param page query int false "页码" default(1)
-// @Param per_page query int false "每页数量" default(10)
+// @Param per_page query int false "每页数量" default(20)
 // @Success 200 {object} response.Response
 // @Failure 403 {object} response.Response
 // @Router /users [get]
 func (h *UserHandler) GetUsers(c *gin.Context) {
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "10"))
+	pagination := response.ParsePage(c, "per_page", response.NoPageSizeLimit)
 	search := c.Query("search")
 
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 {
-		perPage = 10
-	}
-
 	query := h.db.Model(&models.User{})
 	if search != "" {
 		query = query.Where("username LIKE ? OR nickname LIKE ?", "%"+search+"%", "%"+search+"%")
 	}
 
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	var users []models.User
-	offset := (page - 1) * perPage
-	query.Offset(offset).Limit(perPage).Find(&users)
+	if err := query.Order("id ASC").Offset(pagination.Offset()).Limit(pagination.PageSize).Find(&users).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
 	items := make([]gin.H, 0)
 	for _, user := range users {
@@ -238,15 +230,9 @@ func (h *UserHandler) GetUsers(c *gin.Context) {
 		})
 	}
 
-	pages := int((total + int64(perPage) - 1) / int64(perPage))
-
-	response.Success(c, gin.H{
-		"items":    items,
-		"page":     page,
-		"per_page": perPage,
-		"total":    total,
-		"pages":    pages,
-	})
+	data := pagination.Meta("per_page")
+	data["items"] = items
+	response.Success(c, data)
 }
 
 // GetUserByID 获取用户详情（管理员）
@@ -10,6 +10,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 
 	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
 	"jd-task-platform-go/pkg/response"
 )
 
@@ -123,7 +124,9 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 	if req.IsActive != nil {
 		user.IsActive = *req.IsActive
 	}
+	balanceChange := 0
 	if req.JingdouBalance != nil {
+		balanceChange = *req.JingdouBalance - user.JingdouBalance
 		user.JingdouBalance = *req.JingdouBalance
 	}
 	if req.Password != nil && len(*req.Password) >= 6 {
@@ -138,6 +141,8 @@ func (h *UserHandler) UpdateUser(c *gin.Context) {
 		return
 	}
 
+	services.PublishBalance(user.ID, user.JingdouBalance, balanceChange, "admin_set")
+
 	response.SuccessWithMsg(c, "用户信息更新成功", nil)
 }
 
@@ -232,6 +237,8 @@ func (h *UserHandler) AdjustJingdou(c *gin.Context) {
 
 	tx.Commit()
 
+	services.PublishBalance(user.ID, user.JingdouBalance, req.Amount, opType)
+
 	response.Success(c, gin.H{
 		"balance": user.JingdouBalance,
 		"amount":  req.Amount,
@@ -9,6 +9,7 @@ import (
 	"gorm.io/gorm"
 
 	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
 	"jd-task-platform-go/pkg/response"
 )
 
@@ -232,129 +233,46 @@ func (h *UserHomeHandler) QuickCreateTask(c *gin.Context) {
 		taskTypeCode = req.TaskType
 	}
 
-	// 获取任务类型信息
-	var taskType models.TaskType
-	if err := h.db.Where("type_code = ? AND is_active = ?", taskTypeCode, true).First(&taskType).Error; err != nil {
-		response.Error(c, http.StatusBadRequest, "任务类型不可用或未启用")
-		return
-	}
-
-	// 处理关键词：优先使用请求中的，否则使用默认值
+	// 处理关键词和店铺名称：优先使用请求中的，否则使用模板中的
 	if req.Keyword != "" {
 		keyword = req.Keyword
 	}
-	// 搜索关键词浏览任务必须有关键词
-	if taskTypeCode == "search_browse" && keyword == "" {
-		response.Error(c, http.StatusBadRequest, "关键词搜索浏览任务必须填写搜索关键词")
-		return
-	}
-	// 非关键词搜索任务不需要关键词
-	if taskTypeCode != "search_browse" {
-		keyword = ""
-	}
-
-	// 处理店铺名称：优先使用请求中的，否则使用默认值
 	if req.ShopName != "" {
 		shopName = req.ShopName
 	}
 	// 搜索关键词浏览任务必须有店铺名称
-	if taskTypeCode == "search_browse" && shopName == "" {
+	if taskTypeCode == services.KeywordTaskType && shopName == "" {
 		response.Error(c, http.StatusBadRequest, "关键词搜索浏览任务必须填写店铺名称")
 		return
 	}
 
-	// 获取用户信息
-	var user models.User
-	if err := h.db.First(&user, userID).Error; err != nil {
-		response.Error(c, http.StatusNotFound, "用户不存在")
-		return
-	}
-
-	// 计算京豆消耗
-	consumeJingdou := taskType.JingdouPrice * req.ExecuteCount
-	isAdmin := user.Role == "admin"
-	if isAdmin {
-		consumeJingdou = 0
+	spec := services.TaskSpec{
+		TaskType:     taskTypeCode,
+		SKU:          sku,
+		ShopName:     shopName,
+		Keyword:      keyword,
+		StartTime:    req.StartTime,
+		ExecuteCount: req.ExecuteCount,
+		Remark:       "快速创建",
+		ExpireHours:  req.ExpireHours,
 	}
-
-	// 检查余额
-	if !isAdmin && user.JingdouBalance < consumeJingdou {
-		response.Error(c, http.StatusBadRequest, "京豆余额不足")
-		return
+	if template != nil {
+		spec.TemplateID = template.ID
 	}
 
-	// 开始事务
-	tx := h.db.Begin()
-
-	// 创建任务
-	task := models.Task{
-		UserID:         user.ID,
-		TaskType:       taskTypeCode,
-		SKU:            sku,
-		ShopName:       shopName,
-		Keyword:        keyword,
-		StartTime:      req.StartTime,
-		ExecuteCount:   req.ExecuteCount,
-		ExecutedCount:  0,
-		Priority:       0,
-		Status:         "waiting",
-		ConsumeJingdou: consumeJingdou,
-		Remark:         "快速创建",
-		CreatedAt:      time.Now(),
-		UpdatedAt:      time.Now(),
-	}
-
-	if err := tx.Create(&task).Error; err != nil {
-		tx.Rollback()
-		response.Error(c, http.StatusInternalServerError, "创建任务失败")
+	actor := services.TaskActor{UserID: userID.(uint), Source: "快速创建任务"}
+	result, err := services.NewTaskService(h.db).CreateTasks(c.Request.Context(), actor, []services.TaskSpec{spec})
+	if err != nil {
+		status, msg := taskCreateError(err, false)
+		response.Error(c, status, msg)
 		return
 	}
 
-	// 扣除京豆
-	if !isAdmin && consumeJingdou > 0 {
-		user.JingdouBalance -= consumeJingdou
-		tx.Save(&user)
-
-		// 记录京豆日志
-		jingdouLog := models.JingdouLog{
-			UserID:        user.ID,
-			Amount:        -consumeJingdou,
-			Balance:       user.JingdouBalance,
-			OperationType: "task",
-			RelatedID:     &task.ID,
-			Remark:        "快速创建任务扣除 - SKU:" + task.SKU,
-			CreatedAt:     time.Now(),
-		}
-		tx.Create(&jingdouLog)
-	}
-
-	// 如果是模板模式，更新模板使用次数和参数
-	if template != nil {
-		template.TotalCreatedCount += req.ExecuteCount
-		template.LastUsedAt = time.Now()
-		template.UpdatedAt = time.Now()
-		// 如果提供了新的关键词或店铺名，更新到模板
-		if req.Keyword != "" && req.Keyword != template.Keyword {
-			template.Keyword = req.Keyword
-		}
-		if req.ShopName != "" && req.ShopName != template.ShopName {
-			template.ShopName = req.ShopName
-		}
-		tx.Save(template)
-	} else {
-		// 新任务模式，创建模板（仅普通用户）
-		if !isAdmin {
-			UpdateOrCreateTemplate(tx, user.ID, taskTypeCode, sku, shopName, keyword, req.ExecuteCount)
-		}
-	}
-
-	tx.Commit()
-
 	response.Success(c, gin.H{
 		"message":         "任务创建成功",
-		"task_id":         task.ID,
-		"consume_jingdou": consumeJingdou,
-		"jingdou_balance": user.JingdouBalance,
+		"task_id":         result.Tasks[0].ID,
+		"consume_jingdou": result.Consume,
+		"jingdou_balance": result.Balance,
 	})
 }
 
@@ -425,69 +343,6 @@ func (h *UserHomeHandler) GetTemplatePrice(c *gin.Context) {
 	})
 }
 
-// UpdateOrCreateTemplate 更新或创建任务模板（内部方法，任务创建时调用）
-func UpdateOrCreateTemplate(db *gorm.DB, userID uint, taskType, sku, shopName, keyword string, executeCount int) {
-	var template models.TaskTemplate
-	err := db.Where("user_id = ? AND sku = ? AND task_type = ?", userID, sku, taskType).First(&template).Error
-
-	now := time.Now()
-	if err == gorm.ErrRecordNotFound {
-		// 创建新模板
-		template = models.TaskTemplate{
-			UserID:            userID,
-			TaskType:          taskType,
-			SKU:               sku,
-			ShopName:          shopName,
-			Keyword:           keyword,
-			TotalCreatedCount: executeCount,
-			LastUsedAt:        now,
-			CreatedAt:         now,
-			UpdatedAt:         now,
-		}
-		db.Create(&template)
-	} else if err == nil {
-		// 更新已有模板
-		template.TotalCreatedCount += executeCount
-		template.LastUsedAt = now
-		template.UpdatedAt = now
-		// 如果关键词不同，追加
-		if keyword != "" && template.Keyword != keyword {
-			if template.Keyword == "" {
-				template.Keyword = keyword
-			} else {
-				// 检查是否已包含
-				keywords := template.Keyword
-				if !containsKeyword(keywords, keyword) {
-					template.Keyword = keywords + "," + keyword
-				}
-			}
-		}
-		// 更新店铺名
-		if shopName != "" && template.ShopName != shopName {
-			template.ShopName = shopName
-		}
-		db.Save(&template)
-	}
-}
-
-// containsKeyword 检查关键词列表是否包含指定关键词
-func containsKeyword(keywords, target string) bool {
-	// 简单实现，可以改进
-	return keywords == target ||
-		len(keywords) > len(target) && (keywords[:len(target)+1] == target+"," ||
-			keywords[len(keywords)-len(target)-1:] == ","+target ||
-			contains(keywords, ","+target+","))
-}
-
-func contains(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
-		}
-	}
-	return false
-}
-
 // GetJingdouStats 获取用户京豆统计
 // @Summary 获取用户京豆统计
 // @Description 获取当前用户京豆余额、过去消耗、未来预计消耗、可消耗天数等
@@ -10,6 +10,8 @@ import (
 	"gorm.io/gorm"
 
 	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/internal/taskstate"
 	"jd-task-platform-go/pkg/response"
 )
 
@@ -38,6 +40,7 @@ func NewUserTaskManageHandler(db *gorm.DB) *UserTaskManageHandler {
 // @Param sort_order query string false "排序方式: asc,desc" default(desc)
 // @Param page query int false "页码" default(1)
 // @Param per_page query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
 // @Success 200 {object} response.Response
 // @Router /user/tasks [get]
 func (h *UserTaskManageHandler) GetUserTasks(c *gin.Context) {
@@ -61,14 +64,10 @@ func (h *UserTaskManageHandler) GetUserTasks(c *gin.Context) {
 	endDate := c.Query("end_date")
 	sortBy := c.DefaultQuery("sort_by", "created_at")
 	sortOrder := c.DefaultQuery("sort_order", "desc")
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	perPage, _ := strconv.Atoi(c.DefaultQuery("per_page", "20"))
-
-	if page < 1 {
-		page = 1
-	}
-	if perPage < 1 || perPage > 100 {
-		perPage = 20
+	pagination, err := response.ParsePagination(c, "per_page", response.MaxPageSize)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
 	}
 
 	// 验证排序字段（只允许指定字段）
@@ -76,9 +75,7 @@ func (h *UserTaskManageHandler) GetUserTasks(c *gin.Context) {
 	if !allowedSortFields[sortBy] {
 		sortBy = "created_at"
 	}
-	if sortOrder != "asc" && sortOrder != "desc" {
-		sortOrder = "desc"
-	}
+	pagination.Asc = sortOrder == "asc"
 
 	// 构建查询
 	query := h.db.Model(&models.Task{}).Where("user_id = ?", targetUserID)
@@ -109,14 +106,18 @@ func (h *UserTaskManageHandler) GetUserTasks(c *gin.Context) {
 	}
 
 	// 统计总数
-	var total int64
-	query.Count(&total)
+	pagination.Count(query)
 
 	// 分页查询（应用排序）
 	var tasks []models.Task
-	offset := (page - 1) * perPage
-	orderClause := sortBy + " " + sortOrder
-	query.Order(orderClause).Offset(offset).Limit(perPage).Find(&tasks)
+	cursorKey := taskCursorKey
+	if sortBy == "start_time" {
+		cursorKey = func(task *models.Task) (time.Time, uint) { return task.StartTime, task.ID }
+	}
+	if err := response.Find(pagination, query, sortBy, &tasks, cursorKey); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
 
 	// 获取任务类型名称映射
 	var taskTypes []models.TaskType
@@ -137,25 +138,24 @@ func (h *UserTaskManageHandler) GetUserTasks(c *gin.Context) {
 			"shop_name":       task.ShopName,
 			"keyword":         task.Keyword,
 			"start_time":      task.StartTime.Format(time.RFC3339),
+			"end_time":        task.EndTime,
 			"execute_count":   task.ExecuteCount,
 			"executed_count":  task.ExecutedCount,
 			"priority":        task.Priority,
 			"status":          task.Status,
-			"status_text":     getStatusText(task.Status),
+			"status_text":     taskstate.Text(task.Status),
 			"consume_jingdou": task.ConsumeJingdou,
 			"can_cancel":      canCancelTask(task),
 			"can_edit":        canEditTask(task),
+			"can_pause":       canPauseTask(task),
+			"can_resume":      canResumeTask(task),
 			"created_at":      task.CreatedAt.Format(time.RFC3339),
 		})
 	}
 
-	response.Success(c, gin.H{
-		"tasks":    items,
-		"total":    total,
-		"page":     page,
-		"per_page": perPage,
-		"pages":    (total + int64(perPage) - 1) / int64(perPage),
-	})
+	data := pagination.Meta("per_page")
+	data["tasks"] = items
+	response.Success(c, data)
 }
 
 // CancelUserTask 取消用户任务
@@ -191,51 +191,22 @@ func (h *UserTaskManageHandler) CancelUserTask(c *gin.Context) {
 		return
 	}
 
-	tx := h.db.Begin()
-
-	// 更新任务状态
-	task.Status = "cancelled"
-	task.UpdatedAt = time.Now()
-	if err := tx.Save(&task).Error; err != nil {
-		tx.Rollback()
-		response.Error(c, http.StatusInternalServerError, "取消任务失败")
-		return
-	}
-
-	// 退还京豆
-	var user models.User
-	if err := tx.First(&user, userID).Error; err != nil {
-		tx.Rollback()
-		response.Error(c, http.StatusInternalServerError, "获取用户信息失败")
-		return
-	}
-
-	refundAmount := task.ConsumeJingdou
-	user.JingdouBalance += refundAmount
-	if err := tx.Save(&user).Error; err != nil {
-		tx.Rollback()
-		response.Error(c, http.StatusInternalServerError, "退还京豆失败")
+	result, err := taskstate.Transition(h.db, &task, taskstate.Change{
+		To:           taskstate.Cancelled,
+		Actor:        taskstate.ActorUser,
+		ActorID:      &userID,
+		Reason:       "用户取消任务",
+		RefundRemark: "取消任务退还京豆",
+	})
+	if err != nil {
+		transitionError(c, err, "取消任务失败")
 		return
 	}
 
-	// 记录京豆日志
-	jingdouLog := models.JingdouLog{
-		UserID:        userID,
-		Amount:        refundAmount,
-		Balance:       user.JingdouBalance,
-		OperationType: "refund",
-		RelatedID:     &task.ID,
-		Remark:        "取消任务退还京豆",
-		CreatedAt:     time.Now(),
-	}
-	tx.Create(&jingdouLog)
-
-	tx.Commit()
-
-	response.SuccessWithMsg(c, "任务取消成功，已退还"+strconv.Itoa(refundAmount)+"京豆", gin.H{
+	response.SuccessWithMsg(c, "任务取消成功，已退还"+strconv.Itoa(result.Refund)+"京豆", gin.H{
 		"task_id":        task.ID,
-		"refund_jingdou": refundAmount,
-		"new_balance":    user.JingdouBalance,
+		"refund_jingdou": result.Refund,
+		"new_balance":    result.Balance,
 	})
 }
 
@@ -294,7 +265,7 @@ func (h *UserTaskManageHandler) UpdateUserTask(c *gin.Context) {
 	tx := h.db.Begin()
 
 	// 计算京豆差额（如果增加次数）
-	var additionalJingdou int
+	var additionalJingdou, balance int
 	if req.ExecuteCount != nil && *req.ExecuteCount > task.ExecuteCount {
 		// 获取任务类型单价
 		var taskType models.TaskType
@@ -323,6 +294,7 @@ func (h *UserTaskManageHandler) UpdateUserTask(c *gin.Context) {
 
 		// 扣除京豆
 		user.JingdouBalance -= additionalJingdou
+		balance = user.JingdouBalance
 		tx.Save(&user)
 
 		// 记录京豆日志
@@ -352,6 +324,11 @@ func (h *UserTaskManageHandler) UpdateUserTask(c *gin.Context) {
 		}
 	}
 	if req.StartTime != nil {
+		// 截止时间随开始时间平移，保持原有的有效时长
+		if task.EndTime != nil {
+			endTime := task.EndTime.Add(req.StartTime.Sub(task.StartTime))
+			task.EndTime = &endTime
+		}
 		task.StartTime = *req.StartTime
 	}
 
@@ -378,6 +355,8 @@ func (h *UserTaskManageHandler) UpdateUserTask(c *gin.Context) {
 
 	tx.Commit()
 
+	services.PublishBalance(userID, balance, -additionalJingdou, "consume")
+
 	response.SuccessWithMsg(c, "任务修改成功", gin.H{
 		"task_id":            task.ID,
 		"additional_jingdou": additionalJingdou,
@@ -396,6 +375,7 @@ func (h *UserTaskManageHandler) GetTaskStatusOptions(c *gin.Context) {
 		{"value": "", "label": "全部状态"},
 		{"value": "waiting", "label": "待开始"},
 		{"value": "running", "label": "执行中"},
+		{"value": "paused", "label": "已暂停"},
 		{"value": "completed", "label": "已完成"},
 		{"value": "partial_completed", "label": "部分完成"},
 		{"value": "failed", "label": "失败"},
@@ -406,30 +386,20 @@ func (h *UserTaskManageHandler) GetTaskStatusOptions(c *gin.Context) {
 	})
 }
 
-// 获取状态文本
-func getStatusText(status string) string {
-	switch status {
-	case "waiting":
-		return "待开始"
-	case "running":
-		return "执行中"
-	case "completed":
-		return "已完成"
-	case "partial_completed":
-		return "部分完成"
-	case "failed":
-		return "失败"
-	case "cancelled":
-		return "已取消"
-	default:
-		return status
-	}
-}
-
 // 判断任务是否可以取消
 func canCancelTask(task models.Task) bool {
-	// 只有待开始状态且未到开始时间的任务可以取消
-	return task.Status == "waiting" && time.Now().Before(task.StartTime)
+	// 只有待开始（或已暂停）状态且未到开始时间的任务可以取消
+	return (task.Status == "waiting" || task.Status == taskstate.Paused) && time.Now().Before(task.StartTime)
+}
+
+// 判断任务是否可以暂停
+func canPauseTask(task models.Task) bool {
+	return task.Status == "waiting" || task.Status == "running"
+}
+
+// 判断任务是否可以由用户恢复（管理员暂停的任务用户无法恢复）
+func canResumeTask(task models.Task) bool {
+	return task.Status == taskstate.Paused && task.PausedBy != services.PausedByAdmin
 }
 
 // 判断任务是否可以编辑
@@ -437,3 +407,113 @@ func canEditTask(task models.Task) bool {
 	// 只有待开始状态且未到开始时间的任务可以编辑
 	return task.Status == "waiting" && time.Now().Before(task.StartTime)
 }
+
+// PauseUserTask 暂停用户任务
+// @Summary 暂停用户任务
+// @Description 暂停自己的等待中或执行中任务，暂停后不再下发给设备
+// @Tags 用户任务管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "任务ID"
+// @Success 200 {object} response.Response
+// @Router /user/tasks/{id}/pause [post]
+func (h *UserTaskManageHandler) PauseUserTask(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var task models.Task
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&task).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "任务不存在")
+		return
+	}
+
+	if !canPauseTask(task) {
+		response.Error(c, http.StatusBadRequest, "只有待开始或执行中的任务可以暂停")
+		return
+	}
+
+	if _, err := services.PauseTasks(h.db, taskScope(task.ID), services.PausedByUser, "任务已暂停（用户操作）"); err != nil {
+		response.Error(c, http.StatusInternalServerError, "暂停任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "任务已暂停", gin.H{"task_id": task.ID})
+}
+
+// ResumeUserTask 恢复用户任务
+// @Summary 恢复用户任务
+// @Description 恢复自己暂停的任务，管理员暂停的任务无法自行恢复
+// @Tags 用户任务管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "任务ID"
+// @Success 200 {object} response.Response
+// @Router /user/tasks/{id}/resume [post]
+func (h *UserTaskManageHandler) ResumeUserTask(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	var task models.Task
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&task).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "任务不存在")
+		return
+	}
+
+	if !canResumeTask(task) {
+		if task.Status == taskstate.Paused {
+			response.Error(c, http.StatusForbidden, "该任务由管理员暂停，无法自行恢复")
+		} else {
+			response.Error(c, http.StatusBadRequest, "只有已暂停的任务可以恢复")
+		}
+		return
+	}
+
+	if _, err := services.ResumeTasks(h.db, taskScope(task.ID), services.PausedByUser, "任务已恢复（用户操作）"); err != nil {
+		response.Error(c, http.StatusInternalServerError, "恢复任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "任务已恢复", gin.H{"task_id": task.ID})
+}
+
+// PauseAllUserTasks 暂停用户全部任务
+// @Summary 暂停用户全部任务
+// @Description 一键暂停自己所有待开始和执行中的任务
+// @Tags 用户任务管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response
+// @Router /user/tasks/pause-all [post]
+func (h *UserTaskManageHandler) PauseAllUserTasks(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	count, err := services.PauseTasks(h.db, userScope(userID), services.PausedByUser, "用户暂停全部任务")
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "暂停任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "已暂停"+strconv.Itoa(count)+"个任务", gin.H{"affected": count})
+}
+
+// ResumeAllUserTasks 恢复用户全部任务
+// @Summary 恢复用户全部任务
+// @Description 一键恢复自己暂停的全部任务，管理员暂停的任务不受影响
+// @Tags 用户任务管理
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response
+// @Router /user/tasks/resume-all [post]
+func (h *UserTaskManageHandler) ResumeAllUserTasks(c *gin.Context) {
+	userID := c.GetUint("user_id")
+
+	count, err := services.ResumeTasks(h.db, userScope(userID), services.PausedByUser, "用户恢复全部任务")
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "恢复任务失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "已恢复"+strconv.Itoa(count)+"个任务", gin.H{"affected": count})
+}
@@ -0,0 +1,351 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/response"
+)
+
+// WebhookHandler Webhook 订阅处理器
+// 同时用于用户接口（JWT认证）和开放API（API Key认证）
+type WebhookHandler struct {
+	db *gorm.DB
+}
+
+// NewWebhookHandler 创建 Webhook 处理器
+func NewWebhookHandler(db *gorm.DB) *WebhookHandler {
+	return &WebhookHandler{db: db}
+}
+
+// GetWebhooks 获取 Webhook 列表
+// @Summary 获取Webhook列表
+// @Description 获取当前用户的Webhook订阅及支持的事件
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} response.Response{data=object}
+// @Router /webhooks [get]
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var webhooks []models.Webhook
+	h.db.Where("user_id = ?", userID).Order("created_at DESC").Find(&webhooks)
+
+	response.Success(c, gin.H{
+		"items":  webhooks,
+		"events": services.WebhookEvents,
+	})
+}
+
+// CreateWebhook 创建 Webhook
+// @Summary 创建Webhook
+// @Description 创建Webhook订阅，返回的签名密钥只显示一次。推送请求头 X-Webhook-Signature 为 sha256=hex(HMAC-SHA256(密钥, X-Webhook-Timestamp + "." + 请求体))
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body models.WebhookRequest true "Webhook信息"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 400 {object} response.Response
+// @Router /webhooks [post]
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req models.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "生成签名密钥失败")
+		return
+	}
+
+	webhook := models.Webhook{
+		UserID:    userID.(uint),
+		Secret:    secret,
+		IsActive:  true,
+		CreatedAt: time.Now(),
+	}
+	if !applyWebhookRequest(c, &webhook, req) {
+		return
+	}
+
+	if err := h.db.Create(&webhook).Error; err != nil {
+		response.Error(c, http.StatusInternalServerError, "创建Webhook失败")
+		return
+	}
+
+	response.SuccessWithMsg(c, "Webhook创建成功，请妥善保存签名密钥", gin.H{
+		"webhook": webhook,
+		"secret":  secret,
+	})
+}
+
+// UpdateWebhook 修改 Webhook
+// @Summary 修改Webhook
+// @Description 修改Webhook地址、订阅事件和启用状态
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param request body models.WebhookRequest true "Webhook信息"
+// @Success 200 {object} response.Response{data=models.Webhook}
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /webhooks/{id} [put]
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	var req models.WebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.Error(c, http.StatusBadRequest, "请求参数错误")
+		return
+	}
+	if !applyWebhookRequest(c, &webhook, req) {
+		return
+	}
+
+	h.db.Save(&webhook)
+
+	response.SuccessWithMsg(c, "Webhook修改成功", webhook)
+}
+
+// DeleteWebhook 删除 Webhook
+// @Summary 删除Webhook
+// @Description 删除Webhook订阅及其推送记录
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	h.db.Transaction(func(tx *gorm.DB) error {
+		deliveryIDs := tx.Model(&models.WebhookDelivery{}).Select("id").Where("webhook_id = ?", webhook.ID)
+		tx.Where("delivery_id IN (?)", deliveryIDs).Delete(&models.WebhookDeliveryLog{})
+		tx.Where("webhook_id = ?", webhook.ID).Delete(&models.WebhookDelivery{})
+		return tx.Delete(&webhook).Error
+	})
+
+	response.SuccessWithMsg(c, "Webhook已删除", nil)
+}
+
+// RotateWebhookSecret 重新生成签名密钥
+// @Summary 重新生成Webhook签名密钥
+// @Description 重新生成签名密钥，旧密钥立即失效，新密钥只显示一次
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 404 {object} response.Response
+// @Router /webhooks/{id}/secret [post]
+func (h *WebhookHandler) RotateWebhookSecret(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	secret, err := generateWebhookSecret()
+	if err != nil {
+		response.Error(c, http.StatusInternalServerError, "生成签名密钥失败")
+		return
+	}
+
+	h.db.Model(&webhook).Updates(map[string]interface{}{
+		"secret":     secret,
+		"updated_at": time.Now(),
+	})
+
+	response.SuccessWithMsg(c, "签名密钥已更新", gin.H{
+		"webhook_id": webhook.ID,
+		"secret":     secret,
+	})
+}
+
+// GetWebhookDeliveries 获取推送记录
+// @Summary 获取Webhook推送记录
+// @Description 获取Webhook的推送记录及每次尝试的结果
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param status query string false "推送状态：pending, success, failed"
+// @Param page query int false "页码" default(1)
+// @Param page_size query int false "每页数量" default(20)
+// @Param cursor query string false "分页游标，首页传空值，之后传上一页返回的 next_cursor；传入后忽略 page"
+// @Success 200 {object} response.Response{data=object}
+// @Failure 404 {object} response.Response
+// @Router /webhooks/{id}/deliveries [get]
+func (h *WebhookHandler) GetWebhookDeliveries(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	pagination, err := response.ParsePagination(c, "page_size", response.MaxPageSize)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := h.db.Model(&models.WebhookDelivery{}).Where("webhook_id = ?", webhook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	pagination.Count(query)
+
+	var deliveries []models.WebhookDelivery
+	if err := response.Find(pagination, query, "created_at", &deliveries, func(d *models.WebhookDelivery) (time.Time, uint) {
+		return d.CreatedAt, d.ID
+	}); err != nil {
+		response.Error(c, http.StatusInternalServerError, "查询失败")
+		return
+	}
+
+	// 附带每条记录的尝试日志
+	deliveryIDs := make([]uint, 0, len(deliveries))
+	for _, d := range deliveries {
+		deliveryIDs = append(deliveryIDs, d.ID)
+	}
+	attempts := map[uint][]models.WebhookDeliveryLog{}
+	if len(deliveryIDs) > 0 {
+		var logs []models.WebhookDeliveryLog
+		h.db.Where("delivery_id IN ?", deliveryIDs).Order("id ASC").Find(&logs)
+		for _, l := range logs {
+			attempts[l.DeliveryID] = append(attempts[l.DeliveryID], l)
+		}
+	}
+
+	items := make([]gin.H, 0, len(deliveries))
+	for _, d := range deliveries {
+		items = append(items, gin.H{
+			"delivery": d,
+			"attempts": attempts[d.ID],
+		})
+	}
+
+	data := pagination.Meta("page_size")
+	data["items"] = items
+	response.Success(c, data)
+}
+
+// RedeliverWebhook 重新推送
+// @Summary 重新推送Webhook
+// @Description 将推送记录重置为待推送，推送服务会在几秒内重新投递（重试次数重新计算）
+// @Tags Webhook
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "推送记录ID"
+// @Success 200 {object} response.Response
+// @Failure 400 {object} response.Response
+// @Failure 404 {object} response.Response
+// @Router /webhooks/deliveries/{id}/redeliver [post]
+func (h *WebhookHandler) RedeliverWebhook(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+
+	var delivery models.WebhookDelivery
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&delivery).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "推送记录不存在")
+		return
+	}
+	if delivery.Status == services.DeliveryStatusPending && delivery.Attempts == 0 {
+		response.Error(c, http.StatusBadRequest, "该记录正在等待推送")
+		return
+	}
+
+	h.db.Model(&delivery).Updates(map[string]interface{}{
+		"status":          services.DeliveryStatusPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+		"updated_at":      time.Now(),
+	})
+
+	response.SuccessWithMsg(c, "已重新加入推送队列", gin.H{"delivery_id": delivery.ID})
+}
+
+// findWebhook 按路径参数查询当前用户的 Webhook
+func (h *WebhookHandler) findWebhook(c *gin.Context) (models.Webhook, bool) {
+	userID, _ := c.Get("user_id")
+
+	var webhook models.Webhook
+	if err := h.db.Where("id = ? AND user_id = ?", c.Param("id"), userID).First(&webhook).Error; err != nil {
+		response.Error(c, http.StatusNotFound, "Webhook不存在")
+		return webhook, false
+	}
+	return webhook, true
+}
+
+// applyWebhookRequest 校验请求并写入 Webhook
+func applyWebhookRequest(c *gin.Context, webhook *models.Webhook, req models.WebhookRequest) bool {
+	webhookURL, err := services.ValidateWebhookURL(c.Request.Context(), req.URL)
+	if err != nil {
+		response.Error(c, http.StatusBadRequest, err.Error())
+		return false
+	}
+
+	events := make([]string, 0, len(req.Events))
+	for _, event := range req.Events {
+		event = strings.TrimSpace(event)
+		if event == "" {
+			continue
+		}
+		if !services.ValidWebhookEvent(event) {
+			response.Error(c, http.StatusBadRequest, "不支持的事件: "+event)
+			return false
+		}
+		events = append(events, event)
+	}
+
+	if req.LowBalanceThreshold < 0 {
+		response.Error(c, http.StatusBadRequest, "余额提醒阈值不能为负数")
+		return false
+	}
+
+	webhook.URL = webhookURL
+	webhook.Events = strings.Join(events, ",")
+	webhook.LowBalanceThreshold = req.LowBalanceThreshold
+	webhook.Description = req.Description
+	if req.IsActive != nil {
+		webhook.IsActive = *req.IsActive
+	}
+	webhook.UpdatedAt = time.Now()
+	return true
+}
+
+// generateWebhookSecret 生成签名密钥
+func generateWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
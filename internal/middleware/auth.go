@@ -42,6 +42,19 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// QueryTokenMiddleware 允许通过 token 查询参数传递JWT令牌
+// 仅用于浏览器 EventSource 等无法设置请求头的接口，需放在 AuthMiddleware 之前
+func QueryTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("token"); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		c.Next()
+	}
+}
+
 // AdminMiddleware 管理员权限中间件
 func AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
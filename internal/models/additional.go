@@ -11,12 +11,16 @@ type TaskType struct {
 	TypeName          string    `gorm:"size:64;not null;column:type_name" json:"type_name"`
 	JingdouPrice      int       `gorm:"not null;column:jingdou_price" json:"jingdou_price"`
 	IsActive          bool      `gorm:"default:true;column:is_active" json:"is_active"`
-	ExecuteMultiplier int       `gorm:"default:1;column:execute_multiplier" json:"-"` // 执行倍数，默认1（仅管理员可见）
+	ExecuteMultiplier int       `gorm:"default:1;column:execute_multiplier" json:"-"`                  // 执行倍数，默认1（仅管理员可见）
+	ExpireHours       int       `gorm:"default:24;column:expire_hours" json:"expire_hours"`            // 任务有效时长（小时），创建任务时可在限制范围内覆盖
 	TimeSlot1Start    *string   `gorm:"size:5;column:time_slot1_start" json:"time_slot1_start"`        // 时间段1开始 HH:MM
 	TimeSlot1End      *string   `gorm:"size:5;column:time_slot1_end" json:"time_slot1_end"`            // 时间段1结束 HH:MM
 	TimeSlot2Start    *string   `gorm:"size:5;column:time_slot2_start" json:"time_slot2_start"`        // 时间段2开始 HH:MM
 	TimeSlot2End      *string   `gorm:"size:5;column:time_slot2_end" json:"time_slot2_end"`            // 时间段2结束 HH:MM
 	IsSystemPreset    bool      `gorm:"default:false;column:is_system_preset" json:"is_system_preset"` // 是否系统预设
+	ProxyProvince     string    `gorm:"size:32;column:proxy_province" json:"proxy_province"`           // 执行该类型任务的代理省份，如 广东
+	ProxyISP          string    `gorm:"size:32;column:proxy_isp" json:"proxy_isp"`                     // 执行该类型任务的代理运营商，如 电信
+	ProxyRequired     bool      `gorm:"default:false;column:proxy_required" json:"proxy_required"`     // 必须使用符合地区要求的代理，无匹配时不降级
 	CreatedAt         time.Time `gorm:"column:created_at" json:"created_at"`
 	UpdatedAt         time.Time `gorm:"column:updated_at" json:"updated_at"`
 }
@@ -31,6 +35,7 @@ type TaskLog struct {
 	ID        uint      `gorm:"primaryKey" json:"id"`
 	TaskID    uint      `gorm:"not null;column:task_id" json:"task_id"`
 	DeviceID  string    `gorm:"size:64;column:device_id" json:"device_id"`
+	ProxyID   *uint     `gorm:"index;column:proxy_id" json:"proxy_id"` // 执行时设备使用的代理，未使用代理时为空
 	Status    string    `gorm:"size:20;not null" json:"status"`
 	Message   string    `gorm:"type:text" json:"message"`
 	CreatedAt time.Time `gorm:"column:created_at" json:"created_at"`
@@ -41,6 +46,24 @@ func (TaskLog) TableName() string {
 	return "task_logs"
 }
 
+// TaskStatusHistory 任务状态变更历史
+type TaskStatusHistory struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	TaskID     uint      `gorm:"not null;index;column:task_id" json:"task_id"`
+	FromStatus string    `gorm:"size:20;not null;column:from_status" json:"from_status"`
+	ToStatus   string    `gorm:"size:20;not null;column:to_status" json:"to_status"`
+	Actor      string    `gorm:"size:20;not null" json:"actor"`       // system, device, user, admin
+	ActorID    *uint     `gorm:"column:actor_id" json:"actor_id"`     // 操作用户ID，系统和设备操作为空
+	Reason     string    `gorm:"type:text" json:"reason"`             // 变更说明
+	Refund     int       `gorm:"default:0" json:"refund"`             // 本次变更退还的京豆
+	CreatedAt  time.Time `gorm:"column:created_at" json:"created_at"` // 变更时间
+}
+
+// TableName 指定表名
+func (TaskStatusHistory) TableName() string {
+	return "task_status_history"
+}
+
 // JingdouLog 京豆日志模型
 type JingdouLog struct {
 	ID            uint      `gorm:"primaryKey" json:"id"`
@@ -114,18 +137,23 @@ type CreateTaskTypeRequest struct {
 	TypeName     string `json:"type_name" binding:"required" example:"加购"`
 	JingdouPrice int    `json:"jingdou_price" binding:"required" example:"2"`
 	IsActive     *bool  `json:"is_active" example:"true"`
+	ExpireHours  int    `json:"expire_hours" example:"24"` // 任务有效时长（小时），不填时为默认24小时
 }
 
 // UpdateTaskTypeRequest 更新任务类型请求
 type UpdateTaskTypeRequest struct {
-	TypeName          *string `json:"type_name" example:"浏览任务"`        // 任务类型名称（管理员可修改）
+	TypeName          *string `json:"type_name" example:"浏览任务"` // 任务类型名称（管理员可修改）
 	JingdouPrice      *int    `json:"jingdou_price" example:"3"`
 	IsActive          *bool   `json:"is_active" example:"false"`
-	ExecuteMultiplier *int    `json:"execute_multiplier" example:"1"` // 执行倍数（仅管理员可见可修改）
+	ExecuteMultiplier *int    `json:"execute_multiplier" example:"1"`   // 执行倍数（仅管理员可见可修改）
+	ExpireHours       *int    `json:"expire_hours" example:"24"`        // 任务有效时长（小时）
 	TimeSlot1Start    *string `json:"time_slot1_start" example:"08:00"` // 时间段1开始 HH:MM
 	TimeSlot1End      *string `json:"time_slot1_end" example:"12:00"`   // 时间段1结束 HH:MM
 	TimeSlot2Start    *string `json:"time_slot2_start" example:"14:00"` // 时间段2开始 HH:MM
 	TimeSlot2End      *string `json:"time_slot2_end" example:"18:00"`   // 时间段2结束 HH:MM
+	ProxyProvince     *string `json:"proxy_province" example:"广东"`      // 代理省份要求，空字符串表示不限
+	ProxyISP          *string `json:"proxy_isp" example:"电信"`           // 代理运营商要求，空字符串表示不限
+	ProxyRequired     *bool   `json:"proxy_required" example:"true"`    // 是否必须使用符合要求的代理
 }
 
 // BatchCreateTaskRequest 批量创建任务请求
@@ -162,6 +190,7 @@ type QuickCreateTaskRequest struct {
 	TaskType     string    `json:"task_type" example:"browse"` // 可选，覆盖模板的任务类型
 	Keyword      string    `json:"keyword" example:"手机"`       // 可选，搜索关键词
 	ShopName     string    `json:"shop_name" example:"京东自营"`   // 可选，店铺名称
+	ExpireHours  int       `json:"expire_hours" example:"24"`  // 可选，任务有效时长（小时）
 }
 
 // UpdateTemplateRemarkRequest 更新模板备注请求
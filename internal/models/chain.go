@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+)
+
+// TaskChain 任务链
+// 由多个按顺序执行的任务步骤组成，同一设备完成第N步后优先领取第N+1步
+type TaskChain struct {
+	ID             uint      `gorm:"primaryKey" json:"id"`
+	UserID         uint      `gorm:"not null;index;column:user_id" json:"user_id"`
+	Name           string    `gorm:"size:128" json:"name"`
+	SKU            string    `gorm:"size:64;not null" json:"sku"`
+	ShopName       string    `gorm:"size:128;column:shop_name" json:"shop_name"`
+	StartTime      time.Time `gorm:"not null;column:start_time" json:"start_time"`
+	ExecuteCount   int       `gorm:"not null;column:execute_count" json:"execute_count"`      // 每个步骤的执行次数
+	CompletedCount int       `gorm:"default:0;column:completed_count" json:"completed_count"` // 完整走完全部步骤的次数
+	StepCount      int       `gorm:"not null;column:step_count" json:"step_count"`
+	Status         string    `gorm:"size:20;not null" json:"status"`                         // waiting, running, completed, partial_completed, cancelled
+	ConsumeJingdou int       `gorm:"not null;column:consume_jingdou" json:"consume_jingdou"` // 全部步骤消耗京豆合计
+	Remark         string    `gorm:"type:text" json:"remark"`
+	Steps          []Task    `gorm:"foreignKey:ChainID" json:"steps,omitempty"`
+	CreatedAt      time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (TaskChain) TableName() string {
+	return "task_chains"
+}
+
+// TaskChainStepRequest 任务链步骤
+type TaskChainStepRequest struct {
+	TaskType     string `json:"task_type" binding:"required" example:"add_to_cart"`
+	DelayMinutes int    `json:"delay_minutes" example:"5"` // 上一步完成后等待的分钟数，第一步忽略
+	Keyword      string `json:"keyword" example:"手机"`      // 关键词搜索步骤必填
+	Remark       string `json:"remark" example:""`
+}
+
+// CreateTaskChainRequest 创建任务链请求
+type CreateTaskChainRequest struct {
+	Name         string                 `json:"name" example:"搜索后加购"`
+	SKU          string                 `json:"sku" binding:"required" example:"100001234567"`
+	ShopName     string                 `json:"shop_name" example:"京东自营店"`
+	StartTime    time.Time              `json:"start_time" binding:"required" example:"2023-12-01T10:00:00Z"`
+	ExecuteCount int                    `json:"execute_count" binding:"required" example:"10"`
+	Priority     int                    `json:"priority" example:"0"`
+	Remark       string                 `json:"remark" example:"任务链"`
+	Steps        []TaskChainStepRequest `json:"steps" binding:"required,min=2,dive"`
+}
@@ -15,18 +15,45 @@ type Device struct {
 	AppVersion    string     `gorm:"size:32;column:app_version" json:"app_version"`     // 应用版本
 	IP            string     `gorm:"size:64" json:"ip"`
 	Location      string     `gorm:"size:128;column:location" json:"location"` // 地理位置
+	GroupName     string     `gorm:"size:64;index;column:group_name" json:"group_name"` // 设备分组，用于代理订阅
 	OSInfo        string     `gorm:"size:128;column:os_info" json:"os_info"`   // 兼容旧字段
 	Version       string     `gorm:"size:32" json:"version"`                   // 兼容旧字段
 	Status        string     `gorm:"size:20;not null" json:"status"`           // online, offline, working, idle
 	IsBlocked     bool       `gorm:"default:false;column:is_blocked" json:"is_blocked"`
+	IsPaused      bool       `gorm:"default:false;column:is_paused" json:"is_paused"` // 控制指令暂停后不再下发任务，恢复后清除
 	LastHeartbeat *time.Time `gorm:"column:last_heartbeat" json:"last_heartbeat"`
 	LastActive    *time.Time `gorm:"column:last_active" json:"last_active"`
 	CreatedAt     time.Time  `gorm:"column:created_at" json:"created_at"`
 	LastTaskTime  *time.Time `gorm:"column:last_task_time" json:"last_task_time"`
 	TaskCount     int        `gorm:"default:0;column:task_count" json:"task_count"` // 任务执行次数
+	// 最近一次心跳上报的遥测数据
+	Battery         *int       `gorm:"column:battery" json:"battery"`                   // 电量百分比
+	NetworkType     string     `gorm:"size:20;column:network_type" json:"network_type"` // wifi, 4g, 5g
+	AppState        string     `gorm:"size:32;column:app_state" json:"app_state"`       // 应用状态
+	FreeStorage     *int64     `gorm:"column:free_storage" json:"free_storage"`         // 剩余存储（MB）
+	ProxyIP         string     `gorm:"size:64;column:proxy_ip" json:"proxy_ip"`         // 当前代理IP
+	LastTelemetryAt *time.Time `gorm:"column:last_telemetry_at" json:"last_telemetry_at"`
 }
 
 // TableName 指定表名
 func (Device) TableName() string {
 	return "devices"
 }
+
+// DeviceTelemetry 设备心跳遥测记录（时序数据，由数据清理服务按保留期清理）
+type DeviceTelemetry struct {
+	ID          uint      `gorm:"primaryKey" json:"id"`
+	DeviceID    string    `gorm:"size:64;not null;index:idx_device_telemetry_device_time,priority:1;column:device_id" json:"device_id"`
+	Battery     *int      `gorm:"column:battery" json:"battery"` // 电量百分比
+	IsCharging  bool      `gorm:"default:false;column:is_charging" json:"is_charging"`
+	NetworkType string    `gorm:"size:20;column:network_type" json:"network_type"` // wifi, 4g, 5g
+	AppState    string    `gorm:"size:32;column:app_state" json:"app_state"`       // 应用状态
+	FreeStorage *int64    `gorm:"column:free_storage" json:"free_storage"`         // 剩余存储（MB）
+	ProxyIP     string    `gorm:"size:64;column:proxy_ip" json:"proxy_ip"`         // 当前代理IP
+	CreatedAt   time.Time `gorm:"index:idx_device_telemetry_device_time,priority:2;column:created_at" json:"created_at"`
+}
+
+// TableName 指定表名
+func (DeviceTelemetry) TableName() string {
+	return "device_telemetry"
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// Job 后台作业
+// 耗时的管理操作（过期检查、数据清理、清空设备等）提交为作业，由作业服务的工作协程异步执行
+type Job struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	Type            string     `gorm:"size:50;not null;index" json:"type"`
+	Params          string     `gorm:"type:text" json:"params"`              // 作业参数（JSON）
+	Status          string     `gorm:"size:20;not null;index" json:"status"` // queued, running, succeeded, failed, cancelled
+	Progress        int        `gorm:"default:0" json:"progress"`            // 进度百分比 0-100
+	Message         string     `gorm:"size:255" json:"message"`              // 当前执行步骤
+	Result          string     `gorm:"type:text" json:"result"`              // 执行结果（JSON）
+	Error           string     `gorm:"type:text" json:"error"`
+	CancelRequested bool       `gorm:"column:cancel_requested" json:"cancel_requested"` // 已请求取消，执行中的作业在下一个检查点停止
+	CreatedBy       uint       `gorm:"index;column:created_by" json:"created_by"`       // 提交作业的用户，系统提交为0
+	StartedAt       *time.Time `gorm:"column:started_at" json:"started_at"`
+	HeartbeatAt     *time.Time `gorm:"column:heartbeat_at" json:"heartbeat_at"` // 执行实例最近一次心跳，超时未更新视为实例已退出
+	FinishedAt      *time.Time `gorm:"column:finished_at" json:"finished_at"`
+	CreatedAt       time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (Job) TableName() string {
+	return "jobs"
+}
+
+// SubmitJobRequest 提交作业请求
+type SubmitJobRequest struct {
+	Type   string                 `json:"type" binding:"required" example:"data_cleanup"`
+	Params map[string]interface{} `json:"params"`
+}
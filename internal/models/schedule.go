@@ -0,0 +1,60 @@
+package models
+
+import (
+	"time"
+)
+
+// TaskSchedule 周期任务定义
+// 调度服务按规则提前生成具体的任务记录，京豆在生成任务时扣除
+type TaskSchedule struct {
+	ID           uint       `gorm:"primaryKey" json:"id"`
+	UserID       uint       `gorm:"not null;index;column:user_id" json:"user_id"`
+	Name         string     `gorm:"size:128" json:"name"`
+	TaskType     string     `gorm:"size:32;not null;column:task_type" json:"task_type"`
+	SKU          string     `gorm:"size:64;not null" json:"sku"`
+	ShopName     string     `gorm:"size:128;column:shop_name" json:"shop_name"`
+	Keyword      string     `gorm:"size:128" json:"keyword"`
+	ExecuteCount int        `gorm:"not null;column:execute_count" json:"execute_count"` // 每次生成任务的执行次数
+	Priority     int        `gorm:"default:0" json:"priority"`
+	Remark       string     `gorm:"type:text" json:"remark"`
+	ScheduleType string     `gorm:"size:20;not null;column:schedule_type" json:"schedule_type"` // cron, daily, weekly
+	CronExpr     string     `gorm:"size:64;column:cron_expr" json:"cron_expr"`                  // 标准5段cron表达式（cron类型）
+	RunTime      string     `gorm:"size:5;column:run_time" json:"run_time"`                     // 执行时间 HH:MM（daily/weekly类型）
+	Weekdays     string     `gorm:"size:20;column:weekdays" json:"weekdays"`                    // 星期几，逗号分隔，0=周日（weekly类型）
+	EndDate      *time.Time `gorm:"column:end_date" json:"end_date"`                            // 截止日期，为空表示不限
+	MaxRuns      int        `gorm:"default:0;column:max_runs" json:"max_runs"`                  // 最多生成次数，0表示不限
+	RunCount     int        `gorm:"default:0;column:run_count" json:"run_count"`                // 已生成次数
+	BudgetCap    int        `gorm:"default:0;column:budget_cap" json:"budget_cap"`              // 京豆预算上限，0表示不限
+	SpentJingdou int        `gorm:"default:0;column:spent_jingdou" json:"spent_jingdou"`        // 已消耗京豆
+	Status       string     `gorm:"size:20;not null;index;default:active" json:"status"`        // active, paused, completed
+	PauseReason  string     `gorm:"size:255;column:pause_reason" json:"pause_reason"`           // 暂停/结束原因
+	NextRunAt    *time.Time `gorm:"index;column:next_run_at" json:"next_run_at"`                // 下一次任务开始时间
+	LastRunAt    *time.Time `gorm:"column:last_run_at" json:"last_run_at"`                      // 最近一次生成的任务开始时间
+	LastTaskID   *uint      `gorm:"column:last_task_id" json:"last_task_id"`                    // 最近一次生成的任务ID
+	CreatedAt    time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt    time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (TaskSchedule) TableName() string {
+	return "task_schedules"
+}
+
+// TaskScheduleRequest 创建/修改周期任务请求
+type TaskScheduleRequest struct {
+	Name         string     `json:"name" example:"每日加购"`
+	TaskType     string     `json:"task_type" binding:"required" example:"add_to_cart"`
+	SKU          string     `json:"sku" binding:"required" example:"100001234567"`
+	ShopName     string     `json:"shop_name" example:"京东自营店"`
+	Keyword      string     `json:"keyword" example:"手机"`
+	ExecuteCount int        `json:"execute_count" binding:"required" example:"10"`
+	Priority     int        `json:"priority" example:"0"`
+	Remark       string     `json:"remark" example:"周期任务"`
+	ScheduleType string     `json:"schedule_type" binding:"required" example:"daily"`
+	CronExpr     string     `json:"cron_expr" example:"0 9 * * *"` // 标准5段cron表达式，相邻两次执行间隔不小于10分钟
+	RunTime      string     `json:"run_time" example:"09:00"`
+	Weekdays     string     `json:"weekdays" example:"1,3,5"`
+	EndDate      *time.Time `json:"end_date" example:"2024-12-31T23:59:59+08:00"`
+	MaxRuns      int        `json:"max_runs" example:"30"`
+	BudgetCap    int        `json:"budget_cap" example:"5000"`
+}
@@ -6,21 +6,29 @@ import (
 
 // Task 任务模型
 type Task struct {
-	ID             uint      `gorm:"primaryKey" json:"id"`
-	UserID         uint      `gorm:"not null;column:user_id" json:"user_id"`
-	TaskType       string    `gorm:"size:32;not null;column:task_type" json:"task_type"`
-	SKU            string    `gorm:"size:64;not null" json:"sku"`
-	ShopName       string    `gorm:"size:128;column:shop_name" json:"shop_name"`
-	Keyword        string    `gorm:"size:128" json:"keyword"`
-	StartTime      time.Time `gorm:"not null;column:start_time" json:"start_time"`
-	ExecuteCount   int       `gorm:"not null;column:execute_count" json:"execute_count"`
-	ExecutedCount  int       `gorm:"default:0;column:executed_count" json:"executed_count"`
-	Priority       int       `gorm:"default:0" json:"priority"`
-	Status         string    `gorm:"size:20;not null" json:"status"`
-	ConsumeJingdou int       `gorm:"not null;column:consume_jingdou" json:"consume_jingdou"`
-	Remark         string    `gorm:"type:text" json:"remark"`
-	CreatedAt      time.Time `gorm:"column:created_at" json:"created_at"`
-	UpdatedAt      time.Time `gorm:"column:updated_at" json:"updated_at"`
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	UserID         uint       `gorm:"not null;column:user_id" json:"user_id"`
+	TaskType       string     `gorm:"size:32;not null;column:task_type" json:"task_type"`
+	SKU            string     `gorm:"size:64;not null" json:"sku"`
+	ShopName       string     `gorm:"size:128;column:shop_name" json:"shop_name"`
+	Keyword        string     `gorm:"size:128" json:"keyword"`
+	StartTime      time.Time  `gorm:"not null;column:start_time" json:"start_time"`
+	EndTime        *time.Time `gorm:"index;column:end_time" json:"end_time"` // 截止时间，超过后未完成部分自动退款
+	ExecuteCount   int        `gorm:"not null;column:execute_count" json:"execute_count"`
+	ExecutedCount  int        `gorm:"default:0;column:executed_count" json:"executed_count"`
+	Priority       int        `gorm:"default:0" json:"priority"`
+	Status         string     `gorm:"size:20;not null" json:"status"`
+	ConsumeJingdou int        `gorm:"not null;column:consume_jingdou" json:"consume_jingdou"`
+	Remark         string     `gorm:"type:text" json:"remark"`
+	ScheduleID     *uint      `gorm:"index;column:schedule_id" json:"schedule_id,omitempty"` // 生成该任务的周期任务
+	ChainID        *uint      `gorm:"index;column:chain_id" json:"chain_id,omitempty"`       // 所属任务链
+	ChainStep      int        `gorm:"default:0;column:chain_step" json:"chain_step"`         // 任务链步骤序号，从1开始，0表示普通任务
+	ChainDelay     int        `gorm:"default:0;column:chain_delay" json:"chain_delay"`       // 距上一步完成的等待时间（分钟）
+	PausedAt       *time.Time `gorm:"column:paused_at" json:"paused_at,omitempty"`           // 暂停时间
+	PausedBy       string     `gorm:"size:20;column:paused_by" json:"paused_by,omitempty"`   // 暂停来源：user, admin
+	PausedSeconds  int        `gorm:"default:0;column:paused_seconds" json:"paused_seconds"` // 暂停累计时长（秒），用于顺延过期时间
+	CreatedAt      time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at" json:"updated_at"`
 }
 
 // TableName 指定表名
@@ -38,6 +46,7 @@ type CreateTaskRequest struct {
 	ExecuteCount int       `json:"execute_count" binding:"required" example:"10"`
 	Priority     int       `json:"priority" example:"1"`
 	Remark       string    `json:"remark" example:"测试任务"`
+	ExpireHours  int       `json:"expire_hours" example:"24"` // 可选，任务有效时长（小时），默认使用任务类型配置
 	// 注意: consume_jingdou 由服务端根据任务类型和执行次数自动计算，不接受客户端传入
 }
 
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"time"
+)
+
+// TaskImport 任务批量导入（CSV/XLSX）
+// 上传时逐行校验并保存，试运行只返回校验结果，正式导入按批次提交有效行
+type TaskImport struct {
+	ID              uint       `gorm:"primaryKey" json:"id"`
+	UserID          uint       `gorm:"not null;index;column:user_id" json:"user_id"`
+	FileName        string     `gorm:"size:255;column:file_name" json:"file_name"`
+	FileType        string     `gorm:"size:10;column:file_type" json:"file_type"`     // csv, xlsx
+	Columns         string     `gorm:"type:text" json:"-"`                            // 原文件表头（JSON数组），用于生成结果文件
+	Mapping         string     `gorm:"type:text" json:"mapping"`                      // 字段与表头的对应关系（JSON）
+	Status          string     `gorm:"size:20;not null;index" json:"status"`          // validated, pending, running, completed, failed
+	TotalRows       int        `gorm:"default:0;column:total_rows" json:"total_rows"` // 数据行数（不含表头）
+	ValidRows       int        `gorm:"default:0;column:valid_rows" json:"valid_rows"`
+	InvalidRows     int        `gorm:"default:0;column:invalid_rows" json:"invalid_rows"`
+	ProcessedRows   int        `gorm:"default:0;column:processed_rows" json:"processed_rows"` // 已处理的有效行
+	SuccessRows     int        `gorm:"default:0;column:success_rows" json:"success_rows"`
+	FailedRows      int        `gorm:"default:0;column:failed_rows" json:"failed_rows"`           // 提交时失败的行（如余额不足）
+	TotalJingdou    int        `gorm:"default:0;column:total_jingdou" json:"total_jingdou"`       // 有效行预计消耗京豆
+	ConsumedJingdou int        `gorm:"default:0;column:consumed_jingdou" json:"consumed_jingdou"` // 实际扣除京豆
+	Error           string     `gorm:"type:text" json:"error"`
+	JobID           *uint      `gorm:"column:job_id" json:"job_id"` // 后台导入作业
+	StartedAt       *time.Time `gorm:"column:started_at" json:"started_at"`
+	FinishedAt      *time.Time `gorm:"column:finished_at" json:"finished_at"`
+	CreatedAt       time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt       time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (TaskImport) TableName() string {
+	return "task_imports"
+}
+
+// TaskImportRow 导入文件中的一行
+type TaskImportRow struct {
+	ID       uint   `gorm:"primaryKey" json:"id"`
+	ImportID uint   `gorm:"not null;index:idx_task_import_row,priority:1;column:import_id" json:"import_id"`
+	Line     int    `gorm:"not null;index:idx_task_import_row,priority:2" json:"line"` // 文件中的行号（表头为第1行）
+	Raw      string `gorm:"type:text" json:"-"`                                        // 原始单元格（JSON数组）
+	Data     string `gorm:"type:text" json:"-"`                                        // 解析后的任务参数（JSON）
+	Status   string `gorm:"size:20;not null" json:"status"`                            // valid, invalid, created, failed
+	Jingdou  int    `gorm:"default:0" json:"jingdou"`                                  // 预计消耗京豆
+	TaskID   *uint  `gorm:"column:task_id" json:"task_id"`
+	Error    string `gorm:"type:text" json:"error"`
+}
+
+// TableName 指定表名
+func (TaskImportRow) TableName() string {
+	return "task_import_rows"
+}
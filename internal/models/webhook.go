@@ -0,0 +1,73 @@
+package models
+
+import (
+	"time"
+)
+
+// Webhook 用户的事件回调订阅
+// 任务和余额事件发生时，向订阅地址推送带 HMAC-SHA256 签名的 JSON 通知
+type Webhook struct {
+	ID                  uint      `gorm:"primaryKey" json:"id"`
+	UserID              uint      `gorm:"not null;index;column:user_id" json:"user_id"`
+	URL                 string    `gorm:"size:512;not null" json:"url"`
+	Secret              string    `gorm:"size:128;not null" json:"-"`                                          // 签名密钥，只在创建时返回
+	Events              string    `gorm:"size:255" json:"events"`                                              // 订阅的事件，逗号分隔，为空表示全部
+	LowBalanceThreshold int       `gorm:"default:0;column:low_balance_threshold" json:"low_balance_threshold"` // 余额低于该值时推送 balance.low
+	IsActive            bool      `gorm:"column:is_active" json:"is_active"`
+	Description         string    `gorm:"size:255" json:"description"`
+	CreatedAt           time.Time `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt           time.Time `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (Webhook) TableName() string {
+	return "webhooks"
+}
+
+// WebhookDelivery 待推送/已推送的事件（发件箱）
+// 事件在业务事务中写入，由推送服务异步投递，失败后按指数退避重试
+type WebhookDelivery struct {
+	ID             uint       `gorm:"primaryKey" json:"id"`
+	WebhookID      uint       `gorm:"not null;index;column:webhook_id" json:"webhook_id"`
+	UserID         uint       `gorm:"not null;index;column:user_id" json:"user_id"`
+	Event          string     `gorm:"size:64;not null" json:"event"`
+	Payload        string     `gorm:"type:text;not null" json:"payload"`
+	Status         string     `gorm:"size:20;not null;index:idx_webhook_delivery_due,priority:1" json:"status"` // pending, success, failed
+	Attempts       int        `gorm:"default:0" json:"attempts"`
+	NextAttemptAt  time.Time  `gorm:"index:idx_webhook_delivery_due,priority:2;column:next_attempt_at" json:"next_attempt_at"`
+	LastStatusCode int        `gorm:"default:0;column:last_status_code" json:"last_status_code"`
+	LastError      string     `gorm:"type:text;column:last_error" json:"last_error"`
+	DeliveredAt    *time.Time `gorm:"column:delivered_at" json:"delivered_at"`
+	CreatedAt      time.Time  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+// TableName 指定表名
+func (WebhookDelivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// WebhookDeliveryLog 每次推送尝试的记录
+type WebhookDeliveryLog struct {
+	ID         uint      `gorm:"primaryKey" json:"id"`
+	DeliveryID uint      `gorm:"not null;index;column:delivery_id" json:"delivery_id"`
+	Attempt    int       `gorm:"not null" json:"attempt"`
+	StatusCode int       `gorm:"default:0;column:status_code" json:"status_code"`
+	Error      string    `gorm:"type:text" json:"error"`
+	DurationMs int64     `gorm:"column:duration_ms" json:"duration_ms"`
+	CreatedAt  time.Time `gorm:"column:created_at" json:"created_at"`
+}
+
+// TableName 指定表名
+func (WebhookDeliveryLog) TableName() string {
+	return "webhook_delivery_logs"
+}
+
+// WebhookRequest 创建/修改 Webhook 请求
+type WebhookRequest struct {
+	URL                 string   `json:"url" binding:"required" example:"https://example.com/jd/webhook"`
+	Events              []string `json:"events" example:"task.completed,balance.low"` // 为空表示订阅全部事件
+	LowBalanceThreshold int      `json:"low_balance_threshold" example:"1000"`
+	IsActive            *bool    `json:"is_active" example:"true"`
+	Description         string   `json:"description" example:"任务完成通知"`
+}
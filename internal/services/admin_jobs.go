@@ -0,0 +1,123 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/taskstate"
+)
+
+// 管理员作业类型
+const (
+	JobExpireTasks  = "expire_tasks"  // 过期任务检查与退款
+	JobDataCleanup  = "data_cleanup"  // 清理过期数据
+	JobClearDevices = "clear_devices" // 清空所有设备
+)
+
+// 清空设备时每批删除的数量
+const clearDevicesBatchSize = 1000
+
+func init() {
+	RegisterJob(JobExpireTasks, "过期任务检查", runExpireTasksJob)
+	RegisterJob(JobDataCleanup, "数据清理", runDataCleanupJob)
+	RegisterJob(JobClearDevices, "清空设备", runClearDevicesJob)
+}
+
+// runExpireTasksJob 处理所有过期任务：标记为部分完成并按未完成次数退款
+func runExpireTasksJob(job *JobContext) (interface{}, error) {
+	var expiredTasks []models.Task
+	if err := ExpiredTasksQuery(job.DB, time.Now()).Find(&expiredTasks).Error; err != nil {
+		return nil, fmt.Errorf("查询过期任务失败: %v", err)
+	}
+
+	processedTasks := make([]map[string]interface{}, 0, len(expiredTasks))
+	totalRefund := 0
+	result := func() map[string]interface{} {
+		return map[string]interface{}{
+			"expired_count":   len(expiredTasks),
+			"processed_count": len(processedTasks),
+			"total_refund":    totalRefund,
+			"processed_tasks": processedTasks,
+		}
+	}
+
+	for i := range expiredTasks {
+		if i%20 == 0 {
+			if err := job.Progress(i*100/len(expiredTasks), fmt.Sprintf("正在处理过期任务 %d/%d", i, len(expiredTasks))); err != nil {
+				return result(), err
+			}
+		}
+
+		task := &expiredTasks[i]
+		oldStatus := task.Status
+		res, err := ExpireTask(job.DB, task, taskstate.ActorAdmin)
+		if err != nil {
+			log.Printf("处理过期任务失败 (task_id=%d): %v", task.ID, err)
+			continue
+		}
+		totalRefund += res.Refund
+		processedTasks = append(processedTasks, map[string]interface{}{
+			"task_id":        task.ID,
+			"sku":            task.SKU,
+			"old_status":     oldStatus,
+			"new_status":     res.To,
+			"executed":       task.ExecutedCount,
+			"total":          task.ExecuteCount,
+			"refund_jingdou": res.Refund,
+		})
+	}
+
+	return result(), nil
+}
+
+// runDataCleanupJob 按保留天数清理过期数据
+// 参数：retention_days 保留天数，默认60天
+func runDataCleanupJob(job *JobContext) (interface{}, error) {
+	var params struct {
+		RetentionDays int `json:"retention_days"`
+	}
+	if err := job.Bind(&params); err != nil {
+		return nil, fmt.Errorf("作业参数格式错误: %v", err)
+	}
+
+	cleanup := NewDataCleanupService(job.DB, params.RetentionDays, 0)
+	threshold := time.Now().AddDate(0, 0, -cleanup.GetRetentionDays())
+
+	result, err := cleanup.Cleanup(threshold, func(done, total int, step string) error {
+		return job.Progress(done*100/total, "正在清理"+step)
+	})
+	return map[string]interface{}{
+		"retention_days": cleanup.GetRetentionDays(),
+		"threshold_date": threshold.Format("2006-01-02"),
+		"deleted":        result,
+		"total_deleted":  result.Total(),
+	}, err
+}
+
+// runClearDevicesJob 分批删除所有设备记录
+func runClearDevicesJob(job *JobContext) (interface{}, error) {
+	var total int64
+	job.DB.Model(&models.Device{}).Count(&total)
+
+	var deleted int64
+	for {
+		if total > 0 {
+			if err := job.Progress(int(deleted*100/total), fmt.Sprintf("已删除 %d/%d 台设备", deleted, total)); err != nil {
+				return map[string]interface{}{"deleted_count": deleted}, err
+			}
+		}
+
+		result := job.DB.Exec("DELETE FROM devices LIMIT ?", clearDevicesBatchSize)
+		if result.Error != nil {
+			return map[string]interface{}{"deleted_count": deleted}, result.Error
+		}
+		deleted += result.RowsAffected
+		if result.RowsAffected < clearDevicesBatchSize {
+			break
+		}
+	}
+
+	return map[string]interface{}{"deleted_count": deleted}, nil
+}
@@ -9,6 +9,12 @@ import (
 	"jd-task-platform-go/internal/models"
 )
 
+// 设备遥测数据保留天数（数据量大，保留期短于业务数据）
+const (
+	SettingTelemetryRetentionDays = "telemetry_retention_days" // 设备遥测数据保留天数，默认7
+	DefaultTelemetryRetentionDays = 7
+)
+
 // DataCleanupService 数据清理服务
 type DataCleanupService struct {
 	db            *gorm.DB
@@ -82,20 +88,7 @@ func (s *DataCleanupService) executeCleanup() {
 	threshold := time.Now().AddDate(0, 0, -s.retentionDays)
 	log.Printf("清理 %s 之前的数据（保留%d天）", threshold.Format("2006-01-02"), s.retentionDays)
 
-	// 统计清理结果
-	result := CleanupResult{}
-
-	// 1. 清理任务日志（先清理，因为有外键关联）
-	result.TaskLogsDeleted = s.cleanupTaskLogs(threshold)
-
-	// 2. 清理任务（只清理已完成/已取消/部分完成的）
-	result.TasksDeleted = s.cleanupTasks(threshold)
-
-	// 3. 清理设备任务历史
-	result.DeviceHistoryDeleted = s.cleanupDeviceTaskHistory(threshold)
-
-	// 4. 清理API日志
-	result.APILogsDeleted = s.cleanupAPILogs(threshold)
+	result, _ := s.Cleanup(threshold, nil)
 
 	duration := time.Since(startTime)
 
@@ -105,15 +98,59 @@ func (s *DataCleanupService) executeCleanup() {
 	log.Printf("  - 任务日志: %d 条", result.TaskLogsDeleted)
 	log.Printf("  - 设备历史: %d 条", result.DeviceHistoryDeleted)
 	log.Printf("  - API日志: %d 条", result.APILogsDeleted)
+	log.Printf("  - 设备遥测: %d 条", result.TelemetryDeleted)
+	log.Printf("  - Webhook推送: %d 条", result.WebhookDeliveriesDeleted)
+	log.Printf("  - 任务导入: %d 条", result.TaskImportsDeleted)
+	log.Printf("  - 后台作业: %d 条", result.JobsDeleted)
 	log.Println("========================================")
 }
 
 // CleanupResult 清理结果统计
 type CleanupResult struct {
-	TasksDeleted         int64
-	TaskLogsDeleted      int64
-	DeviceHistoryDeleted int64
-	APILogsDeleted       int64
+	TasksDeleted             int64 `json:"tasks_deleted"`
+	TaskLogsDeleted          int64 `json:"task_logs_deleted"`
+	DeviceHistoryDeleted     int64 `json:"device_history_deleted"`
+	APILogsDeleted           int64 `json:"api_logs_deleted"`
+	TelemetryDeleted         int64 `json:"telemetry_deleted"`
+	WebhookDeliveriesDeleted int64 `json:"webhook_deliveries_deleted"`
+	TaskImportsDeleted       int64 `json:"task_imports_deleted"`
+	JobsDeleted              int64 `json:"jobs_deleted"`
+}
+
+// Total 删除的记录总数
+func (r CleanupResult) Total() int64 {
+	return r.TasksDeleted + r.TaskLogsDeleted + r.DeviceHistoryDeleted + r.APILogsDeleted +
+		r.TelemetryDeleted + r.WebhookDeliveriesDeleted + r.TaskImportsDeleted + r.JobsDeleted
+}
+
+// Cleanup 按步骤清理 threshold 之前的数据（设备遥测使用独立的保留期）
+// 每一步开始前调用 progress（可为 nil），progress 返回错误时停止并返回已完成步骤的统计
+func (s *DataCleanupService) Cleanup(threshold time.Time, progress func(done, total int, step string) error) (CleanupResult, error) {
+	result := CleanupResult{}
+	steps := []struct {
+		name string
+		run  func()
+	}{
+		// 先清理任务日志，再清理任务（只清理已完成/已取消/部分完成/失败的）
+		{"任务日志", func() { result.TaskLogsDeleted = s.cleanupTaskLogs(threshold) }},
+		{"任务记录", func() { result.TasksDeleted = s.cleanupTasks(threshold) }},
+		{"设备任务历史", func() { result.DeviceHistoryDeleted = s.cleanupDeviceTaskHistory(threshold) }},
+		{"API日志", func() { result.APILogsDeleted = s.cleanupAPILogs(threshold) }},
+		{"设备遥测", func() { result.TelemetryDeleted = s.cleanupDeviceTelemetry(s.telemetryThreshold()) }},
+		{"Webhook推送记录", func() { result.WebhookDeliveriesDeleted = s.cleanupWebhookDeliveries(threshold) }},
+		{"任务导入记录", func() { result.TaskImportsDeleted = s.cleanupTaskImports(threshold) }},
+		{"后台作业记录", func() { result.JobsDeleted = s.cleanupJobs(threshold) }},
+	}
+
+	for i, step := range steps {
+		if progress != nil {
+			if err := progress(i, len(steps), step.name); err != nil {
+				return result, err
+			}
+		}
+		step.run()
+	}
+	return result, nil
 }
 
 // cleanupTasks 清理过期任务
@@ -151,7 +188,7 @@ func (s *DataCleanupService) cleanupTaskLogs(threshold time.Time) int64 {
 		return 0
 	}
 
-	// 删除这些任务的日志
+	// 删除这些任务的日志和状态变更历史
 	result := s.db.Where("task_id IN ?", taskIDs).Delete(&models.TaskLog{})
 
 	if result.Error != nil {
@@ -159,7 +196,12 @@ func (s *DataCleanupService) cleanupTaskLogs(threshold time.Time) int64 {
 		return 0
 	}
 
-	return result.RowsAffected
+	history := s.db.Where("task_id IN ?", taskIDs).Delete(&models.TaskStatusHistory{})
+	if history.Error != nil {
+		log.Printf("清理任务状态历史失败: %v", history.Error)
+	}
+
+	return result.RowsAffected + history.RowsAffected
 }
 
 // cleanupDeviceTaskHistory 清理设备任务历史
@@ -186,6 +228,77 @@ func (s *DataCleanupService) cleanupAPILogs(threshold time.Time) int64 {
 	return result.RowsAffected
 }
 
+// cleanupDeviceTelemetry 清理设备遥测数据
+func (s *DataCleanupService) cleanupDeviceTelemetry(threshold time.Time) int64 {
+	result := s.db.Where("created_at < ?", threshold).Delete(&models.DeviceTelemetry{})
+
+	if result.Error != nil {
+		log.Printf("清理设备遥测数据失败: %v", result.Error)
+		return 0
+	}
+
+	return result.RowsAffected
+}
+
+// cleanupJobs 清理已结束的后台作业记录
+func (s *DataCleanupService) cleanupJobs(threshold time.Time) int64 {
+	result := s.db.Where("created_at < ? AND status IN ?", threshold,
+		[]string{JobStatusSucceeded, JobStatusFailed, JobStatusCancelled}).Delete(&models.Job{})
+	if result.Error != nil {
+		log.Printf("清理后台作业记录失败: %v", result.Error)
+		return 0
+	}
+
+	return result.RowsAffected
+}
+
+// cleanupTaskImports 清理已结束或未提交的任务导入记录及其数据行
+func (s *DataCleanupService) cleanupTaskImports(threshold time.Time) int64 {
+	finished := []string{ImportStatusValidated, ImportStatusCompleted, ImportStatusFailed}
+	imports := s.db.Model(&models.TaskImport{}).Select("id").
+		Where("created_at < ? AND status IN ?", threshold, finished)
+	if err := s.db.Where("import_id IN (?)", imports).Delete(&models.TaskImportRow{}).Error; err != nil {
+		log.Printf("清理任务导入数据失败: %v", err)
+		return 0
+	}
+
+	result := s.db.Where("created_at < ? AND status IN ?", threshold, finished).Delete(&models.TaskImport{})
+	if result.Error != nil {
+		log.Printf("清理任务导入记录失败: %v", result.Error)
+		return 0
+	}
+
+	return result.RowsAffected
+}
+
+// cleanupWebhookDeliveries 清理已结束（成功或放弃重试）的Webhook推送记录及其尝试日志
+func (s *DataCleanupService) cleanupWebhookDeliveries(threshold time.Time) int64 {
+	finished := s.db.Model(&models.WebhookDelivery{}).Select("id").
+		Where("created_at < ? AND status IN ?", threshold, []string{DeliveryStatusSuccess, DeliveryStatusFailed})
+	if err := s.db.Where("delivery_id IN (?)", finished).Delete(&models.WebhookDeliveryLog{}).Error; err != nil {
+		log.Printf("清理Webhook推送日志失败: %v", err)
+		return 0
+	}
+
+	result := s.db.Where("created_at < ? AND status IN ?", threshold, []string{DeliveryStatusSuccess, DeliveryStatusFailed}).
+		Delete(&models.WebhookDelivery{})
+	if result.Error != nil {
+		log.Printf("清理Webhook推送记录失败: %v", result.Error)
+		return 0
+	}
+
+	return result.RowsAffected
+}
+
+// telemetryThreshold 设备遥测数据清理阈值，保留天数读取系统设置，未设置或无效时为7天
+func (s *DataCleanupService) telemetryThreshold() time.Time {
+	days := settingInt(s.db, SettingTelemetryRetentionDays, DefaultTelemetryRetentionDays)
+	if days <= 0 {
+		days = DefaultTelemetryRetentionDays
+	}
+	return time.Now().AddDate(0, 0, -days)
+}
+
 // ManualCleanup 手动触发清理（供API调用）
 func (s *DataCleanupService) ManualCleanup() CleanupResult {
 	startTime := time.Now()
@@ -193,11 +306,7 @@ func (s *DataCleanupService) ManualCleanup() CleanupResult {
 
 	threshold := time.Now().AddDate(0, 0, -s.retentionDays)
 
-	result := CleanupResult{}
-	result.TaskLogsDeleted = s.cleanupTaskLogs(threshold)
-	result.TasksDeleted = s.cleanupTasks(threshold)
-	result.DeviceHistoryDeleted = s.cleanupDeviceTaskHistory(threshold)
-	result.APILogsDeleted = s.cleanupAPILogs(threshold)
+	result, _ := s.Cleanup(threshold, nil)
 
 	log.Printf("手动清理完成，耗时: %v", time.Since(startTime).Round(time.Millisecond))
 
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// 设备控制指令
+const (
+	DeviceActionPause       = "pause"        // 暂停接单
+	DeviceActionResume      = "resume"       // 恢复接单
+	DeviceActionBlock       = "block"        // 封禁设备
+	DeviceActionUnblock     = "unblock"      // 解除封禁
+	DeviceActionUpdateProxy = "update_proxy" // 更换代理
+	DeviceActionRotateProxy = "rotate_proxy" // 代理达到轮换条件，重新申请代理
+)
+
+// DeviceMessage 推送给设备的消息
+type DeviceMessage struct {
+	Type      string      `json:"type"`              // control
+	Action    string      `json:"action"`            // pause, resume, block, unblock, update_proxy, rotate_proxy
+	Payload   interface{} `json:"payload,omitempty"` // 指令附加数据
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// DeviceHub 设备推送中心
+// 维护长轮询中的设备连接，在有新任务或控制指令时唤醒对应设备
+type DeviceHub struct {
+	mu            sync.Mutex
+	pending       map[string][]DeviceMessage // 待下发的控制指令（按设备ID）
+	signals       map[string]chan struct{}   // 设备专属唤醒信号
+	waiting       map[string]int             // 正在等待的连接数
+	taskAvailable chan struct{}              // 新任务广播信号（关闭即广播）
+	recheck       time.Duration              // 兜底重新检查间隔（处理定时开始的任务）
+	maxPending    int                        // 单设备最多积压的指令数
+}
+
+// NewDeviceHub 创建设备推送中心
+func NewDeviceHub() *DeviceHub {
+	return &DeviceHub{
+		pending:       make(map[string][]DeviceMessage),
+		signals:       make(map[string]chan struct{}),
+		waiting:       make(map[string]int),
+		taskAvailable: make(chan struct{}),
+		recheck:       5 * time.Second,
+		maxPending:    20,
+	}
+}
+
+// 全局设备推送中心
+var defaultDeviceHub = NewDeviceHub()
+
+// GetDeviceHub 获取全局设备推送中心
+func GetDeviceHub() *DeviceHub {
+	return defaultDeviceHub
+}
+
+// NotifyTaskAvailable 通知所有等待中的设备有新任务可领取
+func (h *DeviceHub) NotifyTaskAvailable() {
+	h.mu.Lock()
+	close(h.taskAvailable)
+	h.taskAvailable = make(chan struct{})
+	h.mu.Unlock()
+}
+
+// SendControl 向设备发送控制指令，设备正在等待时立即唤醒
+func (h *DeviceHub) SendControl(deviceID, action string, payload interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msgs := append(h.pending[deviceID], DeviceMessage{
+		Type:      "control",
+		Action:    action,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	})
+	// 设备长时间不在线时只保留最近的指令
+	if len(msgs) > h.maxPending {
+		msgs = msgs[len(msgs)-h.maxPending:]
+	}
+	h.pending[deviceID] = msgs
+
+	if ch, ok := h.signals[deviceID]; ok {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// PopMessages 取出设备所有待下发的控制指令
+func (h *DeviceHub) PopMessages(deviceID string) []DeviceMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	msgs := h.pending[deviceID]
+	delete(h.pending, deviceID)
+	return msgs
+}
+
+// IsConnected 设备当前是否有长轮询连接
+func (h *DeviceHub) IsConnected(deviceID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.waiting[deviceID] > 0
+}
+
+// ConnectedCount 当前长轮询连接的设备数
+func (h *DeviceHub) ConnectedCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.waiting)
+}
+
+// Wait 阻塞等待直到有新任务、控制指令或到达兜底检查间隔
+// 返回 false 表示已超过 deadline 或连接已断开，调用方应结束本次长轮询
+func (h *DeviceHub) Wait(ctx context.Context, deviceID string, deadline time.Time) bool {
+	remaining := time.Until(deadline)
+	if remaining <= 0 {
+		return false
+	}
+
+	h.mu.Lock()
+	signal, ok := h.signals[deviceID]
+	if !ok {
+		signal = make(chan struct{}, 1)
+		h.signals[deviceID] = signal
+	}
+	h.waiting[deviceID]++
+	taskAvailable := h.taskAvailable
+	h.mu.Unlock()
+
+	defer func() {
+		h.mu.Lock()
+		h.waiting[deviceID]--
+		if h.waiting[deviceID] <= 0 {
+			delete(h.waiting, deviceID)
+			delete(h.signals, deviceID)
+		}
+		h.mu.Unlock()
+	}()
+
+	wait := h.recheck
+	if remaining < wait {
+		wait = remaining
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-signal:
+		return true
+	case <-taskAvailable:
+		return true
+	case <-timer.C:
+		return time.Now().Before(deadline)
+	case <-ctx.Done():
+		return false
+	}
+}
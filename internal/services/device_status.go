@@ -50,13 +50,23 @@ func (s *DeviceStatusService) Stop() {
 func (s *DeviceStatusService) checkDeviceStatus() {
 	offlineThreshold := time.Now().Add(-s.offlineTime)
 
-	// 将超过3分钟未活动的非离线设备标记为离线
+	// 找出超过3分钟未活动的非离线设备，用于推送离线事件
+	var deviceIDs []string
+	s.db.Table("devices").
+		Where("status != 'offline' AND last_heartbeat < ?", offlineThreshold).
+		Pluck("device_id", &deviceIDs)
+	if len(deviceIDs) == 0 {
+		return
+	}
+
+	// 将这些设备标记为离线（仍按活动时间判断，跳过期间刚恢复心跳的设备）
 	result := s.db.Exec(`
 		UPDATE devices 
 		SET status = 'offline' 
-		WHERE status != 'offline' 
+		WHERE device_id IN ?
+		  AND status != 'offline' 
 		  AND last_heartbeat < ?
-	`, offlineThreshold)
+	`, deviceIDs, offlineThreshold)
 
 	if result.Error != nil {
 		log.Printf("更新设备离线状态失败: %v", result.Error)
@@ -65,5 +75,8 @@ func (s *DeviceStatusService) checkDeviceStatus() {
 
 	if result.RowsAffected > 0 {
 		log.Printf("已将 %d 台设备标记为离线（超过3分钟无活动）", result.RowsAffected)
+		for _, deviceID := range deviceIDs {
+			PublishDeviceStatus(deviceID, false)
+		}
 	}
 }
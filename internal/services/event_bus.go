@@ -0,0 +1,162 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/taskstate"
+)
+
+// 实时事件类型（/api/events 推送）
+const (
+	StreamTaskProgress   = "task.progress"   // 任务状态或执行进度变化
+	StreamDeviceStatus   = "device.status"   // 设备上线/离线
+	StreamBalance        = "balance.changed" // 京豆余额变化
+	StreamImportProgress = "import.progress" // 任务导入进度
+	StreamJobProgress    = "job.progress"    // 后台作业进度（仅管理员可见）
+)
+
+// StreamEvent 进程内事件
+// UserID 为事件所属用户，0 表示系统事件（仅管理员可见）
+type StreamEvent struct {
+	ID        uint64      `json:"id"`
+	Type      string      `json:"type"`
+	UserID    uint        `json:"user_id,omitempty"`
+	Data      interface{} `json:"data"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// EventSubscriber 事件订阅者
+type EventSubscriber struct {
+	C       chan StreamEvent
+	userID  uint
+	isAdmin bool
+	dropped uint64 // 因缓冲区已满丢弃的事件数
+}
+
+// accepts 订阅者是否可以接收该事件：管理员接收全部事件，普通用户只接收自己的事件
+func (s *EventSubscriber) accepts(event StreamEvent) bool {
+	return s.isAdmin || (event.UserID != 0 && event.UserID == s.userID)
+}
+
+// EventBus 进程内事件总线
+// 处理器和后台服务在状态变化（已提交）后发布事件，SSE 连接订阅后按角色过滤推送
+// 发布不会阻塞：订阅者缓冲区已满时丢弃该事件，客户端可根据事件ID的间断重新拉取数据
+type EventBus struct {
+	mu          sync.RWMutex
+	nextID      uint64
+	subscribers map[*EventSubscriber]struct{}
+	bufferSize  int
+}
+
+// NewEventBus 创建事件总线
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[*EventSubscriber]struct{}),
+		bufferSize:  64,
+	}
+}
+
+// 全局事件总线
+var defaultEventBus = NewEventBus()
+
+// GetEventBus 获取全局事件总线
+func GetEventBus() *EventBus {
+	return defaultEventBus
+}
+
+// Subscribe 订阅事件，使用完毕后必须调用 Unsubscribe
+func (b *EventBus) Subscribe(userID uint, isAdmin bool) *EventSubscriber {
+	sub := &EventSubscriber{
+		C:       make(chan StreamEvent, b.bufferSize),
+		userID:  userID,
+		isAdmin: isAdmin,
+	}
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+	return sub
+}
+
+// Unsubscribe 取消订阅
+func (b *EventBus) Unsubscribe(sub *EventSubscriber) {
+	b.mu.Lock()
+	delete(b.subscribers, sub)
+	b.mu.Unlock()
+}
+
+// SubscriberCount 当前订阅数
+func (b *EventBus) SubscriberCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
+
+// Publish 发布事件
+func (b *EventBus) Publish(eventType string, userID uint, data interface{}) {
+	b.mu.Lock()
+	b.nextID++
+	event := StreamEvent{
+		ID:        b.nextID,
+		Type:      eventType,
+		UserID:    userID,
+		Data:      data,
+		CreatedAt: time.Now(),
+	}
+	for sub := range b.subscribers {
+		if !sub.accepts(event) {
+			continue
+		}
+		select {
+		case sub.C <- event:
+		default:
+			sub.dropped++
+		}
+	}
+	b.mu.Unlock()
+}
+
+// PublishTaskProgress 发布任务进度事件
+func PublishTaskProgress(task *models.Task) {
+	progress := 0.0
+	if task.ExecuteCount > 0 {
+		progress = float64(task.ExecutedCount) / float64(task.ExecuteCount) * 100
+		if progress > 100 {
+			progress = 100
+		}
+	}
+	GetEventBus().Publish(StreamTaskProgress, task.UserID, map[string]interface{}{
+		"task_id":        task.ID,
+		"chain_id":       task.ChainID,
+		"task_type":      task.TaskType,
+		"sku":            task.SKU,
+		"status":         task.Status,
+		"status_text":    taskstate.Text(task.Status),
+		"executed_count": task.ExecutedCount,
+		"execute_count":  task.ExecuteCount,
+		"progress":       progress,
+	})
+}
+
+// PublishDeviceStatus 发布设备上线/离线事件（仅管理员可见）
+func PublishDeviceStatus(deviceID string, online bool) {
+	GetEventBus().Publish(StreamDeviceStatus, 0, map[string]interface{}{
+		"device_id": deviceID,
+		"online":    online,
+	})
+}
+
+// PublishBalance 发布京豆余额变化事件
+// amount 为本次变化量（扣除为负数），operation 与京豆日志的操作类型一致
+func PublishBalance(userID uint, balance, amount int, operation string) {
+	if amount == 0 {
+		return
+	}
+	GetEventBus().Publish(StreamBalance, userID, map[string]interface{}{
+		"user_id":        userID,
+		"balance":        balance,
+		"amount":         amount,
+		"operation_type": operation,
+	})
+}
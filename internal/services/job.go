@@ -0,0 +1,497 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// 作业状态
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// ErrJobCancelled 作业已被取消，作业处理函数在检查点返回该错误即可结束
+var ErrJobCancelled = errors.New("作业已取消")
+
+// JobHandler 作业处理函数，返回值作为作业结果（JSON）保存
+type JobHandler func(job *JobContext) (interface{}, error)
+
+// jobType 已注册的作业类型
+type jobType struct {
+	name      string
+	handler   JobHandler
+	resumable bool // 执行实例退出后重新排队，处理函数需能从中断处继续
+}
+
+var (
+	jobTypesMu sync.RWMutex
+	jobTypes   = map[string]jobType{}
+)
+
+// RegisterJob 注册作业类型
+func RegisterJob(typ, name string, handler JobHandler) {
+	jobTypesMu.Lock()
+	defer jobTypesMu.Unlock()
+	jobTypes[typ] = jobType{name: name, handler: handler}
+}
+
+// RegisterResumableJob 注册可续跑的作业类型，执行实例退出后作业重新排队而不是标记为失败
+func RegisterResumableJob(typ, name string, handler JobHandler) {
+	jobTypesMu.Lock()
+	defer jobTypesMu.Unlock()
+	jobTypes[typ] = jobType{name: name, handler: handler, resumable: true}
+}
+
+// resumableJobTypes 可续跑的作业类型
+func resumableJobTypes() []string {
+	jobTypesMu.RLock()
+	defer jobTypesMu.RUnlock()
+	var types []string
+	for typ, t := range jobTypes {
+		if t.resumable {
+			types = append(types, typ)
+		}
+	}
+	return types
+}
+
+// JobTypes 已注册的作业类型及名称
+func JobTypes() []map[string]string {
+	jobTypesMu.RLock()
+	defer jobTypesMu.RUnlock()
+
+	types := make([]map[string]string, 0, len(jobTypes))
+	for typ, t := range jobTypes {
+		types = append(types, map[string]string{"type": typ, "name": t.name})
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i]["type"] < types[j]["type"] })
+	return types
+}
+
+func lookupJob(typ string) (jobType, bool) {
+	jobTypesMu.RLock()
+	defer jobTypesMu.RUnlock()
+	t, ok := jobTypes[typ]
+	return t, ok
+}
+
+// jobWake 提交作业后唤醒作业服务，无需等待下一次轮询
+var jobWake = make(chan struct{}, 1)
+
+// SubmitJob 提交作业
+func SubmitJob(db *gorm.DB, typ string, params interface{}, userID uint) (*models.Job, error) {
+	if _, ok := lookupJob(typ); !ok {
+		return nil, fmt.Errorf("不支持的作业类型: %s", typ)
+	}
+
+	paramsJSON := "{}"
+	if params != nil {
+		data, err := json.Marshal(params)
+		if err != nil {
+			return nil, fmt.Errorf("作业参数格式错误: %v", err)
+		}
+		paramsJSON = string(data)
+	}
+
+	job := models.Job{
+		Type:      typ,
+		Params:    paramsJSON,
+		Status:    JobStatusQueued,
+		CreatedBy: userID,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if err := db.Create(&job).Error; err != nil {
+		return nil, err
+	}
+
+	select {
+	case jobWake <- struct{}{}:
+	default:
+	}
+	return &job, nil
+}
+
+// CancelJob 取消作业：排队中的作业直接取消，执行中的作业在下一个检查点停止
+func CancelJob(db *gorm.DB, job *models.Job) error {
+	now := time.Now()
+	res := db.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, JobStatusQueued).
+		Updates(map[string]interface{}{
+			"status":           JobStatusCancelled,
+			"cancel_requested": true,
+			"message":          "已取消",
+			"finished_at":      now,
+			"updated_at":       now,
+		})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected > 0 {
+		job.Status = JobStatusCancelled
+		job.CancelRequested = true
+		return nil
+	}
+
+	res = db.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, JobStatusRunning).
+		Updates(map[string]interface{}{"cancel_requested": true, "updated_at": now})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("作业已结束，无法取消")
+	}
+	job.CancelRequested = true
+	return nil
+}
+
+// JobContext 作业执行上下文
+type JobContext struct {
+	context.Context
+	DB  *gorm.DB
+	Job *models.Job
+}
+
+// Bind 解析作业参数
+func (j *JobContext) Bind(v interface{}) error {
+	if j.Job.Params == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(j.Job.Params), v)
+}
+
+// Progress 更新作业进度，作业已被取消时返回 ErrJobCancelled
+func (j *JobContext) Progress(percent int, message string) error {
+	if percent < 0 {
+		percent = 0
+	}
+	if percent > 100 {
+		percent = 100
+	}
+	j.Job.Progress = percent
+	j.Job.Message = message
+	j.DB.Model(&models.Job{}).Where("id = ?", j.Job.ID).Updates(map[string]interface{}{
+		"progress":   percent,
+		"message":    message,
+		"updated_at": time.Now(),
+	})
+	publishJobProgress(j.Job)
+	return j.Err()
+}
+
+// Err 作业已被取消时返回 ErrJobCancelled
+func (j *JobContext) Err() error {
+	if j.Context.Err() != nil {
+		return ErrJobCancelled
+	}
+	return nil
+}
+
+// publishJobProgress 推送作业进度（仅管理员可见）
+func publishJobProgress(job *models.Job) {
+	GetEventBus().Publish(StreamJobProgress, 0, map[string]interface{}{
+		"job_id":   job.ID,
+		"type":     job.Type,
+		"status":   job.Status,
+		"progress": job.Progress,
+		"message":  job.Message,
+	})
+}
+
+// jobLeaseTimeout 执行中的作业超过该时间没有心跳，视为执行实例已退出
+const jobLeaseTimeout = time.Minute
+
+// JobService 作业服务：轮询排队中的作业，在并发上限内依次领取执行
+// 执行中的作业定期写入心跳，多实例部署时各实例只回收心跳超时的作业
+type JobService struct {
+	db           *gorm.DB
+	slots        chan struct{} // 并发执行的作业数上限
+	stopChan     chan struct{}
+	pollInterval time.Duration
+	leaseTimeout time.Duration
+	lastRecover  time.Time
+	wg           sync.WaitGroup
+}
+
+// NewJobService 创建作业服务，workers 为并发执行的作业数
+func NewJobService(db *gorm.DB, workers int) *JobService {
+	if workers <= 0 {
+		workers = 2
+	}
+	return &JobService{
+		db:           db,
+		slots:        make(chan struct{}, workers),
+		stopChan:     make(chan struct{}),
+		pollInterval: 2 * time.Second,
+		leaseTimeout: jobLeaseTimeout,
+	}
+}
+
+// Start 启动作业服务
+func (s *JobService) Start() {
+	s.recoverStale()
+
+	log.Printf("✓ 作业服务已启动（最多同时执行 %d 个作业）", cap(s.slots))
+	go s.run()
+}
+
+// Stop 停止作业服务，执行中的作业会被取消并等待其结束
+func (s *JobService) Stop() {
+	close(s.stopChan)
+	s.wg.Wait()
+	log.Println("作业服务已停止")
+}
+
+// run 运行作业调度循环
+func (s *JobService) run() {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if time.Since(s.lastRecover) >= s.leaseTimeout/2 {
+			s.recoverStale()
+		}
+		s.dispatch()
+		select {
+		case <-ticker.C:
+		case <-jobWake:
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// recoverStale 回收心跳超时的执行中作业：可续跑的作业重新排队，其他作业标记为失败
+// 执行实例退出前未执行完的作业无法确定执行到哪一步，由管理员决定是否重新提交；
+// 其他实例仍在执行的作业会持续更新心跳，不受影响
+func (s *JobService) recoverStale() {
+	now := time.Now()
+	s.lastRecover = now
+	deadline := now.Add(-s.leaseTimeout)
+	stale := func() *gorm.DB {
+		return s.db.Model(&models.Job{}).
+			Where("status = ?", JobStatusRunning).
+			Where("heartbeat_at < ? OR (heartbeat_at IS NULL AND started_at < ?)", deadline, deadline)
+	}
+
+	if resumable := resumableJobTypes(); len(resumable) > 0 {
+		res := stale().Where("type IN ?", resumable).Updates(map[string]interface{}{
+			"status":       JobStatusQueued,
+			"message":      "执行中断，等待继续",
+			"heartbeat_at": nil,
+			"updated_at":   now,
+		})
+		if res.Error != nil {
+			log.Printf("回收超时作业失败: %v", res.Error)
+			return
+		}
+		if res.RowsAffected > 0 {
+			log.Printf("已将 %d 个心跳超时的作业重新排队", res.RowsAffected)
+		}
+	}
+
+	res := stale().Updates(map[string]interface{}{
+		"status":      JobStatusFailed,
+		"error":       "作业心跳超时，执行实例可能已退出",
+		"finished_at": now,
+		"updated_at":  now,
+	})
+	if res.Error != nil {
+		log.Printf("回收超时作业失败: %v", res.Error)
+		return
+	}
+	if res.RowsAffected > 0 {
+		log.Printf("已将 %d 个心跳超时的作业标记为失败", res.RowsAffected)
+	}
+}
+
+// dispatch 有空闲名额时按提交顺序领取作业执行
+func (s *JobService) dispatch() {
+	for {
+		select {
+		case s.slots <- struct{}{}:
+		case <-s.stopChan:
+			return
+		}
+
+		job, ok := s.claim()
+		if !ok {
+			<-s.slots
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer func() {
+				<-s.slots
+				s.wg.Done()
+			}()
+			s.execute(job)
+		}()
+	}
+}
+
+// claim 领取最早提交的排队作业，多个实例同时领取时只有一个成功
+func (s *JobService) claim() (*models.Job, bool) {
+	for {
+		var job models.Job
+		if err := s.db.Where("status = ?", JobStatusQueued).Order("id ASC").First(&job).Error; err != nil {
+			return nil, false
+		}
+
+		now := time.Now()
+		res := s.db.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, JobStatusQueued).
+			Updates(map[string]interface{}{"status": JobStatusRunning, "started_at": now, "heartbeat_at": now, "updated_at": now})
+		if res.Error != nil {
+			return nil, false
+		}
+		if res.RowsAffected > 0 {
+			job.Status = JobStatusRunning
+			job.StartedAt = &now
+			job.HeartbeatAt = &now
+			return &job, true
+		}
+	}
+}
+
+// execute 执行作业
+func (s *JobService) execute(job *models.Job) {
+	t, ok := lookupJob(job.Type)
+	if !ok {
+		s.finish(job, nil, fmt.Errorf("不支持的作业类型: %s", job.Type))
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.heartbeat(ctx, cancel, job.ID)
+
+	publishJobProgress(job)
+	result, err := s.safeRun(t.handler, &JobContext{Context: ctx, DB: s.db, Job: job})
+	s.finish(job, result, err)
+}
+
+// safeRun 执行作业处理函数，处理函数 panic 时作业失败而不影响工作协程
+func (s *JobService) safeRun(handler JobHandler, jc *JobContext) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("作业执行异常: %v", r)
+		}
+	}()
+	return handler(jc)
+}
+
+// heartbeat 定期更新作业心跳并检查取消标记，管理员取消作业时取消执行上下文
+// 作业已被其他实例判定为超时失败时同样取消执行，避免同一作业结束后覆盖失败状态
+func (s *JobService) heartbeat(ctx context.Context, cancel context.CancelFunc, id uint) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			res := s.db.Model(&models.Job{}).Where("id = ? AND status = ?", id, JobStatusRunning).
+				UpdateColumn("heartbeat_at", time.Now())
+			if res.Error == nil && res.RowsAffected == 0 {
+				cancel()
+				return
+			}
+			var requested bool
+			s.db.Model(&models.Job{}).Where("id = ?", id).Select("cancel_requested").Scan(&requested)
+			if requested {
+				cancel()
+				return
+			}
+		case <-s.stopChan:
+			cancel()
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finish 保存作业结果
+func (s *JobService) finish(job *models.Job, result interface{}, err error) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"finished_at": now,
+		"updated_at":  now,
+	}
+	if result != nil {
+		if data, marshalErr := json.Marshal(result); marshalErr == nil {
+			job.Result = string(data)
+			updates["result"] = job.Result
+		}
+	}
+
+	// 服务停止时中断的可续跑作业重新排队，由下次启动的实例继续执行
+	if t, _ := lookupJob(job.Type); errors.Is(err, ErrJobCancelled) && t.resumable && s.stopping() {
+		s.requeue(job)
+		return
+	}
+
+	switch {
+	case errors.Is(err, ErrJobCancelled):
+		job.Status = JobStatusCancelled
+		job.Message = "已取消"
+	case err != nil:
+		job.Status = JobStatusFailed
+		job.Error = err.Error()
+		updates["error"] = job.Error
+		log.Printf("作业 #%d (%s) 执行失败: %v", job.ID, job.Type, err)
+	default:
+		job.Status = JobStatusSucceeded
+		job.Progress = 100
+		job.Message = "已完成"
+		updates["progress"] = 100
+	}
+	updates["status"] = job.Status
+	updates["message"] = job.Message
+	// 作业已因心跳超时被判定失败时保留失败状态
+	res := s.db.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, JobStatusRunning).Updates(updates)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+	publishJobProgress(job)
+}
+
+// stopping 作业服务是否正在停止
+func (s *JobService) stopping() bool {
+	select {
+	case <-s.stopChan:
+		return true
+	default:
+		return false
+	}
+}
+
+// requeue 将执行中的作业重新排队
+func (s *JobService) requeue(job *models.Job) {
+	job.Status = JobStatusQueued
+	job.Message = "执行中断，等待继续"
+	job.HeartbeatAt = nil
+	res := s.db.Model(&models.Job{}).Where("id = ? AND status = ?", job.ID, JobStatusRunning).Updates(map[string]interface{}{
+		"status":       job.Status,
+		"message":      job.Message,
+		"heartbeat_at": nil,
+		"updated_at":   time.Now(),
+	})
+	if res.Error != nil || res.RowsAffected == 0 {
+		return
+	}
+	publishJobProgress(job)
+}
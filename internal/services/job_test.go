@@ -0,0 +1,109 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"jd-task-platform-go/internal/models"
+)
+
+func TestJobRecoverStale(t *testing.T) {
+	db := newTestDB(t, &models.Job{})
+	s := NewJobService(db, 1)
+
+	now := time.Now()
+	old := now.Add(-2 * jobLeaseTimeout)
+	jobs := map[string]*models.Job{
+		"alive":   {Type: "t", Status: JobStatusRunning, StartedAt: &old, HeartbeatAt: &now},
+		"stale":   {Type: "t", Status: JobStatusRunning, StartedAt: &old, HeartbeatAt: &old},
+		"legacy":  {Type: "t", Status: JobStatusRunning, StartedAt: &old},
+		"started": {Type: "t", Status: JobStatusRunning, StartedAt: &now},
+		"queued":  {Type: "t", Status: JobStatusQueued},
+	}
+	for _, job := range jobs {
+		if err := db.Create(job).Error; err != nil {
+			t.Fatalf("创建作业失败: %v", err)
+		}
+	}
+
+	s.recoverStale()
+
+	want := map[string]string{
+		"alive":   JobStatusRunning,
+		"stale":   JobStatusFailed,
+		"legacy":  JobStatusFailed,
+		"started": JobStatusRunning,
+		"queued":  JobStatusQueued,
+	}
+	for name, job := range jobs {
+		var saved models.Job
+		db.First(&saved, job.ID)
+		if saved.Status != want[name] {
+			t.Errorf("%s: status = %s, 期望 %s", name, saved.Status, want[name])
+		}
+	}
+}
+
+func TestJobFinishKeepsRecoveredFailure(t *testing.T) {
+	db := newTestDB(t, &models.Job{})
+	s := NewJobService(db, 1)
+
+	job := models.Job{Type: "t", Status: JobStatusFailed, Error: "作业心跳超时，执行实例可能已退出"}
+	db.Create(&job)
+
+	s.finish(&job, map[string]int{"n": 1}, nil)
+
+	var saved models.Job
+	db.First(&saved, job.ID)
+	if saved.Status != JobStatusFailed || saved.Result != "" {
+		t.Errorf("已判定失败的作业不应被覆盖: %+v", saved)
+	}
+}
+
+func TestJobRecoverStaleRequeuesResumable(t *testing.T) {
+	db := newTestDB(t, &models.Job{})
+	s := NewJobService(db, 1)
+
+	old := time.Now().Add(-2 * jobLeaseTimeout)
+	job := models.Job{Type: JobTaskImport, Status: JobStatusRunning, StartedAt: &old, HeartbeatAt: &old}
+	db.Create(&job)
+
+	s.recoverStale()
+
+	var saved models.Job
+	db.First(&saved, job.ID)
+	if saved.Status != JobStatusQueued || saved.HeartbeatAt != nil {
+		t.Errorf("可续跑的作业应重新排队: %+v", saved)
+	}
+}
+
+func TestJobFinishCancelled(t *testing.T) {
+	db := newTestDB(t, &models.Job{})
+	s := NewJobService(db, 1)
+
+	now := time.Now()
+	plain := models.Job{Type: "t", Status: JobStatusRunning, StartedAt: &now, HeartbeatAt: &now}
+	resumable := models.Job{Type: JobTaskImport, Status: JobStatusRunning, StartedAt: &now, HeartbeatAt: &now}
+	db.Create(&plain)
+	db.Create(&resumable)
+
+	// 管理员取消：作业记为已取消
+	s.finish(&resumable, nil, ErrJobCancelled)
+	var saved models.Job
+	db.First(&saved, resumable.ID)
+	if saved.Status != JobStatusCancelled || saved.Progress == 100 {
+		t.Errorf("取消的作业 %+v", saved)
+	}
+
+	// 服务停止时中断：可续跑的作业重新排队，其他作业记为已取消
+	db.Model(&models.Job{}).Where("id = ?", resumable.ID).UpdateColumn("status", JobStatusRunning)
+	close(s.stopChan)
+	s.finish(&plain, nil, ErrJobCancelled)
+	s.finish(&resumable, nil, ErrJobCancelled)
+	var stopped, requeued models.Job
+	db.First(&stopped, plain.ID)
+	db.First(&requeued, resumable.ID)
+	if stopped.Status != JobStatusCancelled || requeued.Status != JobStatusQueued || requeued.HeartbeatAt != nil {
+		t.Errorf("停止时 plain=%s resumable=%+v", stopped.Status, requeued)
+	}
+}
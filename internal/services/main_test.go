@@ -0,0 +1,32 @@
+package services
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testDBSeq atomic.Int64
+
+// newTestDB 创建内存 SQLite 数据库并建好 tables 对应的表，测试结束后关闭
+func newTestDB(tb testing.TB, tables ...interface{}) *gorm.DB {
+	tb.Helper()
+	// 内存数据库只对创建它的连接可见，连接池限制为1个连接
+	dsn := fmt.Sprintf("file:testdb%d?mode=memory", testDBSeq.Add(1))
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		tb.Fatalf("打开测试数据库失败: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	tb.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(tables...); err != nil {
+		tb.Fatalf("创建测试表失败: %v", err)
+	}
+	return db
+}
@@ -0,0 +1,254 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// 代理失败率异常判断配置
+const (
+	SettingProxyAnomalyMinTasks = "proxy_anomaly_min_tasks" // 参与异常判断的最少执行次数，默认20
+	SettingProxyAnomalyZScore   = "proxy_anomaly_z_score"   // 失败率高出其余代理多少个标准差判定为异常，默认3
+
+	defaultProxyAnomalyMinTasks = 20
+	defaultProxyAnomalyZScore   = 3
+)
+
+// 代理使用分析的排序方式
+const (
+	ProxyOutcomeSortAnomaly     = "anomaly" // 异常的在前，按偏离程度从高到低（默认）
+	ProxyOutcomeSortFailureRate = "failure_rate"
+	ProxyOutcomeSortExecutions  = "executions"
+	ProxyOutcomeSortDevices     = "devices"
+	ProxyOutcomeSortActiveTime  = "active_time"
+)
+
+// DeviceProxyID 设备当前使用的代理：优先取设备的代理租约，没有租约时按设备上报的代理IP匹配
+func DeviceProxyID(db *gorm.DB, deviceID string) *uint {
+	var lease models.ProxyLease
+	if err := db.Where("device_id = ?", deviceID).First(&lease).Error; err == nil {
+		return &lease.ProxyID
+	}
+
+	var proxyIP string
+	if err := db.Model(&models.Device{}).Where("device_id = ?", deviceID).
+		Select("proxy_ip").Scan(&proxyIP).Error; err != nil || proxyIP == "" {
+		return nil
+	}
+	var proxy models.Proxy
+	if err := db.Select("id").Where("ip = ?", proxyIP).Order("is_active DESC, id DESC").First(&proxy).Error; err != nil {
+		return nil
+	}
+	return &proxy.ID
+}
+
+// ProxyOutcomes 统计 since 之后每个代理的任务执行结果、租用设备数和租用时长，
+// 并把失败率明显高于其余代理的标记为异常
+func ProxyOutcomes(db *gorm.DB, since time.Time) (*models.ProxyOutcomeReport, error) {
+	now := time.Now()
+	minTasks := settingInt(db, SettingProxyAnomalyMinTasks, defaultProxyAnomalyMinTasks)
+	if minTasks <= 0 {
+		minTasks = defaultProxyAnomalyMinTasks
+	}
+	zThreshold := settingInt(db, SettingProxyAnomalyZScore, defaultProxyAnomalyZScore)
+	if zThreshold <= 0 {
+		zThreshold = defaultProxyAnomalyZScore
+	}
+
+	stats := map[uint]*models.ProxyOutcome{}
+	stat := func(proxyID uint) *models.ProxyOutcome {
+		if stats[proxyID] == nil {
+			stats[proxyID] = &models.ProxyOutcome{ProxyID: proxyID}
+		}
+		return stats[proxyID]
+	}
+
+	// 任务执行结果
+	var executions []struct {
+		ProxyID      uint
+		Executions   int64
+		SuccessCount int64
+		FailureCount int64
+		Tasks        int64
+	}
+	if err := db.Model(&models.TaskLog{}).
+		Select("proxy_id, COUNT(*) AS executions, "+
+			"SUM(CASE WHEN status = 'success' THEN 1 ELSE 0 END) AS success_count, "+
+			"SUM(CASE WHEN status = 'failed' THEN 1 ELSE 0 END) AS failure_count, "+
+			"COUNT(DISTINCT task_id) AS tasks").
+		Where("proxy_id IS NOT NULL AND created_at >= ?", since).
+		Group("proxy_id").Scan(&executions).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range executions {
+		s := stat(row.ProxyID)
+		s.Executions = row.Executions
+		s.SuccessCount = row.SuccessCount
+		s.FailureCount = row.FailureCount
+		s.Tasks = row.Tasks
+	}
+
+	// 分配次数和已释放租约的租用时长，跨越统计起点的租约只计算起点之后的部分
+	var usage []struct {
+		ProxyID       uint
+		Assignments   int64
+		ActiveSeconds int64
+	}
+	if err := db.Model(&models.ProxyUsageLog{}).
+		Select("proxy_id, SUM(CASE WHEN assigned_at >= ? THEN 1 ELSE 0 END) AS assignments, "+
+			"COALESCE(SUM(CASE WHEN released_at IS NOT NULL "+
+			"THEN TIMESTAMPDIFF(SECOND, GREATEST(assigned_at, ?), released_at) ELSE 0 END), 0) AS active_seconds", since, since).
+		Where("assigned_at >= ? OR released_at >= ?", since, since).
+		Group("proxy_id").Scan(&usage).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range usage {
+		s := stat(row.ProxyID)
+		s.Assignments = row.Assignments
+		s.ActiveSeconds = row.ActiveSeconds
+	}
+
+	// 租用中的租约计算到现在
+	var leases []models.ProxyLease
+	if err := db.Select("proxy_id, device_id, created_at").Find(&leases).Error; err != nil {
+		return nil, err
+	}
+	for _, lease := range leases {
+		start := lease.CreatedAt
+		if start.Before(since) {
+			start = since
+		}
+		stat(lease.ProxyID).ActiveSeconds += int64(now.Sub(start).Seconds())
+	}
+
+	// 租用过的设备：统计周期内分配或释放过的，加上租用中的
+	var pairs []struct {
+		ProxyID  uint
+		DeviceID string
+	}
+	if err := db.Model(&models.ProxyUsageLog{}).Distinct("proxy_id", "device_id").
+		Where("assigned_at >= ? OR released_at >= ?", since, since).
+		Scan(&pairs).Error; err != nil {
+		return nil, err
+	}
+	devices := map[uint]map[string]bool{}
+	addDevice := func(proxyID uint, deviceID string) {
+		if devices[proxyID] == nil {
+			devices[proxyID] = map[string]bool{}
+		}
+		devices[proxyID][deviceID] = true
+	}
+	for _, pair := range pairs {
+		addDevice(pair.ProxyID, pair.DeviceID)
+	}
+	for _, lease := range leases {
+		addDevice(lease.ProxyID, lease.DeviceID)
+	}
+	for proxyID, set := range devices {
+		stat(proxyID).Devices = int64(len(set))
+	}
+
+	// 代理信息，已删除的代理不再统计
+	ids := make([]uint, 0, len(stats))
+	for id := range stats {
+		ids = append(ids, id)
+	}
+	var proxies []models.Proxy
+	if len(ids) > 0 {
+		if err := db.Omit("username", "password").Where("id IN ?", ids).Find(&proxies).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	report := &models.ProxyOutcomeReport{
+		Since:         since,
+		MinExecutions: minTasks,
+		AnomalyZScore: float64(zThreshold),
+		Proxies:       make([]models.ProxyOutcome, 0, len(proxies)),
+	}
+	var totalFailures int64
+	for i := range proxies {
+		s := stats[proxies[i].ID]
+		s.IP = proxies[i].IP
+		s.Port = proxies[i].Port
+		s.Protocol = ProxyProtocol(&proxies[i])
+		s.Province = proxies[i].Province
+		s.City = proxies[i].City
+		s.ISP = proxies[i].ISP
+		s.GroupName = proxies[i].GroupName
+		s.IsActive = proxies[i].IsActive
+		s.HealthStatus = proxies[i].HealthStatus
+		if s.Executions > 0 {
+			s.FailureRate = float64(s.FailureCount) / float64(s.Executions)
+		}
+		report.Executions += s.Executions
+		totalFailures += s.FailureCount
+		report.Proxies = append(report.Proxies, *s)
+	}
+	if report.Executions > 0 {
+		report.FailureRate = float64(totalFailures) / float64(report.Executions)
+	}
+
+	for i := range report.Proxies {
+		s := &report.Proxies[i]
+		s.ZScore = failureZScore(s.FailureCount, s.Executions, totalFailures, report.Executions)
+		if s.Executions >= int64(minTasks) && s.ZScore >= float64(zThreshold) {
+			s.Anomalous = true
+			report.AnomalousCount++
+		}
+	}
+	return report, nil
+}
+
+// failureZScore 代理失败率相对其余代理失败率的偏离程度（以其余代理失败率下的二项分布标准差为单位）。
+// 与其余代理比较，避免执行次数多的代理拉高整体失败率而掩盖自身的异常
+func failureZScore(failures, executions, totalFailures, totalExecutions int64) float64 {
+	restExecutions := totalExecutions - executions
+	if executions == 0 || restExecutions == 0 {
+		return 0
+	}
+	rate := float64(failures) / float64(executions)
+	restRate := float64(totalFailures-failures) / float64(restExecutions)
+	// 其余代理从不失败（或全部失败）时标准差为0，用半次失败平滑
+	if restRate == 0 {
+		restRate = 0.5 / float64(restExecutions)
+	} else if restRate == 1 {
+		restRate = 1 - 0.5/float64(restExecutions)
+	}
+	stddev := math.Sqrt(restRate * (1 - restRate) / float64(executions))
+	return math.Round((rate-restRate)/stddev*100) / 100
+}
+
+// SortProxyOutcomes 按指定方式排序，排序方式无效时按异常程度排序
+func SortProxyOutcomes(outcomes []models.ProxyOutcome, by string) {
+	less := func(a, b *models.ProxyOutcome) bool {
+		if a.Anomalous != b.Anomalous {
+			return a.Anomalous
+		}
+		return a.ZScore > b.ZScore
+	}
+	switch by {
+	case ProxyOutcomeSortFailureRate:
+		less = func(a, b *models.ProxyOutcome) bool { return a.FailureRate > b.FailureRate }
+	case ProxyOutcomeSortExecutions:
+		less = func(a, b *models.ProxyOutcome) bool { return a.Executions > b.Executions }
+	case ProxyOutcomeSortDevices:
+		less = func(a, b *models.ProxyOutcome) bool { return a.Devices > b.Devices }
+	case ProxyOutcomeSortActiveTime:
+		less = func(a, b *models.ProxyOutcome) bool { return a.ActiveSeconds > b.ActiveSeconds }
+	}
+	sort.SliceStable(outcomes, func(i, j int) bool {
+		if less(&outcomes[i], &outcomes[j]) {
+			return true
+		}
+		if less(&outcomes[j], &outcomes[i]) {
+			return false
+		}
+		return outcomes[i].ProxyID < outcomes[j].ProxyID
+	})
+}
@@ -0,0 +1,230 @@
+package services
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/pkg/utils"
+)
+
+// 代理配置格式
+const (
+	ProxyConfigClash = "clash"
+	ProxyConfigV2ray = "v2ray"
+)
+
+// 配置下载链接的访问结果
+const (
+	ProxyConfigAccessOK       = "ok"
+	ProxyConfigAccessInvalid  = "invalid"
+	ProxyConfigAccessExpired  = "expired"
+	ProxyConfigAccessNotFound = "not_found"
+)
+
+// SettingProxyConfigLinkMinutes 配置下载链接的有效期（分钟），默认1440（一天）
+const SettingProxyConfigLinkMinutes = "proxy_config_link_minutes"
+
+const (
+	defaultProxyConfigLinkMinutes = 24 * 60
+	proxyConfigSignatureSize      = 16
+)
+
+var (
+	ErrProxyConfigTokenInvalid = errors.New("配置链接无效")
+	ErrProxyConfigTokenExpired = errors.New("配置链接已过期")
+)
+
+// ProxyConfigToken 配置下载链接中的令牌内容
+type ProxyConfigToken struct {
+	ProxyID   uint
+	Format    string
+	KeyID     string
+	ExpiresAt time.Time
+}
+
+// ProxyConfigLinkTTL 读取配置下载链接的有效期
+func ProxyConfigLinkTTL(db *gorm.DB) time.Duration {
+	minutes := settingInt(db, SettingProxyConfigLinkMinutes, defaultProxyConfigLinkMinutes)
+	if minutes <= 0 {
+		minutes = defaultProxyConfigLinkMinutes
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// IssueProxyConfigToken 生成配置下载令牌：密钥ID.base64(代理ID.格式.到期时间).base64(签名)
+// 签名使用当前凭据密钥派生的签名密钥并覆盖密钥ID，密钥轮换后旧令牌在旧密钥移除前仍然有效
+func IssueProxyConfigToken(proxyID uint, format string, expiresAt time.Time) string {
+	keyID := utils.CurrentSigningKeyID()
+	payload := fmt.Sprintf("%d.%s.%d", proxyID, format, expiresAt.Unix())
+	signature, _ := proxyConfigSignature(keyID, payload)
+	return keyID + "." + base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func proxyConfigSignature(keyID, payload string) ([]byte, bool) {
+	signature, ok := utils.CredentialSignature(keyID, []byte(keyID+"."+payload))
+	if !ok {
+		return nil, false
+	}
+	return signature[:proxyConfigSignatureSize], true
+}
+
+// ParseProxyConfigToken 校验并解析配置下载令牌，签名错误时返回 ErrProxyConfigTokenInvalid，过期时返回 ErrProxyConfigTokenExpired（同时返回令牌内容）
+func ParseProxyConfigToken(token string) (*ProxyConfigToken, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrProxyConfigTokenInvalid
+	}
+	keyID := parts[0]
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrProxyConfigTokenInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != proxyConfigSignatureSize {
+		return nil, ErrProxyConfigTokenInvalid
+	}
+	expected, ok := proxyConfigSignature(keyID, string(payload))
+	if !ok || !hmac.Equal(expected, signature) {
+		return nil, ErrProxyConfigTokenInvalid
+	}
+
+	fields := strings.Split(string(payload), ".")
+	if len(fields) != 3 {
+		return nil, ErrProxyConfigTokenInvalid
+	}
+	id, err := strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return nil, ErrProxyConfigTokenInvalid
+	}
+	expires, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return nil, ErrProxyConfigTokenInvalid
+	}
+
+	parsed := &ProxyConfigToken{
+		ProxyID:   uint(id),
+		Format:    fields[1],
+		KeyID:     keyID,
+		ExpiresAt: time.Unix(expires, 0),
+	}
+	if !parsed.ExpiresAt.After(time.Now()) {
+		return parsed, ErrProxyConfigTokenExpired
+	}
+	return parsed, nil
+}
+
+// RecordProxyConfigAccess 记录配置下载链接的访问
+func RecordProxyConfigAccess(db *gorm.DB, token *ProxyConfigToken, result, clientIP, userAgent string) {
+	entry := models.ProxyConfigAccessLog{
+		Result:    result,
+		ClientIP:  clientIP,
+		UserAgent: truncateError(userAgent, 255),
+		CreatedAt: time.Now(),
+	}
+	if token != nil {
+		entry.ProxyID = token.ProxyID
+		entry.Format = token.Format
+		entry.KeyID = token.KeyID
+	}
+	if err := db.Create(&entry).Error; err != nil {
+		log.Printf("记录代理配置访问失败: %v", err)
+	}
+}
+
+// SecureProxyCredentials 启动时保护代理凭据：删除旧版本保存分享链接（含明文账号密码）的 qr_code_url 列，
+// 并把未加密或使用旧密钥加密的账号密码用当前密钥重新加密
+func SecureProxyCredentials(db *gorm.DB) {
+	if db.Migrator().HasColumn(&models.Proxy{}, "qr_code_url") {
+		if err := db.Migrator().DropColumn(&models.Proxy{}, "qr_code_url"); err != nil {
+			log.Printf("删除代理 qr_code_url 列失败: %v", err)
+		}
+	}
+
+	if !utils.CredentialEncryptionEnabled() {
+		log.Printf("⚠ 未配置 %s，代理账号密码以明文保存，配置下载链接在重启后失效", utils.CredentialKeysEnv)
+		return
+	}
+	count, err := ReencryptProxyCredentials(db)
+	if err != nil {
+		log.Printf("重新加密代理凭据失败: %v", err)
+		return
+	}
+	if count > 0 {
+		log.Printf("✓ 已用密钥 %s 重新加密 %d 个代理的凭据", utils.CurrentCredentialKeyID(), count)
+	}
+}
+
+// ReencryptProxyCredentials 把未加密或使用旧密钥加密的代理账号密码用当前密钥重新加密，返回更新的代理数。
+// 直接读写数据库中的原始值，不经过模型的加解密
+func ReencryptProxyCredentials(db *gorm.DB) (int, error) {
+	currentKey := utils.CurrentCredentialKeyID()
+	if currentKey == "" {
+		return 0, nil
+	}
+
+	type rawCredential struct {
+		ID       uint
+		Username string
+		Password string
+	}
+	var rows []rawCredential
+	if err := db.Table("proxies").Select("id, username, password").Find(&rows).Error; err != nil {
+		return 0, err
+	}
+
+	updated := 0
+	for _, row := range rows {
+		updates := map[string]interface{}{}
+		for column, value := range map[string]string{"username": row.Username, "password": row.Password} {
+			if value == "" || utils.CredentialKeyID(value) == currentKey {
+				continue
+			}
+			plain, err := utils.DecryptCredential(value)
+			if err != nil {
+				return updated, fmt.Errorf("代理 %d: %w", row.ID, err)
+			}
+			encrypted, err := utils.EncryptCredential(plain)
+			if err != nil {
+				return updated, err
+			}
+			updates[column] = encrypted
+		}
+		if len(updates) == 0 {
+			continue
+		}
+		// 条件更新，期间被修改过的代理已经用当前密钥保存，不再覆盖
+		result := db.Table("proxies").
+			Where("id = ? AND username = ? AND password = ?", row.ID, row.Username, row.Password).
+			UpdateColumns(updates)
+		if result.Error != nil {
+			return updated, result.Error
+		}
+		if result.RowsAffected > 0 {
+			updated++
+		}
+	}
+	return updated, nil
+}
+
+// EncryptedProxyCredentials 加密后的账号密码，用于不经过模型的 map 更新
+func EncryptedProxyCredentials(username, password string) (string, string, error) {
+	encUsername, err := utils.EncryptCredential(username)
+	if err != nil {
+		return "", "", err
+	}
+	encPassword, err := utils.EncryptCredential(password)
+	if err != nil {
+		return "", "", err
+	}
+	return encUsername, encPassword, nil
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"log"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// MergeDuplicateProxies 在建立 IP+端口唯一索引前合并重复的代理，需在 AutoMigrate 之前调用
+func MergeDuplicateProxies(db *gorm.DB) {
+	if !db.Migrator().HasTable(&models.Proxy{}) {
+		return
+	}
+	merged, err := mergeDuplicateProxies(db)
+	if err != nil {
+		log.Printf("合并重复代理失败: %v", err)
+		return
+	}
+	if merged > 0 {
+		log.Printf("✓ 已合并 %d 个 IP+端口重复的代理", merged)
+	}
+}
+
+// proxyRefTables 通过 proxy_id 引用代理的表，合并时改为引用保留的代理
+var proxyRefTables = []interface{}{
+	&models.ProxyLease{},
+	&models.ProxyUsageLog{},
+	&models.ProxyConfigAccessLog{},
+	&models.TaskLog{},
+}
+
+// mergeDuplicateProxies 每组 IP+端口相同的代理保留一个（优先启用中的，其次最早创建的），
+// 其余代理的使用次数、租约和记录并入保留的代理后删除，返回删除的代理数
+func mergeDuplicateProxies(db *gorm.DB) (int, error) {
+	type duplicate struct {
+		IP   string
+		Port int
+	}
+	var groups []duplicate
+	if err := db.Model(&models.Proxy{}).Select("ip, port").
+		Group("ip, port").Having("COUNT(*) > 1").Scan(&groups).Error; err != nil {
+		return 0, err
+	}
+
+	merged := 0
+	for _, group := range groups {
+		// 不读取账号密码，避免旧密钥加密的凭据解密失败
+		var proxies []models.Proxy
+		if err := db.Select("id", "usage_count", "lease_count").
+			Where("ip = ? AND port = ?", group.IP, group.Port).
+			Order("is_active DESC, id ASC").Find(&proxies).Error; err != nil {
+			return merged, err
+		}
+		if len(proxies) < 2 {
+			continue
+		}
+
+		keep := proxies[0]
+		var ids []uint
+		usage, leases := keep.UsageCount, keep.LeaseCount
+		for _, proxy := range proxies[1:] {
+			ids = append(ids, proxy.ID)
+			usage += proxy.UsageCount
+			leases += proxy.LeaseCount
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			for _, table := range proxyRefTables {
+				if !tx.Migrator().HasTable(table) {
+					continue
+				}
+				if err := tx.Model(table).Where("proxy_id IN ?", ids).
+					UpdateColumn("proxy_id", keep.ID).Error; err != nil {
+					return err
+				}
+			}
+			if err := tx.Model(&models.Proxy{}).Where("id = ?", keep.ID).UpdateColumns(map[string]interface{}{
+				"usage_count": usage,
+				"lease_count": leases,
+			}).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&models.Proxy{}, ids).Error
+		})
+		if err != nil {
+			return merged, err
+		}
+		log.Printf("合并重复代理 %s:%d：保留 #%d，删除 %v", group.IP, group.Port, keep.ID, ids)
+		merged += len(ids)
+	}
+	return merged, nil
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"jd-task-platform-go/internal/models"
+)
+
+func TestMergeDuplicateProxies(t *testing.T) {
+	db := newTestDB(t, &models.Proxy{}, &models.ProxyLease{}, &models.ProxyUsageLog{}, &models.ProxyConfigAccessLog{}, &models.TaskLog{})
+	// 模拟建立唯一索引前的旧数据
+	if err := db.Migrator().DropIndex(&models.Proxy{}, "idx_proxies_ip_port"); err != nil {
+		t.Fatalf("删除唯一索引失败: %v", err)
+	}
+
+	inactive := seedProxy(t, db, 1080, "", "")
+	db.Model(&models.Proxy{}).Where("id = ?", inactive.ID).UpdateColumns(map[string]interface{}{"is_active": false, "usage_count": 3})
+	keep := seedProxy(t, db, 1080, "", "")
+	db.Model(&models.Proxy{}).Where("id = ?", keep.ID).UpdateColumns(map[string]interface{}{"usage_count": 2, "lease_count": 1})
+	dup := seedProxy(t, db, 1080, "", "")
+	db.Model(&models.Proxy{}).Where("id = ?", dup.ID).UpdateColumns(map[string]interface{}{"usage_count": 1, "lease_count": 1})
+	other := seedProxy(t, db, 1081, "", "")
+
+	db.Create(&models.ProxyLease{ProxyID: dup.ID, DeviceID: "dev1", ExpiresAt: time.Now().Add(time.Hour)})
+	db.Create(&models.ProxyUsageLog{ProxyID: inactive.ID, DeviceID: "dev2", AssignedAt: time.Now()})
+	db.Create(&models.TaskLog{TaskID: 1, ProxyID: &dup.ID, Status: "completed", CreatedAt: time.Now()})
+
+	merged, err := mergeDuplicateProxies(db)
+	if err != nil || merged != 2 {
+		t.Fatalf("mergeDuplicateProxies = %d, %v", merged, err)
+	}
+
+	var proxies []models.Proxy
+	db.Order("id ASC").Find(&proxies)
+	if len(proxies) != 2 || proxies[0].ID != keep.ID || proxies[1].ID != other.ID {
+		t.Fatalf("合并后的代理 %+v", proxies)
+	}
+	// 优先保留启用中的代理，使用次数和租约数合并
+	if proxies[0].UsageCount != 6 || proxies[0].LeaseCount != 2 || !proxies[0].IsActive {
+		t.Errorf("保留的代理 %+v", proxies[0])
+	}
+
+	var lease models.ProxyLease
+	db.First(&lease)
+	var usage models.ProxyUsageLog
+	db.First(&usage)
+	var taskLog models.TaskLog
+	db.First(&taskLog)
+	if lease.ProxyID != keep.ID || usage.ProxyID != keep.ID || taskLog.ProxyID == nil || *taskLog.ProxyID != keep.ID {
+		t.Errorf("引用未改为保留的代理: lease=%d usage=%d task_log=%v", lease.ProxyID, usage.ProxyID, taskLog.ProxyID)
+	}
+
+	// 合并后可以建立唯一索引
+	if err := db.Migrator().CreateIndex(&models.Proxy{}, "idx_proxies_ip_port"); err != nil {
+		t.Errorf("建立唯一索引失败: %v", err)
+	}
+	if err := db.Create(&models.Proxy{IP: "127.0.0.1", Port: 1080}).Error; err == nil {
+		t.Error("唯一索引应拒绝重复的 IP+端口")
+	}
+}
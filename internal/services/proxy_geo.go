@@ -0,0 +1,194 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// 代理分配的选择依据，记录在租约和使用记录中
+const (
+	ProxyMatchRequest        = "request"         // 请求指定的地区/运营商
+	ProxyMatchTaskType       = "task_type"       // 任务类型的代理要求
+	ProxyMatchDeviceCity     = "device_city"     // 设备所在城市
+	ProxyMatchDeviceProvince = "device_province" // 设备所在省份
+	ProxyMatchAny            = "any"             // 无匹配，按使用次数分配
+)
+
+// ProxyTarget 一级代理地区匹配条件，空字段表示不限
+type ProxyTarget struct {
+	Province  string
+	City      string
+	ISP       string
+	MatchType string
+	Reason    string
+}
+
+// Apply 在代理查询上加上地区条件
+// 省份和城市按前缀匹配（"广东" 匹配 "广东省"），运营商按包含匹配（"电信" 匹配 "中国电信"）
+func (t ProxyTarget) Apply(query *gorm.DB) *gorm.DB {
+	if t.Province != "" {
+		query = query.Where("province LIKE ?", t.Province+"%")
+	}
+	if t.City != "" {
+		query = query.Where("city LIKE ?", t.City+"%")
+	}
+	if t.ISP != "" {
+		query = query.Where("isp LIKE ?", "%"+t.ISP+"%")
+	}
+	return query
+}
+
+// Matches 代理是否满足地区条件，规则与 Apply 相同
+func (t ProxyTarget) Matches(proxy *models.Proxy) bool {
+	return strings.HasPrefix(proxy.Province, t.Province) &&
+		strings.HasPrefix(proxy.City, t.City) &&
+		strings.Contains(proxy.ISP, t.ISP)
+}
+
+func (t ProxyTarget) label() string {
+	parts := make([]string, 0, 3)
+	for _, part := range []string{t.Province, t.City, t.ISP} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func (t ProxyTarget) sameRegion(other ProxyTarget) bool {
+	return t.Province == other.Province && t.City == other.City && t.ISP == other.ISP
+}
+
+// proxyTargets 按优先顺序生成地区匹配条件：
+// 请求指定的省份/城市/运营商（逐级放宽城市、运营商）→ 任务类型的代理要求 → 设备所在城市 → 设备所在省份 → 不限地区。
+// 任务类型设置了必须匹配时，每一级都叠加任务类型的要求，最后一级为任务类型的要求本身，不降级到不限地区，
+// 并返回该要求用于校验设备已有的租约
+func proxyTargets(db *gorm.DB, req models.ProxyAssignRequest) ([]ProxyTarget, *ProxyTarget) {
+	var rule, required *ProxyTarget
+	if req.TaskType != "" {
+		var taskType models.TaskType
+		if err := db.Where("type_code = ?", req.TaskType).First(&taskType).Error; err == nil &&
+			(taskType.ProxyProvince != "" || taskType.ProxyISP != "") {
+			rule = &ProxyTarget{
+				Province:  normalizeRegion(taskType.ProxyProvince),
+				ISP:       normalizeISP(taskType.ProxyISP),
+				MatchType: ProxyMatchTaskType,
+			}
+			rule.Reason = fmt.Sprintf("匹配任务类型 %s 的代理要求：%s", req.TaskType, rule.label())
+			if taskType.ProxyRequired {
+				required = rule
+			}
+		}
+	}
+
+	var targets []ProxyTarget
+	add := func(t ProxyTarget, reason string) {
+		if t.label() == "" && t.MatchType != ProxyMatchAny {
+			return
+		}
+		if required != nil && t.MatchType != ProxyMatchTaskType {
+			if required.Province != "" {
+				t.Province = required.Province
+			}
+			if required.ISP != "" {
+				t.ISP = required.ISP
+			}
+		}
+		for _, existing := range targets {
+			if existing.sameRegion(t) {
+				return
+			}
+		}
+		if t.Reason == "" {
+			t.Reason = reason + t.label()
+			if required != nil && t.MatchType != ProxyMatchTaskType {
+				t.Reason += "（任务类型 " + req.TaskType + " 要求 " + required.label() + "）"
+			}
+		}
+		targets = append(targets, t)
+	}
+
+	// 请求指定的条件，无可用代理时依次放宽城市和运营商
+	province, city, isp := normalizeRegion(req.Province), normalizeRegion(req.City), normalizeISP(req.ISP)
+	add(ProxyTarget{Province: province, City: city, ISP: isp, MatchType: ProxyMatchRequest}, "匹配请求指定的代理地区：")
+	add(ProxyTarget{Province: province, ISP: isp, MatchType: ProxyMatchRequest}, "请求指定的城市无可用代理，匹配：")
+	add(ProxyTarget{Province: province, MatchType: ProxyMatchRequest}, "请求指定的运营商无可用代理，匹配：")
+
+	deviceProvince, deviceCity := deviceRegion(db, req.DeviceID)
+	addDevice := func() {
+		if deviceProvince == "" {
+			return
+		}
+		if deviceCity != "" {
+			add(ProxyTarget{Province: deviceProvince, City: deviceCity, MatchType: ProxyMatchDeviceCity}, "匹配设备所在城市：")
+		}
+		add(ProxyTarget{Province: deviceProvince, MatchType: ProxyMatchDeviceProvince}, "匹配设备所在省份：")
+	}
+
+	// 任务类型强制要求时，先在要求范围内优先设备所在地区；否则任务类型的要求优先于设备地区，并可放宽运营商
+	if required != nil {
+		addDevice()
+		add(*required, "")
+		return targets, required
+	}
+	if rule != nil {
+		add(*rule, "")
+		if rule.Province != "" && rule.ISP != "" {
+			add(ProxyTarget{Province: rule.Province, MatchType: ProxyMatchTaskType}, "任务类型要求的运营商无可用代理，匹配：")
+		}
+	}
+	addDevice()
+
+	reason := "按使用次数分配"
+	if len(targets) > 0 {
+		reason = "没有符合 " + targets[0].label() + " 的可用代理，按使用次数分配"
+	}
+	add(ProxyTarget{MatchType: ProxyMatchAny, Reason: reason}, "")
+	return targets, nil
+}
+
+// deviceRegion 设备的省份和城市，来自设备注册时按IP解析的地理位置（如 "广东 深圳"）
+func deviceRegion(db *gorm.DB, deviceID string) (string, string) {
+	var device models.Device
+	if err := db.Select("location").Where("device_id = ?", deviceID).First(&device).Error; err != nil {
+		return "", ""
+	}
+	if strings.Contains(device.Location, "未知") {
+		return "", ""
+	}
+	fields := strings.Fields(device.Location)
+	switch len(fields) {
+	case 0:
+		return "", ""
+	case 1:
+		return normalizeRegion(fields[0]), ""
+	default:
+		return normalizeRegion(fields[0]), normalizeRegion(fields[1])
+	}
+}
+
+// 省市名称的行政区划后缀，匹配时去掉，"广东省" 与 "广东" 视为相同
+var regionSuffixes = []string{"特别行政区", "维吾尔自治区", "壮族自治区", "回族自治区", "自治区", "省", "市"}
+
+func normalizeRegion(name string) string {
+	name = strings.TrimSpace(name)
+	for _, suffix := range regionSuffixes {
+		if trimmed := strings.TrimSuffix(name, suffix); trimmed != name && trimmed != "" {
+			return trimmed
+		}
+	}
+	return name
+}
+
+// normalizeISP 去掉运营商名称的 "中国" 前缀，"中国电信" 与 "电信" 视为相同
+func normalizeISP(name string) string {
+	name = strings.TrimSpace(name)
+	if trimmed := strings.TrimPrefix(name, "中国"); trimmed != "" {
+		return trimmed
+	}
+	return name
+}
@@ -0,0 +1,338 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// 批量修改代理的操作
+const (
+	ProxyBatchActivate   = "activate"
+	ProxyBatchDeactivate = "deactivate"
+	ProxyBatchSetGroup   = "set_group"
+	ProxyBatchSetTags    = "set_tags"
+	ProxyBatchAddTags    = "add_tags"
+	ProxyBatchRemoveTags = "remove_tags"
+)
+
+var (
+	ErrProxyTagsTooLong  = errors.New("标签总长度不能超过255个字符")
+	ErrProxyBatchNoScope = errors.New("请选择代理或填写筛选条件")
+	ErrNoGroupProxy      = errors.New("代理分组中暂无可用代理")
+)
+
+// proxyTagsMaxLength 标签字段的长度，与 models.Proxy.Tags 一致
+const proxyTagsMaxLength = 255
+
+// SplitProxyList 拆分逗号分隔的标签、设备分组或任务类型
+func SplitProxyList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// joinProxyList 去掉空白和重复项后用逗号连接，元素中的逗号视为分隔符
+func joinProxyList(items []string) string {
+	seen := map[string]bool{}
+	var result []string
+	for _, item := range SplitProxyList(strings.Join(items, ",")) {
+		if !seen[item] {
+			seen[item] = true
+			result = append(result, item)
+		}
+	}
+	return strings.Join(result, ",")
+}
+
+// NormalizeProxyTags 规范化标签列表，返回逗号分隔的标签
+func NormalizeProxyTags(tags []string) (string, error) {
+	joined := joinProxyList(tags)
+	if len(joined) > proxyTagsMaxLength {
+		return "", ErrProxyTagsTooLong
+	}
+	return joined, nil
+}
+
+// ApplyProxyFilter 在代理查询上加上筛选条件
+func ApplyProxyFilter(query *gorm.DB, f models.ProxyFilter) *gorm.DB {
+	if f.IsActive != nil {
+		query = query.Where("is_active = ?", *f.IsActive)
+	}
+	if f.HealthStatus != "" {
+		query = query.Where("health_status = ?", f.HealthStatus)
+	}
+	if f.Protocol != "" {
+		query = query.Where("protocol = ?", f.Protocol)
+	}
+	if f.Keyword != "" {
+		query = query.Where("(ip LIKE ? OR remark LIKE ?)", "%"+f.Keyword+"%", "%"+f.Keyword+"%")
+	}
+	query = ProxyTarget{Province: normalizeRegion(f.Province), ISP: normalizeISP(f.ISP)}.Apply(query)
+	if f.GroupName != "" {
+		query = query.Where("group_name = ?", f.GroupName)
+	}
+	if f.Tag != "" {
+		query = query.Where("FIND_IN_SET(?, tags) > 0", f.Tag)
+	}
+	if f.SupplierID != nil {
+		query = query.Where("supplier_id = ?", *f.SupplierID)
+	}
+	return query
+}
+
+// BatchUpdateProxies 批量启用、停用、修改分组或标签，ids 和筛选条件同时提供时取交集，返回修改的代理数
+func BatchUpdateProxies(db *gorm.DB, req models.ProxyBatchUpdateRequest) (int64, error) {
+	if len(req.IDs) == 0 && req.Filter.IsEmpty() {
+		return 0, ErrProxyBatchNoScope
+	}
+	scope := func() *gorm.DB {
+		query := ApplyProxyFilter(db.Model(&models.Proxy{}), req.Filter)
+		if len(req.IDs) > 0 {
+			query = query.Where("id IN ?", req.IDs)
+		}
+		return query
+	}
+	now := time.Now()
+
+	switch req.Action {
+	case ProxyBatchActivate, ProxyBatchDeactivate:
+		var ids []uint
+		if err := scope().Pluck("id", &ids).Error; err != nil || len(ids) == 0 {
+			return 0, err
+		}
+		var affected int64
+		err := db.Transaction(func(tx *gorm.DB) error {
+			result := tx.Model(&models.Proxy{}).Where("id IN ?", ids).UpdateColumns(map[string]interface{}{
+				"is_active":  req.Action == ProxyBatchActivate,
+				"updated_at": now,
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			affected = result.RowsAffected
+			// 与单个修改一致，手动启用或停用后解除隔离
+			return tx.Model(&models.Proxy{}).Where("id IN ? AND quarantined_at IS NOT NULL", ids).
+				UpdateColumns(map[string]interface{}{
+					"quarantined_at":       nil,
+					"consecutive_failures": 0,
+					"health_status":        ProxyHealthUnknown,
+				}).Error
+		})
+		return affected, err
+
+	case ProxyBatchSetGroup:
+		result := scope().UpdateColumns(map[string]interface{}{
+			"group_name": strings.TrimSpace(req.GroupName),
+			"updated_at": now,
+		})
+		return result.RowsAffected, result.Error
+
+	case ProxyBatchSetTags:
+		tags, err := NormalizeProxyTags(req.Tags)
+		if err != nil {
+			return 0, err
+		}
+		result := scope().UpdateColumns(map[string]interface{}{"tags": tags, "updated_at": now})
+		return result.RowsAffected, result.Error
+
+	case ProxyBatchAddTags, ProxyBatchRemoveTags:
+		changes := SplitProxyList(strings.Join(req.Tags, ","))
+		if len(changes) == 0 {
+			return 0, nil
+		}
+		var rows []struct {
+			ID   uint
+			Tags string
+		}
+		if err := scope().Select("id, tags").Scan(&rows).Error; err != nil {
+			return 0, err
+		}
+		var affected int64
+		for _, row := range rows {
+			tags, err := editProxyTags(row.Tags, changes, req.Action == ProxyBatchAddTags)
+			if err != nil {
+				return affected, fmt.Errorf("代理 %d: %w", row.ID, err)
+			}
+			if tags == row.Tags {
+				continue
+			}
+			// 条件更新，期间被修改过标签的代理不覆盖
+			result := db.Model(&models.Proxy{}).Where("id = ? AND tags = ?", row.ID, row.Tags).
+				UpdateColumns(map[string]interface{}{"tags": tags, "updated_at": now})
+			if result.Error != nil {
+				return affected, result.Error
+			}
+			affected += result.RowsAffected
+		}
+		return affected, nil
+	}
+	return 0, fmt.Errorf("不支持的操作: %s", req.Action)
+}
+
+// editProxyTags 在逗号分隔的标签中追加或删除标签
+func editProxyTags(current string, changes []string, add bool) (string, error) {
+	if add {
+		return NormalizeProxyTags(append(SplitProxyList(current), changes...))
+	}
+	remove := map[string]bool{}
+	for _, tag := range changes {
+		remove[tag] = true
+	}
+	var kept []string
+	for _, tag := range SplitProxyList(current) {
+		if !remove[tag] {
+			kept = append(kept, tag)
+		}
+	}
+	return strings.Join(kept, ","), nil
+}
+
+// ProxyGroupFor 分配代理时使用的代理分组：优先取绑定了任务类型的分组，其次取绑定了设备所在设备分组的分组，都没有时返回 nil
+func ProxyGroupFor(db *gorm.DB, deviceID, taskType string) *models.ProxyGroup {
+	var group models.ProxyGroup
+	if taskType != "" &&
+		db.Where("FIND_IN_SET(?, task_types) > 0", taskType).Order("id ASC").First(&group).Error == nil {
+		return &group
+	}
+
+	var deviceGroup string
+	if err := db.Model(&models.Device{}).Where("device_id = ?", deviceID).
+		Select("group_name").Scan(&deviceGroup).Error; err != nil || deviceGroup == "" {
+		return nil
+	}
+	if db.Where("FIND_IN_SET(?, device_groups) > 0", deviceGroup).Order("id ASC").First(&group).Error == nil {
+		return &group
+	}
+	return nil
+}
+
+// ApplyProxyGroupRequest 校验并写入代理分组配置：名称不能重复，同一设备分组或任务类型只能绑定一个代理分组
+func ApplyProxyGroupRequest(db *gorm.DB, group *models.ProxyGroup, req models.ProxyGroupRequest) error {
+	name := strings.TrimSpace(req.Name)
+	if name == "" {
+		return errors.New("分组名称不能为空")
+	}
+	deviceGroups := joinProxyList(req.DeviceGroups)
+	taskTypes := joinProxyList(req.TaskTypes)
+	if len(deviceGroups) > 500 || len(taskTypes) > 500 {
+		return errors.New("绑定的设备分组或任务类型总长度不能超过500个字符")
+	}
+
+	var others []models.ProxyGroup
+	if err := db.Where("id <> ?", group.ID).Find(&others).Error; err != nil {
+		return err
+	}
+	for _, other := range others {
+		if other.Name == name {
+			return fmt.Errorf("分组 %s 已存在", name)
+		}
+		for _, item := range SplitProxyList(deviceGroups) {
+			if containsItem(SplitProxyList(other.DeviceGroups), item) {
+				return fmt.Errorf("设备分组 %s 已绑定代理分组 %s", item, other.Name)
+			}
+		}
+		for _, item := range SplitProxyList(taskTypes) {
+			if containsItem(SplitProxyList(other.TaskTypes), item) {
+				return fmt.Errorf("任务类型 %s 已绑定代理分组 %s", item, other.Name)
+			}
+		}
+	}
+
+	group.Name = name
+	group.DeviceGroups = deviceGroups
+	group.TaskTypes = taskTypes
+	group.Remark = strings.TrimSpace(req.Remark)
+	group.UpdatedAt = time.Now()
+	return nil
+}
+
+func containsItem(items []string, item string) bool {
+	for _, existing := range items {
+		if existing == item {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyGroupStats 按分组统计代理数、健康和租用情况，以及最近7天的任务失败率。
+// 包括有绑定配置的分组和代理上出现的分组，未分组的代理汇总在名称为空的一行
+func ProxyGroupStats(db *gorm.DB) ([]models.ProxyGroupStat, error) {
+	var groups []models.ProxyGroup
+	if err := db.Order("name ASC").Find(&groups).Error; err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		GroupName    string
+		TotalCount   int64
+		ActiveCount  int64
+		HealthyCount int64
+		LeaseCount   int64
+		UsageCount   int64
+	}
+	if err := db.Model(&models.Proxy{}).
+		Select("group_name, COUNT(*) AS total_count, "+
+			"SUM(CASE WHEN is_active THEN 1 ELSE 0 END) AS active_count, "+
+			"SUM(CASE WHEN health_status = ? THEN 1 ELSE 0 END) AS healthy_count, "+
+			"COALESCE(SUM(lease_count), 0) AS lease_count, COALESCE(SUM(usage_count), 0) AS usage_count", ProxyHealthHealthy).
+		Group("group_name").Order("group_name ASC").Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make([]models.ProxyGroupStat, 0, len(groups)+len(counts))
+	index := map[string]int{}
+	stat := func(name string) *models.ProxyGroupStat {
+		if i, ok := index[name]; ok {
+			return &stats[i]
+		}
+		index[name] = len(stats)
+		stats = append(stats, models.ProxyGroupStat{Name: name})
+		return &stats[len(stats)-1]
+	}
+	for _, group := range groups {
+		s := stat(group.Name)
+		s.ID = group.ID
+		s.DeviceGroups = group.DeviceGroups
+		s.TaskTypes = group.TaskTypes
+		s.Remark = group.Remark
+	}
+	for _, row := range counts {
+		s := stat(row.GroupName)
+		s.TotalCount = row.TotalCount
+		s.ActiveCount = row.ActiveCount
+		s.HealthyCount = row.HealthyCount
+		s.LeaseCount = row.LeaseCount
+		s.UsageCount = row.UsageCount
+	}
+
+	report, err := ProxyOutcomes(db, time.Now().AddDate(0, 0, -7))
+	if err != nil {
+		return nil, err
+	}
+	failures := map[string]int64{}
+	for _, outcome := range report.Proxies {
+		s := stat(outcome.GroupName)
+		s.Executions += outcome.Executions
+		failures[outcome.GroupName] += outcome.FailureCount
+		if outcome.Anomalous {
+			s.AnomalousCount++
+		}
+	}
+	for i := range stats {
+		if stats[i].Executions > 0 {
+			stats[i].FailureRate = float64(failures[stats[i].Name]) / float64(stats[i].Executions)
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,465 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/pkg/utils"
+)
+
+// 代理健康状态
+const (
+	ProxyHealthUnknown     = "unknown"
+	ProxyHealthHealthy     = "healthy"
+	ProxyHealthUnhealthy   = "unhealthy"
+	ProxyHealthQuarantined = "quarantined"
+)
+
+// SettingProxyCheckURL 健康检查时通过代理访问的地址，未配置时 SOCKS5 代理只检查握手和认证，HTTP/HTTPS 代理只检查连接
+const SettingProxyCheckURL = "proxy_check_url"
+
+// 健康检查策略：启用的代理每5分钟检查一次，连续失败3次自动停用（隔离），
+// 隔离中的代理每30分钟复查一次，成功后自动恢复启用
+const (
+	ProxyFailureThreshold   = 3
+	proxyCheckInterval      = 5 * time.Minute
+	proxyQuarantineInterval = 30 * time.Minute
+	proxyCheckTimeout       = 10 * time.Second
+	proxyCheckConcurrency   = 10
+	proxyCheckErrorSize     = 255
+)
+
+// ProxyHealthService 代理健康检查服务
+type ProxyHealthService struct {
+	db       *gorm.DB
+	stopChan chan struct{}
+}
+
+// NewProxyHealthService 创建代理健康检查服务
+func NewProxyHealthService(db *gorm.DB) *ProxyHealthService {
+	return &ProxyHealthService{
+		db:       db,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start 启动代理健康检查服务
+func (s *ProxyHealthService) Start() {
+	log.Printf("✓ 代理健康检查服务已启动（每%v检查一次，连续失败%d次自动停用）", proxyCheckInterval, ProxyFailureThreshold)
+	go s.run()
+}
+
+// Stop 停止代理健康检查服务
+func (s *ProxyHealthService) Stop() {
+	close(s.stopChan)
+	log.Println("代理健康检查服务已停止")
+}
+
+// run 运行检查循环，隔离中的代理按较长的间隔复查
+func (s *ProxyHealthService) run() {
+	s.checkActive()
+
+	ticker := time.NewTicker(proxyCheckInterval)
+	defer ticker.Stop()
+	lastQuarantineCheck := time.Now()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.checkActive()
+			if time.Since(lastQuarantineCheck) >= proxyQuarantineInterval {
+				s.checkQuarantined()
+				lastQuarantineCheck = time.Now()
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// checkActive 检查所有启用的代理
+func (s *ProxyHealthService) checkActive() {
+	var proxies []models.Proxy
+	if err := s.db.Where("is_active = ?", true).Find(&proxies).Error; err != nil {
+		log.Printf("查询代理失败: %v", err)
+		return
+	}
+	s.checkAll(proxies)
+}
+
+// checkQuarantined 复查被自动停用的代理，手动停用的代理不复查
+func (s *ProxyHealthService) checkQuarantined() {
+	var proxies []models.Proxy
+	if err := s.db.Where("is_active = ? AND quarantined_at IS NOT NULL", false).Find(&proxies).Error; err != nil {
+		log.Printf("查询隔离代理失败: %v", err)
+		return
+	}
+	s.checkAll(proxies)
+}
+
+// checkAll 并发检查一批代理
+func (s *ProxyHealthService) checkAll(proxies []models.Proxy) {
+	if len(proxies) == 0 {
+		return
+	}
+
+	checkURL := ProxyCheckURL(s.db)
+	sem := make(chan struct{}, proxyCheckConcurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	quarantined, recovered := 0, 0
+
+	for i := range proxies {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(proxy *models.Proxy) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			wasActive := proxy.IsActive
+			result, err := CheckAndRecordProxy(s.db, proxy, checkURL)
+			if err != nil {
+				log.Printf("记录代理检查结果失败 (proxy_id=%d): %v", proxy.ID, err)
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if wasActive && !result.IsActive {
+				quarantined++
+			} else if !wasActive && result.IsActive {
+				recovered++
+			}
+		}(&proxies[i])
+	}
+	wg.Wait()
+
+	if quarantined > 0 || recovered > 0 {
+		log.Printf("代理健康检查完成：检查 %d 个，自动停用 %d 个，恢复启用 %d 个", len(proxies), quarantined, recovered)
+	}
+}
+
+// ProxyCheckURL 读取健康检查访问地址的配置
+func ProxyCheckURL(db *gorm.DB) string {
+	var setting models.Setting
+	if err := db.Where("param_key = ?", SettingProxyCheckURL).First(&setting).Error; err != nil {
+		return ""
+	}
+	return setting.ParamValue
+}
+
+// CheckAndRecordProxy 检查代理并记录结果
+// 连续失败达到阈值时停用并标记隔离；隔离中的代理检查成功后恢复启用
+func CheckAndRecordProxy(db *gorm.DB, proxy *models.Proxy, checkURL string) (*models.ProxyCheckResult, error) {
+	latency, checkErr := CheckProxy(proxy, checkURL)
+	now := time.Now()
+
+	updates := map[string]interface{}{
+		"check_count":     gorm.Expr("check_count + 1"),
+		"last_checked_at": now,
+	}
+	if checkErr == nil {
+		proxy.ConsecutiveFailures = 0
+		proxy.HealthStatus = ProxyHealthHealthy
+		proxy.LatencyMs = int(latency.Milliseconds())
+		proxy.LastCheckError = ""
+		updates["success_count"] = gorm.Expr("success_count + 1")
+		updates["latency_ms"] = proxy.LatencyMs
+		if proxy.QuarantinedAt != nil {
+			proxy.IsActive = true
+			proxy.QuarantinedAt = nil
+			updates["is_active"] = true
+			updates["quarantined_at"] = nil
+		}
+	} else {
+		proxy.ConsecutiveFailures++
+		proxy.LastCheckError = truncateError(checkErr.Error(), proxyCheckErrorSize)
+		proxy.HealthStatus = ProxyHealthUnhealthy
+		if proxy.QuarantinedAt != nil {
+			proxy.HealthStatus = ProxyHealthQuarantined
+		} else if proxy.IsActive && proxy.ConsecutiveFailures >= ProxyFailureThreshold {
+			proxy.IsActive = false
+			proxy.QuarantinedAt = &now
+			proxy.HealthStatus = ProxyHealthQuarantined
+			updates["is_active"] = false
+			updates["quarantined_at"] = now
+		}
+	}
+	updates["consecutive_failures"] = proxy.ConsecutiveFailures
+	updates["health_status"] = proxy.HealthStatus
+	updates["last_check_error"] = proxy.LastCheckError
+
+	// 只更新检查相关字段，不覆盖并发分配时累加的使用次数
+	if err := db.Model(&models.Proxy{}).Where("id = ?", proxy.ID).UpdateColumns(updates).Error; err != nil {
+		return nil, err
+	}
+	proxy.CheckCount++
+	if checkErr == nil {
+		proxy.SuccessCount++
+	}
+	proxy.LastCheckedAt = &now
+
+	result := &models.ProxyCheckResult{
+		ProxyID:      proxy.ID,
+		OK:           checkErr == nil,
+		LatencyMs:    int(latency.Milliseconds()),
+		HealthStatus: proxy.HealthStatus,
+		IsActive:     proxy.IsActive,
+	}
+	if checkErr != nil {
+		result.Error = proxy.LastCheckError
+	}
+	return result, nil
+}
+
+// CheckProxy 检查代理是否可用，返回耗时
+// SOCKS5 代理完成握手和认证，HTTP 代理建立连接，HTTPS 代理完成 TLS 握手；
+// 配置了 checkURL 时再通过代理访问该地址，要求返回 2xx/3xx（HTTP/HTTPS 代理的认证在这一步校验）
+func CheckProxy(proxy *models.Proxy, checkURL string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), proxyCheckTimeout)
+	defer cancel()
+	start := time.Now()
+	isHTTP := proxy.Protocol == utils.ProxyProtocolHTTP || proxy.Protocol == utils.ProxyProtocolHTTPS
+
+	if checkURL == "" {
+		var conn net.Conn
+		var err error
+		if isHTTP {
+			conn, err = dialHTTPProxy(ctx, proxy)
+		} else {
+			conn, err = dialSOCKS5(ctx, proxy, "")
+		}
+		if err != nil {
+			return 0, err
+		}
+		conn.Close()
+		return time.Since(start), nil
+	}
+
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dialSOCKS5(ctx, proxy, addr)
+		},
+		DisableKeepAlives: true,
+	}
+	if isHTTP {
+		proxyURL := &url.URL{Scheme: proxy.Protocol, Host: net.JoinHostPort(proxy.IP, strconv.Itoa(proxy.Port))}
+		if proxy.Username != "" {
+			proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+		}
+		transport = &http.Transport{
+			Proxy:             http.ProxyURL(proxyURL),
+			TLSClientConfig:   proxyCheckTLSConfig,
+			DisableKeepAlives: true,
+		}
+	}
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkURL, nil)
+	if err != nil {
+		return 0, fmt.Errorf("检查地址无效: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 400 {
+		return 0, fmt.Errorf("通过代理访问检查地址返回 %d", resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+// proxyCheckTLSConfig 检查 HTTPS 代理时不校验证书：代理按 IP 连接，证书通常签发给域名，健康检查只关心代理是否可用
+var proxyCheckTLSConfig = &tls.Config{InsecureSkipVerify: true}
+
+// dialHTTPProxy 连接 HTTP 代理，HTTPS 代理再完成 TLS 握手
+func dialHTTPProxy(ctx context.Context, proxy *models.Proxy) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(proxy.IP, strconv.Itoa(proxy.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("连接代理失败: %w", err)
+	}
+	if proxy.Protocol != utils.ProxyProtocolHTTPS {
+		return conn, nil
+	}
+
+	tlsConn := tls.Client(conn, proxyCheckTLSConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("代理 TLS 握手失败: %w", err)
+	}
+	return tlsConn, nil
+}
+
+// SOCKS5 协议常量（RFC 1928 / RFC 1929）
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+	socks5CmdConnect   = 0x01
+	socks5AddrIPv4     = 0x01
+	socks5AddrDomain   = 0x03
+	socks5AddrIPv6     = 0x04
+)
+
+// dialSOCKS5 连接代理并完成握手和认证；target 不为空时再请求代理连接 target（host:port）
+func dialSOCKS5(ctx context.Context, proxy *models.Proxy, target string) (net.Conn, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(proxy.IP, strconv.Itoa(proxy.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("连接代理失败: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := socks5Handshake(conn, proxy.Username, proxy.Password, target); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+func socks5Handshake(conn net.Conn, username, password, target string) error {
+	methods := []byte{socks5AuthNone}
+	if username != "" {
+		methods = []byte{socks5AuthPassword, socks5AuthNone}
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return fmt.Errorf("SOCKS5 握手失败: %w", err)
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("SOCKS5 握手失败: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return errors.New("SOCKS5 握手失败: 不是 SOCKS5 代理")
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if len(username) > 255 || len(password) > 255 {
+			return errors.New("SOCKS5 认证失败: 用户名或密码过长")
+		}
+		req := []byte{0x01, byte(len(username))}
+		req = append(req, username...)
+		req = append(req, byte(len(password)))
+		req = append(req, password...)
+		if _, err := conn.Write(req); err != nil {
+			return fmt.Errorf("SOCKS5 认证失败: %w", err)
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return fmt.Errorf("SOCKS5 认证失败: %w", err)
+		}
+		if reply[1] != 0x00 {
+			return errors.New("SOCKS5 认证失败: 用户名或密码错误")
+		}
+	case socks5AuthNoAccept:
+		return errors.New("SOCKS5 握手失败: 代理不接受认证方式")
+	default:
+		return fmt.Errorf("SOCKS5 握手失败: 不支持的认证方式 %d", reply[1])
+	}
+
+	if target == "" {
+		return nil
+	}
+	return socks5Connect(conn, target)
+}
+
+// socks5Connect 发送 CONNECT 请求并读取应答
+func socks5Connect(conn net.Conn, target string) error {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("无效端口: %s", portStr)
+	}
+
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			req = append(req, socks5AddrIPv4)
+			req = append(req, ip4...)
+		} else {
+			req = append(req, socks5AddrIPv6)
+			req = append(req, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("目标域名过长")
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return fmt.Errorf("SOCKS5 连接请求失败: %w", err)
+	}
+
+	head := make([]byte, 4)
+	if _, err := io.ReadFull(conn, head); err != nil {
+		return fmt.Errorf("SOCKS5 连接请求失败: %w", err)
+	}
+	if head[1] != 0x00 {
+		return fmt.Errorf("SOCKS5 代理无法连接目标地址（应答码 %d）", head[1])
+	}
+
+	// 读取并丢弃代理绑定的地址
+	var addrLen int
+	switch head[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return fmt.Errorf("SOCKS5 连接请求失败: %w", err)
+		}
+		addrLen = int(n[0])
+	default:
+		return fmt.Errorf("SOCKS5 应答地址类型无效 %d", head[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, addrLen+2)); err != nil {
+		return fmt.Errorf("SOCKS5 连接请求失败: %w", err)
+	}
+	return nil
+}
+
+// truncateError 按字符截断错误信息，避免超出字段长度
+func truncateError(msg string, size int) string {
+	runes := []rune(msg)
+	if len(runes) <= size {
+		return msg
+	}
+	return string(runes[:size])
+}
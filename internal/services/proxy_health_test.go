@@ -0,0 +1,264 @@
+package services
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// socks5Stub 本地 SOCKS5 测试服务，支持用户名密码认证和 CONNECT
+type socks5Stub struct {
+	ln net.Listener
+
+	mu       sync.Mutex
+	username string
+	password string
+}
+
+func newSOCKS5Stub(t *testing.T, username, password string) *socks5Stub {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	s := &socks5Stub{ln: ln, username: username, password: password}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+// setPassword 修改服务端密码，用于模拟代理恢复
+func (s *socks5Stub) setPassword(password string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.password = password
+}
+
+func (s *socks5Stub) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *socks5Stub) serve(conn net.Conn) {
+	defer conn.Close()
+	s.mu.Lock()
+	username, password := s.username, s.password
+	s.mu.Unlock()
+
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(conn, head); err != nil || head[0] != socks5Version {
+		return
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return
+	}
+	method := byte(socks5AuthNone)
+	if username != "" {
+		method = socks5AuthNoAccept
+		for _, m := range methods {
+			if m == socks5AuthPassword {
+				method = socks5AuthPassword
+			}
+		}
+	}
+	conn.Write([]byte{socks5Version, method})
+
+	switch method {
+	case socks5AuthNoAccept:
+		return
+	case socks5AuthPassword:
+		if !readSOCKS5Auth(conn, username, password) {
+			conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		conn.Write([]byte{0x01, 0x00})
+	}
+
+	// CONNECT 请求：只支持 IPv4 和域名
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case socks5AddrIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return
+		}
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(conn, name); err != nil {
+			return
+		}
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	if err != nil {
+		conn.Write([]byte{socks5Version, 0x05, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{socks5Version, 0x00, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+// readSOCKS5Auth 读取用户名密码认证请求并校验
+func readSOCKS5Auth(conn net.Conn, username, password string) bool {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return false
+	}
+	user := make([]byte, buf[1])
+	if _, err := io.ReadFull(conn, user); err != nil {
+		return false
+	}
+	if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+		return false
+	}
+	pass := make([]byte, buf[0])
+	if _, err := io.ReadFull(conn, pass); err != nil {
+		return false
+	}
+	return string(user) == username && string(pass) == password
+}
+
+func seedProxy(t *testing.T, db *gorm.DB, port int, username, password string) *models.Proxy {
+	t.Helper()
+	proxy := models.Proxy{IP: "127.0.0.1", Port: port, Protocol: "socks5", Username: username, Password: password, IsActive: true}
+	if err := db.Create(&proxy).Error; err != nil {
+		t.Fatalf("创建代理失败: %v", err)
+	}
+	return &proxy
+}
+
+func TestCheckProxySOCKS5(t *testing.T) {
+	stub := newSOCKS5Stub(t, "user", "pass")
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer target.Close()
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		port     int
+		password string
+		checkURL string
+		wantErr  string
+	}{
+		{"握手和认证", stub.port(), "pass", "", ""},
+		{"通过代理访问", stub.port(), "pass", target.URL, ""},
+		{"密码错误", stub.port(), "wrong", "", "用户名或密码错误"},
+		{"检查地址返回404", stub.port(), "pass", target.URL + "/missing", "返回 404"},
+		{"代理无法连接", closedPort, "pass", "", "连接代理失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy := &models.Proxy{IP: "127.0.0.1", Port: tt.port, Protocol: "socks5", Username: "user", Password: tt.password}
+			_, err := CheckProxy(proxy, tt.checkURL)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("CheckProxy 失败: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, 期望包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestProxyQuarantineAndRecovery(t *testing.T) {
+	db := newTestDB(t, &models.Proxy{}, &models.Setting{})
+	stub := newSOCKS5Stub(t, "user", "rotated")
+	proxy := seedProxy(t, db, stub.port(), "user", "pass")
+	// 手动停用的代理不参与复查
+	manual := models.Proxy{IP: "127.0.0.2", Port: stub.port(), Protocol: "socks5", Username: "user", Password: "pass", IsActive: true}
+	db.Create(&manual)
+	db.Model(&models.Proxy{}).Where("id = ?", manual.ID).UpdateColumn("is_active", false)
+
+	s := NewProxyHealthService(db)
+	load := func(id uint) models.Proxy {
+		var saved models.Proxy
+		db.First(&saved, id)
+		return saved
+	}
+
+	for i := 1; i < ProxyFailureThreshold; i++ {
+		s.checkActive()
+		if saved := load(proxy.ID); !saved.IsActive || saved.ConsecutiveFailures != i || saved.HealthStatus != ProxyHealthUnhealthy {
+			t.Fatalf("第%d次失败后 %+v", i, saved)
+		}
+	}
+	s.checkActive()
+	saved := load(proxy.ID)
+	if saved.IsActive || saved.QuarantinedAt == nil || saved.HealthStatus != ProxyHealthQuarantined || saved.CheckCount != ProxyFailureThreshold {
+		t.Fatalf("连续失败%d次后应隔离: %+v", ProxyFailureThreshold, saved)
+	}
+
+	// 隔离后不再参与常规检查
+	s.checkActive()
+	if load(proxy.ID).CheckCount != ProxyFailureThreshold {
+		t.Error("隔离的代理不应参与常规检查")
+	}
+
+	// 复查仍失败时保持隔离
+	s.checkQuarantined()
+	if saved := load(proxy.ID); saved.IsActive || saved.HealthStatus != ProxyHealthQuarantined {
+		t.Errorf("复查失败后 %+v", saved)
+	}
+
+	// 代理恢复后复查成功，自动重新启用
+	stub.setPassword("pass")
+	s.checkQuarantined()
+	saved = load(proxy.ID)
+	if !saved.IsActive || saved.QuarantinedAt != nil || saved.HealthStatus != ProxyHealthHealthy || saved.ConsecutiveFailures != 0 {
+		t.Errorf("复查成功后应恢复: %+v", saved)
+	}
+	if saved.SuccessCount != 1 || saved.CheckCount != ProxyFailureThreshold+2 || saved.LastCheckedAt == nil {
+		t.Errorf("检查统计 check=%d success=%d", saved.CheckCount, saved.SuccessCount)
+	}
+
+	if manual := load(manual.ID); manual.CheckCount != 0 || manual.IsActive {
+		t.Errorf("手动停用的代理不应被检查或恢复: %+v", manual)
+	}
+}
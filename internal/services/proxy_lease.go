@@ -0,0 +1,419 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/pkg/utils"
+)
+
+// 代理租约配置
+const (
+	SettingProxyLeaseMinutes = "proxy_lease_minutes" // 租约时长（分钟），默认30
+	SettingProxyMaxDevices   = "proxy_max_devices"   // 每个代理同时租给的设备数上限，默认1（独占），0 表示不限制
+
+	defaultProxyLeaseMinutes = 30
+	defaultProxyMaxDevices   = 1
+)
+
+// 分配代理时每轮取的候选数和最多尝试轮数，候选代理被并发请求占满时换下一个
+const (
+	proxyAssignCandidates = 5
+	proxyAssignAttempts   = 3
+)
+
+var (
+	ErrNoProxyAvailable   = errors.New("暂无可用代理")
+	ErrProxyLeaseNotFound = errors.New("代理租约不存在或已失效，请重新申请代理")
+	ErrNoMatchingProxy    = errors.New("暂无符合任务类型地区要求的可用代理")
+
+	errProxyFull = errors.New("代理已被占满")
+)
+
+// ProxyLeasePolicy 读取租约时长和每个代理的设备数上限
+func ProxyLeasePolicy(db *gorm.DB) (time.Duration, int) {
+	minutes := settingInt(db, SettingProxyLeaseMinutes, defaultProxyLeaseMinutes)
+	if minutes <= 0 {
+		minutes = defaultProxyLeaseMinutes
+	}
+	maxDevices := settingInt(db, SettingProxyMaxDevices, defaultProxyMaxDevices)
+	if maxDevices < 0 {
+		maxDevices = defaultProxyMaxDevices
+	}
+	return time.Duration(minutes) * time.Minute, maxDevices
+}
+
+func settingInt(db *gorm.DB, key string, def int) int {
+	var setting models.Setting
+	if err := db.Where("param_key = ?", key).First(&setting).Error; err != nil {
+		return def
+	}
+	value, err := strconv.Atoi(setting.ParamValue)
+	if err != nil {
+		return def
+	}
+	return value
+}
+
+// AssignProxyLease 为设备分配代理
+// 设备已有未到期的租约且代理仍启用（并满足请求的协议、任务类型的强制地区要求和绑定的代理分组）时返回原代理；
+// 否则按 proxyTargets 的优先顺序逐级匹配地区，在匹配的代理中选择租用设备最少、使用次数最少的代理并创建新租约。
+// 任务类型或设备分组绑定了代理分组时只在该分组中分配，分组内无可用代理时返回 ErrNoGroupProxy。
+// 代理的租用数通过条件更新占位，并发分配时不会超过设备数上限
+func AssignProxyLease(db *gorm.DB, req models.ProxyAssignRequest) (*models.ProxyLease, *models.Proxy, error) {
+	targets, required := proxyTargets(db, req)
+	policy := LoadProxyRotationPolicy(db)
+	group := ProxyGroupFor(db, req.DeviceID, req.TaskType)
+	groupName := ""
+	if group != nil {
+		groupName = group.Name
+	}
+
+	// 需要轮换的代理释放后不再分配给该设备
+	var rotatedProxyID uint
+	if lease, proxy, ok := currentProxyLease(db, req.DeviceID); ok {
+		rotate := lease.RotateReason != ""
+		if !rotate {
+			reason, _ := policy.rotationReason(db, lease, time.Now())
+			rotate = reason != ""
+		}
+		if !rotate && proxyProtocolAllowed(req.Protocol, proxy.Protocol) && (required == nil || required.Matches(proxy)) &&
+			(group == nil || proxy.GroupName == group.Name) {
+			return lease, proxy, nil
+		}
+		// 原代理需要轮换、协议客户端不支持、不满足将要执行的任务类型的地区要求，或不在绑定的代理分组中，换一个
+		if err := releaseProxyLease(db, lease); err != nil {
+			return nil, nil, err
+		}
+		if rotate {
+			rotatedProxyID = lease.ProxyID
+		}
+	}
+
+	duration, maxDevices := ProxyLeasePolicy(db)
+	for _, target := range targets {
+		lease, proxy, err := assignProxyTarget(db, req, target, proxyAssignLimits{
+			maxDevices: maxDevices,
+			duration:   duration,
+			rotation:   policy,
+			excludeID:  rotatedProxyID,
+			group:      groupName,
+		})
+		if errors.Is(err, ErrNoProxyAvailable) {
+			continue
+		}
+		return lease, proxy, err
+	}
+	if required != nil {
+		return nil, nil, ErrNoMatchingProxy
+	}
+	if group != nil {
+		return nil, nil, ErrNoGroupProxy
+	}
+	return nil, nil, ErrNoProxyAvailable
+}
+
+// proxyAssignLimits 分配代理时的限制
+type proxyAssignLimits struct {
+	maxDevices int           // 每个代理同时租给的设备数上限，0 表示不限制
+	duration   time.Duration // 租约时长
+	rotation   ProxyRotationPolicy
+	excludeID  uint   // 刚轮换掉的代理，不再分配
+	group      string // 设备分组或任务类型绑定的代理分组，只在该分组中分配
+}
+
+// assignProxyTarget 在符合 target 的代理中分配，没有可用代理时返回 ErrNoProxyAvailable
+func assignProxyTarget(db *gorm.DB, req models.ProxyAssignRequest, target ProxyTarget, limits proxyAssignLimits) (*models.ProxyLease, *models.Proxy, error) {
+	for attempt := 0; attempt < proxyAssignAttempts; attempt++ {
+		now := time.Now()
+		query := target.Apply(db.Where("is_active = ? AND (expires_at IS NULL OR expires_at > ?)", true, now))
+		if protocol := requestProxyProtocol(req.Protocol); protocol != "" {
+			query = query.Where("protocol = ?", protocol)
+		}
+		if limits.maxDevices > 0 {
+			query = query.Where("lease_count < ?", limits.maxDevices)
+		}
+		if limits.excludeID != 0 {
+			query = query.Where("id <> ?", limits.excludeID)
+		}
+		if limits.group != "" {
+			query = query.Where("group_name = ?", limits.group)
+		}
+		query = limits.rotation.Apply(query, req.DeviceID, now)
+		var candidates []models.Proxy
+		if err := query.Order("lease_count ASC, usage_count ASC, id ASC").
+			Limit(proxyAssignCandidates).Find(&candidates).Error; err != nil {
+			return nil, nil, err
+		}
+		if len(candidates) == 0 {
+			return nil, nil, ErrNoProxyAvailable
+		}
+
+		for i := range candidates {
+			proxy := &candidates[i]
+			lease, err := claimProxy(db, proxy, req, target, limits)
+			if err == nil {
+				return lease, proxy, nil
+			}
+			if errors.Is(err, errProxyFull) {
+				continue
+			}
+			// 同一设备的并发请求已经创建了租约
+			if lease, proxy, ok := currentProxyLease(db, req.DeviceID); ok {
+				return lease, proxy, nil
+			}
+			return nil, nil, err
+		}
+	}
+	return nil, nil, ErrNoProxyAvailable
+}
+
+// requestProxyProtocol 分配时限定的代理协议，空字符串表示不限
+// 请求未指定协议时只分配 socks5，兼容只支持 SOCKS5 的旧客户端
+func requestProxyProtocol(protocol string) string {
+	switch protocol {
+	case "":
+		return utils.ProxyProtocolSOCKS5
+	case "any":
+		return ""
+	default:
+		return protocol
+	}
+}
+
+func proxyProtocolAllowed(requested, protocol string) bool {
+	want := requestProxyProtocol(requested)
+	return want == "" || want == protocol
+}
+
+// currentProxyLease 查询设备未到期且代理仍启用的租约，已失效的租约直接释放
+func currentProxyLease(db *gorm.DB, deviceID string) (*models.ProxyLease, *models.Proxy, bool) {
+	var lease models.ProxyLease
+	if err := db.Where("device_id = ?", deviceID).First(&lease).Error; err != nil {
+		return nil, nil, false
+	}
+
+	now := time.Now()
+	var proxy models.Proxy
+	if lease.ExpiresAt.After(now) &&
+		db.Where("id = ? AND is_active = ? AND (expires_at IS NULL OR expires_at > ?)", lease.ProxyID, true, now).
+			First(&proxy).Error == nil {
+		return &lease, &proxy, true
+	}
+
+	if err := releaseProxyLease(db, &lease); err != nil {
+		log.Printf("释放代理租约失败 (device_id=%s): %v", deviceID, err)
+	}
+	return nil, nil, false
+}
+
+// claimProxy 占用代理的一个租用名额并创建租约，代理已停用、已占满或达到轮换上限时返回 errProxyFull
+func claimProxy(db *gorm.DB, proxy *models.Proxy, req models.ProxyAssignRequest, target ProxyTarget, limits proxyAssignLimits) (*models.ProxyLease, error) {
+	now := time.Now()
+	expiresAt := now.Add(limits.duration)
+	if limits.group != "" {
+		target.Reason = truncateError(target.Reason+"（代理分组 "+limits.group+"）", 255)
+	}
+	// 租约不超过供应商代理的到期时间
+	if proxy.ExpiresAt != nil && proxy.ExpiresAt.Before(expiresAt) {
+		expiresAt = *proxy.ExpiresAt
+	}
+	lease := models.ProxyLease{
+		ProxyID:   proxy.ID,
+		DeviceID:  req.DeviceID,
+		DeviceSN:  req.DeviceSN,
+		ExpiresAt: expiresAt,
+		MatchType: target.MatchType,
+		Reason:    target.Reason,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := tx.Model(&models.Proxy{}).Where("id = ? AND is_active = ?", proxy.ID, true)
+		if limits.maxDevices > 0 {
+			query = query.Where("lease_count < ?", limits.maxDevices)
+		}
+		query = limits.rotation.Apply(query, req.DeviceID, now)
+		result := query.UpdateColumns(map[string]interface{}{
+			"lease_count": gorm.Expr("lease_count + 1"),
+			"usage_count": gorm.Expr("usage_count + 1"),
+			"updated_at":  now,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errProxyFull
+		}
+
+		if err := tx.Create(&lease).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.ProxyUsageLog{
+			ProxyID:    proxy.ID,
+			DeviceID:   req.DeviceID,
+			DeviceSN:   req.DeviceSN,
+			IP:         proxy.IP,
+			Port:       proxy.Port,
+			AssignedAt: now,
+			MatchType:  target.MatchType,
+			Reason:     target.Reason,
+			CreatedAt:  now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	proxy.LeaseCount++
+	proxy.UsageCount++
+	return &lease, nil
+}
+
+// RenewProxyLease 续租：租约到期时间从现在起顺延一个租约时长
+// 租约已到期或代理已停用时释放租约并返回 ErrProxyLeaseNotFound，代理需要轮换时释放租约并返回 ErrProxyRotate，设备需重新申请代理
+func RenewProxyLease(db *gorm.DB, deviceID string) (*models.ProxyLease, error) {
+	lease, proxy, ok := currentProxyLease(db, deviceID)
+	if !ok {
+		return nil, ErrProxyLeaseNotFound
+	}
+	if lease.RotateReason != "" || DeviceProxyRotation(db, deviceID) != nil {
+		if err := releaseProxyLease(db, lease); err != nil {
+			return nil, err
+		}
+		return nil, ErrProxyRotate
+	}
+
+	duration, _ := ProxyLeasePolicy(db)
+	now := time.Now()
+	expiresAt := now.Add(duration)
+	if proxy.ExpiresAt != nil && proxy.ExpiresAt.Before(expiresAt) {
+		expiresAt = *proxy.ExpiresAt
+	}
+	result := db.Model(&models.ProxyLease{}).
+		Where("id = ? AND expires_at > ?", lease.ID, now).
+		Updates(map[string]interface{}{"expires_at": expiresAt, "updated_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrProxyLeaseNotFound
+	}
+	lease.ExpiresAt = expiresAt
+	lease.UpdatedAt = now
+	return lease, nil
+}
+
+// ReleaseProxyLease 设备主动释放代理租约
+func ReleaseProxyLease(db *gorm.DB, deviceID string) error {
+	var lease models.ProxyLease
+	if err := db.Where("device_id = ?", deviceID).First(&lease).Error; err != nil {
+		return ErrProxyLeaseNotFound
+	}
+	return releaseProxyLease(db, &lease)
+}
+
+// releaseProxyLease 删除租约并归还代理的租用名额，租约已被并发释放时不重复归还
+func releaseProxyLease(db *gorm.DB, lease *models.ProxyLease) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("id = ?", lease.ID).Delete(&models.ProxyLease{})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		now := time.Now()
+		if err := closeProxyUsageLog(tx, lease, now); err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Proxy{}).Where("id = ?", lease.ProxyID).
+			UpdateColumn("last_released_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&models.Proxy{}).Where("id = ? AND lease_count > 0", lease.ProxyID).
+			UpdateColumn("lease_count", gorm.Expr("lease_count - 1")).Error
+	})
+}
+
+// closeProxyUsageLog 记录租约对应的使用记录的释放时间，用于统计代理的租用时长
+func closeProxyUsageLog(tx *gorm.DB, lease *models.ProxyLease, now time.Time) error {
+	return tx.Model(&models.ProxyUsageLog{}).
+		Where("proxy_id = ? AND device_id = ? AND assigned_at >= ? AND released_at IS NULL",
+			lease.ProxyID, lease.DeviceID, lease.CreatedAt).
+		UpdateColumn("released_at", now).Error
+}
+
+// ReleaseExpiredProxyLeases 释放所有已到期的租约，返回释放数量
+func ReleaseExpiredProxyLeases(db *gorm.DB) (int, error) {
+	var leases []models.ProxyLease
+	if err := db.Where("expires_at <= ?", time.Now()).Find(&leases).Error; err != nil {
+		return 0, err
+	}
+
+	released := 0
+	for i := range leases {
+		if err := releaseProxyLease(db, &leases[i]); err != nil {
+			log.Printf("释放代理租约失败 (lease_id=%d): %v", leases[i].ID, err)
+			continue
+		}
+		released++
+	}
+	return released, nil
+}
+
+// ProxyLeaseService 代理租约回收服务，定期释放到期的租约，并通知达到轮换条件的设备更换代理
+type ProxyLeaseService struct {
+	db       *gorm.DB
+	interval time.Duration
+	stopChan chan struct{}
+}
+
+// NewProxyLeaseService 创建代理租约回收服务
+func NewProxyLeaseService(db *gorm.DB) *ProxyLeaseService {
+	return &ProxyLeaseService{
+		db:       db,
+		interval: time.Minute,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start 启动租约回收服务
+func (s *ProxyLeaseService) Start() {
+	log.Println("✓ 代理租约回收服务已启动（每分钟检查到期租约和代理轮换）")
+	go s.run()
+}
+
+// Stop 停止租约回收服务
+func (s *ProxyLeaseService) Stop() {
+	close(s.stopChan)
+	log.Println("代理租约回收服务已停止")
+}
+
+func (s *ProxyLeaseService) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			released, err := ReleaseExpiredProxyLeases(s.db)
+			if err != nil {
+				log.Printf("查询到期代理租约失败: %v", err)
+			} else if released > 0 {
+				log.Printf("已释放 %d 个到期的代理租约", released)
+			}
+			notified, err := FlagProxyRotations(s.db)
+			if err != nil {
+				log.Printf("检查代理轮换失败: %v", err)
+			} else if notified > 0 {
+				log.Printf("已通知 %d 台设备轮换代理", notified)
+			}
+		case <-s.stopChan:
+			return
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/pkg/utils"
+)
+
+// SettingProxyPoolTarget 代理池目标数量：可用代理少于该数量时从供应商补充，0 表示不自动补充
+const SettingProxyPoolTarget = "proxy_pool_target_size"
+
+const (
+	proxyReplenishInterval = time.Minute
+	proxyReplenishTimeout  = 30 * time.Second
+	defaultSupplierBatch   = 10
+)
+
+// queryProxyLocation 查询供应商代理的归属地，测试中替换以免访问外部接口
+var queryProxyLocation = utils.QueryIPLocationWithFallback
+
+// ProxyReplenishService 代理池补充服务：停用到期的代理，可用代理不足目标数量时按优先级从供应商补充
+type ProxyReplenishService struct {
+	db       *gorm.DB
+	stopChan chan struct{}
+}
+
+// NewProxyReplenishService 创建代理池补充服务
+func NewProxyReplenishService(db *gorm.DB) *ProxyReplenishService {
+	return &ProxyReplenishService{
+		db:       db,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start 启动代理池补充服务
+func (s *ProxyReplenishService) Start() {
+	log.Println("✓ 代理池补充服务已启动（每分钟检查一次）")
+	go s.run()
+}
+
+// Stop 停止代理池补充服务
+func (s *ProxyReplenishService) Stop() {
+	close(s.stopChan)
+	log.Println("代理池补充服务已停止")
+}
+
+func (s *ProxyReplenishService) run() {
+	s.replenish()
+
+	ticker := time.NewTicker(proxyReplenishInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.replenish()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+func (s *ProxyReplenishService) replenish() {
+	if retired, err := RetireExpiredProxies(s.db); err != nil {
+		log.Printf("停用到期代理失败: %v", err)
+	} else if retired > 0 {
+		log.Printf("已停用 %d 个到期的供应商代理", retired)
+	}
+
+	target := settingInt(s.db, SettingProxyPoolTarget, 0)
+	if target <= 0 {
+		return
+	}
+	need := target - int(CountAvailableProxies(s.db))
+	if need <= 0 {
+		return
+	}
+
+	var suppliers []models.ProxySupplier
+	s.db.Where("is_active = ?", true).Order("priority DESC, id ASC").Find(&suppliers)
+	for i := range suppliers {
+		if need <= 0 {
+			break
+		}
+		added, err := ReplenishFromSupplier(s.db, &suppliers[i], need)
+		if err != nil {
+			log.Printf("从代理供应商补充失败 (supplier=%s): %v", suppliers[i].Name, err)
+			continue
+		}
+		if added > 0 {
+			log.Printf("从代理供应商 %s 补充了 %d 个代理", suppliers[i].Name, added)
+		}
+		need -= added
+	}
+}
+
+// CountAvailableProxies 可分配的代理数：已启用且未到期
+func CountAvailableProxies(db *gorm.DB) int64 {
+	var count int64
+	db.Model(&models.Proxy{}).
+		Where("is_active = ? AND (expires_at IS NULL OR expires_at > ?)", true, time.Now()).
+		Count(&count)
+	return count
+}
+
+// ReplenishFromSupplier 从供应商获取代理并加入代理池，最多加入 min(need, 供应商每批数量) 个（need<=0 时按每批数量），返回加入的数量
+// 已存在的代理（IP+端口）跳过；同一供应商之前到期停用的代理按新的账号和到期时间重新启用
+func ReplenishFromSupplier(db *gorm.DB, supplier *models.ProxySupplier, need int) (int, error) {
+	limit := supplier.BatchSize
+	if limit <= 0 {
+		limit = defaultSupplierBatch
+	}
+	if need > 0 && need < limit {
+		limit = need
+	}
+
+	supplied, err := fetchFromSupplier(supplier, limit)
+	now := time.Now()
+	updates := map[string]interface{}{"last_fetched_at": now, "last_error": ""}
+	if err != nil {
+		updates["last_error"] = truncateError(err.Error(), proxyCheckErrorSize)
+		db.Model(supplier).UpdateColumns(updates)
+		return 0, err
+	}
+
+	added := 0
+	for _, item := range supplied {
+		if added >= limit {
+			break
+		}
+		if addSuppliedProxy(db, supplier, item, now) {
+			added++
+		}
+	}
+
+	updates["fetched_count"] = gorm.Expr("fetched_count + ?", added)
+	db.Model(supplier).UpdateColumns(updates)
+	return added, nil
+}
+
+func fetchFromSupplier(supplier *models.ProxySupplier, limit int) ([]SuppliedProxy, error) {
+	source, err := NewProxySource(supplier)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), proxyReplenishTimeout)
+	defer cancel()
+	return source.Fetch(ctx, limit)
+}
+
+// addSuppliedProxy 加入一个供应商代理，已存在时返回 false
+func addSuppliedProxy(db *gorm.DB, supplier *models.ProxySupplier, item SuppliedProxy, now time.Time) bool {
+	protocol := item.Protocol
+	if protocol == "" {
+		protocol = supplier.Protocol
+	}
+	if protocol == "" {
+		protocol = utils.ProxyProtocolSOCKS5
+	}
+	expiresAt := item.ExpiresAt
+	if expiresAt == nil && supplier.TTLMinutes > 0 {
+		t := now.Add(time.Duration(supplier.TTLMinutes) * time.Minute)
+		expiresAt = &t
+	}
+	if expiresAt != nil && !expiresAt.After(now) {
+		return false
+	}
+
+	var existing models.Proxy
+	if err := db.Where("ip = ? AND port = ?", item.IP, item.Port).First(&existing).Error; err == nil {
+		// 同一供应商到期停用的代理重新启用，其他已存在的代理不动
+		if existing.SupplierID == nil || *existing.SupplierID != supplier.ID ||
+			existing.IsActive || existing.QuarantinedAt != nil {
+			return false
+		}
+		// map 更新不经过模型的加密，需要先加密账号密码
+		username, password, err := EncryptedProxyCredentials(item.Username, item.Password)
+		if err != nil {
+			return false
+		}
+		return db.Model(&existing).UpdateColumns(map[string]interface{}{
+			"protocol":             protocol,
+			"username":             username,
+			"password":             password,
+			"expires_at":           expiresAt,
+			"is_active":            true,
+			"health_status":        ProxyHealthUnknown,
+			"consecutive_failures": 0,
+			"updated_at":           now,
+		}).Error == nil
+	}
+
+	location, _ := queryProxyLocation(item.IP)
+	proxy := models.Proxy{
+		IP:           item.IP,
+		Port:         item.Port,
+		Protocol:     protocol,
+		Username:     item.Username,
+		Password:     item.Password,
+		Province:     location.Province,
+		City:         location.City,
+		ISP:          location.ISP,
+		IsActive:     true,
+		HealthStatus: ProxyHealthUnknown,
+		SupplierID:   &supplier.ID,
+		ExpiresAt:    expiresAt,
+		Remark:       "供应商: " + supplier.Name,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+	// 并发补充同一代理时由 IP+端口唯一索引兜底
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&proxy)
+	return res.Error == nil && res.RowsAffected > 0
+}
+
+// RetireExpiredProxies 停用已到期的供应商代理并释放其租约，返回停用数量
+func RetireExpiredProxies(db *gorm.DB) (int, error) {
+	var ids []uint
+	if err := db.Model(&models.Proxy{}).
+		Where("is_active = ? AND expires_at IS NOT NULL AND expires_at <= ?", true, time.Now()).
+		Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.Proxy{}).Where("id IN ?", ids).UpdateColumns(map[string]interface{}{
+			"is_active":   false,
+			"lease_count": 0,
+			"updated_at":  now,
+		}).Error; err != nil {
+			return err
+		}
+		var leases []models.ProxyLease
+		if err := tx.Where("proxy_id IN ?", ids).Find(&leases).Error; err != nil {
+			return err
+		}
+		for i := range leases {
+			if err := closeProxyUsageLog(tx, &leases[i], now); err != nil {
+				return err
+			}
+		}
+		return tx.Where("proxy_id IN ?", ids).Delete(&models.ProxyLease{}).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), nil
+}
@@ -0,0 +1,223 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/pkg/utils"
+)
+
+func newReplenishTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	// 不访问外部的归属地接口
+	orig := queryProxyLocation
+	queryProxyLocation = func(string) (*utils.IPLocationInfo, error) {
+		return &utils.IPLocationInfo{Province: "广东", City: "深圳", ISP: "电信"}, nil
+	}
+	t.Cleanup(func() { queryProxyLocation = orig })
+
+	return newTestDB(t, &models.Proxy{}, &models.ProxySupplier{}, &models.ProxyLease{}, &models.ProxyUsageLog{}, &models.Setting{})
+}
+
+// supplierStub 本地供应商接口：按 num 参数返回 10.<subnet>.0.x 的代理，记录每次请求的数量
+type supplierStub struct {
+	server *httptest.Server
+
+	mu     sync.Mutex
+	counts []int
+}
+
+func newSupplierStub(t *testing.T, subnet int) *supplierStub {
+	t.Helper()
+	s := &supplierStub{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("num"))
+		s.mu.Lock()
+		s.counts = append(s.counts, n)
+		s.mu.Unlock()
+		for i := 1; i <= n; i++ {
+			fmt.Fprintf(w, "10.%d.0.%d|1080|u%d|p%d\n", subnet, i, i, i)
+		}
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *supplierStub) requested() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.counts...)
+}
+
+func seedSupplier(t *testing.T, db *gorm.DB, name, url string, priority, batch int) *models.ProxySupplier {
+	t.Helper()
+	supplier := models.ProxySupplier{
+		Name:       name,
+		SourceType: ProxySourceHTTP,
+		URL:        url,
+		Format:     ProxyFormatText,
+		Template:   "{ip}|{port}|{username}|{password}",
+		Protocol:   "socks5",
+		TTLMinutes: 60,
+		BatchSize:  batch,
+		Priority:   priority,
+		IsActive:   true,
+	}
+	if err := db.Create(&supplier).Error; err != nil {
+		t.Fatalf("创建供应商失败: %v", err)
+	}
+	return &supplier
+}
+
+func TestReplenishToTarget(t *testing.T) {
+	db := newReplenishTestDB(t)
+	db.Create(&models.Setting{ParamKey: SettingProxyPoolTarget, ParamValue: "5"})
+
+	// 手动添加的可用代理和一个已到期的供应商代理
+	seedProxy(t, db, 1080, "", "")
+	expired := seedProxy(t, db, 1081, "", "")
+	past := time.Now().Add(-time.Minute)
+	db.Model(&models.Proxy{}).Where("id = ?", expired.ID).UpdateColumn("expires_at", past)
+
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer broken.Close()
+	high, low, unused := newSupplierStub(t, 1), newSupplierStub(t, 2), newSupplierStub(t, 3)
+	failing := seedSupplier(t, db, "故障", broken.URL+"?num={count}", 20, 10)
+	seedSupplier(t, db, "优先", high.server.URL+"?num={count}", 10, 2)
+	seedSupplier(t, db, "备用", low.server.URL+"?num={count}", 5, 10)
+	inactive := seedSupplier(t, db, "停用", unused.server.URL+"?num={count}", 30, 10)
+	db.Model(&models.ProxySupplier{}).Where("id = ?", inactive.ID).UpdateColumn("is_active", false)
+
+	NewProxyReplenishService(db).replenish()
+
+	if CountAvailableProxies(db) != 5 {
+		t.Fatalf("可用代理 = %d, 期望补充到 5", CountAvailableProxies(db))
+	}
+	// 需要补充 4 个：优先供应商每批 2 个，剩余 2 个由备用供应商补充
+	if got := high.requested(); len(got) != 1 || got[0] != 2 {
+		t.Errorf("优先供应商请求数量 %v", got)
+	}
+	if got := low.requested(); len(got) != 1 || got[0] != 2 {
+		t.Errorf("备用供应商请求数量 %v", got)
+	}
+	if got := unused.requested(); len(got) != 0 {
+		t.Errorf("停用的供应商不应被请求: %v", got)
+	}
+
+	var saved models.ProxySupplier
+	db.First(&saved, failing.ID)
+	if !strings.Contains(saved.LastError, "502") || saved.LastFetchedAt == nil || saved.FetchedCount != 0 {
+		t.Errorf("故障供应商 %+v", saved)
+	}
+
+	var supplied []models.Proxy
+	db.Where("supplier_id IS NOT NULL").Order("id ASC").Find(&supplied)
+	if len(supplied) != 4 {
+		t.Fatalf("供应商代理 %d 个", len(supplied))
+	}
+	p := supplied[0]
+	if p.IP != "10.1.0.1" || p.Username != "u1" || p.Password != "p1" || p.Protocol != "socks5" || p.Province != "广东" ||
+		p.ExpiresAt == nil || p.ExpiresAt.Before(time.Now().Add(59*time.Minute)) || !p.IsActive {
+		t.Errorf("补充的代理 %+v", p)
+	}
+
+	var retired models.Proxy
+	db.First(&retired, expired.ID)
+	if retired.IsActive {
+		t.Error("到期代理应被停用")
+	}
+
+	// 已达到目标数量时不再请求供应商
+	NewProxyReplenishService(db).replenish()
+	if len(high.requested()) != 1 || len(low.requested()) != 1 {
+		t.Error("代理池已满时不应再补充")
+	}
+}
+
+func TestReplenishReactivatesRetiredProxy(t *testing.T) {
+	db := newReplenishTestDB(t)
+	stub := newSupplierStub(t, 1)
+	supplier := seedSupplier(t, db, "供应商", stub.server.URL+"?num={count}", 0, 10)
+
+	// 10.1.0.1 是该供应商之前到期停用的代理，10.1.0.2 是手动添加的同地址代理
+	past := time.Now().Add(-time.Hour)
+	retired := models.Proxy{IP: "10.1.0.1", Port: 1080, Protocol: "socks5", Username: "old", SupplierID: &supplier.ID, ExpiresAt: &past, IsActive: true}
+	db.Create(&retired)
+	db.Model(&models.Proxy{}).Where("id = ?", retired.ID).UpdateColumn("is_active", false)
+	manual := models.Proxy{IP: "10.1.0.2", Port: 1080, Protocol: "http", Username: "manual", IsActive: true}
+	db.Create(&manual)
+
+	added, err := ReplenishFromSupplier(db, supplier, 3)
+	if err != nil || added != 2 {
+		t.Fatalf("ReplenishFromSupplier = %d, %v", added, err)
+	}
+
+	db.First(&retired, retired.ID)
+	if !retired.IsActive || retired.Username != "u1" || retired.ExpiresAt == nil || !retired.ExpiresAt.After(time.Now()) {
+		t.Errorf("到期停用的代理应按新的账号和到期时间重新启用: %+v", retired)
+	}
+	db.First(&manual, manual.ID)
+	if manual.Username != "manual" || manual.SupplierID != nil {
+		t.Errorf("手动添加的代理不应被修改: %+v", manual)
+	}
+	var total int64
+	db.Model(&models.Proxy{}).Count(&total)
+	if total != 3 {
+		t.Errorf("代理总数 = %d, 期望 3", total)
+	}
+	db.First(supplier, supplier.ID)
+	if supplier.FetchedCount != 2 || supplier.LastError != "" {
+		t.Errorf("供应商 %+v", supplier)
+	}
+}
+
+func TestRetireExpiredProxies(t *testing.T) {
+	db := newReplenishTestDB(t)
+	now := time.Now()
+	past, future := now.Add(-time.Minute), now.Add(time.Hour)
+
+	expired := seedProxy(t, db, 1080, "", "")
+	alive := seedProxy(t, db, 1081, "", "")
+	db.Model(&models.Proxy{}).Where("id = ?", expired.ID).UpdateColumns(map[string]interface{}{"expires_at": past, "lease_count": 1})
+	db.Model(&models.Proxy{}).Where("id = ?", alive.ID).UpdateColumn("expires_at", future)
+
+	assigned := now.Add(-time.Hour)
+	db.Create(&models.ProxyLease{ProxyID: expired.ID, DeviceID: "dev1", ExpiresAt: future, CreatedAt: assigned})
+	db.Create(&models.ProxyUsageLog{ProxyID: expired.ID, DeviceID: "dev1", AssignedAt: assigned})
+	db.Create(&models.ProxyLease{ProxyID: alive.ID, DeviceID: "dev2", ExpiresAt: future, CreatedAt: assigned})
+
+	retired, err := RetireExpiredProxies(db)
+	if err != nil || retired != 1 {
+		t.Fatalf("RetireExpiredProxies = %d, %v", retired, err)
+	}
+
+	var proxy models.Proxy
+	db.First(&proxy, expired.ID)
+	if proxy.IsActive || proxy.LeaseCount != 0 {
+		t.Errorf("到期代理 %+v", proxy)
+	}
+	var kept models.Proxy
+	db.First(&kept, alive.ID)
+	if !kept.IsActive {
+		t.Error("未到期的代理不应停用")
+	}
+
+	var leases []models.ProxyLease
+	db.Find(&leases)
+	var log models.ProxyUsageLog
+	db.First(&log)
+	if len(leases) != 1 || leases[0].DeviceID != "dev2" || log.ReleasedAt == nil {
+		t.Errorf("租约 %+v 使用记录 %+v", leases, log)
+	}
+}
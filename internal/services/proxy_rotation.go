@@ -0,0 +1,206 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// 代理轮换策略配置，均为0时不轮换
+const (
+	SettingProxyMaxDailyAssignments = "proxy_max_daily_assignments" // 每个代理每天最多分配次数，默认0（不限制）
+	SettingProxyMaxDistinctDevices  = "proxy_max_distinct_devices"  // 每个代理最多分配给的不同设备数，默认0（不限制）
+	SettingProxyCooldownMinutes     = "proxy_cooldown_minutes"      // 代理释放后的冷却时间（分钟），冷却期内不再分配，默认0
+	SettingProxyMaxHoldMinutes      = "proxy_max_hold_minutes"      // 设备连续使用同一代理的最长时间（分钟，含续租），默认0（不限制）
+)
+
+// 代理轮换原因
+const (
+	ProxyRotateHoldTime         = "hold_time"         // 连续使用时间达到上限
+	ProxyRotateDailyAssignments = "daily_assignments" // 代理当天分配次数超过上限
+	ProxyRotateDistinctDevices  = "distinct_devices"  // 代理分配过的设备数超过上限
+	ProxyRotateManual           = "manual"            // 管理员通过控制指令要求轮换
+)
+
+// ErrProxyRotate 设备的代理需要轮换，续租被拒绝
+var ErrProxyRotate = errors.New("代理需要轮换，请重新申请代理")
+
+// ProxyRotationPolicy 代理轮换策略。分配次数和设备数达到上限的代理不再分配；
+// 设备使用中的代理在连续使用时间达到上限，或分配次数、设备数超过上限（如调低了上限）时通知设备轮换
+type ProxyRotationPolicy struct {
+	MaxDailyAssignments int
+	MaxDistinctDevices  int
+	Cooldown            time.Duration
+	MaxHold             time.Duration
+}
+
+// LoadProxyRotationPolicy 读取代理轮换策略，负数按0处理
+func LoadProxyRotationPolicy(db *gorm.DB) ProxyRotationPolicy {
+	minutes := func(key string) time.Duration {
+		return time.Duration(max(settingInt(db, key, 0), 0)) * time.Minute
+	}
+	return ProxyRotationPolicy{
+		MaxDailyAssignments: max(settingInt(db, SettingProxyMaxDailyAssignments, 0), 0),
+		MaxDistinctDevices:  max(settingInt(db, SettingProxyMaxDistinctDevices, 0), 0),
+		Cooldown:            minutes(SettingProxyCooldownMinutes),
+		MaxHold:             minutes(SettingProxyMaxHoldMinutes),
+	}
+}
+
+// Apply 在代理查询上加上可分配给设备的条件：冷却期已过、当天分配次数和分配过的设备数未达到上限。
+// 设备数不计入申请的设备本身，已经分配过的设备可以再次分配
+func (p ProxyRotationPolicy) Apply(query *gorm.DB, deviceID string, now time.Time) *gorm.DB {
+	if p.Cooldown > 0 {
+		query = query.Where("(proxies.last_released_at IS NULL OR proxies.last_released_at <= ?)", now.Add(-p.Cooldown))
+	}
+	if p.MaxDailyAssignments > 0 {
+		query = query.Where("(SELECT COUNT(*) FROM proxy_usage_logs WHERE proxy_usage_logs.proxy_id = proxies.id "+
+			"AND proxy_usage_logs.assigned_at >= ?) < ?", startOfDay(now), p.MaxDailyAssignments)
+	}
+	if p.MaxDistinctDevices > 0 {
+		query = query.Where("(SELECT COUNT(DISTINCT device_id) FROM proxy_usage_logs WHERE proxy_usage_logs.proxy_id = proxies.id "+
+			"AND proxy_usage_logs.device_id <> ?) < ?", deviceID, p.MaxDistinctDevices)
+	}
+	return query
+}
+
+// rotatesLeases 是否配置了会让使用中的租约轮换的上限，冷却时间只影响新分配
+func (p ProxyRotationPolicy) rotatesLeases() bool {
+	return p.MaxHold > 0 || p.MaxDailyAssignments > 0 || p.MaxDistinctDevices > 0
+}
+
+// rotationReason 租约需要轮换的原因，不需要时为空
+func (p ProxyRotationPolicy) rotationReason(db *gorm.DB, lease *models.ProxyLease, now time.Time) (string, string) {
+	if p.MaxHold > 0 && now.Sub(lease.CreatedAt) >= p.MaxHold {
+		return ProxyRotateHoldTime, fmt.Sprintf("已连续使用该代理 %d 分钟，请更换代理", int(now.Sub(lease.CreatedAt).Minutes()))
+	}
+	if p.MaxDailyAssignments > 0 {
+		var count int64
+		db.Model(&models.ProxyUsageLog{}).
+			Where("proxy_id = ? AND assigned_at >= ?", lease.ProxyID, startOfDay(now)).Count(&count)
+		if count > int64(p.MaxDailyAssignments) {
+			return ProxyRotateDailyAssignments, fmt.Sprintf("代理今天已分配 %d 次，超过上限 %d 次，请更换代理", count, p.MaxDailyAssignments)
+		}
+	}
+	if p.MaxDistinctDevices > 0 {
+		var count int64
+		db.Model(&models.ProxyUsageLog{}).Where("proxy_id = ?", lease.ProxyID).
+			Distinct("device_id").Count(&count)
+		if count > int64(p.MaxDistinctDevices) {
+			return ProxyRotateDistinctDevices, fmt.Sprintf("代理已分配给 %d 台设备，超过上限 %d 台，请更换代理", count, p.MaxDistinctDevices)
+		}
+	}
+	return "", ""
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// DeviceProxyRotation 设备当前租约的轮换指令，不需要轮换时返回 nil。
+// 首次判定需要轮换时记录在租约上，之后设备重新申请代理前每次都返回同一指令
+func DeviceProxyRotation(db *gorm.DB, deviceID string) *models.ProxyRotateInstruction {
+	var lease models.ProxyLease
+	if err := db.Where("device_id = ?", deviceID).First(&lease).Error; err != nil {
+		return nil
+	}
+	if lease.RotateReason == "" {
+		instruction, _ := flagProxyRotation(db, LoadProxyRotationPolicy(db), &lease, time.Now())
+		return instruction
+	}
+	return rotateInstruction(&lease, proxyRotateMessage(lease.RotateReason))
+}
+
+// FlagProxyRotations 检查所有租约，把需要轮换的记录在租约上并推送轮换指令给设备，返回新通知的设备数
+func FlagProxyRotations(db *gorm.DB) (int, error) {
+	policy := LoadProxyRotationPolicy(db)
+	if !policy.rotatesLeases() {
+		return 0, nil
+	}
+
+	var leases []models.ProxyLease
+	if err := db.Where("rotate_reason = ?", "").Find(&leases).Error; err != nil {
+		return 0, err
+	}
+	notified := 0
+	now := time.Now()
+	for i := range leases {
+		instruction, flagged := flagProxyRotation(db, policy, &leases[i], now)
+		if !flagged {
+			continue
+		}
+		GetDeviceHub().SendControl(leases[i].DeviceID, DeviceActionRotateProxy, instruction)
+		notified++
+	}
+	return notified, nil
+}
+
+// RequestProxyRotation 管理员要求设备轮换代理：在设备当前租约上记录手动轮换，
+// 设备重新申请代理时不再分配原代理。设备没有租约时返回 nil
+func RequestProxyRotation(db *gorm.DB, deviceID string) (*models.ProxyRotateInstruction, error) {
+	var lease models.ProxyLease
+	if err := db.Where("device_id = ?", deviceID).First(&lease).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	now := time.Now()
+	if err := db.Model(&models.ProxyLease{}).Where("id = ?", lease.ID).
+		UpdateColumns(map[string]interface{}{"rotate_reason": ProxyRotateManual, "rotate_requested_at": now}).Error; err != nil {
+		return nil, err
+	}
+	lease.RotateReason = ProxyRotateManual
+	lease.RotateRequestedAt = &now
+	return rotateInstruction(&lease, proxyRotateMessage(ProxyRotateManual)), nil
+}
+
+// flagProxyRotation 判定租约是否需要轮换，需要时用条件更新记录原因，flagged 表示本次新记录
+func flagProxyRotation(db *gorm.DB, policy ProxyRotationPolicy, lease *models.ProxyLease, now time.Time) (*models.ProxyRotateInstruction, bool) {
+	reason, message := policy.rotationReason(db, lease, now)
+	if reason == "" {
+		return nil, false
+	}
+	result := db.Model(&models.ProxyLease{}).Where("id = ? AND rotate_reason = ?", lease.ID, "").
+		UpdateColumns(map[string]interface{}{"rotate_reason": reason, "rotate_requested_at": now})
+	if result.Error != nil {
+		log.Printf("记录代理轮换失败 (device_id=%s): %v", lease.DeviceID, result.Error)
+		return nil, false
+	}
+	lease.RotateReason = reason
+	lease.RotateRequestedAt = &now
+	return rotateInstruction(lease, message), result.RowsAffected > 0
+}
+
+func rotateInstruction(lease *models.ProxyLease, message string) *models.ProxyRotateInstruction {
+	instruction := &models.ProxyRotateInstruction{
+		ProxyID: lease.ProxyID,
+		Reason:  lease.RotateReason,
+		Message: message,
+	}
+	if lease.RotateRequestedAt != nil {
+		instruction.RequestedAt = *lease.RotateRequestedAt
+	}
+	return instruction
+}
+
+// proxyRotateMessage 已记录的轮换原因的提示
+func proxyRotateMessage(reason string) string {
+	switch reason {
+	case ProxyRotateHoldTime:
+		return "连续使用该代理的时间已达到上限，请更换代理"
+	case ProxyRotateDailyAssignments:
+		return "代理今天的分配次数已超过上限，请更换代理"
+	case ProxyRotateDistinctDevices:
+		return "代理分配过的设备数已超过上限，请更换代理"
+	case ProxyRotateManual:
+		return "管理员要求更换代理，请重新申请代理"
+	}
+	return ErrProxyRotate.Error()
+}
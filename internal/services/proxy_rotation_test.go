@@ -0,0 +1,40 @@
+package services
+
+import (
+	"testing"
+
+	"jd-task-platform-go/internal/models"
+)
+
+func TestRequestProxyRotationExcludesProxy(t *testing.T) {
+	db := newTestDB(t, &models.Proxy{}, &models.ProxyLease{}, &models.ProxyUsageLog{}, &models.Setting{},
+		&models.Device{}, &models.TaskType{}, &models.ProxyGroup{})
+	seedProxy(t, db, 1080, "", "")
+	seedProxy(t, db, 1081, "", "")
+	req := models.ProxyAssignRequest{DeviceID: "dev1"}
+
+	if instruction, err := RequestProxyRotation(db, "dev1"); err != nil || instruction != nil {
+		t.Fatalf("没有租约时 = %+v, %v", instruction, err)
+	}
+
+	_, first, err := AssignProxyLease(db, req)
+	if err != nil {
+		t.Fatalf("分配代理失败: %v", err)
+	}
+	instruction, err := RequestProxyRotation(db, "dev1")
+	if err != nil || instruction == nil || instruction.Reason != ProxyRotateManual || instruction.ProxyID != first.ID {
+		t.Fatalf("RequestProxyRotation = %+v, %v", instruction, err)
+	}
+	if got := DeviceProxyRotation(db, "dev1"); got == nil || got.Reason != ProxyRotateManual {
+		t.Errorf("设备应收到手动轮换指令: %+v", got)
+	}
+
+	// 重新申请时分配另一个代理
+	renewed, second, err := AssignProxyLease(db, req)
+	if err != nil {
+		t.Fatalf("重新分配代理失败: %v", err)
+	}
+	if second.ID == first.ID || renewed.RotateReason != "" {
+		t.Errorf("轮换后 lease=%+v proxy=%d, 原代理 %d", renewed, second.ID, first.ID)
+	}
+}
@@ -0,0 +1,383 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/pkg/utils"
+)
+
+// 代理供应商类型
+const (
+	ProxySourceStatic = "static" // 固定列表
+	ProxySourceHTTP   = "http"   // 供应商接口
+	ProxySourceFile   = "file"   // 本地文件
+)
+
+// 供应商返回格式
+const (
+	ProxyFormatText = "text"
+	ProxyFormatJSON = "json"
+)
+
+const (
+	proxySourceTimeout  = 15 * time.Second
+	proxySourceMaxBytes = 1 << 20
+)
+
+// SuppliedProxy 供应商提供的一个代理
+type SuppliedProxy struct {
+	Protocol  string // 供应商未给出时为空，使用供应商配置的协议
+	IP        string
+	Port      int
+	Username  string
+	Password  string
+	ExpiresAt *time.Time // 供应商给出的到期时间，未给出时为空
+}
+
+// ProxySource 代理来源，limit 为本次需要的数量，来源可以返回更多，由调用方截取
+type ProxySource interface {
+	Fetch(ctx context.Context, limit int) ([]SuppliedProxy, error)
+}
+
+// NewProxySource 根据供应商配置创建代理来源
+func NewProxySource(supplier *models.ProxySupplier) (ProxySource, error) {
+	switch supplier.SourceType {
+	case ProxySourceStatic:
+		return &StaticProxySource{List: supplier.StaticList, Format: supplier.Format, Template: supplier.Template}, nil
+	case ProxySourceHTTP:
+		if supplier.URL == "" {
+			return nil, errors.New("未配置供应商接口地址")
+		}
+		return &HTTPProxySource{URL: supplier.URL, Format: supplier.Format, Template: supplier.Template}, nil
+	case ProxySourceFile:
+		if supplier.FilePath == "" {
+			return nil, errors.New("未配置代理列表文件")
+		}
+		return &FileProxySource{Path: supplier.FilePath, Format: supplier.Format, Template: supplier.Template}, nil
+	default:
+		return nil, fmt.Errorf("不支持的供应商类型: %s", supplier.SourceType)
+	}
+}
+
+// StaticProxySource 固定的代理列表
+type StaticProxySource struct {
+	List     string
+	Format   string
+	Template string
+}
+
+// Fetch 解析代理列表
+func (s *StaticProxySource) Fetch(_ context.Context, _ int) ([]SuppliedProxy, error) {
+	return ParseSuppliedProxies([]byte(s.List), s.Format, s.Template)
+}
+
+// HTTPProxySource 通过供应商接口获取代理，URL 中的 {count} 替换为需要的数量
+type HTTPProxySource struct {
+	URL      string
+	Format   string
+	Template string
+	Client   *http.Client // 为空时使用默认超时的客户端
+}
+
+// Fetch 请求供应商接口并解析返回内容
+func (s *HTTPProxySource) Fetch(ctx context.Context, limit int) ([]SuppliedProxy, error) {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: proxySourceTimeout}
+	}
+
+	url := strings.ReplaceAll(s.URL, "{count}", strconv.Itoa(limit))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("供应商接口地址无效: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("请求供应商接口失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, proxySourceMaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("读取供应商接口返回失败: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("供应商接口返回 %d", resp.StatusCode)
+	}
+	return ParseSuppliedProxies(body, s.Format, s.Template)
+}
+
+// FileProxySource 本地代理列表文件，每次获取时重新读取，文件更新后下次补充即生效
+type FileProxySource struct {
+	Path     string
+	Format   string
+	Template string
+}
+
+// Fetch 读取并解析文件
+func (s *FileProxySource) Fetch(_ context.Context, _ int) ([]SuppliedProxy, error) {
+	f, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("读取代理列表文件失败: %w", err)
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(io.LimitReader(f, proxySourceMaxBytes))
+	if err != nil {
+		return nil, fmt.Errorf("读取代理列表文件失败: %w", err)
+	}
+	return ParseSuppliedProxies(data, s.Format, s.Template)
+}
+
+// ParseSuppliedProxies 按格式模板解析供应商返回的代理列表
+//
+// text：每行一个代理，模板中的 {protocol} {ip} {port} {username} {password} {expire} 为字段占位，其余字符原样匹配；
+// 未配置模板时按批量导入支持的格式解析（IP|端口|用户名|密码、IP:端口、协议://用户名:密码@IP:端口 等）；空行和 # 开头的行忽略。
+//
+// json：模板为字段映射，如 {"list":"data.proxies","protocol":"type","ip":"ip","port":"port","username":"user","password":"pass","expire":"expire_time"}，
+// list 为代理数组的路径（用 . 分隔，为空时取根数组或根对象的 data 字段），其余为数组元素中对应字段的名称，未配置时使用同名字段（到期时间默认为 expire_at）。
+//
+// 协议取值为 socks5/socks/socks5h/http/https，未给出时为空，协议无效的条目被跳过。
+//
+// {expire} 支持 Unix 时间戳（秒或毫秒）、"2006-01-02 15:04:05" 和 RFC3339 格式。格式不正确的条目被跳过
+func ParseSuppliedProxies(data []byte, format, template string) ([]SuppliedProxy, error) {
+	switch format {
+	case ProxyFormatJSON:
+		return parseJSONProxies(data, template)
+	case "", ProxyFormatText:
+		return parseTextProxies(data, template)
+	default:
+		return nil, fmt.Errorf("不支持的格式: %s", format)
+	}
+}
+
+var templateFieldPattern = regexp.MustCompile(`\{(protocol|ip|port|username|password|expire)\}`)
+
+// ValidateProxyTemplate 校验格式模板
+func ValidateProxyTemplate(format, template string) error {
+	switch format {
+	case ProxyFormatJSON:
+		if template == "" {
+			return nil
+		}
+		var mapping map[string]string
+		if err := json.Unmarshal([]byte(template), &mapping); err != nil {
+			return fmt.Errorf("格式模板不是有效的 JSON: %w", err)
+		}
+		return nil
+	case "", ProxyFormatText:
+		if template == "" {
+			return nil
+		}
+		_, _, err := compileTextTemplate(template)
+		return err
+	default:
+		return fmt.Errorf("不支持的格式: %s", format)
+	}
+}
+
+// compileTextTemplate 将文本模板转为正则：占位替换为分组，其余字符转义后按整行匹配
+func compileTextTemplate(template string) (*regexp.Regexp, []string, error) {
+	var pattern strings.Builder
+	var fields []string
+	last := 0
+	for _, loc := range templateFieldPattern.FindAllStringSubmatchIndex(template, -1) {
+		pattern.WriteString(regexp.QuoteMeta(template[last:loc[0]]))
+		pattern.WriteString("(.*?)")
+		fields = append(fields, template[loc[2]:loc[3]])
+		last = loc[1]
+	}
+	pattern.WriteString(regexp.QuoteMeta(template[last:]))
+	if !containsString(fields, "ip") || !containsString(fields, "port") {
+		return nil, nil, errors.New("格式模板必须包含 {ip} 和 {port}")
+	}
+	re, err := regexp.Compile("^" + pattern.String() + "$")
+	if err != nil {
+		return nil, nil, fmt.Errorf("格式模板无效: %w", err)
+	}
+	return re, fields, nil
+}
+
+func parseTextProxies(data []byte, template string) ([]SuppliedProxy, error) {
+	var re *regexp.Regexp
+	var fields []string
+	if template != "" {
+		var err error
+		if re, fields, err = compileTextTemplate(template); err != nil {
+			return nil, err
+		}
+	}
+
+	var proxies []SuppliedProxy
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if re == nil {
+			if proxy, ok := parseProxyLine(line); ok {
+				proxies = append(proxies, proxy)
+			}
+			continue
+		}
+		match := re.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		values := make(map[string]interface{}, len(fields))
+		for i, field := range fields {
+			values[field] = strings.TrimSpace(match[i+1])
+		}
+		if proxy, ok := suppliedProxy(values, nil); ok {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies, nil
+}
+
+func parseJSONProxies(data []byte, template string) ([]SuppliedProxy, error) {
+	mapping := map[string]string{}
+	if template != "" {
+		if err := json.Unmarshal([]byte(template), &mapping); err != nil {
+			return nil, fmt.Errorf("格式模板不是有效的 JSON: %w", err)
+		}
+	}
+
+	var root interface{}
+	if err := json.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("供应商返回的不是有效的 JSON: %w", err)
+	}
+
+	list := root
+	if path := mapping["list"]; path != "" {
+		for _, key := range strings.Split(path, ".") {
+			obj, ok := list.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("未找到代理列表: %s", path)
+			}
+			list = obj[key]
+		}
+	} else if obj, ok := root.(map[string]interface{}); ok {
+		list = obj["data"]
+	}
+	items, ok := list.([]interface{})
+	if !ok {
+		return nil, errors.New("未找到代理列表")
+	}
+
+	var proxies []SuppliedProxy
+	for _, item := range items {
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if proxy, ok := suppliedProxy(obj, mapping); ok {
+			proxies = append(proxies, proxy)
+		}
+	}
+	return proxies, nil
+}
+
+// suppliedProxy 从字段值构造代理，mapping 为字段名映射，IP 或端口无效时返回 false
+func suppliedProxy(values map[string]interface{}, mapping map[string]string) (SuppliedProxy, bool) {
+	get := func(field string) string {
+		key := field
+		if mapped := mapping[field]; mapped != "" {
+			key = mapped
+		} else if field == "expire" && mapping != nil {
+			key = "expire_at"
+		}
+		switch v := values[key].(type) {
+		case string:
+			return strings.TrimSpace(v)
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return ""
+		}
+	}
+
+	protocol, ok := normalizeProxyProtocol(get("protocol"))
+	if !ok {
+		return SuppliedProxy{}, false
+	}
+	proxy := SuppliedProxy{
+		Protocol: protocol,
+		IP:       get("ip"),
+		Username: get("username"),
+		Password: get("password"),
+	}
+	port, err := strconv.Atoi(get("port"))
+	if err != nil || port < 1 || port > 65535 || proxy.IP == "" {
+		return SuppliedProxy{}, false
+	}
+	proxy.Port = port
+	if expire := get("expire"); expire != "" {
+		proxy.ExpiresAt = parseExpireTime(expire)
+	}
+	return proxy, true
+}
+
+// parseProxyLine 按批量导入支持的格式解析一行，行中未写协议时协议为空
+func parseProxyLine(line string) (SuppliedProxy, bool) {
+	parsed, err := utils.ParseProxyLine(line, "")
+	if err != nil {
+		return SuppliedProxy{}, false
+	}
+	return SuppliedProxy{
+		Protocol: parsed.Protocol,
+		IP:       parsed.IP,
+		Port:     parsed.Port,
+		Username: parsed.Username,
+		Password: parsed.Password,
+	}, true
+}
+
+// normalizeProxyProtocol 统一协议名称，空值原样返回，不支持的协议返回 false
+func normalizeProxyProtocol(protocol string) (string, bool) {
+	protocol = strings.ToLower(protocol)
+	switch protocol {
+	case "":
+		return "", true
+	case "socks", "socks5h":
+		return utils.ProxyProtocolSOCKS5, true
+	}
+	return protocol, utils.ValidProxyProtocol(protocol)
+}
+
+// parseExpireTime 解析到期时间，无法解析时返回 nil
+func parseExpireTime(value string) *time.Time {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		t := time.Unix(n, 0)
+		if n > 1e12 {
+			t = time.UnixMilli(n)
+		}
+		return &t
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
+
+func containsString(list []string, target string) bool {
+	for _, s := range list {
+		if s == target {
+			return true
+		}
+	}
+	return false
+}
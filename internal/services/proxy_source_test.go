@@ -0,0 +1,144 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// newSupplierServer 本地供应商接口，返回 body 并记录请求的数量参数
+func newSupplierServer(t *testing.T, status int, body string) (*httptest.Server, *string) {
+	t.Helper()
+	var count string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count = r.URL.Query().Get("num")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server, &count
+}
+
+func TestHTTPProxySourceFormats(t *testing.T) {
+	expire := time.Date(2030, 1, 2, 3, 4, 5, 0, time.Local)
+	tests := []struct {
+		name     string
+		format   string
+		template string
+		body     string
+		want     []SuppliedProxy
+	}{
+		{
+			name:     "文本模板",
+			format:   ProxyFormatText,
+			template: "{protocol}://{ip}:{port}#{username}/{password}@{expire}",
+			body: "socks5://1.1.1.1:1080#u1/p1@2030-01-02 03:04:05\n" +
+				"# 注释\n\n" +
+				"http://2.2.2.2:8080#u2/p2@\n" +
+				"ftp://3.3.3.3:21#u3/p3@\n" +
+				"4.4.4.4:99999\n",
+			want: []SuppliedProxy{
+				{Protocol: "socks5", IP: "1.1.1.1", Port: 1080, Username: "u1", Password: "p1", ExpiresAt: &expire},
+				{Protocol: "http", IP: "2.2.2.2", Port: 8080, Username: "u2", Password: "p2"},
+			},
+		},
+		{
+			name:   "文本默认格式",
+			format: ProxyFormatText,
+			body:   "1.1.1.1|1080|u1|p1\nsocks5h://u2:p2@2.2.2.2:1081\n3.3.3.3:3128\n无效行\n",
+			want: []SuppliedProxy{
+				{IP: "1.1.1.1", Port: 1080, Username: "u1", Password: "p1"},
+				{Protocol: "socks5", IP: "2.2.2.2", Port: 1081, Username: "u2", Password: "p2"},
+				{IP: "3.3.3.3", Port: 3128},
+			},
+		},
+		{
+			name:     "JSON字段映射",
+			format:   ProxyFormatJSON,
+			template: `{"list":"data.proxies","protocol":"type","ip":"host","port":"port","username":"user","password":"pass","expire":"expire_time"}`,
+			body: `{"code":0,"data":{"proxies":[
+				{"type":"socks","host":"1.1.1.1","port":1080,"user":"u1","pass":"p1","expire_time":` + strconv.FormatInt(expire.Unix(), 10) + `},
+				{"type":"https","host":"2.2.2.2","port":"443","user":"u2","pass":"p2"},
+				{"type":"socks5","host":"","port":1080},
+				"bad"
+			]}}`,
+			want: []SuppliedProxy{
+				{Protocol: "socks5", IP: "1.1.1.1", Port: 1080, Username: "u1", Password: "p1", ExpiresAt: &expire},
+				{Protocol: "https", IP: "2.2.2.2", Port: 443, Username: "u2", Password: "p2"},
+			},
+		},
+		{
+			name:   "JSON默认字段",
+			format: ProxyFormatJSON,
+			body:   `{"data":[{"ip":"1.1.1.1","port":1080,"username":"u1","password":"p1"}]}`,
+			want: []SuppliedProxy{
+				{IP: "1.1.1.1", Port: 1080, Username: "u1", Password: "p1"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, count := newSupplierServer(t, http.StatusOK, tt.body)
+			source, err := NewProxySource(&models.ProxySupplier{
+				SourceType: ProxySourceHTTP,
+				URL:        server.URL + "/get?num={count}",
+				Format:     tt.format,
+				Template:   tt.template,
+			})
+			if err != nil {
+				t.Fatalf("NewProxySource 失败: %v", err)
+			}
+
+			got, err := source.Fetch(context.Background(), 5)
+			if err != nil {
+				t.Fatalf("Fetch 失败: %v", err)
+			}
+			if *count != "5" {
+				t.Errorf("请求数量 = %q, 期望 5", *count)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %+v, 期望 %+v", got, tt.want)
+			}
+			for i := range got {
+				g, w := got[i], tt.want[i]
+				sameExpire := (g.ExpiresAt == nil) == (w.ExpiresAt == nil) && (g.ExpiresAt == nil || g.ExpiresAt.Equal(*w.ExpiresAt))
+				g.ExpiresAt, w.ExpiresAt = nil, nil
+				if g != w || !sameExpire {
+					t.Errorf("第%d个 %+v, 期望 %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPProxySourceErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   int
+		format   string
+		template string
+		body     string
+		wantErr  string
+	}{
+		{"接口返回错误", http.StatusInternalServerError, ProxyFormatText, "", "", "返回 500"},
+		{"JSON无效", http.StatusOK, ProxyFormatJSON, "", "not json", "不是有效的 JSON"},
+		{"未找到列表", http.StatusOK, ProxyFormatJSON, `{"list":"data.items"}`, `{"data":{}}`, "未找到代理列表"},
+		{"模板缺少端口", http.StatusOK, ProxyFormatText, "{ip}", "1.1.1.1", "必须包含"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := newSupplierServer(t, tt.status, tt.body)
+			source := &HTTPProxySource{URL: server.URL, Format: tt.format, Template: tt.template}
+			_, err := source.Fetch(context.Background(), 1)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, 期望包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
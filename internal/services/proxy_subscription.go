@@ -0,0 +1,299 @@
+package services
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/pkg/utils"
+)
+
+// 代理订阅输出格式
+const (
+	SubscriptionClash    = "clash"    // 完整的 Clash/Mihomo 配置
+	SubscriptionProvider = "provider" // Clash proxy-provider，只含 proxies
+	SubscriptionSingBox  = "singbox"  // sing-box JSON 配置
+	SubscriptionV2ray    = "v2ray"    // v2rayN 订阅：base64 编码的分享链接列表
+)
+
+// SubscriptionFormats 支持的订阅格式
+var SubscriptionFormats = []string{SubscriptionClash, SubscriptionProvider, SubscriptionSingBox, SubscriptionV2ray}
+
+// DefaultSubscriptionTestURL url-test/fallback 默认的测速地址
+const DefaultSubscriptionTestURL = "http://www.gstatic.com/generate_204"
+
+// 订阅配置中的代理组名称
+const (
+	subscriptionGroupSelect   = "代理"
+	subscriptionGroupURLTest  = "自动选择"
+	subscriptionGroupFallback = "故障转移"
+)
+
+// 局域网和本机地址直连，其余流量走代理
+var subscriptionDirectCIDRs = []string{"127.0.0.0/8", "10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10"}
+
+// GenerateSubscriptionToken 生成订阅令牌
+func GenerateSubscriptionToken() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "sub_" + hex.EncodeToString(b), nil
+}
+
+// SubscriptionProxies 订阅包含的代理：已启用且未到期，按订阅的设备分组、地区和协议筛选；
+// province/isp 不为空时在订阅的条件上再筛选
+func SubscriptionProxies(db *gorm.DB, sub *models.ProxySubscription, province, isp string) ([]models.Proxy, error) {
+	now := time.Now()
+	query := db.Where("is_active = ? AND (expires_at IS NULL OR expires_at > ?)", true, now)
+
+	if sub.DeviceGroup != "" {
+		query = query.Where("id IN (?)", db.Model(&models.ProxyLease{}).Select("proxy_id").
+			Where("expires_at > ? AND device_id IN (?)", now,
+				db.Model(&models.Device{}).Select("device_id").Where("group_name = ?", sub.DeviceGroup)))
+	}
+	if sub.Protocol != "" {
+		query = query.Where("protocol = ?", sub.Protocol)
+	}
+	query = ProxyTarget{Province: normalizeRegion(sub.Province), ISP: normalizeISP(sub.ISP)}.Apply(query)
+	query = ProxyTarget{Province: normalizeRegion(province), ISP: normalizeISP(isp)}.Apply(query)
+
+	var proxies []models.Proxy
+	err := query.Order("province ASC, city ASC, id ASC").Find(&proxies).Error
+	return proxies, err
+}
+
+// ProxyNodeName 客户端配置中的节点名称，如 "SOCKS5-广东深圳-42.101.12.24:11011"，同一配置中不重复
+func ProxyNodeName(proxy *models.Proxy) string {
+	region := proxy.Province + proxy.City
+	if region == "" {
+		return fmt.Sprintf("%s-%s:%d", strings.ToUpper(ProxyProtocol(proxy)), proxy.IP, proxy.Port)
+	}
+	return fmt.Sprintf("%s-%s-%s:%d", strings.ToUpper(ProxyProtocol(proxy)), region, proxy.IP, proxy.Port)
+}
+
+// ProxyProtocol 代理协议，协议字段加入前的代理为 socks5
+func ProxyProtocol(proxy *models.Proxy) string {
+	if proxy.Protocol == "" {
+		return utils.ProxyProtocolSOCKS5
+	}
+	return proxy.Protocol
+}
+
+// ClashProxy Clash/Mihomo 的代理节点
+func ClashProxy(proxy *models.Proxy) map[string]interface{} {
+	node := map[string]interface{}{
+		"name":   ProxyNodeName(proxy),
+		"type":   "socks5",
+		"server": proxy.IP,
+		"port":   proxy.Port,
+	}
+	switch ProxyProtocol(proxy) {
+	case utils.ProxyProtocolHTTP:
+		node["type"] = "http"
+	case utils.ProxyProtocolHTTPS:
+		node["type"] = "http"
+		node["tls"] = true
+	default:
+		node["udp"] = true
+	}
+	if proxy.Username != "" {
+		node["username"] = proxy.Username
+		node["password"] = proxy.Password
+	}
+	return node
+}
+
+// RenderClashProvider 输出 Clash proxy-provider 内容（只含 proxies）
+func RenderClashProvider(proxies []models.Proxy) []byte {
+	var buf bytes.Buffer
+	writeClashProxies(&buf, proxies)
+	return buf.Bytes()
+}
+
+// RenderClashConfig 输出完整的 Clash/Mihomo 配置：所有代理、url-test 和 fallback 代理组、手动选择组，
+// 局域网地址直连，其余流量走代理
+func RenderClashConfig(name string, proxies []models.Proxy, testURL string) []byte {
+	if testURL == "" {
+		testURL = DefaultSubscriptionTestURL
+	}
+	names := make([]string, len(proxies))
+	for i := range proxies {
+		names[i] = ProxyNodeName(&proxies[i])
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Subscription: %s\n# Generated by JD Task Platform at %s, %d proxies\n\n",
+		strings.Join(strings.Fields(name), " "), time.Now().Format("2006-01-02 15:04:05"), len(proxies))
+	buf.WriteString("mixed-port: 7890\nallow-lan: false\nmode: rule\nlog-level: info\n\n")
+
+	writeClashProxies(&buf, proxies)
+
+	// 没有代理时代理组指向 DIRECT，保持配置可加载
+	members := names
+	if len(members) == 0 {
+		members = []string{"DIRECT"}
+	}
+	buf.WriteString("\nproxy-groups:\n")
+	fmt.Fprintf(&buf, "  - name: %s\n    type: select\n    proxies: %s\n",
+		yamlString(subscriptionGroupSelect),
+		yamlList(append([]string{subscriptionGroupURLTest, subscriptionGroupFallback}, names...)))
+	for _, group := range []struct{ name, kind string }{
+		{subscriptionGroupURLTest, "url-test"},
+		{subscriptionGroupFallback, "fallback"},
+	} {
+		fmt.Fprintf(&buf, "  - name: %s\n    type: %s\n    proxies: %s\n    url: %s\n    interval: 300\n",
+			yamlString(group.name), group.kind, yamlList(members), yamlString(testURL))
+		if group.kind == "url-test" {
+			buf.WriteString("    tolerance: 50\n")
+		}
+	}
+
+	buf.WriteString("\nrules:\n")
+	for _, cidr := range subscriptionDirectCIDRs {
+		fmt.Fprintf(&buf, "  - IP-CIDR,%s,DIRECT,no-resolve\n", cidr)
+	}
+	fmt.Fprintf(&buf, "  - MATCH,%s\n", subscriptionGroupSelect)
+	return buf.Bytes()
+}
+
+// writeClashProxies 输出 proxies 列表，每个节点为一行 JSON（YAML 的流式映射），账号密码中的特殊字符无需另外转义
+func writeClashProxies(buf *bytes.Buffer, proxies []models.Proxy) {
+	if len(proxies) == 0 {
+		buf.WriteString("proxies: []\n")
+		return
+	}
+	buf.WriteString("proxies:\n")
+	for i := range proxies {
+		node, _ := json.Marshal(ClashProxy(&proxies[i]))
+		fmt.Fprintf(buf, "  - %s\n", node)
+	}
+}
+
+// RenderSingBoxConfig 输出 sing-box 配置：本地 mixed 入站，selector + urltest 出站，局域网地址直连
+func RenderSingBoxConfig(proxies []models.Proxy, testURL string) ([]byte, error) {
+	if testURL == "" {
+		testURL = DefaultSubscriptionTestURL
+	}
+
+	names := make([]string, 0, len(proxies))
+	nodes := make([]map[string]interface{}, 0, len(proxies))
+	for i := range proxies {
+		proxy := &proxies[i]
+		node := map[string]interface{}{
+			"tag":         ProxyNodeName(proxy),
+			"type":        "socks",
+			"server":      proxy.IP,
+			"server_port": proxy.Port,
+		}
+		switch ProxyProtocol(proxy) {
+		case utils.ProxyProtocolHTTP:
+			node["type"] = "http"
+		case utils.ProxyProtocolHTTPS:
+			node["type"] = "http"
+			node["tls"] = map[string]interface{}{"enabled": true}
+		default:
+			node["version"] = "5"
+		}
+		if proxy.Username != "" {
+			node["username"] = proxy.Username
+			node["password"] = proxy.Password
+		}
+		names = append(names, ProxyNodeName(proxy))
+		nodes = append(nodes, node)
+	}
+
+	members := names
+	if len(members) == 0 {
+		members = []string{"direct"}
+	}
+	outbounds := []map[string]interface{}{
+		{
+			"tag":       subscriptionGroupSelect,
+			"type":      "selector",
+			"outbounds": append([]string{subscriptionGroupURLTest}, members...),
+			"default":   subscriptionGroupURLTest,
+		},
+		{
+			"tag":       subscriptionGroupURLTest,
+			"type":      "urltest",
+			"outbounds": members,
+			"url":       testURL,
+			"interval":  "5m",
+			"tolerance": 50,
+		},
+	}
+	outbounds = append(outbounds, nodes...)
+	outbounds = append(outbounds, map[string]interface{}{"tag": "direct", "type": "direct"})
+
+	config := map[string]interface{}{
+		"log": map[string]interface{}{"level": "warn"},
+		"inbounds": []map[string]interface{}{
+			{"tag": "mixed-in", "type": "mixed", "listen": "127.0.0.1", "listen_port": 2080},
+		},
+		"outbounds": outbounds,
+		"route": map[string]interface{}{
+			"rules": []map[string]interface{}{
+				{"ip_is_private": true, "outbound": "direct"},
+			},
+			"final":                 subscriptionGroupSelect,
+			"auto_detect_interface": true,
+		},
+	}
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// RenderV2raySubscription 输出 v2rayN 订阅：每行一个分享链接，整体 base64 编码
+func RenderV2raySubscription(proxies []models.Proxy) []byte {
+	lines := make([]string, 0, len(proxies))
+	for i := range proxies {
+		proxy := &proxies[i]
+		lines = append(lines, utils.ProxyShareURL(ProxyProtocol(proxy), proxy.IP, proxy.Port, proxy.Username, proxy.Password))
+	}
+	return []byte(base64.StdEncoding.EncodeToString([]byte(strings.Join(lines, "\n"))))
+}
+
+// yamlString 输出 YAML 字符串标量，JSON 字符串是合法的 YAML 双引号字符串
+func yamlString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// yamlList 输出 YAML 流式列表
+func yamlList(items []string) string {
+	b, _ := json.Marshal(items)
+	return string(b)
+}
+
+// RecordProxySubscriptionAccess 记录订阅链接的访问，成功时累加订阅的访问次数
+func RecordProxySubscriptionAccess(db *gorm.DB, sub *models.ProxySubscription, format, result, clientIP, userAgent string) {
+	now := time.Now()
+	entry := models.ProxyConfigAccessLog{
+		Format:    format,
+		Result:    result,
+		ClientIP:  clientIP,
+		UserAgent: truncateError(userAgent, 255),
+		CreatedAt: now,
+	}
+	if sub != nil {
+		entry.SubscriptionID = sub.ID
+		if result == ProxyConfigAccessOK {
+			db.Model(sub).UpdateColumns(map[string]interface{}{
+				"access_count":   gorm.Expr("access_count + 1"),
+				"last_access_at": now,
+			})
+		}
+	}
+	if err := db.Create(&entry).Error; err != nil {
+		log.Printf("记录代理订阅访问失败: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package services
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// TaskStatusStat 某个状态下的任务汇总
+type TaskStatusStat struct {
+	Status        string
+	Tasks         int64 // 任务数
+	ExecuteCount  int64 // 执行次数合计
+	ExecutedCount int64 // 已执行次数合计
+}
+
+// TaskStatusStats 按状态汇总的任务统计
+type TaskStatusStats map[string]TaskStatusStat
+
+// Tasks 指定状态的任务数，不传状态时为全部任务数
+func (s TaskStatusStats) Tasks(statuses ...string) int64 {
+	var total int64
+	if len(statuses) == 0 {
+		for _, stat := range s {
+			total += stat.Tasks
+		}
+		return total
+	}
+	for _, status := range statuses {
+		total += s[status].Tasks
+	}
+	return total
+}
+
+// SummarizeTasksByStatus 在一次分组查询中按状态汇总任务数和执行次数
+// query 为已加好筛选条件的任务查询
+func SummarizeTasksByStatus(query *gorm.DB) (TaskStatusStats, error) {
+	var rows []TaskStatusStat
+	if err := query.Session(&gorm.Session{}).
+		Select("status, COUNT(*) AS tasks, COALESCE(SUM(execute_count), 0) AS execute_count, COALESCE(SUM(executed_count), 0) AS executed_count").
+		Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	stats := make(TaskStatusStats, len(rows))
+	for _, row := range rows {
+		stats[row.Status] = row
+	}
+	return stats, nil
+}
+
+// CountDevicesByStatus 在一次分组查询中按状态统计设备数
+func CountDevicesByStatus(db *gorm.DB) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := db.Model(&models.Device{}).Select("status, COUNT(*) AS count").
+		Group("status").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+// CompletedTaskTotals 已完成任务在两个时间段内的合计
+type CompletedTaskTotals struct {
+	Recent int64 // [recentFrom, to)
+	Total  int64 // [from, to)
+}
+
+// SumCompletedTasks 在一次查询中统计开始时间落在 [from, to) 和 [recentFrom, to) 内的已完成任务
+// byCount 为 true 时统计任务数，否则统计已执行次数
+func SumCompletedTasks(query *gorm.DB, from, recentFrom, to time.Time, byCount bool) (CompletedTaskTotals, error) {
+	value := "executed_count"
+	if byCount {
+		value = "1"
+	}
+
+	var totals CompletedTaskTotals
+	err := query.Session(&gorm.Session{}).
+		Select("COALESCE(SUM(CASE WHEN start_time >= ? THEN "+value+" ELSE 0 END), 0) AS recent, COALESCE(SUM("+value+"), 0) AS total", recentFrom).
+		Where("status = ? AND start_time >= ? AND start_time < ?", "completed", from, to).
+		Scan(&totals).Error
+	return totals, err
+}
+
+// Usernames 批量查询用户名，避免逐行查询用户
+func Usernames(db *gorm.DB, userIDs []uint) map[uint]string {
+	names := make(map[uint]string, len(userIDs))
+	if len(userIDs) == 0 {
+		return names
+	}
+
+	var users []models.User
+	db.Select("id", "username").Where("id IN ?", userIDs).Find(&users)
+	for _, u := range users {
+		names[u.ID] = u.Username
+	}
+	return names
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// 仪表盘统计的缓存时间
+const (
+	DashboardCacheTTL = 30 * time.Second
+	DeviceStatsTTL    = 10 * time.Second
+)
+
+// StatsCache 统计结果缓存，仪表盘在短时间内重复刷新时直接返回缓存的聚合结果
+type StatsCache struct {
+	mu      sync.Mutex
+	entries map[string]statsEntry
+}
+
+type statsEntry struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// 全局统计缓存实例
+var defaultStatsCache = NewStatsCache()
+
+// NewStatsCache 创建统计缓存
+func NewStatsCache() *StatsCache {
+	return &StatsCache{entries: make(map[string]statsEntry)}
+}
+
+// GetStatsCache 获取全局统计缓存
+func GetStatsCache() *StatsCache {
+	return defaultStatsCache
+}
+
+// Get 返回未过期的缓存结果，否则调用 load 计算并缓存 ttl 时长，load 出错时不缓存
+func (c *StatsCache) Get(key string, ttl time.Duration, load func() (interface{}, error)) (interface{}, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && now.Before(entry.expiresAt) {
+		c.mu.Unlock()
+		return entry.value, nil
+	}
+	c.mu.Unlock()
+
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, entry := range c.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = statsEntry{value: value, expiresAt: now.Add(ttl)}
+	return value, nil
+}
@@ -0,0 +1,283 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+var (
+	statsTaskStatuses   = []string{"waiting", "running", "completed", "failed", "cancelled"}
+	statsDeviceStatuses = []string{"online", "offline", "working", "idle"}
+)
+
+// seedStatsDB 创建 users 个用户、每个用户 tasksPerUser 个任务（状态和开始时间轮流分布在过去几天）和 devices 台设备
+func seedStatsDB(tb testing.TB, users, tasksPerUser, devices int) *gorm.DB {
+	tb.Helper()
+	db := newTestDB(tb, &models.User{}, &models.Task{}, &models.Device{})
+
+	now := time.Now()
+	for u := 1; u <= users; u++ {
+		db.Create(&models.User{Username: fmt.Sprintf("user%d", u), PasswordHash: "x", ApiKey: fmt.Sprintf("key%d", u), Role: "common", CreatedAt: now})
+	}
+
+	tasks := make([]models.Task, 0, users*tasksPerUser)
+	for i := 0; i < users*tasksPerUser; i++ {
+		tasks = append(tasks, models.Task{
+			UserID:        uint(i%users + 1),
+			TaskType:      "browse",
+			SKU:           "100001",
+			StartTime:     now.Add(-time.Duration(i%96) * time.Hour),
+			ExecuteCount:  i%5 + 1,
+			ExecutedCount: i % 3,
+			Status:        statsTaskStatuses[i%len(statsTaskStatuses)],
+			CreatedAt:     now,
+		})
+	}
+	if err := db.CreateInBatches(tasks, 200).Error; err != nil {
+		tb.Fatalf("创建任务失败: %v", err)
+	}
+
+	deviceRows := make([]models.Device, 0, devices)
+	for i := 0; i < devices; i++ {
+		deviceRows = append(deviceRows, models.Device{
+			DeviceID:   fmt.Sprintf("dev%d", i),
+			DeviceName: fmt.Sprintf("设备%d", i),
+			Status:     statsDeviceStatuses[i%len(statsDeviceStatuses)],
+			CreatedAt:  now,
+		})
+	}
+	if devices > 0 {
+		if err := db.CreateInBatches(deviceRows, 200).Error; err != nil {
+			tb.Fatalf("创建设备失败: %v", err)
+		}
+	}
+	return db
+}
+
+// 原实现：逐行查询任务所属用户
+func usernamesPerRow(db *gorm.DB, tasks []models.Task) map[uint]string {
+	names := make(map[uint]string)
+	for _, task := range tasks {
+		var user models.User
+		db.First(&user, task.UserID)
+		names[task.UserID] = user.Username
+	}
+	return names
+}
+
+// 原实现：每个状态单独统计一次
+func countTasksPerStatus(db *gorm.DB, userID uint) map[string]int64 {
+	counts := map[string]int64{}
+	var total int64
+	db.Model(&models.Task{}).Where("user_id = ?", userID).Count(&total)
+	counts[""] = total
+	for _, status := range statsTaskStatuses {
+		var n int64
+		db.Model(&models.Task{}).Where("user_id = ? AND status = ?", userID, status).Count(&n)
+		counts[status] = n
+	}
+	return counts
+}
+
+// 原实现：设备总数和每个状态各查一次
+func countDevicesPerStatus(db *gorm.DB) map[string]int64 {
+	counts := map[string]int64{}
+	var total int64
+	db.Model(&models.Device{}).Count(&total)
+	counts[""] = total
+	for _, status := range statsDeviceStatuses {
+		var n int64
+		db.Model(&models.Device{}).Where("status = ?", status).Count(&n)
+		counts[status] = n
+	}
+	return counts
+}
+
+// 原实现：昨日完成和过去3天完成分两次查询
+func sumCompletedSeparately(db *gorm.DB, from, recentFrom, to time.Time) CompletedTaskTotals {
+	var totals CompletedTaskTotals
+	db.Model(&models.Task{}).Where("status = ? AND start_time >= ? AND start_time < ?", "completed", recentFrom, to).
+		Select("COALESCE(SUM(executed_count), 0)").Scan(&totals.Recent)
+	db.Model(&models.Task{}).Where("status = ? AND start_time >= ? AND start_time < ?", "completed", from, to).
+		Select("COALESCE(SUM(executed_count), 0)").Scan(&totals.Total)
+	return totals
+}
+
+func pageTasks(tb testing.TB, db *gorm.DB) []models.Task {
+	var tasks []models.Task
+	if err := db.Order("created_at DESC, id DESC").Limit(20).Find(&tasks).Error; err != nil {
+		tb.Fatalf("查询任务失败: %v", err)
+	}
+	return tasks
+}
+
+func completedRange() (from, recentFrom, to time.Time) {
+	now := time.Now()
+	to = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return to.Add(-72 * time.Hour), to.Add(-24 * time.Hour), to
+}
+
+// 新旧查询的结果必须一致
+func TestStatsMatchPerRowQueries(t *testing.T) {
+	db := seedStatsDB(t, 10, 20, 37)
+
+	tasks := pageTasks(t, db)
+	userIDs := make([]uint, 0, len(tasks))
+	for _, task := range tasks {
+		userIDs = append(userIDs, task.UserID)
+	}
+	names := Usernames(db, userIDs)
+	for id, name := range usernamesPerRow(db, tasks) {
+		if names[id] != name {
+			t.Errorf("用户 %d: %q, 期望 %q", id, names[id], name)
+		}
+	}
+
+	summary, err := SummarizeTasksByStatus(db.Model(&models.Task{}).Where("user_id = ?", 3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for status, want := range countTasksPerStatus(db, 3) {
+		got := summary.Tasks()
+		if status != "" {
+			got = summary.Tasks(status)
+		}
+		if got != want {
+			t.Errorf("任务状态 %q: %d, 期望 %d", status, got, want)
+		}
+	}
+
+	devices, err := CountDevicesByStatus(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var deviceTotal int64
+	for _, n := range devices {
+		deviceTotal += n
+	}
+	for status, want := range countDevicesPerStatus(db) {
+		got := devices[status]
+		if status == "" {
+			got = deviceTotal
+		}
+		if got != want {
+			t.Errorf("设备状态 %q: %d, 期望 %d", status, got, want)
+		}
+	}
+
+	from, recentFrom, to := completedRange()
+	completed, err := SumCompletedTasks(db.Model(&models.Task{}), from, recentFrom, to, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sumCompletedSeparately(db, from, recentFrom, to); completed != want || want.Total == 0 {
+		t.Errorf("已完成合计 %+v, 期望 %+v", completed, want)
+	}
+}
+
+func TestStatsCache(t *testing.T) {
+	cache := NewStatsCache()
+	loads := 0
+	load := func() (interface{}, error) {
+		loads++
+		return loads, nil
+	}
+
+	first, _ := cache.Get("k", time.Minute, load)
+	second, _ := cache.Get("k", time.Minute, load)
+	if first != 1 || second != 1 || loads != 1 {
+		t.Errorf("缓存期内应只计算一次: %v %v loads=%d", first, second, loads)
+	}
+
+	if _, err := cache.Get("err", time.Minute, func() (interface{}, error) { return nil, fmt.Errorf("失败") }); err == nil {
+		t.Error("load 出错时应返回错误")
+	}
+	if value, _ := cache.Get("expired", -time.Second, load); value != 2 {
+		t.Errorf("value = %v", value)
+	}
+	if value, _ := cache.Get("expired", time.Minute, load); value != 3 {
+		t.Errorf("过期后应重新计算: %v", value)
+	}
+}
+
+// 基准测试：go test ./internal/services -run ^$ -bench Stats -benchmem
+// 对比原来的逐行/逐状态查询和现在的批量/分组查询
+
+func BenchmarkStatsUsernames(b *testing.B) {
+	db := seedStatsDB(b, 50, 40, 0)
+	tasks := pageTasks(b, db)
+
+	b.Run("per_row", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			usernamesPerRow(db, tasks)
+		}
+	})
+	b.Run("batched", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			userIDs := make([]uint, 0, len(tasks))
+			for _, task := range tasks {
+				userIDs = append(userIDs, task.UserID)
+			}
+			Usernames(db, userIDs)
+		}
+	})
+}
+
+func BenchmarkStatsTaskStatus(b *testing.B) {
+	db := seedStatsDB(b, 50, 40, 0)
+
+	b.Run("per_status", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			countTasksPerStatus(db, 7)
+		}
+	})
+	b.Run("grouped", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			SummarizeTasksByStatus(db.Model(&models.Task{}).Where("user_id = ?", 7))
+		}
+	})
+}
+
+func BenchmarkStatsDeviceStatus(b *testing.B) {
+	db := seedStatsDB(b, 1, 0, 2000)
+
+	b.Run("per_status", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			countDevicesPerStatus(db)
+		}
+	})
+	b.Run("grouped", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			CountDevicesByStatus(db)
+		}
+	})
+	b.Run("cached", func(b *testing.B) {
+		cache := NewStatsCache()
+		for i := 0; i < b.N; i++ {
+			cache.Get("device_statistics", DeviceStatsTTL, func() (interface{}, error) {
+				return CountDevicesByStatus(db)
+			})
+		}
+	})
+}
+
+func BenchmarkStatsCompletedTasks(b *testing.B) {
+	db := seedStatsDB(b, 50, 40, 0)
+	from, recentFrom, to := completedRange()
+
+	b.Run("separate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			sumCompletedSeparately(db, from, recentFrom, to)
+		}
+	})
+	b.Run("single", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			SumCompletedTasks(db.Model(&models.Task{}), from, recentFrom, to, false)
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package services
+
+import (
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// RefreshTaskChain 根据各步骤任务的进度重新计算任务链状态
+// 在步骤任务进度或状态变化后调用（设备反馈、过期处理、取消）
+func RefreshTaskChain(db *gorm.DB, chainID uint) {
+	var steps []models.Task
+	if err := db.Where("chain_id = ?", chainID).Order("chain_step ASC").Find(&steps).Error; err != nil || len(steps) == 0 {
+		return
+	}
+
+	last := steps[len(steps)-1]
+	completedCount := last.ExecutedCount
+	if completedCount > last.ExecuteCount {
+		completedCount = last.ExecuteCount
+	}
+
+	finished, cancelled, started := 0, 0, false
+	for _, step := range steps {
+		switch step.Status {
+		case "completed", "partial_completed", "failed":
+			finished++
+		case "cancelled":
+			finished++
+			cancelled++
+		}
+		if step.ExecutedCount > 0 || step.Status == "running" {
+			started = true
+		}
+	}
+
+	status := "waiting"
+	switch {
+	case last.Status == "completed":
+		status = "completed"
+	case cancelled == len(steps):
+		status = "cancelled"
+	case finished == len(steps):
+		status = "partial_completed"
+	case started:
+		status = "running"
+	}
+
+	if err := db.Model(&models.TaskChain{}).Where("id = ?", chainID).Updates(map[string]interface{}{
+		"status":          status,
+		"completed_count": completedCount,
+		"updated_at":      time.Now(),
+	}).Error; err != nil {
+		log.Printf("更新任务链状态失败 (chain_id=%d): %v", chainID, err)
+	}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/taskstate"
+)
+
+// 任务有效时长限制（小时）
+const (
+	DefaultTaskExpireHours = 24
+	MinTaskExpireHours     = 1
+	MaxTaskExpireHours     = 7 * 24
+)
+
+// TaskEndTime 计算任务截止时间
+// overrideHours 为创建任务时指定的有效时长，0 表示使用任务类型的默认值
+func TaskEndTime(taskType *models.TaskType, start time.Time, overrideHours int) (time.Time, error) {
+	hours := DefaultTaskExpireHours
+	if taskType != nil && taskType.ExpireHours > 0 {
+		hours = taskType.ExpireHours
+	}
+	if overrideHours != 0 {
+		if overrideHours < MinTaskExpireHours || overrideHours > MaxTaskExpireHours {
+			return time.Time{}, fmt.Errorf("任务有效时长必须在%d-%d小时之间", MinTaskExpireHours, MaxTaskExpireHours)
+		}
+		hours = overrideHours
+	}
+	return start.Add(time.Duration(hours) * time.Hour), nil
+}
+
+// BackfillTaskEndTime 为升级前创建的任务补充截止时间（沿用原来的开始时间 + 24小时规则）
+func BackfillTaskEndTime(db *gorm.DB) {
+	result := db.Exec(
+		"UPDATE tasks SET end_time = DATE_ADD(start_time, INTERVAL (? + paused_seconds) SECOND) WHERE end_time IS NULL",
+		DefaultTaskExpireHours*3600,
+	)
+	if result.Error != nil {
+		log.Printf("补充任务截止时间失败: %v", result.Error)
+		return
+	}
+	if result.RowsAffected > 0 {
+		log.Printf("✓ 已为 %d 个历史任务补充截止时间", result.RowsAffected)
+	}
+}
+
+// ExpiredTasksQuery 过期任务查询条件（截止时间 < 当前时间且未完成）
+// 开启暂停顺延时，暂停中的任务计时停止，不会过期；否则暂停中的任务到期后同样按过期处理
+func ExpiredTasksQuery(db *gorm.DB, now time.Time) *gorm.DB {
+	statuses := []string{taskstate.Waiting, taskstate.Running}
+	if !PauseExtendsExpiry(db) {
+		statuses = append(statuses, taskstate.Paused)
+	}
+	return db.Where(
+		"end_time < ? AND status IN ? AND executed_count < execute_count",
+		now, statuses,
+	)
+}
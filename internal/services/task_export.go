@@ -0,0 +1,378 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/taskstate"
+)
+
+// 导出格式
+const (
+	ExportFormatCSV    = "csv"
+	ExportFormatXLSX   = "xlsx"
+	ExportFormatNDJSON = "ndjson"
+)
+
+// exportBatchSize 导出时每批读取的任务数，按ID分批读取，不一次性加载全部任务
+const exportBatchSize = 500
+
+// TaskExportFilter 任务导出筛选条件，与任务列表接口的筛选条件一致
+type TaskExportFilter struct {
+	UserID    uint     // 为0时导出全部用户（仅管理员）
+	Statuses  []string // 任务状态，多个为任一匹配
+	TaskType  string
+	SKU       string // 模糊匹配
+	ShopName  string // 模糊匹配
+	Keyword   string // 模糊匹配
+	DateField string // 日期范围筛选的字段：created_at（默认）或 start_time
+	StartDate *time.Time
+	EndDate   *time.Time // 包含结束日期当天
+}
+
+// Apply 将筛选条件应用到任务查询
+func (f TaskExportFilter) Apply(query *gorm.DB) *gorm.DB {
+	if f.UserID > 0 {
+		query = query.Where("user_id = ?", f.UserID)
+	}
+	if len(f.Statuses) > 0 {
+		query = query.Where("status IN ?", f.Statuses)
+	}
+	if f.TaskType != "" {
+		query = query.Where("task_type = ?", f.TaskType)
+	}
+	if f.SKU != "" {
+		query = query.Where("sku LIKE ?", "%"+f.SKU+"%")
+	}
+	if f.ShopName != "" {
+		query = query.Where("shop_name LIKE ?", "%"+f.ShopName+"%")
+	}
+	if f.Keyword != "" {
+		query = query.Where("keyword LIKE ?", "%"+f.Keyword+"%")
+	}
+
+	dateField := "created_at"
+	if f.DateField == "start_time" {
+		dateField = "start_time"
+	}
+	if f.StartDate != nil {
+		query = query.Where(dateField+" >= ?", *f.StartDate)
+	}
+	if f.EndDate != nil {
+		query = query.Where(dateField+" < ?", f.EndDate.Add(24*time.Hour))
+	}
+	return query
+}
+
+// DeviceExecution 单台设备对任务的执行统计
+type DeviceExecution struct {
+	DeviceID string         `json:"device_id"`
+	Count    int            `json:"count"`
+	Statuses map[string]int `json:"statuses"` // 按反馈状态统计的次数
+}
+
+// TaskExportRow 导出的任务数据
+type TaskExportRow struct {
+	ID              uint              `json:"id"`
+	UserID          uint              `json:"user_id"`
+	Username        string            `json:"username"`
+	TaskType        string            `json:"task_type"`
+	TaskTypeName    string            `json:"task_type_name"`
+	SKU             string            `json:"sku"`
+	ShopName        string            `json:"shop_name"`
+	Keyword         string            `json:"keyword"`
+	Status          string            `json:"status"`
+	StatusText      string            `json:"status_text"`
+	Priority        int               `json:"priority"`
+	ExecuteCount    int               `json:"execute_count"`
+	ExecutedCount   int               `json:"executed_count"`
+	Progress        float64           `json:"progress"` // 执行进度百分比
+	ConsumeJingdou  int               `json:"consume_jingdou"`
+	RefundedJingdou int               `json:"refunded_jingdou"`
+	NetJingdou      int               `json:"net_jingdou"` // 实际消耗 = 消耗 - 退款
+	StartTime       time.Time         `json:"start_time"`
+	EndTime         *time.Time        `json:"end_time"`
+	Remark          string            `json:"remark"`
+	Devices         []DeviceExecution `json:"devices"`
+	CreatedAt       time.Time         `json:"created_at"`
+	UpdatedAt       time.Time         `json:"updated_at"`
+}
+
+var taskExportHeader = []string{
+	"任务ID", "用户ID", "用户名", "任务类型", "任务类型名称", "SKU", "店铺名称", "关键词",
+	"状态", "优先级", "执行次数", "已执行次数", "执行进度(%)",
+	"消耗京豆", "退款京豆", "实际消耗京豆", "开始时间", "结束时间", "备注", "设备执行明细", "创建时间",
+}
+
+// record CSV/XLSX 的一行
+func (r *TaskExportRow) record() []string {
+	endTime := ""
+	if r.EndTime != nil {
+		endTime = r.EndTime.Format("2006-01-02 15:04:05")
+	}
+
+	devices := make([]string, 0, len(r.Devices))
+	for _, d := range r.Devices {
+		statuses := make([]string, 0, len(d.Statuses))
+		for status, count := range d.Statuses {
+			statuses = append(statuses, fmt.Sprintf("%s:%d", status, count))
+		}
+		sort.Strings(statuses)
+		devices = append(devices, fmt.Sprintf("%s×%d(%s)", d.DeviceID, d.Count, strings.Join(statuses, ",")))
+	}
+
+	return []string{
+		strconv.FormatUint(uint64(r.ID), 10),
+		strconv.FormatUint(uint64(r.UserID), 10),
+		r.Username,
+		r.TaskType,
+		r.TaskTypeName,
+		r.SKU,
+		r.ShopName,
+		r.Keyword,
+		r.StatusText,
+		strconv.Itoa(r.Priority),
+		strconv.Itoa(r.ExecuteCount),
+		strconv.Itoa(r.ExecutedCount),
+		strconv.FormatFloat(r.Progress, 'f', 1, 64),
+		strconv.Itoa(r.ConsumeJingdou),
+		strconv.Itoa(r.RefundedJingdou),
+		strconv.Itoa(r.NetJingdou),
+		r.StartTime.Format("2006-01-02 15:04:05"),
+		endTime,
+		r.Remark,
+		strings.Join(devices, "; "),
+		r.CreatedAt.Format("2006-01-02 15:04:05"),
+	}
+}
+
+// EachTaskExportBatch 按ID顺序分批读取符合条件的任务，并补充用户名、退款和设备执行明细
+func EachTaskExportBatch(db *gorm.DB, filter TaskExportFilter, fn func(rows []TaskExportRow) error) error {
+	typeNames := make(map[string]string)
+	var taskTypes []models.TaskType
+	db.Find(&taskTypes)
+	for _, tt := range taskTypes {
+		typeNames[tt.TypeCode] = tt.TypeName
+	}
+	usernames := make(map[uint]string)
+
+	var lastID uint
+	for {
+		var tasks []models.Task
+		if err := filter.Apply(db.Model(&models.Task{})).
+			Where("id > ?", lastID).Order("id ASC").Limit(exportBatchSize).
+			Find(&tasks).Error; err != nil {
+			return err
+		}
+		if len(tasks) == 0 {
+			return nil
+		}
+		lastID = tasks[len(tasks)-1].ID
+
+		rows, err := buildTaskExportRows(db, tasks, typeNames, usernames)
+		if err != nil {
+			return err
+		}
+		if err := fn(rows); err != nil {
+			return err
+		}
+		if len(tasks) < exportBatchSize {
+			return nil
+		}
+	}
+}
+
+// buildTaskExportRows 为一批任务查询退款和设备执行明细
+func buildTaskExportRows(db *gorm.DB, tasks []models.Task, typeNames map[string]string, usernames map[uint]string) ([]TaskExportRow, error) {
+	taskIDs := make([]uint, 0, len(tasks))
+	var missingUsers []uint
+	for _, task := range tasks {
+		taskIDs = append(taskIDs, task.ID)
+		if _, ok := usernames[task.UserID]; !ok {
+			usernames[task.UserID] = ""
+			missingUsers = append(missingUsers, task.UserID)
+		}
+	}
+
+	if len(missingUsers) > 0 {
+		var users []models.User
+		if err := db.Select("id", "username").Where("id IN ?", missingUsers).Find(&users).Error; err != nil {
+			return nil, err
+		}
+		for _, u := range users {
+			usernames[u.ID] = u.Username
+		}
+	}
+
+	var refunds []struct {
+		RelatedID uint
+		Amount    int
+	}
+	if err := db.Model(&models.JingdouLog{}).
+		Select("related_id, SUM(amount) AS amount").
+		Where("operation_type = ? AND related_id IN ?", "refund", taskIDs).
+		Group("related_id").Scan(&refunds).Error; err != nil {
+		return nil, err
+	}
+	refundMap := make(map[uint]int, len(refunds))
+	for _, r := range refunds {
+		refundMap[r.RelatedID] = r.Amount
+	}
+
+	var executions []struct {
+		TaskID   uint
+		DeviceID string
+		Status   string
+		Count    int
+	}
+	if err := db.Model(&models.DeviceTaskHistory{}).
+		Select("task_id, device_id, status, COUNT(*) AS count").
+		Where("task_id IN ?", taskIDs).
+		Group("task_id, device_id, status").
+		Order("task_id, device_id").Scan(&executions).Error; err != nil {
+		return nil, err
+	}
+	deviceMap := make(map[uint][]DeviceExecution)
+	for _, e := range executions {
+		devices := deviceMap[e.TaskID]
+		if n := len(devices); n == 0 || devices[n-1].DeviceID != e.DeviceID {
+			devices = append(devices, DeviceExecution{DeviceID: e.DeviceID, Statuses: map[string]int{}})
+		}
+		d := &devices[len(devices)-1]
+		d.Count += e.Count
+		d.Statuses[e.Status] += e.Count
+		deviceMap[e.TaskID] = devices
+	}
+
+	rows := make([]TaskExportRow, 0, len(tasks))
+	for _, task := range tasks {
+		progress := 0.0
+		if task.ExecuteCount > 0 {
+			progress = float64(task.ExecutedCount) / float64(task.ExecuteCount) * 100
+		}
+		devices := deviceMap[task.ID]
+		if devices == nil {
+			devices = []DeviceExecution{}
+		}
+		rows = append(rows, TaskExportRow{
+			ID:              task.ID,
+			UserID:          task.UserID,
+			Username:        usernames[task.UserID],
+			TaskType:        task.TaskType,
+			TaskTypeName:    typeNames[task.TaskType],
+			SKU:             task.SKU,
+			ShopName:        task.ShopName,
+			Keyword:         task.Keyword,
+			Status:          task.Status,
+			StatusText:      taskstate.Text(task.Status),
+			Priority:        task.Priority,
+			ExecuteCount:    task.ExecuteCount,
+			ExecutedCount:   task.ExecutedCount,
+			Progress:        progress,
+			ConsumeJingdou:  task.ConsumeJingdou,
+			RefundedJingdou: refundMap[task.ID],
+			NetJingdou:      task.ConsumeJingdou - refundMap[task.ID],
+			StartTime:       task.StartTime,
+			EndTime:         task.EndTime,
+			Remark:          task.Remark,
+			Devices:         devices,
+			CreatedAt:       task.CreatedAt,
+			UpdatedAt:       task.UpdatedAt,
+		})
+	}
+	return rows, nil
+}
+
+// WriteTaskExport 按格式流式写出任务，每批写完即输出，内存占用与任务总数无关
+// format 为 csv、xlsx 或 ndjson
+func WriteTaskExport(db *gorm.DB, w io.Writer, format string, filter TaskExportFilter) error {
+	switch format {
+	case ExportFormatNDJSON:
+		encoder := json.NewEncoder(w)
+		return EachTaskExportBatch(db, filter, func(rows []TaskExportRow) error {
+			for i := range rows {
+				if err := encoder.Encode(&rows[i]); err != nil {
+					return err
+				}
+			}
+			flushWriter(w)
+			return nil
+		})
+
+	case ExportFormatXLSX:
+		// 流式写入器超过内存阈值后写入临时文件
+		f := excelize.NewFile()
+		defer f.Close()
+		sw, err := f.NewStreamWriter(f.GetSheetName(0))
+		if err != nil {
+			return err
+		}
+		line := 1
+		writeRow := func(record []string) error {
+			cell, _ := excelize.CoordinatesToCellName(1, line)
+			line++
+			values := make([]interface{}, len(record))
+			for i, v := range record {
+				values[i] = v
+			}
+			return sw.SetRow(cell, values)
+		}
+		if err := writeRow(taskExportHeader); err != nil {
+			return err
+		}
+		if err := EachTaskExportBatch(db, filter, func(rows []TaskExportRow) error {
+			for i := range rows {
+				if err := writeRow(rows[i].record()); err != nil {
+					return err
+				}
+			}
+			return nil
+		}); err != nil {
+			return err
+		}
+		if err := sw.Flush(); err != nil {
+			return err
+		}
+		return f.Write(w)
+	}
+
+	// 带 BOM 的 UTF-8，Excel 可直接打开
+	if _, err := w.Write([]byte("\xef\xbb\xbf")); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.Write(taskExportHeader); err != nil {
+		return err
+	}
+	if err := EachTaskExportBatch(db, filter, func(rows []TaskExportRow) error {
+		for i := range rows {
+			if err := writer.Write(rows[i].record()); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		flushWriter(w)
+		return writer.Error()
+	}); err != nil {
+		return err
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// flushWriter 响应支持分块输出时立即发送已写入的数据
+func flushWriter(w io.Writer) {
+	if f, ok := w.(interface{ Flush() }); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,702 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/xuri/excelize/v2"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// 导入状态
+const (
+	ImportStatusValidated = "validated" // 试运行，只做了校验
+	ImportStatusPending   = "pending"   // 等待后台导入
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// 导入行状态
+const (
+	ImportRowValid   = "valid"
+	ImportRowInvalid = "invalid"
+	ImportRowCreated = "created"
+	ImportRowFailed  = "failed"
+)
+
+const (
+	MaxImportRows   = 10000 // 单个文件最多导入的行数
+	ImportSyncRows  = 100   // 不超过该行数的文件在请求内直接导入，超过时转为后台导入
+	importChunkSize = 50    // 每批提交的任务数
+)
+
+// ImportField 可导入的任务字段
+type ImportField struct {
+	Key      string   `json:"key"`
+	Label    string   `json:"label"`
+	Required bool     `json:"required"`
+	Aliases  []string `json:"aliases"` // 自动识别的表头名称
+}
+
+// ImportFields 可导入的任务字段及默认识别的表头
+var ImportFields = []ImportField{
+	{Key: "task_type", Label: "任务类型", Required: true, Aliases: []string{"task_type", "任务类型", "类型"}},
+	{Key: "sku", Label: "SKU", Required: true, Aliases: []string{"sku", "商品sku", "商品编号", "商品id"}},
+	{Key: "shop_name", Label: "店铺名称", Aliases: []string{"shop_name", "店铺名称", "店铺"}},
+	{Key: "keyword", Label: "关键词", Aliases: []string{"keyword", "关键词", "搜索关键词"}},
+	{Key: "start_time", Label: "开始时间", Aliases: []string{"start_time", "开始时间"}},
+	{Key: "execute_count", Label: "执行次数", Required: true, Aliases: []string{"execute_count", "执行次数", "次数"}},
+	{Key: "priority", Label: "优先级", Aliases: []string{"priority", "优先级"}},
+	{Key: "expire_hours", Label: "有效时长", Aliases: []string{"expire_hours", "有效时长", "有效时长(小时)"}},
+	{Key: "remark", Label: "备注", Aliases: []string{"remark", "备注"}},
+}
+
+// importTimeLayouts 开始时间支持的格式
+var importTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+	"2006/1/2",
+}
+
+// ReadImportFile 读取 CSV/XLSX 文件，返回文件类型和所有行（第一行为表头）
+// CSV 支持 UTF-8（可带BOM）和 GBK 编码，XLSX 读取第一个工作表
+func ReadImportFile(fileName string, r io.Reader) (string, [][]string, error) {
+	var fileType string
+	var rows [][]string
+
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		content, err := io.ReadAll(r)
+		if err != nil {
+			return "", nil, err
+		}
+		content = bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))
+		if !utf8.Valid(content) {
+			if content, err = simplifiedchinese.GBK.NewDecoder().Bytes(content); err != nil {
+				return "", nil, fmt.Errorf("无法识别的文件编码，请保存为 UTF-8 或 GBK 编码")
+			}
+		}
+		reader := csv.NewReader(bytes.NewReader(content))
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		if rows, err = reader.ReadAll(); err != nil {
+			return "", nil, fmt.Errorf("CSV 格式错误: %v", err)
+		}
+		fileType = "csv"
+	case ".xlsx":
+		f, err := excelize.OpenReader(r)
+		if err != nil {
+			return "", nil, fmt.Errorf("无法读取 Excel 文件: %v", err)
+		}
+		defer f.Close()
+		sheets := f.GetSheetList()
+		if len(sheets) == 0 {
+			return "", nil, fmt.Errorf("Excel 文件中没有工作表")
+		}
+		if rows, err = f.GetRows(sheets[0]); err != nil {
+			return "", nil, fmt.Errorf("无法读取 Excel 工作表: %v", err)
+		}
+		fileType = "xlsx"
+	default:
+		return "", nil, fmt.Errorf("只支持 .csv 和 .xlsx 文件")
+	}
+
+	if len(rows) == 0 {
+		return "", nil, fmt.Errorf("文件为空")
+	}
+	return fileType, rows, nil
+}
+
+// ResolveImportMapping 确定每个字段对应的列
+// custom 为字段到表头名称的映射，未指定的字段按默认表头名称识别；defaults 中有默认值的必填字段可以不对应任何列
+func ResolveImportMapping(header []string, custom, defaults map[string]string) (map[string]int, error) {
+	index := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if _, exists := index[name]; !exists && name != "" {
+			index[name] = i
+		}
+	}
+
+	mapping := make(map[string]int)
+	for _, field := range ImportFields {
+		if column, ok := custom[field.Key]; ok && strings.TrimSpace(column) != "" {
+			i, found := index[strings.ToLower(strings.TrimSpace(column))]
+			if !found {
+				return nil, fmt.Errorf("字段「%s」映射的列「%s」不存在", field.Label, column)
+			}
+			mapping[field.Key] = i
+			continue
+		}
+		for _, alias := range field.Aliases {
+			if i, found := index[alias]; found {
+				mapping[field.Key] = i
+				break
+			}
+		}
+		if _, mapped := mapping[field.Key]; !mapped && field.Required && strings.TrimSpace(defaults[field.Key]) == "" {
+			return nil, fmt.Errorf("缺少必填列「%s」，请在列映射中指定或提供默认值", field.Label)
+		}
+	}
+	for key := range custom {
+		if !validImportField(key) {
+			return nil, fmt.Errorf("不支持的导入字段: %s", key)
+		}
+	}
+	return mapping, nil
+}
+
+// validImportField 是否为可导入的字段
+func validImportField(key string) bool {
+	for _, field := range ImportFields {
+		if field.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// importCell 读取一行中字段的值，单元格为空时使用默认值
+func importCell(cells []string, mapping map[string]int, defaults map[string]string, key string) string {
+	if i, ok := mapping[key]; ok && i < len(cells) {
+		if value := strings.TrimSpace(cells[i]); value != "" {
+			return value
+		}
+	}
+	return strings.TrimSpace(defaults[key])
+}
+
+// parseImportTime 解析开始时间，支持常见日期格式和 Excel 日期序列号
+func parseImportTime(value string) (time.Time, error) {
+	for _, layout := range importTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 {
+		if t, err := excelize.ExcelDateToTime(serial, false); err == nil {
+			// Excel 序列号没有时区，按本地时间解释
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.Local), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("开始时间格式错误: %s", value)
+}
+
+// ParseImportRow 解析并校验一行，返回任务参数和预计消耗的京豆
+func ParseImportRow(cells []string, mapping map[string]int, defaults map[string]string, taskTypes map[string]models.TaskType) (models.CreateTaskRequest, int, error) {
+	cell := func(key string) string { return importCell(cells, mapping, defaults, key) }
+
+	req := models.CreateTaskRequest{
+		TaskType: cell("task_type"),
+		SKU:      cell("sku"),
+		ShopName: cell("shop_name"),
+		Keyword:  cell("keyword"),
+		Remark:   cell("remark"),
+	}
+
+	if req.TaskType == "" {
+		return req, 0, fmt.Errorf("任务类型不能为空")
+	}
+	taskType, ok := taskTypes[req.TaskType]
+	if !ok {
+		return req, 0, fmt.Errorf("无效的任务类型: %s", req.TaskType)
+	}
+	if req.SKU == "" {
+		return req, 0, fmt.Errorf("SKU不能为空")
+	}
+
+	count, err := strconv.Atoi(cell("execute_count"))
+	if err != nil || count <= 0 {
+		return req, 0, fmt.Errorf("执行次数必须是正整数")
+	}
+	req.ExecuteCount = count
+
+	if value := cell("priority"); value != "" {
+		if req.Priority, err = strconv.Atoi(value); err != nil {
+			return req, 0, fmt.Errorf("优先级必须是整数")
+		}
+	}
+	if value := cell("expire_hours"); value != "" {
+		if req.ExpireHours, err = strconv.Atoi(value); err != nil {
+			return req, 0, fmt.Errorf("有效时长必须是整数（小时）")
+		}
+	}
+
+	req.StartTime = time.Now()
+	if value := cell("start_time"); value != "" {
+		if req.StartTime, err = parseImportTime(value); err != nil {
+			return req, 0, err
+		}
+	}
+
+	// 只有关键词搜索任务需要关键词参数
+	if req.TaskType == KeywordTaskType && req.Keyword == "" {
+		return req, 0, fmt.Errorf("关键词搜索任务必须填写关键词")
+	}
+	if req.TaskType != KeywordTaskType {
+		req.Keyword = ""
+	}
+	if _, err := TaskEndTime(&taskType, req.StartTime, req.ExpireHours); err != nil {
+		return req, 0, err
+	}
+
+	return req, taskType.JingdouPrice * req.ExecuteCount, nil
+}
+
+// ActiveTaskTypes 按类型代码索引的已启用任务类型
+func ActiveTaskTypes(db *gorm.DB) map[string]models.TaskType {
+	var types []models.TaskType
+	db.Where("is_active = ?", true).Find(&types)
+	byCode := make(map[string]models.TaskType, len(types))
+	for _, t := range types {
+		byCode[t.TypeCode] = t
+	}
+	return byCode
+}
+
+// CreateTaskImport 校验文件中的所有行并保存导入记录
+// dryRun 为 true 时只保存校验结果（状态 validated），否则状态为 pending 等待导入
+func CreateTaskImport(db *gorm.DB, user *models.User, fileName, fileType string, rows [][]string, custom, defaults map[string]string, dryRun bool) (*models.TaskImport, error) {
+	header := rows[0]
+	mapping, err := ResolveImportMapping(header, custom, defaults)
+	if err != nil {
+		return nil, err
+	}
+
+	// 跳过空行，保留文件中的原始行号
+	var dataRows []models.TaskImportRow
+	taskTypes := ActiveTaskTypes(db)
+	isAdmin := user.Role == "admin"
+	imp := models.TaskImport{
+		UserID:    user.ID,
+		FileName:  fileName,
+		FileType:  fileType,
+		Status:    ImportStatusPending,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if dryRun {
+		imp.Status = ImportStatusValidated
+	}
+
+	for i, cells := range rows[1:] {
+		if strings.TrimSpace(strings.Join(cells, "")) == "" {
+			continue
+		}
+		if len(dataRows) >= MaxImportRows {
+			return nil, fmt.Errorf("单个文件最多导入 %d 行", MaxImportRows)
+		}
+
+		raw, _ := json.Marshal(cells)
+		row := models.TaskImportRow{
+			Line:   i + 2,
+			Raw:    string(raw),
+			Status: ImportRowValid,
+		}
+		req, cost, err := ParseImportRow(cells, mapping, defaults, taskTypes)
+		if err != nil {
+			row.Status = ImportRowInvalid
+			row.Error = err.Error()
+			imp.InvalidRows++
+		} else {
+			if isAdmin {
+				cost = 0
+			}
+			data, _ := json.Marshal(req)
+			row.Data = string(data)
+			row.Jingdou = cost
+			imp.ValidRows++
+			imp.TotalJingdou += cost
+		}
+		dataRows = append(dataRows, row)
+	}
+	if len(dataRows) == 0 {
+		return nil, fmt.Errorf("文件中没有数据行")
+	}
+	imp.TotalRows = len(dataRows)
+
+	columns, _ := json.Marshal(header)
+	imp.Columns = string(columns)
+	mappedColumns := make(map[string]string, len(mapping))
+	for key, i := range mapping {
+		mappedColumns[key] = header[i]
+	}
+	mappingJSON, _ := json.Marshal(mappedColumns)
+	imp.Mapping = string(mappingJSON)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&imp).Error; err != nil {
+			return err
+		}
+		for i := range dataRows {
+			dataRows[i].ImportID = imp.ID
+		}
+		return tx.CreateInBatches(&dataRows, 500).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &imp, nil
+}
+
+// JobTaskImport 后台导入作业类型，处理超过同步行数的导入
+const JobTaskImport = "task_import"
+
+func init() {
+	RegisterResumableJob(JobTaskImport, "任务导入", runTaskImportJob)
+}
+
+// errImportRowTaken 导入行已被其他处理者提交
+var errImportRowTaken = errors.New("导入行已被处理")
+
+// SubmitTaskImportJob 提交后台导入作业并记录作业ID
+func SubmitTaskImportJob(db *gorm.DB, imp *models.TaskImport) (*models.Job, error) {
+	job, err := SubmitJob(db, JobTaskImport, map[string]interface{}{"import_id": imp.ID}, imp.UserID)
+	if err != nil {
+		return nil, err
+	}
+	imp.JobID = &job.ID
+	db.Model(&models.TaskImport{}).Where("id = ?", imp.ID).Update("job_id", job.ID)
+	return job, nil
+}
+
+// runTaskImportJob 后台导入作业，参数：import_id 导入ID
+// 作业中断后重新执行时从未处理的行继续
+func runTaskImportJob(job *JobContext) (interface{}, error) {
+	var params struct {
+		ImportID uint `json:"import_id"`
+	}
+	if err := job.Bind(&params); err != nil || params.ImportID == 0 {
+		return nil, fmt.Errorf("作业参数错误：缺少导入ID")
+	}
+
+	imp, err := processTaskImport(job, job.DB, params.ImportID, true, func(imp *models.TaskImport) error {
+		percent := 100
+		if imp.ValidRows > 0 {
+			percent = imp.ProcessedRows * 100 / imp.ValidRows
+		}
+		return job.Progress(percent, fmt.Sprintf("已处理 %d/%d 行", imp.ProcessedRows, imp.ValidRows))
+	})
+	if imp == nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"import_id":        imp.ID,
+		"status":           imp.Status,
+		"success_rows":     imp.SuccessRows,
+		"failed_rows":      imp.FailedRows,
+		"consumed_jingdou": imp.ConsumedJingdou,
+	}, err
+}
+
+// ProcessTaskImport 在请求内按批次创建导入中的有效行
+func ProcessTaskImport(ctx context.Context, db *gorm.DB, importID uint) error {
+	_, err := processTaskImport(ctx, db, importID, false, nil)
+	return err
+}
+
+// processTaskImport 按批次创建导入中的有效行
+// 每批通过任务服务在一个事务中扣费和创建任务，不符合创建规则的行标记为失败，余额不足时剩余行标记为失败；
+// 已提交的批次不会回滚，resume 为 true 时可以继续处理中断的导入
+func processTaskImport(ctx context.Context, db *gorm.DB, importID uint, resume bool, progress func(*models.TaskImport) error) (*models.TaskImport, error) {
+	statuses := []string{ImportStatusPending}
+	if resume {
+		statuses = append(statuses, ImportStatusRunning)
+	}
+	now := time.Now()
+	res := db.Model(&models.TaskImport{}).
+		Where("id = ? AND status IN ?", importID, statuses).
+		Updates(map[string]interface{}{"status": ImportStatusRunning, "error": "", "started_at": now, "updated_at": now})
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, nil // 已被其他处理者领取或已结束
+	}
+
+	var imp models.TaskImport
+	if err := db.First(&imp, importID).Error; err != nil {
+		return nil, err
+	}
+	actor := TaskActor{UserID: imp.UserID, Source: "导入任务"}
+	service := NewTaskService(db)
+
+	for {
+		if ctx.Err() != nil {
+			return &imp, suspendTaskImport(db, &imp)
+		}
+
+		var rows []models.TaskImportRow
+		db.Where("import_id = ? AND status = ?", imp.ID, ImportRowValid).
+			Order("id ASC").Limit(importChunkSize).Find(&rows)
+		if len(rows) == 0 {
+			break
+		}
+
+		result, err := commitImportChunk(ctx, db, service, actor, rows)
+		var balanceErr *InsufficientBalanceError
+		switch {
+		case errors.As(err, &balanceErr):
+			// 余额不足：剩余有效行全部标记为失败
+			failed := db.Model(&models.TaskImportRow{}).
+				Where("import_id = ? AND status = ?", imp.ID, ImportRowValid).
+				Updates(map[string]interface{}{"status": ImportRowFailed, "error": "京豆余额不足"})
+			imp.FailedRows += int(failed.RowsAffected)
+			imp.ProcessedRows += int(failed.RowsAffected)
+			return &imp, finishTaskImport(db, &imp, ImportStatusCompleted, "")
+		case errors.Is(err, ErrTaskUserNotFound):
+			return &imp, finishTaskImport(db, &imp, ImportStatusFailed, "用户不存在")
+		case err != nil && ctx.Err() != nil:
+			// 批次事务已回滚，本批的行仍为有效行
+			return &imp, suspendTaskImport(db, &imp)
+		case errors.Is(err, errImportRowTaken):
+			log.Printf("任务导入 #%d 正在由其他处理者导入", imp.ID)
+			return &imp, nil
+		case err != nil:
+			log.Printf("任务导入 #%d 提交失败: %v", imp.ID, err)
+			return &imp, finishTaskImport(db, &imp, ImportStatusFailed, "导入中断: "+err.Error())
+		}
+
+		imp.ProcessedRows += len(rows)
+		imp.SuccessRows += result.created
+		imp.FailedRows += len(rows) - result.created
+		imp.ConsumedJingdou += result.consume
+		db.Model(&models.TaskImport{}).Where("id = ?", imp.ID).Updates(map[string]interface{}{
+			"processed_rows":   imp.ProcessedRows,
+			"success_rows":     imp.SuccessRows,
+			"failed_rows":      imp.FailedRows,
+			"consumed_jingdou": imp.ConsumedJingdou,
+			"updated_at":       time.Now(),
+		})
+		publishImportProgress(&imp)
+		if progress != nil {
+			if err := progress(&imp); err != nil {
+				return &imp, suspendTaskImport(db, &imp)
+			}
+		}
+	}
+
+	return &imp, finishTaskImport(db, &imp, ImportStatusCompleted, "")
+}
+
+// importChunkResult 一批导入行的提交结果
+type importChunkResult struct {
+	created int // 创建成功的行数
+	consume int // 扣除的京豆
+}
+
+// commitImportChunk 通过任务服务为一批行创建任务
+// 不符合创建规则的行（如任务类型已停用、不在允许的时间段）单独标记为失败，其余行重新提交
+func commitImportChunk(ctx context.Context, db *gorm.DB, service *TaskService, actor TaskActor, rows []models.TaskImportRow) (importChunkResult, error) {
+	var result importChunkResult
+	pending := make([]*models.TaskImportRow, 0, len(rows))
+	specs := make([]TaskSpec, 0, len(rows))
+	for i := range rows {
+		row := &rows[i]
+		var req models.CreateTaskRequest
+		if err := json.Unmarshal([]byte(row.Data), &req); err != nil {
+			if err := failImportRow(db, row, "导入数据损坏"); err != nil {
+				return result, err
+			}
+			continue
+		}
+		pending = append(pending, row)
+		specs = append(specs, TaskSpec{
+			TaskType:     req.TaskType,
+			SKU:          req.SKU,
+			ShopName:     req.ShopName,
+			Keyword:      req.Keyword,
+			StartTime:    req.StartTime,
+			ExecuteCount: req.ExecuteCount,
+			Priority:     req.Priority,
+			Remark:       req.Remark,
+			ExpireHours:  req.ExpireHours,
+		})
+	}
+
+	for len(specs) > 0 {
+		created, err := service.CreateTasksWithOptions(ctx, actor, specs, CreateOptions{
+			SkipTemplate: true,
+			Created: func(tx *gorm.DB, tasks []models.Task) error {
+				for i := range tasks {
+					// 只提交仍待导入的行，同一导入被并发处理时回滚整批
+					res := tx.Model(&models.TaskImportRow{}).
+						Where("id = ? AND status = ?", pending[i].ID, ImportRowValid).
+						Updates(map[string]interface{}{"status": ImportRowCreated, "task_id": tasks[i].ID, "error": ""})
+					if res.Error != nil {
+						return res.Error
+					}
+					if res.RowsAffected == 0 {
+						return errImportRowTaken
+					}
+				}
+				return nil
+			},
+		})
+
+		var specErr *TaskSpecError
+		if errors.As(err, &specErr) {
+			if err := failImportRow(db, pending[specErr.Index], specErr.Err.Error()); err != nil {
+				return result, err
+			}
+			pending = append(pending[:specErr.Index], pending[specErr.Index+1:]...)
+			specs = append(specs[:specErr.Index], specs[specErr.Index+1:]...)
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+
+		result.created = len(created.Tasks)
+		result.consume = created.Consume
+		break
+	}
+	return result, nil
+}
+
+// failImportRow 将待导入的行标记为失败
+func failImportRow(db *gorm.DB, row *models.TaskImportRow, reason string) error {
+	res := db.Model(&models.TaskImportRow{}).
+		Where("id = ? AND status = ?", row.ID, ImportRowValid).
+		Updates(map[string]interface{}{"status": ImportRowFailed, "error": reason})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return errImportRowTaken
+	}
+	row.Status, row.Error = ImportRowFailed, reason
+	return nil
+}
+
+// finishTaskImport 结束导入
+func finishTaskImport(db *gorm.DB, imp *models.TaskImport, status, message string) error {
+	now := time.Now()
+	imp.Status = status
+	imp.Error = message
+	imp.FinishedAt = &now
+	err := db.Model(&models.TaskImport{}).Where("id = ?", imp.ID).Updates(map[string]interface{}{
+		"status":           status,
+		"error":            message,
+		"processed_rows":   imp.ProcessedRows,
+		"success_rows":     imp.SuccessRows,
+		"failed_rows":      imp.FailedRows,
+		"consumed_jingdou": imp.ConsumedJingdou,
+		"finished_at":      now,
+		"updated_at":       now,
+	}).Error
+	publishImportProgress(imp)
+	log.Printf("任务导入 #%d 结束: 成功 %d，失败 %d，扣除京豆 %d", imp.ID, imp.SuccessRows, imp.FailedRows, imp.ConsumedJingdou)
+	return err
+}
+
+// suspendTaskImport 导入被取消或执行中断时保存进度并退回等待状态，返回 ErrJobCancelled
+// 已处理的行保持不变，重新执行导入作业时从未处理的行继续
+func suspendTaskImport(db *gorm.DB, imp *models.TaskImport) error {
+	imp.Status = ImportStatusPending
+	imp.Error = "导入已中断，重新执行后从未处理的行继续"
+	err := db.Model(&models.TaskImport{}).Where("id = ? AND status = ?", imp.ID, ImportStatusRunning).Updates(map[string]interface{}{
+		"status":           imp.Status,
+		"error":            imp.Error,
+		"processed_rows":   imp.ProcessedRows,
+		"success_rows":     imp.SuccessRows,
+		"failed_rows":      imp.FailedRows,
+		"consumed_jingdou": imp.ConsumedJingdou,
+		"updated_at":       time.Now(),
+	}).Error
+	if err != nil {
+		return err
+	}
+	publishImportProgress(imp)
+	log.Printf("任务导入 #%d 已中断: 已处理 %d/%d 行", imp.ID, imp.ProcessedRows, imp.ValidRows)
+	return ErrJobCancelled
+}
+
+// publishImportProgress 推送导入进度
+func publishImportProgress(imp *models.TaskImport) {
+	GetEventBus().Publish(StreamImportProgress, imp.UserID, map[string]interface{}{
+		"import_id":      imp.ID,
+		"status":         imp.Status,
+		"valid_rows":     imp.ValidRows,
+		"processed_rows": imp.ProcessedRows,
+		"success_rows":   imp.SuccessRows,
+		"failed_rows":    imp.FailedRows,
+	})
+}
+
+// WriteImportResult 生成导入结果文件：原始列后追加导入结果、任务ID和错误信息
+// format 为 csv 或 xlsx
+func WriteImportResult(w io.Writer, format string, imp *models.TaskImport, rows []models.TaskImportRow) error {
+	var header []string
+	json.Unmarshal([]byte(imp.Columns), &header)
+	header = append(header, "导入结果", "任务ID", "消耗京豆", "错误信息")
+
+	records := make([][]string, 0, len(rows)+1)
+	records = append(records, header)
+	for _, row := range rows {
+		var cells []string
+		json.Unmarshal([]byte(row.Raw), &cells)
+		for len(cells) < len(header)-4 {
+			cells = append(cells, "")
+		}
+		taskID := ""
+		if row.TaskID != nil {
+			taskID = strconv.FormatUint(uint64(*row.TaskID), 10)
+		}
+		records = append(records, append(cells[:len(header)-4:len(header)-4],
+			importRowStatusText[row.Status], taskID, strconv.Itoa(row.Jingdou), row.Error))
+	}
+
+	if format == "xlsx" {
+		f := excelize.NewFile()
+		defer f.Close()
+		sheet := f.GetSheetName(0)
+		for i, record := range records {
+			cell, _ := excelize.CoordinatesToCellName(1, i+1)
+			values := make([]interface{}, len(record))
+			for j, v := range record {
+				values[j] = v
+			}
+			if err := f.SetSheetRow(sheet, cell, &values); err != nil {
+				return err
+			}
+		}
+		return f.Write(w)
+	}
+
+	// 带 BOM 的 UTF-8，Excel 可直接打开
+	if _, err := w.Write([]byte("\xef\xbb\xbf")); err != nil {
+		return err
+	}
+	writer := csv.NewWriter(w)
+	if err := writer.WriteAll(records); err != nil {
+		return err
+	}
+	return writer.Error()
+}
+
+var importRowStatusText = map[string]string{
+	ImportRowValid:   "待导入",
+	ImportRowInvalid: "校验失败",
+	ImportRowCreated: "已创建",
+	ImportRowFailed:  "创建失败",
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// newImportTestDB 在任务服务测试数据库基础上增加导入和作业表，closed 类型当前不在允许创建的时间段
+func newImportTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := newTaskServiceDB(t)
+	if err := db.AutoMigrate(&models.TaskImport{}, &models.TaskImportRow{}, &models.Job{}); err != nil {
+		t.Fatalf("创建测试表失败: %v", err)
+	}
+	slotStart := time.Now().Add(2 * time.Hour).Format("15:04")
+	slotEnd := time.Now().Add(3 * time.Hour).Format("15:04")
+	db.Create(&models.TaskType{TypeCode: "closed", TypeName: "限时", JingdouPrice: 10, IsActive: true,
+		TimeSlot1Start: &slotStart, TimeSlot1End: &slotEnd})
+	return db
+}
+
+func createImport(t *testing.T, db *gorm.DB, userID uint, lines ...string) *models.TaskImport {
+	t.Helper()
+	rows := [][]string{{"任务类型", "SKU", "执行次数", "关键词"}}
+	for _, line := range lines {
+		rows = append(rows, strings.Split(line, ","))
+	}
+	var user models.User
+	db.First(&user, userID)
+	imp, err := CreateTaskImport(db, &user, "tasks.csv", "csv", rows, nil, nil, false)
+	if err != nil {
+		t.Fatalf("创建导入失败: %v", err)
+	}
+	return imp
+}
+
+func importRows(db *gorm.DB, importID uint) []models.TaskImportRow {
+	var rows []models.TaskImportRow
+	db.Where("import_id = ?", importID).Order("line ASC").Find(&rows)
+	return rows
+}
+
+func TestProcessTaskImport(t *testing.T) {
+	db := newImportTestDB(t)
+	userID := seedTaskUser(t, db, "common", 1000)
+	imp := createImport(t, db, userID,
+		"browse,1001,2,",
+		"closed,1002,1,",
+		"search_browse,1003,1,手机",
+		"unknown,1004,1,",
+	)
+	// 校验后停用的任务类型在提交时失败
+	db.Model(&models.TaskType{}).Where("type_code = ?", KeywordTaskType).UpdateColumn("is_active", false)
+
+	if err := ProcessTaskImport(context.Background(), db, imp.ID); err != nil {
+		t.Fatalf("ProcessTaskImport 失败: %v", err)
+	}
+
+	db.First(imp, imp.ID)
+	if imp.Status != ImportStatusCompleted || imp.ProcessedRows != 3 || imp.SuccessRows != 1 || imp.FailedRows != 2 || imp.ConsumedJingdou != 20 {
+		t.Errorf("导入结果 %+v", imp)
+	}
+
+	rows := importRows(db, imp.ID)
+	want := []struct{ status, err string }{
+		{ImportRowCreated, ""},
+		{ImportRowFailed, "时段内允许创建任务"},
+		{ImportRowFailed, ErrTaskTypeDisabled.Error()},
+		{ImportRowInvalid, "无效的任务类型"},
+	}
+	for i, row := range rows {
+		if row.Status != want[i].status || !strings.Contains(row.Error, want[i].err) {
+			t.Errorf("第%d行 status=%s error=%q, 期望 %s %q", row.Line, row.Status, row.Error, want[i].status, want[i].err)
+		}
+	}
+	if rows[0].TaskID == nil {
+		t.Fatal("创建成功的行缺少任务ID")
+	}
+
+	var task models.Task
+	db.First(&task, *rows[0].TaskID)
+	var logs []models.JingdouLog
+	db.Find(&logs)
+	if task.ConsumeJingdou != 20 || userBalance(db, userID) != 980 || len(logs) != 1 || logs[0].Remark != "导入任务扣除 - SKU:1001" {
+		t.Errorf("任务 %+v 余额 %d 日志 %+v", task, userBalance(db, userID), logs)
+	}
+}
+
+func TestProcessTaskImportInsufficientBalance(t *testing.T) {
+	db := newImportTestDB(t)
+	userID := seedTaskUser(t, db, "common", 50)
+
+	lines := make([]string, 0, importChunkSize+2)
+	for i := 0; i < importChunkSize+2; i++ {
+		lines = append(lines, "browse,1001,1,")
+	}
+	imp := createImport(t, db, userID, lines...)
+
+	if err := ProcessTaskImport(context.Background(), db, imp.ID); err != nil {
+		t.Fatalf("ProcessTaskImport 失败: %v", err)
+	}
+
+	db.First(imp, imp.ID)
+	var created int64
+	db.Model(&models.TaskImportRow{}).Where("import_id = ? AND status = ?", imp.ID, ImportRowCreated).Count(&created)
+	if imp.Status != ImportStatusCompleted || created != 0 || imp.FailedRows != len(lines) || userBalance(db, userID) != 50 {
+		t.Errorf("余额不足时 %+v created=%d balance=%d", imp, created, userBalance(db, userID))
+	}
+}
+
+func TestTaskImportJobResumes(t *testing.T) {
+	db := newImportTestDB(t)
+	userID := seedTaskUser(t, db, "common", 1000)
+	imp := createImport(t, db, userID, "browse,1001,1,", "browse,1002,1,", "browse,1003,1,")
+
+	// 模拟中断：第一行已在上次执行中提交
+	first := importRows(db, imp.ID)[0]
+	task := models.Task{UserID: userID, TaskType: "browse", SKU: "1001", ExecuteCount: 1, Status: "waiting", StartTime: time.Now()}
+	db.Create(&task)
+	db.Model(&first).Updates(map[string]interface{}{"status": ImportRowCreated, "task_id": task.ID})
+	db.Model(imp).Updates(map[string]interface{}{"status": ImportStatusRunning, "processed_rows": 1, "success_rows": 1})
+
+	job, err := SubmitTaskImportJob(db, imp)
+	if err != nil {
+		t.Fatalf("提交导入作业失败: %v", err)
+	}
+	if _, err := runTaskImportJob(&JobContext{Context: context.Background(), DB: db, Job: job}); err != nil {
+		t.Fatalf("导入作业失败: %v", err)
+	}
+
+	db.First(imp, imp.ID)
+	var tasks int64
+	db.Model(&models.Task{}).Count(&tasks)
+	if imp.Status != ImportStatusCompleted || imp.SuccessRows != 3 || tasks != 3 || *imp.JobID != job.ID {
+		t.Errorf("续跑后 %+v tasks=%d", imp, tasks)
+	}
+	if userBalance(db, userID) != 980 {
+		t.Errorf("余额 = %d, 期望只扣除剩余两行", userBalance(db, userID))
+	}
+}
+
+func TestProcessTaskImportSkipsClaimed(t *testing.T) {
+	db := newImportTestDB(t)
+	userID := seedTaskUser(t, db, "common", 1000)
+	imp := createImport(t, db, userID, "browse,1001,1,")
+	db.Model(imp).Update("status", ImportStatusRunning)
+
+	// 请求内导入只领取等待中的导入
+	if err := ProcessTaskImport(context.Background(), db, imp.ID); err != nil {
+		t.Fatalf("ProcessTaskImport 失败: %v", err)
+	}
+	var tasks int64
+	db.Model(&models.Task{}).Count(&tasks)
+	if tasks != 0 {
+		t.Errorf("已被领取的导入不应重复处理")
+	}
+}
+
+// cancelWhen 满足条件后报告已取消的上下文
+type cancelWhen struct {
+	context.Context
+	cond func() bool
+}
+
+func (c cancelWhen) Err() error {
+	if c.cond() {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestTaskImportJobCancelledResumes(t *testing.T) {
+	db := newImportTestDB(t)
+	userID := seedTaskUser(t, db, "common", 1000)
+	lines := make([]string, 0, importChunkSize+2)
+	for i := 0; i < importChunkSize+2; i++ {
+		lines = append(lines, "browse,1001,1,")
+	}
+	imp := createImport(t, db, userID, lines...)
+	job, err := SubmitTaskImportJob(db, imp)
+	if err != nil {
+		t.Fatalf("提交导入作业失败: %v", err)
+	}
+
+	// 第一批提交后取消
+	ctx := cancelWhen{Context: context.Background(), cond: func() bool {
+		var tasks int64
+		db.Model(&models.Task{}).Count(&tasks)
+		return tasks > 0
+	}}
+	if _, err := runTaskImportJob(&JobContext{Context: ctx, DB: db, Job: job}); !errors.Is(err, ErrJobCancelled) {
+		t.Fatalf("err = %v, 期望 ErrJobCancelled", err)
+	}
+	db.First(imp, imp.ID)
+	if imp.Status != ImportStatusPending || imp.ProcessedRows != importChunkSize || imp.SuccessRows != importChunkSize {
+		t.Fatalf("取消后应保留进度并可继续: %+v", imp)
+	}
+
+	if _, err := runTaskImportJob(&JobContext{Context: context.Background(), DB: db, Job: job}); err != nil {
+		t.Fatalf("继续导入失败: %v", err)
+	}
+	db.First(imp, imp.ID)
+	var tasks int64
+	db.Model(&models.Task{}).Count(&tasks)
+	if imp.Status != ImportStatusCompleted || imp.SuccessRows != len(lines) || tasks != int64(len(lines)) || imp.Error != "" {
+		t.Errorf("继续导入后 %+v tasks=%d", imp, tasks)
+	}
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/taskstate"
+)
+
+// 暂停操作来源，用户不能恢复管理员暂停的任务
+const (
+	PausedByUser  = taskstate.ActorUser
+	PausedByAdmin = taskstate.ActorAdmin
+)
+
+// SettingPauseExtendsExpiry 暂停期间是否顺延任务过期时间
+const SettingPauseExtendsExpiry = "task_pause_extend_expiry"
+
+// PauseExtendsExpiry 读取暂停是否顺延过期时间的配置，默认顺延
+func PauseExtendsExpiry(db *gorm.DB) bool {
+	var setting models.Setting
+	if err := db.Where("param_key = ?", SettingPauseExtendsExpiry).First(&setting).Error; err != nil {
+		return true
+	}
+	return setting.ParamValue != "false"
+}
+
+// PauseTasks 暂停范围内所有等待中/执行中的任务
+// scope 用于限定任务范围（单个任务、某用户、某任务类型）
+func PauseTasks(db *gorm.DB, scope func(*gorm.DB) *gorm.DB, pausedBy, message string) (int, error) {
+	var results []*taskstate.Result
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var tasks []models.Task
+		if err := scope(tx.Model(&models.Task{})).
+			Where("status IN ?", []string{taskstate.Waiting, taskstate.Running}).
+			Find(&tasks).Error; err != nil {
+			return err
+		}
+
+		for i := range tasks {
+			result, err := taskstate.Apply(tx, &tasks[i], taskstate.Change{
+				To:     taskstate.Paused,
+				Actor:  pausedBy,
+				Reason: message,
+			})
+			if errors.Is(err, taskstate.ErrConflict) {
+				continue // 期间已被设备完成或其他操作修改
+			}
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	taskstate.Committed(db, results...)
+	return len(results), nil
+}
+
+// ResumeTasks 恢复范围内已暂停的任务
+// 普通用户只能恢复自己暂停的任务；开启暂停顺延时，截止时间按暂停时长顺延
+func ResumeTasks(db *gorm.DB, scope func(*gorm.DB) *gorm.DB, resumedBy, message string) (int, error) {
+	extend := PauseExtendsExpiry(db)
+	var results []*taskstate.Result
+	err := db.Transaction(func(tx *gorm.DB) error {
+		query := scope(tx.Model(&models.Task{})).Where("status = ?", taskstate.Paused)
+		if resumedBy != PausedByAdmin {
+			query = query.Where("paused_by = ?", PausedByUser)
+		}
+		var tasks []models.Task
+		if err := query.Find(&tasks).Error; err != nil {
+			return err
+		}
+		if len(tasks) == 0 {
+			return nil
+		}
+
+		// 先累计暂停时长并顺延截止时间，再恢复状态（恢复时会清空暂停时间）
+		if extend {
+			taskIDs := make([]uint, 0, len(tasks))
+			for _, task := range tasks {
+				taskIDs = append(taskIDs, task.ID)
+			}
+			paused := gorm.Expr("GREATEST(TIMESTAMPDIFF(SECOND, paused_at, ?), 0)", time.Now())
+			if err := tx.Model(&models.Task{}).Where("id IN ? AND paused_at IS NOT NULL", taskIDs).
+				Updates(map[string]interface{}{
+					"end_time":       gorm.Expr("DATE_ADD(end_time, INTERVAL ? SECOND)", paused),
+					"paused_seconds": gorm.Expr("paused_seconds + ?", paused),
+				}).Error; err != nil {
+				return err
+			}
+		}
+
+		for i := range tasks {
+			result, err := taskstate.Apply(tx, &tasks[i], taskstate.Change{
+				To:     taskstate.Waiting,
+				Actor:  resumedBy,
+				Reason: message,
+			})
+			if errors.Is(err, taskstate.ErrConflict) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	taskstate.Committed(db, results...)
+	return len(results), nil
+}
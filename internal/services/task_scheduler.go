@@ -0,0 +1,348 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// 周期任务规则类型
+const (
+	ScheduleTypeCron   = "cron"
+	ScheduleTypeDaily  = "daily"
+	ScheduleTypeWeekly = "weekly"
+)
+
+// 周期任务状态
+const (
+	ScheduleStatusActive    = "active"
+	ScheduleStatusPaused    = "paused"
+	ScheduleStatusCompleted = "completed"
+)
+
+// 单个周期任务每轮最多补生成的次数，避免服务长时间停机后集中扣费
+const maxScheduleCatchUp = 5
+
+// MinScheduleInterval 相邻两次执行的最短间隔
+const MinScheduleInterval = 10 * time.Minute
+
+// scheduleParser 只接受标准5段cron表达式，不支持 @every 等描述符
+var scheduleParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow)
+
+var runTimePattern = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+
+// ScheduleSpec 将周期任务规则转换为标准5段cron表达式
+func ScheduleSpec(schedule *models.TaskSchedule) (string, error) {
+	switch schedule.ScheduleType {
+	case ScheduleTypeCron:
+		if strings.TrimSpace(schedule.CronExpr) == "" {
+			return "", errors.New("cron类型必须填写cron表达式")
+		}
+		return strings.TrimSpace(schedule.CronExpr), nil
+	case ScheduleTypeDaily, ScheduleTypeWeekly:
+		if !runTimePattern.MatchString(schedule.RunTime) {
+			return "", errors.New("执行时间格式错误，应为 HH:MM")
+		}
+		hour, _ := strconv.Atoi(schedule.RunTime[:2])
+		minute, _ := strconv.Atoi(schedule.RunTime[3:])
+		if schedule.ScheduleType == ScheduleTypeDaily {
+			return fmt.Sprintf("%d %d * * *", minute, hour), nil
+		}
+
+		days := make([]string, 0, 7)
+		for _, part := range strings.Split(schedule.Weekdays, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			day, err := strconv.Atoi(part)
+			if err != nil || day < 0 || day > 6 {
+				return "", errors.New("星期设置错误，应为0-6（0表示周日）")
+			}
+			days = append(days, strconv.Itoa(day))
+		}
+		if len(days) == 0 {
+			return "", errors.New("weekly类型必须指定星期")
+		}
+		return fmt.Sprintf("%d %d * * %s", minute, hour, strings.Join(days, ",")), nil
+	default:
+		return "", errors.New("不支持的周期类型，可选 cron、daily、weekly")
+	}
+}
+
+// NextScheduleRun 计算 after 之后的下一次执行时间
+// 超过截止日期时返回 nil
+func NextScheduleRun(schedule *models.TaskSchedule, after time.Time) (*time.Time, error) {
+	spec, err := ScheduleSpec(schedule)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := parseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	next := parsed.Next(after)
+	if next.IsZero() || (schedule.EndDate != nil && next.After(*schedule.EndDate)) {
+		return nil, nil
+	}
+	return &next, nil
+}
+
+// parseSchedule 解析cron表达式并检查执行间隔不低于 MinScheduleInterval
+func parseSchedule(spec string) (cron.Schedule, error) {
+	if strings.HasPrefix(spec, "@") {
+		return nil, errors.New("cron表达式无效: 只支持标准5段cron表达式")
+	}
+	parsed, err := scheduleParser.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("cron表达式无效: %v", err)
+	}
+
+	// 取接下来的若干次执行时间检查间隔，覆盖一天内不均匀的表达式（如 "0,5 * * * *"）
+	prev := parsed.Next(time.Now())
+	for i := 0; i < 48 && !prev.IsZero(); i++ {
+		next := parsed.Next(prev)
+		if next.IsZero() {
+			break
+		}
+		if next.Sub(prev) < MinScheduleInterval {
+			return nil, fmt.Errorf("执行间隔不能小于%d分钟", int(MinScheduleInterval/time.Minute))
+		}
+		prev = next
+	}
+	return parsed, nil
+}
+
+// TaskSchedulerService 周期任务调度服务
+// 按周期任务定义提前生成任务记录，余额不足时暂停周期任务
+type TaskSchedulerService struct {
+	db        *gorm.DB
+	interval  time.Duration
+	lookahead time.Duration // 提前生成的时间窗口
+	stopChan  chan struct{}
+}
+
+// NewTaskSchedulerService 创建周期任务调度服务
+func NewTaskSchedulerService(db *gorm.DB) *TaskSchedulerService {
+	return &TaskSchedulerService{
+		db:        db,
+		interval:  time.Minute,      // 每分钟检查一次
+		lookahead: 30 * time.Minute, // 提前30分钟生成任务
+		stopChan:  make(chan struct{}),
+	}
+}
+
+// Start 启动周期任务调度服务
+func (s *TaskSchedulerService) Start() {
+	log.Printf("✓ 周期任务调度服务已启动（每分钟检查，提前%v生成任务）", s.lookahead)
+	go s.run()
+}
+
+// Stop 停止周期任务调度服务
+func (s *TaskSchedulerService) Stop() {
+	close(s.stopChan)
+	log.Println("周期任务调度服务已停止")
+}
+
+// run 运行调度循环
+func (s *TaskSchedulerService) run() {
+	s.processDueSchedules()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.processDueSchedules()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// processDueSchedules 生成即将到期的周期任务
+func (s *TaskSchedulerService) processDueSchedules() {
+	var schedules []models.TaskSchedule
+	if err := s.db.Where(
+		"status = ? AND next_run_at IS NOT NULL AND next_run_at <= ?",
+		ScheduleStatusActive, time.Now().Add(s.lookahead),
+	).Find(&schedules).Error; err != nil {
+		log.Printf("查询周期任务失败: %v", err)
+		return
+	}
+
+	created := 0
+	for i := range schedules {
+		for n := 0; n < maxScheduleCatchUp; n++ {
+			taskID, err := s.materialize(&schedules[i])
+			if err != nil {
+				log.Printf("周期任务 #%d 生成失败: %v", schedules[i].ID, err)
+				break
+			}
+			if taskID == 0 {
+				break
+			}
+			created++
+			if schedules[i].Status != ScheduleStatusActive || schedules[i].NextRunAt == nil ||
+				schedules[i].NextRunAt.After(time.Now().Add(s.lookahead)) {
+				break
+			}
+		}
+	}
+
+	if created > 0 {
+		log.Printf("周期任务调度完成，生成任务 %d 个", created)
+		GetDeviceHub().NotifyTaskAvailable()
+	}
+}
+
+// 周期任务生成中止的原因
+var (
+	errScheduleBudget   = errors.New("已达到京豆预算上限")
+	errScheduleConflict = errors.New("周期任务已被其他实例推进")
+)
+
+// materialize 为周期任务生成一次具体任务并扣除京豆
+// 任务按任务服务的统一规则创建，时间段按计划执行时间检查；返回生成的任务ID，未生成任务时返回0（例如周期任务被暂停或结束）
+func (s *TaskSchedulerService) materialize(schedule *models.TaskSchedule) (uint, error) {
+	if schedule.NextRunAt == nil {
+		return 0, nil
+	}
+
+	// 已达到生成次数上限
+	if schedule.MaxRuns > 0 && schedule.RunCount >= schedule.MaxRuns {
+		return 0, s.finish(schedule, ScheduleStatusCompleted, "已达到生成次数上限")
+	}
+
+	// 服务停机错过的执行时间从当前时间开始，避免生成即刻过期的任务
+	runAt := *schedule.NextRunAt
+	startTime := runAt
+	if startTime.Before(time.Now()) {
+		startTime = time.Now()
+	}
+
+	nextRun, err := NextScheduleRun(schedule, startTime)
+	if err != nil {
+		return 0, s.finish(schedule, ScheduleStatusPaused, err.Error())
+	}
+	// 本次生成后周期任务结束的原因
+	completeReason := ""
+	if nextRun == nil {
+		completeReason = "已超过截止日期"
+	} else if schedule.MaxRuns > 0 && schedule.RunCount+1 >= schedule.MaxRuns {
+		completeReason = "已达到生成次数上限"
+	}
+
+	spec := TaskSpec{
+		TaskType:     schedule.TaskType,
+		SKU:          schedule.SKU,
+		ShopName:     schedule.ShopName,
+		Keyword:      schedule.Keyword,
+		StartTime:    startTime,
+		ExecuteCount: schedule.ExecuteCount,
+		Priority:     schedule.Priority,
+		Remark:       schedule.Remark,
+	}
+	var consumeJingdou int
+	actor := TaskActor{UserID: schedule.UserID, Source: "周期任务"}
+	result, err := NewTaskService(s.db).CreateTasksWithOptions(context.Background(), actor, []TaskSpec{spec}, CreateOptions{
+		SlotTime:     runAt,
+		SkipTemplate: true,
+		Prepare: func(tx *gorm.DB, tasks []models.Task) error {
+			consumeJingdou = tasks[0].ConsumeJingdou
+			if schedule.BudgetCap > 0 && schedule.SpentJingdou+consumeJingdou > schedule.BudgetCap {
+				return errScheduleBudget
+			}
+			tasks[0].ScheduleID = &schedule.ID
+			return nil
+		},
+		Created: func(tx *gorm.DB, tasks []models.Task) error {
+			// 按生成次数条件推进，多个实例同时处理同一周期任务时只有一个成功
+			updates := map[string]interface{}{
+				"run_count":     schedule.RunCount + 1,
+				"spent_jingdou": schedule.SpentJingdou + consumeJingdou,
+				"last_run_at":   startTime,
+				"last_task_id":  tasks[0].ID,
+				"next_run_at":   nextRun,
+			}
+			if completeReason != "" {
+				updates["status"], updates["pause_reason"] = ScheduleStatusCompleted, completeReason
+			}
+			res := tx.Model(&models.TaskSchedule{}).
+				Where("id = ? AND run_count = ?", schedule.ID, schedule.RunCount).
+				Updates(updates)
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				return errScheduleConflict
+			}
+			return nil
+		},
+	})
+
+	var (
+		balanceErr *InsufficientBalanceError
+		slotErr    *TimeSlotError
+		specErr    *TaskSpecError
+	)
+	switch {
+	case errors.Is(err, errScheduleBudget):
+		return 0, s.finish(schedule, ScheduleStatusCompleted, "已达到京豆预算上限")
+	case errors.Is(err, errScheduleConflict):
+		return 0, nil
+	case errors.Is(err, ErrTaskUserNotFound):
+		return 0, s.finish(schedule, ScheduleStatusPaused, "用户不存在")
+	case errors.As(err, &balanceErr):
+		return 0, s.finish(schedule, ScheduleStatusPaused, fmt.Sprintf("京豆余额不足（需要%d）", balanceErr.Need))
+	case errors.Is(err, ErrTaskTypeInvalid), errors.Is(err, ErrTaskTypeDisabled):
+		return 0, s.finish(schedule, ScheduleStatusPaused, "任务类型不存在或已停用")
+	case errors.As(err, &slotErr):
+		return 0, s.finish(schedule, ScheduleStatusPaused, fmt.Sprintf("执行时间不在任务类型允许的时段内（%s）", slotErr.Slots))
+	case errors.As(err, &specErr):
+		return 0, s.finish(schedule, ScheduleStatusPaused, specErr.Err.Error())
+	case err != nil:
+		return 0, err
+	}
+
+	task := result.Tasks[0]
+	lastRun := startTime
+	schedule.RunCount++
+	schedule.SpentJingdou += consumeJingdou
+	schedule.LastRunAt = &lastRun
+	schedule.LastTaskID = &task.ID
+	schedule.NextRunAt = nextRun
+	if completeReason != "" {
+		schedule.Status, schedule.PauseReason = ScheduleStatusCompleted, completeReason
+	}
+
+	log.Printf("周期任务 #%d 已生成任务 #%d（开始时间 %s，扣除京豆 %d）",
+		schedule.ID, task.ID, startTime.Format("2006-01-02 15:04"), consumeJingdou)
+	return task.ID, nil
+}
+
+// finish 暂停或结束周期任务
+func (s *TaskSchedulerService) finish(schedule *models.TaskSchedule, status, reason string) error {
+	schedule.Status = status
+	schedule.PauseReason = reason
+	log.Printf("周期任务 #%d 已%s: %s", schedule.ID, map[string]string{
+		ScheduleStatusPaused:    "暂停",
+		ScheduleStatusCompleted: "结束",
+	}[status], reason)
+	return s.db.Model(&models.TaskSchedule{}).Where("id = ?", schedule.ID).Updates(map[string]interface{}{
+		"status":       status,
+		"pause_reason": reason,
+	}).Error
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+func TestNextScheduleRunInterval(t *testing.T) {
+	tests := []struct {
+		schedule models.TaskSchedule
+		wantErr  string
+	}{
+		{models.TaskSchedule{ScheduleType: ScheduleTypeCron, CronExpr: "*/10 * * * *"}, ""},
+		{models.TaskSchedule{ScheduleType: ScheduleTypeCron, CronExpr: "0 9 * * 1-5"}, ""},
+		{models.TaskSchedule{ScheduleType: ScheduleTypeDaily, RunTime: "09:30"}, ""},
+		{models.TaskSchedule{ScheduleType: ScheduleTypeWeekly, RunTime: "21:00", Weekdays: "0,6"}, ""},
+		{models.TaskSchedule{ScheduleType: ScheduleTypeCron, CronExpr: "@every 1s"}, "标准5段"},
+		{models.TaskSchedule{ScheduleType: ScheduleTypeCron, CronExpr: "@hourly"}, "标准5段"},
+		{models.TaskSchedule{ScheduleType: ScheduleTypeCron, CronExpr: "* * * * *"}, "执行间隔"},
+		{models.TaskSchedule{ScheduleType: ScheduleTypeCron, CronExpr: "*/5 * * * *"}, "执行间隔"},
+		{models.TaskSchedule{ScheduleType: ScheduleTypeCron, CronExpr: "0,5 9 * * *"}, "执行间隔"},
+		{models.TaskSchedule{ScheduleType: ScheduleTypeCron, CronExpr: "0 0 9 * * *"}, "cron表达式无效"},
+	}
+	for _, tt := range tests {
+		name := tt.schedule.CronExpr + tt.schedule.RunTime
+		t.Run(name, func(t *testing.T) {
+			next, err := NextScheduleRun(&tt.schedule, time.Now())
+			if tt.wantErr == "" {
+				if err != nil || next == nil {
+					t.Fatalf("next = %v err = %v", next, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, 期望包含 %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// seedSchedule 创建每天 runAt 执行的周期任务，下一次执行时间为明天的 runAt
+func seedSchedule(t *testing.T, db *gorm.DB, userID uint, taskType, runAt string) *models.TaskSchedule {
+	t.Helper()
+	tomorrow := time.Now().AddDate(0, 0, 1)
+	next, _ := time.ParseInLocation("2006-01-02 15:04", tomorrow.Format("2006-01-02")+" "+runAt, time.Local)
+	schedule := models.TaskSchedule{
+		UserID:       userID,
+		TaskType:     taskType,
+		SKU:          "100001",
+		ExecuteCount: 3,
+		ScheduleType: ScheduleTypeDaily,
+		RunTime:      runAt,
+		Status:       ScheduleStatusActive,
+		NextRunAt:    &next,
+	}
+	if err := db.Create(&schedule).Error; err != nil {
+		t.Fatalf("创建周期任务失败: %v", err)
+	}
+	return &schedule
+}
+
+func newSchedulerTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := newTaskServiceDB(t)
+	if err := db.AutoMigrate(&models.TaskSchedule{}); err != nil {
+		t.Fatalf("创建测试表失败: %v", err)
+	}
+	return db
+}
+
+func TestMaterialize(t *testing.T) {
+	db := newSchedulerTestDB(t)
+	userID := seedTaskUser(t, db, "common", 100)
+	schedule := seedSchedule(t, db, userID, "browse", "09:30")
+	runAt := *schedule.NextRunAt
+	s := NewTaskSchedulerService(db)
+
+	taskID, err := s.materialize(schedule)
+	if err != nil || taskID == 0 {
+		t.Fatalf("materialize = %d, %v", taskID, err)
+	}
+
+	var task models.Task
+	db.First(&task, taskID)
+	if task.ScheduleID == nil || *task.ScheduleID != schedule.ID || task.ConsumeJingdou != 30 || !task.StartTime.Equal(runAt) {
+		t.Errorf("生成的任务 %+v", task)
+	}
+	var saved models.TaskSchedule
+	db.First(&saved, schedule.ID)
+	if saved.RunCount != 1 || saved.SpentJingdou != 30 || *saved.LastTaskID != taskID || !saved.NextRunAt.Equal(runAt.AddDate(0, 0, 1)) {
+		t.Errorf("周期任务 %+v", saved)
+	}
+	var log models.JingdouLog
+	db.First(&log)
+	if userBalance(db, userID) != 70 || log.Amount != -30 || log.Remark != "周期任务扣除 - SKU:100001" {
+		t.Errorf("余额 %d 日志 %+v", userBalance(db, userID), log)
+	}
+}
+
+func TestMaterializePauses(t *testing.T) {
+	tests := []struct {
+		name       string
+		taskType   string
+		runAt      string
+		balance    int
+		prepare    func(db *gorm.DB, schedule *models.TaskSchedule)
+		wantStatus string
+		wantReason string
+	}{
+		{"不在时间段内", "morning", "11:00", 100, nil, ScheduleStatusPaused, "时段内（09:00-10:00）"},
+		{"余额不足", "browse", "09:30", 20, nil, ScheduleStatusPaused, "京豆余额不足（需要30）"},
+		{"任务类型已停用", "disabled", "09:30", 100, nil, ScheduleStatusPaused, "任务类型不存在或已停用"},
+		{"超过预算", "browse", "09:30", 100, func(db *gorm.DB, schedule *models.TaskSchedule) {
+			schedule.BudgetCap, schedule.SpentJingdou = 50, 30
+		}, ScheduleStatusCompleted, "已达到京豆预算上限"},
+		{"表达式已不合法", "browse", "09:30", 100, func(db *gorm.DB, schedule *models.TaskSchedule) {
+			schedule.ScheduleType, schedule.CronExpr = ScheduleTypeCron, "@every 1s"
+		}, ScheduleStatusPaused, "标准5段"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newSchedulerTestDB(t)
+			userID := seedTaskUser(t, db, "common", tt.balance)
+			schedule := seedSchedule(t, db, userID, tt.taskType, tt.runAt)
+			if tt.prepare != nil {
+				tt.prepare(db, schedule)
+			}
+
+			taskID, err := NewTaskSchedulerService(db).materialize(schedule)
+			if err != nil || taskID != 0 {
+				t.Fatalf("materialize = %d, %v", taskID, err)
+			}
+
+			var saved models.TaskSchedule
+			db.First(&saved, schedule.ID)
+			if saved.Status != tt.wantStatus || !strings.Contains(saved.PauseReason, tt.wantReason) {
+				t.Errorf("status = %s reason = %q, 期望 %s %q", saved.Status, saved.PauseReason, tt.wantStatus, tt.wantReason)
+			}
+			var tasks int64
+			db.Model(&models.Task{}).Count(&tasks)
+			if tasks != 0 || userBalance(db, userID) != tt.balance {
+				t.Errorf("未生成任务时不应扣费: tasks=%d balance=%d", tasks, userBalance(db, userID))
+			}
+		})
+	}
+}
+
+func TestMaterializeConflict(t *testing.T) {
+	db := newSchedulerTestDB(t)
+	userID := seedTaskUser(t, db, "common", 100)
+	schedule := seedSchedule(t, db, userID, "browse", "09:30")
+
+	// 其他实例已推进了周期任务
+	db.Model(&models.TaskSchedule{}).Where("id = ?", schedule.ID).Update("run_count", 1)
+
+	taskID, err := NewTaskSchedulerService(db).materialize(schedule)
+	if err != nil || taskID != 0 {
+		t.Fatalf("materialize = %d, %v", taskID, err)
+	}
+	var tasks int64
+	db.Model(&models.Task{}).Count(&tasks)
+	if tasks != 0 || userBalance(db, userID) != 100 {
+		t.Errorf("冲突时应回滚: tasks=%d balance=%d", tasks, userBalance(db, userID))
+	}
+}
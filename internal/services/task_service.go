@@ -0,0 +1,404 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// 任务创建规则校验失败的原因
+var (
+	ErrTaskUserNotFound     = errors.New("用户不存在")
+	ErrTaskTypeInvalid      = errors.New("任务类型不存在")
+	ErrTaskTypeDisabled     = errors.New("任务类型已禁用")
+	ErrTaskKeywordRequired  = errors.New("关键词搜索任务必须填写关键词")
+	ErrTaskExecuteCount     = errors.New("执行次数必须大于0")
+	ErrTaskTemplateNotFound = errors.New("任务模板不存在")
+)
+
+// KeywordTaskType 需要填写搜索关键词的任务类型，其他类型的关键词会被清空
+const KeywordTaskType = "search_browse"
+
+// TimeSlotError 当前时间不在任务类型允许创建的时间段内
+type TimeSlotError struct {
+	Slots string // 允许的时间段，如 "09:00-12:00, 14:00-18:00"
+}
+
+func (e *TimeSlotError) Error() string {
+	return "该任务类型仅在 " + e.Slots + " 时段内允许创建任务"
+}
+
+// InsufficientBalanceError 京豆余额不足
+type InsufficientBalanceError struct {
+	Need    int
+	Balance int
+}
+
+func (e *InsufficientBalanceError) Error() string {
+	return fmt.Sprintf("京豆余额不足：需要 %d 京豆，当前余额 %d 京豆", e.Need, e.Balance)
+}
+
+// TaskSpecError 某个任务的参数不符合创建规则，Index 从0开始
+type TaskSpecError struct {
+	Index int
+	Err   error
+}
+
+func (e *TaskSpecError) Error() string {
+	return fmt.Sprintf("第 %d 个任务：%v", e.Index+1, e.Err)
+}
+
+func (e *TaskSpecError) Unwrap() error {
+	return e.Err
+}
+
+// TaskActor 创建任务的用户
+type TaskActor struct {
+	UserID uint
+	Source string // 创建来源，写入京豆扣除日志备注，如 "创建任务"、"API创建任务"
+}
+
+// TaskSpec 待创建任务的参数
+type TaskSpec struct {
+	TaskType     string
+	SKU          string
+	ShopName     string
+	Keyword      string
+	StartTime    time.Time
+	ExecuteCount int
+	Priority     int
+	Remark       string
+	ExpireHours  int  // 有效时长（小时），0 表示使用任务类型配置
+	TemplateID   uint // 从模板创建时更新该模板，否则按 SKU 和任务类型更新或创建模板
+}
+
+// CreateTasksResult 任务创建结果
+type CreateTasksResult struct {
+	Tasks   []models.Task
+	Consume int  // 消耗京豆合计
+	Balance int  // 创建后的京豆余额
+	IsAdmin bool // 管理员创建不消耗京豆
+}
+
+// CreateOptions 任务创建的附加规则，任务链、导入和周期任务在同一事务中写入各自的关联记录
+type CreateOptions struct {
+	SlotTime     time.Time // 检查时间段使用的时间，零值为当前时间（周期任务按计划执行时间检查）
+	SkipTemplate bool      // 不更新任务模板
+
+	// Prepare 在事务中扣除京豆和创建任务前调用，可以设置任务的关联字段，返回错误时整体回滚
+	Prepare func(tx *gorm.DB, tasks []models.Task) error
+	// Created 在事务中创建任务后调用，返回错误时整体回滚
+	Created func(tx *gorm.DB, tasks []models.Task) error
+}
+
+// TaskService 任务创建服务，所有创建任务的接口共用同一套规则：
+// 任务类型启用检查、关键词要求、时间段限制、有效时长、定价、京豆扣除和模板更新
+type TaskService struct {
+	db *gorm.DB
+}
+
+// NewTaskService 创建任务服务
+func NewTaskService(db *gorm.DB) *TaskService {
+	return &TaskService{db: db}
+}
+
+// CreateTasks 校验并创建一批任务，全部成功或全部失败
+// 管理员创建不消耗京豆、不受时间段限制，也不记录任务模板
+func (s *TaskService) CreateTasks(ctx context.Context, actor TaskActor, specs []TaskSpec) (*CreateTasksResult, error) {
+	return s.CreateTasksWithOptions(ctx, actor, specs, CreateOptions{})
+}
+
+// CreateTasksWithOptions 按附加规则校验并创建一批任务，全部成功或全部失败
+func (s *TaskService) CreateTasksWithOptions(ctx context.Context, actor TaskActor, specs []TaskSpec, opts CreateOptions) (*CreateTasksResult, error) {
+	db := s.db.WithContext(ctx)
+
+	var user models.User
+	if err := db.First(&user, actor.UserID).Error; err != nil {
+		return nil, ErrTaskUserNotFound
+	}
+	isAdmin := user.Role == "admin"
+
+	now := time.Now()
+	slotTime := opts.SlotTime
+	if slotTime.IsZero() {
+		slotTime = now
+	}
+	taskTypes := make(map[string]*models.TaskType)
+	tasks := make([]models.Task, 0, len(specs))
+	consume := 0
+
+	for i := range specs {
+		spec := &specs[i]
+
+		taskType, ok := taskTypes[spec.TaskType]
+		if !ok {
+			var tt models.TaskType
+			if err := db.Where("type_code = ?", spec.TaskType).First(&tt).Error; err != nil {
+				return nil, &TaskSpecError{Index: i, Err: ErrTaskTypeInvalid}
+			}
+			taskType = &tt
+			taskTypes[spec.TaskType] = taskType
+		}
+
+		task, err := buildTask(&user, isAdmin, taskType, spec, slotTime, now)
+		if err != nil {
+			return nil, &TaskSpecError{Index: i, Err: err}
+		}
+		tasks = append(tasks, task)
+		consume += task.ConsumeJingdou
+	}
+
+	if !isAdmin && user.JingdouBalance < consume {
+		return nil, &InsufficientBalanceError{Need: consume, Balance: user.JingdouBalance}
+	}
+
+	balance := user.JingdouBalance
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if opts.Prepare != nil {
+			if err := opts.Prepare(tx, tasks); err != nil {
+				return err
+			}
+		}
+
+		if consume > 0 {
+			// 条件扣除，并发创建时不会扣成负数
+			res := tx.Model(&models.User{}).
+				Where("id = ? AND jingdou_balance >= ?", user.ID, consume).
+				Update("jingdou_balance", gorm.Expr("jingdou_balance - ?", consume))
+			if res.Error != nil {
+				return res.Error
+			}
+			if res.RowsAffected == 0 {
+				var current models.User
+				tx.Select("jingdou_balance").First(&current, user.ID)
+				return &InsufficientBalanceError{Need: consume, Balance: current.JingdouBalance}
+			}
+			var current models.User
+			if err := tx.Select("jingdou_balance").First(&current, user.ID).Error; err != nil {
+				return err
+			}
+			balance = current.JingdouBalance
+		}
+
+		if err := tx.Create(&tasks).Error; err != nil {
+			return err
+		}
+
+		// 按创建顺序记录每个任务的扣除日志，余额为扣除该任务后的余额
+		remaining := balance + consume
+		for i := range tasks {
+			task := &tasks[i]
+			if task.ConsumeJingdou > 0 {
+				remaining -= task.ConsumeJingdou
+				if err := tx.Create(&models.JingdouLog{
+					UserID:        user.ID,
+					Amount:        -task.ConsumeJingdou,
+					Balance:       remaining,
+					OperationType: "task",
+					RelatedID:     &task.ID,
+					Remark:        actor.Source + "扣除 - SKU:" + task.SKU,
+					CreatedAt:     now,
+				}).Error; err != nil {
+					return err
+				}
+			}
+
+			if opts.SkipTemplate {
+				continue
+			}
+			if err := s.updateTemplate(tx, user.ID, isAdmin, &specs[i], task); err != nil {
+				return err
+			}
+		}
+
+		if opts.Created != nil {
+			if err := opts.Created(tx, tasks); err != nil {
+				return err
+			}
+		}
+
+		EmitTasksCreated(tx, user.ID, tasks...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// 唤醒等待任务的设备，推送余额变化
+	GetDeviceHub().NotifyTaskAvailable()
+	PublishBalance(user.ID, balance, -consume, "task")
+
+	return &CreateTasksResult{
+		Tasks:   tasks,
+		Consume: consume,
+		Balance: balance,
+		IsAdmin: isAdmin,
+	}, nil
+}
+
+// buildTask 按任务类型规则校验参数并计算截止时间和京豆消耗，slotTime 为检查时间段使用的时间
+func buildTask(user *models.User, isAdmin bool, taskType *models.TaskType, spec *TaskSpec, slotTime, now time.Time) (models.Task, error) {
+	if !taskType.IsActive {
+		return models.Task{}, ErrTaskTypeDisabled
+	}
+	if spec.ExecuteCount <= 0 {
+		return models.Task{}, ErrTaskExecuteCount
+	}
+
+	// 只有关键词搜索任务需要关键词
+	keyword := spec.Keyword
+	if spec.TaskType == KeywordTaskType {
+		if keyword == "" {
+			return models.Task{}, ErrTaskKeywordRequired
+		}
+	} else {
+		keyword = ""
+	}
+	spec.Keyword = keyword
+
+	if !isAdmin {
+		if err := CheckTimeSlot(taskType, slotTime); err != nil {
+			return models.Task{}, err
+		}
+	}
+
+	endTime, err := TaskEndTime(taskType, spec.StartTime, spec.ExpireHours)
+	if err != nil {
+		return models.Task{}, err
+	}
+
+	consume := taskType.JingdouPrice * spec.ExecuteCount
+	if isAdmin {
+		consume = 0
+	}
+
+	return models.Task{
+		UserID:         user.ID,
+		TaskType:       spec.TaskType,
+		SKU:            spec.SKU,
+		ShopName:       spec.ShopName,
+		Keyword:        keyword,
+		StartTime:      spec.StartTime,
+		EndTime:        &endTime,
+		ExecuteCount:   spec.ExecuteCount,
+		Priority:       spec.Priority,
+		Status:         "waiting",
+		ConsumeJingdou: consume,
+		Remark:         spec.Remark,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}, nil
+}
+
+// CheckTimeSlot 检查当前时间是否在任务类型允许创建的时间段内，未配置时间段时不限制
+func CheckTimeSlot(taskType *models.TaskType, now time.Time) error {
+	slot1 := timeSlot(taskType.TimeSlot1Start, taskType.TimeSlot1End)
+	if slot1 == nil {
+		return nil
+	}
+	slots := [][2]string{*slot1}
+	if slot2 := timeSlot(taskType.TimeSlot2Start, taskType.TimeSlot2End); slot2 != nil {
+		slots = append(slots, *slot2)
+	}
+
+	current := now.Format("15:04")
+	ranges := make([]string, 0, len(slots))
+	for _, slot := range slots {
+		if current >= slot[0] && current <= slot[1] {
+			return nil
+		}
+		ranges = append(ranges, slot[0]+"-"+slot[1])
+	}
+	return &TimeSlotError{Slots: strings.Join(ranges, ", ")}
+}
+
+func timeSlot(start, end *string) *[2]string {
+	if start == nil || end == nil || *start == "" || *end == "" {
+		return nil
+	}
+	return &[2]string{*start, *end}
+}
+
+// updateTemplate 创建任务后更新任务模板
+// 从模板创建时更新该模板的使用次数和参数；否则普通用户按 SKU 和任务类型更新或创建模板
+func (s *TaskService) updateTemplate(tx *gorm.DB, userID uint, isAdmin bool, spec *TaskSpec, task *models.Task) error {
+	if spec.TemplateID == 0 {
+		if !isAdmin {
+			UpdateOrCreateTemplate(tx, userID, task.TaskType, task.SKU, task.ShopName, task.Keyword, task.ExecuteCount)
+		}
+		return nil
+	}
+
+	var template models.TaskTemplate
+	if err := tx.Where("id = ? AND user_id = ?", spec.TemplateID, userID).First(&template).Error; err != nil {
+		return ErrTaskTemplateNotFound
+	}
+	now := time.Now()
+	template.TotalCreatedCount += task.ExecuteCount
+	template.LastUsedAt = now
+	template.UpdatedAt = now
+	if task.Keyword != "" {
+		template.Keyword = task.Keyword
+	}
+	if task.ShopName != "" {
+		template.ShopName = task.ShopName
+	}
+	return tx.Save(&template).Error
+}
+
+// UpdateOrCreateTemplate 更新或创建任务模板（任务创建时调用）
+func UpdateOrCreateTemplate(db *gorm.DB, userID uint, taskType, sku, shopName, keyword string, executeCount int) {
+	var template models.TaskTemplate
+	err := db.Where("user_id = ? AND sku = ? AND task_type = ?", userID, sku, taskType).First(&template).Error
+
+	now := time.Now()
+	if err == gorm.ErrRecordNotFound {
+		// 创建新模板
+		template = models.TaskTemplate{
+			UserID:            userID,
+			TaskType:          taskType,
+			SKU:               sku,
+			ShopName:          shopName,
+			Keyword:           keyword,
+			TotalCreatedCount: executeCount,
+			LastUsedAt:        now,
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		}
+		db.Create(&template)
+	} else if err == nil {
+		// 更新已有模板
+		template.TotalCreatedCount += executeCount
+		template.LastUsedAt = now
+		template.UpdatedAt = now
+		// 如果关键词不同，追加
+		if keyword != "" && !containsKeyword(template.Keyword, keyword) {
+			if template.Keyword == "" {
+				template.Keyword = keyword
+			} else {
+				template.Keyword += "," + keyword
+			}
+		}
+		// 更新店铺名
+		if shopName != "" && template.ShopName != shopName {
+			template.ShopName = shopName
+		}
+		db.Save(&template)
+	}
+}
+
+// containsKeyword 检查逗号分隔的关键词列表是否包含指定关键词
+func containsKeyword(keywords, target string) bool {
+	for _, k := range strings.Split(keywords, ",") {
+		if k == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,329 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// newTaskServiceDB 创建任务服务测试数据库：浏览任务10京豆/次，关键词搜索任务20京豆/次，限时任务只能在 09:00-10:00 创建
+func newTaskServiceDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db := newTestDB(t, &models.User{}, &models.TaskType{}, &models.Task{}, &models.JingdouLog{},
+		&models.TaskTemplate{}, &models.Webhook{}, &models.WebhookDelivery{})
+
+	slotStart, slotEnd := "09:00", "10:00"
+	types := []models.TaskType{
+		{TypeCode: "browse", TypeName: "浏览", JingdouPrice: 10, IsActive: true, ExpireHours: 24},
+		{TypeCode: KeywordTaskType, TypeName: "关键词搜索", JingdouPrice: 20, IsActive: true, ExpireHours: 12},
+		{TypeCode: "morning", TypeName: "限时", JingdouPrice: 10, IsActive: true, TimeSlot1Start: &slotStart, TimeSlot1End: &slotEnd},
+		{TypeCode: "disabled", TypeName: "已停用", JingdouPrice: 10, IsActive: true},
+	}
+	if err := db.Create(&types).Error; err != nil {
+		t.Fatalf("创建任务类型失败: %v", err)
+	}
+	db.Model(&models.TaskType{}).Where("type_code = ?", "disabled").UpdateColumn("is_active", false)
+	return db
+}
+
+func seedTaskUser(t *testing.T, db *gorm.DB, role string, balance int) uint {
+	t.Helper()
+	var n int64
+	db.Model(&models.User{}).Count(&n)
+	user := models.User{
+		Username:       fmt.Sprintf("user%d", n+1),
+		PasswordHash:   "x",
+		ApiKey:         fmt.Sprintf("key%d", n+1),
+		Role:           role,
+		JingdouBalance: balance,
+		CreatedAt:      time.Now(),
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	return user.ID
+}
+
+func userBalance(db *gorm.DB, userID uint) int {
+	var user models.User
+	db.First(&user, userID)
+	return user.JingdouBalance
+}
+
+func browseSpec(count int) TaskSpec {
+	return TaskSpec{TaskType: "browse", SKU: "100001", StartTime: time.Now(), ExecuteCount: count}
+}
+
+func TestCreateTasksPricing(t *testing.T) {
+	db := newTaskServiceDB(t)
+	userID := seedTaskUser(t, db, "common", 1000)
+	start := time.Now().Add(time.Hour)
+
+	specs := []TaskSpec{
+		{TaskType: "browse", SKU: "100001", StartTime: start, ExecuteCount: 5, Keyword: "忽略"},
+		{TaskType: KeywordTaskType, SKU: "100002", StartTime: start, ExecuteCount: 3, Keyword: "手机", ExpireHours: 48},
+	}
+	result, err := NewTaskService(db).CreateTasks(context.Background(), TaskActor{UserID: userID, Source: "创建任务"}, specs)
+	if err != nil {
+		t.Fatalf("CreateTasks 失败: %v", err)
+	}
+
+	if result.Consume != 110 || result.Balance != 890 || result.IsAdmin || userBalance(db, userID) != 890 {
+		t.Errorf("消耗 = %d 余额 = %d, 期望 110 890", result.Consume, result.Balance)
+	}
+	browse, search := result.Tasks[0], result.Tasks[1]
+	if browse.ConsumeJingdou != 50 || browse.Keyword != "" || !browse.EndTime.Equal(start.Add(24*time.Hour)) {
+		t.Errorf("浏览任务 %+v", browse)
+	}
+	if search.ConsumeJingdou != 60 || search.Keyword != "手机" || !search.EndTime.Equal(start.Add(48*time.Hour)) {
+		t.Errorf("关键词任务 %+v", search)
+	}
+
+	// 每个任务一条扣除日志，余额按创建顺序递减
+	var logs []models.JingdouLog
+	db.Order("id ASC").Find(&logs)
+	if len(logs) != 2 || logs[0].Amount != -50 || logs[0].Balance != 950 || logs[1].Amount != -60 || logs[1].Balance != 890 {
+		t.Errorf("扣除日志 %+v", logs)
+	}
+	if *logs[0].RelatedID != browse.ID || logs[0].Remark != "创建任务扣除 - SKU:100001" {
+		t.Errorf("扣除日志 %+v", logs[0])
+	}
+
+	var templates int64
+	db.Model(&models.TaskTemplate{}).Where("user_id = ?", userID).Count(&templates)
+	if templates != 2 {
+		t.Errorf("任务模板数 = %d, 期望 2", templates)
+	}
+}
+
+func TestCreateTasksAdmin(t *testing.T) {
+	db := newTaskServiceDB(t)
+	adminID := seedTaskUser(t, db, "admin", 0)
+
+	// 管理员不扣京豆、不受时间段限制、不记录模板
+	specs := []TaskSpec{browseSpec(100), {TaskType: "morning", SKU: "1", StartTime: time.Now(), ExecuteCount: 1}}
+	result, err := NewTaskService(db).CreateTasksWithOptions(context.Background(), TaskActor{UserID: adminID}, specs,
+		CreateOptions{SlotTime: time.Date(2026, 1, 1, 23, 0, 0, 0, time.Local)})
+	if err != nil {
+		t.Fatalf("CreateTasks 失败: %v", err)
+	}
+	if !result.IsAdmin || result.Consume != 0 || result.Tasks[0].ConsumeJingdou != 0 {
+		t.Errorf("管理员创建结果 %+v", result)
+	}
+
+	var logs, templates int64
+	db.Model(&models.JingdouLog{}).Count(&logs)
+	db.Model(&models.TaskTemplate{}).Count(&templates)
+	if logs != 0 || templates != 0 {
+		t.Errorf("扣除日志 = %d 模板 = %d, 期望 0", logs, templates)
+	}
+}
+
+func TestCreateTasksRejects(t *testing.T) {
+	outside := time.Date(2026, 1, 1, 11, 0, 0, 0, time.Local)
+	inside := time.Date(2026, 1, 1, 9, 30, 0, 0, time.Local)
+
+	tests := []struct {
+		name    string
+		spec    TaskSpec
+		slot    time.Time
+		wantErr error
+	}{
+		{"任务类型不存在", TaskSpec{TaskType: "unknown", ExecuteCount: 1}, time.Time{}, ErrTaskTypeInvalid},
+		{"任务类型已停用", TaskSpec{TaskType: "disabled", ExecuteCount: 1}, time.Time{}, ErrTaskTypeDisabled},
+		{"执行次数为0", TaskSpec{TaskType: "browse", ExecuteCount: 0}, time.Time{}, ErrTaskExecuteCount},
+		{"关键词任务缺少关键词", TaskSpec{TaskType: KeywordTaskType, ExecuteCount: 1}, time.Time{}, ErrTaskKeywordRequired},
+		{"不在时间段内", TaskSpec{TaskType: "morning", ExecuteCount: 1}, outside, &TimeSlotError{}},
+		{"有效时长超出范围", TaskSpec{TaskType: "browse", ExecuteCount: 1, ExpireHours: MaxTaskExpireHours + 1}, time.Time{}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTaskServiceDB(t)
+			userID := seedTaskUser(t, db, "common", 1000)
+
+			// 第二个任务不符合规则，整批都不创建
+			specs := []TaskSpec{browseSpec(1), tt.spec}
+			_, err := NewTaskService(db).CreateTasksWithOptions(context.Background(), TaskActor{UserID: userID}, specs,
+				CreateOptions{SlotTime: tt.slot})
+
+			var specErr *TaskSpecError
+			if !errors.As(err, &specErr) || specErr.Index != 1 {
+				t.Fatalf("错误 = %v, 期望第2个任务的参数错误", err)
+			}
+			var slotErr *TimeSlotError
+			switch want := tt.wantErr.(type) {
+			case *TimeSlotError:
+				if !errors.As(err, &slotErr) || slotErr.Slots != "09:00-10:00" {
+					t.Errorf("错误 = %v, 期望时间段错误", err)
+				}
+			case error:
+				if !errors.Is(err, want) {
+					t.Errorf("错误 = %v, 期望 %v", err, want)
+				}
+			}
+
+			var tasks int64
+			db.Model(&models.Task{}).Count(&tasks)
+			if tasks != 0 || userBalance(db, userID) != 1000 {
+				t.Errorf("失败时不应创建任务或扣费: tasks=%d balance=%d", tasks, userBalance(db, userID))
+			}
+		})
+	}
+
+	t.Run("时间段内", func(t *testing.T) {
+		db := newTaskServiceDB(t)
+		userID := seedTaskUser(t, db, "common", 1000)
+		spec := TaskSpec{TaskType: "morning", SKU: "1", StartTime: time.Now(), ExecuteCount: 1}
+		if _, err := NewTaskService(db).CreateTasksWithOptions(context.Background(), TaskActor{UserID: userID},
+			[]TaskSpec{spec}, CreateOptions{SlotTime: inside}); err != nil {
+			t.Fatalf("CreateTasks 失败: %v", err)
+		}
+	})
+
+	t.Run("用户不存在", func(t *testing.T) {
+		db := newTaskServiceDB(t)
+		if _, err := NewTaskService(db).CreateTasks(context.Background(), TaskActor{UserID: 99}, []TaskSpec{browseSpec(1)}); !errors.Is(err, ErrTaskUserNotFound) {
+			t.Errorf("错误 = %v, 期望 %v", err, ErrTaskUserNotFound)
+		}
+	})
+}
+
+func TestCreateTasksInsufficientBalance(t *testing.T) {
+	db := newTaskServiceDB(t)
+	userID := seedTaskUser(t, db, "common", 45)
+
+	_, err := NewTaskService(db).CreateTasks(context.Background(), TaskActor{UserID: userID}, []TaskSpec{browseSpec(3), browseSpec(2)})
+	var balanceErr *InsufficientBalanceError
+	if !errors.As(err, &balanceErr) || balanceErr.Need != 50 || balanceErr.Balance != 45 {
+		t.Fatalf("错误 = %v, 期望余额不足", err)
+	}
+	if userBalance(db, userID) != 45 {
+		t.Errorf("余额 = %d, 期望 45", userBalance(db, userID))
+	}
+}
+
+func TestCreateTasksConcurrentDebit(t *testing.T) {
+	db := newTaskServiceDB(t)
+	userID := seedTaskUser(t, db, "common", 100)
+	service := NewTaskService(db)
+
+	// 每次消耗30京豆，并发创建时只有3次能成功，余额不会扣成负数
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded, insufficient := 0, 0
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := service.CreateTasks(context.Background(), TaskActor{UserID: userID}, []TaskSpec{browseSpec(3)})
+			mu.Lock()
+			defer mu.Unlock()
+			var balanceErr *InsufficientBalanceError
+			switch {
+			case err == nil:
+				succeeded++
+			case errors.As(err, &balanceErr):
+				insufficient++
+			default:
+				t.Errorf("CreateTasks 失败: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	var tasks int64
+	db.Model(&models.Task{}).Count(&tasks)
+	if succeeded != 3 || insufficient != 7 || tasks != 3 || userBalance(db, userID) != 10 {
+		t.Errorf("成功 %d 余额不足 %d 任务 %d 余额 %d", succeeded, insufficient, tasks, userBalance(db, userID))
+	}
+}
+
+func TestCreateTasksTemplate(t *testing.T) {
+	db := newTaskServiceDB(t)
+	userID := seedTaskUser(t, db, "common", 1000)
+	service := NewTaskService(db)
+	actor := TaskActor{UserID: userID}
+
+	spec := TaskSpec{TaskType: KeywordTaskType, SKU: "100001", StartTime: time.Now(), ExecuteCount: 2, Keyword: "手机"}
+	service.CreateTasks(context.Background(), actor, []TaskSpec{spec})
+	spec.Keyword, spec.ShopName = "耳机", "京东自营"
+	service.CreateTasks(context.Background(), actor, []TaskSpec{spec})
+
+	var template models.TaskTemplate
+	db.Where("user_id = ? AND sku = ?", userID, "100001").First(&template)
+	if template.TotalCreatedCount != 4 || template.Keyword != "手机,耳机" || template.ShopName != "京东自营" {
+		t.Errorf("任务模板 %+v", template)
+	}
+
+	// 从模板创建时更新该模板
+	spec.TemplateID, spec.Keyword = template.ID, "平板"
+	if _, err := service.CreateTasks(context.Background(), actor, []TaskSpec{spec}); err != nil {
+		t.Fatalf("从模板创建失败: %v", err)
+	}
+	db.First(&template, template.ID)
+	if template.TotalCreatedCount != 6 || template.Keyword != "平板" {
+		t.Errorf("任务模板 %+v", template)
+	}
+
+	spec.TemplateID = 999
+	if _, err := service.CreateTasks(context.Background(), actor, []TaskSpec{spec}); !errors.Is(err, ErrTaskTemplateNotFound) {
+		t.Errorf("错误 = %v, 期望 %v", err, ErrTaskTemplateNotFound)
+	}
+	if userBalance(db, userID) != 1000-40*3 {
+		t.Errorf("模板不存在时应回滚扣费: 余额 %d", userBalance(db, userID))
+	}
+}
+
+func TestCreateTasksOptions(t *testing.T) {
+	db := newTaskServiceDB(t)
+	userID := seedTaskUser(t, db, "common", 1000)
+
+	var calls []string
+	opts := CreateOptions{
+		SkipTemplate: true,
+		Prepare: func(tx *gorm.DB, tasks []models.Task) error {
+			calls = append(calls, fmt.Sprintf("prepare:%d", tasks[0].ID))
+			for i := range tasks {
+				tasks[i].Priority = 9
+			}
+			return nil
+		},
+		Created: func(tx *gorm.DB, tasks []models.Task) error {
+			calls = append(calls, fmt.Sprintf("created:%v", tasks[0].ID != 0))
+			return nil
+		},
+	}
+	result, err := NewTaskService(db).CreateTasksWithOptions(context.Background(), TaskActor{UserID: userID}, []TaskSpec{browseSpec(1)}, opts)
+	if err != nil {
+		t.Fatalf("CreateTasks 失败: %v", err)
+	}
+	var saved models.Task
+	db.First(&saved, result.Tasks[0].ID)
+	if saved.Priority != 9 || fmt.Sprint(calls) != "[prepare:0 created:true]" {
+		t.Errorf("priority = %d calls = %v", saved.Priority, calls)
+	}
+	var templates int64
+	db.Model(&models.TaskTemplate{}).Count(&templates)
+	if templates != 0 {
+		t.Errorf("SkipTemplate 时不应记录模板")
+	}
+
+	// 回调返回错误时整体回滚
+	hookErr := errors.New("写入关联记录失败")
+	opts.Created = func(tx *gorm.DB, tasks []models.Task) error { return hookErr }
+	if _, err := NewTaskService(db).CreateTasksWithOptions(context.Background(), TaskActor{UserID: userID}, []TaskSpec{browseSpec(1)}, opts); !errors.Is(err, hookErr) {
+		t.Fatalf("错误 = %v, 期望 %v", err, hookErr)
+	}
+	var tasks int64
+	db.Model(&models.Task{}).Count(&tasks)
+	if tasks != 1 || userBalance(db, userID) != 990 {
+		t.Errorf("回调失败后应回滚: tasks=%d balance=%d", tasks, userBalance(db, userID))
+	}
+}
@@ -0,0 +1,39 @@
+package services
+
+import (
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+	"jd-task-platform-go/internal/taskstate"
+)
+
+// taskStateEvents 任务进入终态时推送的 Webhook 事件
+var taskStateEvents = map[string]string{
+	taskstate.Completed:        EventTaskCompleted,
+	taskstate.PartialCompleted: EventTaskPartialCompleted,
+	taskstate.Cancelled:        EventTaskCancelled,
+}
+
+func init() {
+	// 状态变更写入时：与状态变更同一事务写入 Webhook 事件
+	taskstate.OnApplied(func(tx *gorm.DB, task *models.Task, result *taskstate.Result) error {
+		if event, ok := taskStateEvents[result.To]; ok {
+			return EnqueueTaskEvent(tx, event, task)
+		}
+		return nil
+	})
+
+	// 状态变更提交后：唤醒等待任务的设备，同步任务链进度，推送实时事件
+	taskstate.OnCommitted(func(db *gorm.DB, result *taskstate.Result) {
+		PublishTaskProgress(&result.Task)
+		if result.Refund > 0 {
+			PublishBalance(result.Task.UserID, result.Balance, result.Refund, "refund")
+		}
+		if result.Notify {
+			GetDeviceHub().NotifyTaskAvailable()
+		}
+		if result.ChainID != nil {
+			RefreshTaskChain(db, *result.ChainID)
+		}
+	})
+}
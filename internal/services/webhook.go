@@ -0,0 +1,472 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// Webhook 事件类型
+const (
+	EventTaskCreated          = "task.created"
+	EventTaskProgress         = "task.progress"
+	EventTaskCompleted        = "task.completed"
+	EventTaskPartialCompleted = "task.partial_completed"
+	EventTaskCancelled        = "task.cancelled"
+	EventBalanceLow           = "balance.low"
+)
+
+// WebhookEvents 支持订阅的全部事件
+var WebhookEvents = []string{
+	EventTaskCreated,
+	EventTaskProgress,
+	EventTaskCompleted,
+	EventTaskPartialCompleted,
+	EventTaskCancelled,
+	EventBalanceLow,
+}
+
+// 推送状态
+const (
+	DeliveryStatusPending = "pending"
+	DeliveryStatusSuccess = "success"
+	DeliveryStatusFailed  = "failed"
+)
+
+// 推送重试策略：第N次失败后等待 30秒 * 2^(N-1)，最长6小时，共尝试8次
+const (
+	webhookMaxAttempts = 8
+	webhookBaseBackoff = 30 * time.Second
+	webhookMaxBackoff  = 6 * time.Hour
+	webhookBatchSize   = 50
+	webhookWorkers     = 8                // 并发投递数，单个接收方响应慢不影响其他推送
+	webhookTimeout     = 10 * time.Second // 单次投递超时
+	webhookDrainSize   = 4096             // 读取并丢弃的响应内容上限，便于复用连接
+)
+
+// ErrWebhookAddress Webhook 地址指向内网、本机等不允许推送的地址
+var ErrWebhookAddress = errors.New("Webhook地址不能指向本机、内网或保留地址")
+
+// 推送请求头
+const (
+	WebhookHeaderEvent     = "X-Webhook-Event"
+	WebhookHeaderDelivery  = "X-Webhook-Delivery"
+	WebhookHeaderTimestamp = "X-Webhook-Timestamp"
+	WebhookHeaderSignature = "X-Webhook-Signature"
+)
+
+// WebhookPayload 推送的消息体
+type WebhookPayload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// ValidWebhookEvent 是否为支持订阅的事件
+func ValidWebhookEvent(event string) bool {
+	for _, e := range WebhookEvents {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// SignWebhookPayload 计算推送签名：hex(HMAC-SHA256(secret, timestamp + "." + body))
+// 接收方使用相同算法校验 X-Webhook-Signature（格式 sha256=<hex>）
+func SignWebhookPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ValidateWebhookURL 校验 Webhook 地址：必须是 http/https URL，且域名解析出的所有地址都不是本机、内网、
+// 链路本地、组播或未指定地址。返回规范化后的地址
+func ValidateWebhookURL(ctx context.Context, rawURL string) (string, error) {
+	parsed, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return "", errors.New("Webhook地址必须是有效的 http/https URL")
+	}
+
+	host := parsed.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !webhookIPAllowed(ip) {
+			return "", ErrWebhookAddress
+		}
+		return parsed.String(), nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return "", fmt.Errorf("无法解析Webhook域名: %s", host)
+	}
+	for _, addr := range addrs {
+		if !webhookIPAllowed(addr.IP) {
+			return "", ErrWebhookAddress
+		}
+	}
+	return parsed.String(), nil
+}
+
+// webhookIPAllowed 是否允许向该地址推送
+func webhookIPAllowed(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
+
+// newWebhookClient 推送使用的 HTTP 客户端：不跟随重定向、不使用环境变量中的代理，
+// 并在建立连接时再次检查目标地址（防止保存后域名改为解析到内网地址）
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: webhookTimeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !webhookIPAllowed(ip) {
+				return ErrWebhookAddress
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+			MaxIdleConnsPerHost: 2,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// webhookSubscribed 订阅是否包含该事件
+func webhookSubscribed(webhook *models.Webhook, event string) bool {
+	if strings.TrimSpace(webhook.Events) == "" {
+		return true
+	}
+	for _, e := range strings.Split(webhook.Events, ",") {
+		if strings.TrimSpace(e) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// EnqueueWebhookEvent 为用户订阅了该事件的 Webhook 写入待推送记录
+// 应在产生事件的业务事务中调用，事务回滚时事件一并丢弃
+func EnqueueWebhookEvent(tx *gorm.DB, userID uint, event string, data interface{}) error {
+	var webhooks []models.Webhook
+	if err := tx.Where("user_id = ? AND is_active = ?", userID, true).Find(&webhooks).Error; err != nil {
+		return err
+	}
+	return enqueueWebhooks(tx, webhooks, userID, event, data)
+}
+
+func enqueueWebhooks(tx *gorm.DB, webhooks []models.Webhook, userID uint, event string, data interface{}) error {
+	var body []byte
+	now := time.Now()
+	for i := range webhooks {
+		if !webhookSubscribed(&webhooks[i], event) {
+			continue
+		}
+		if body == nil {
+			var err error
+			body, err = json.Marshal(WebhookPayload{Event: event, CreatedAt: now, Data: data})
+			if err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&models.WebhookDelivery{
+			WebhookID:     webhooks[i].ID,
+			UserID:        userID,
+			Event:         event,
+			Payload:       string(body),
+			Status:        DeliveryStatusPending,
+			NextAttemptAt: now,
+			CreatedAt:     now,
+			UpdatedAt:     now,
+		}).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EnqueueTaskEvent 写入任务事件
+func EnqueueTaskEvent(tx *gorm.DB, event string, task *models.Task) error {
+	return EnqueueWebhookEvent(tx, task.UserID, event, webhookTaskData(task))
+}
+
+// EmitTasksCreated 任务创建并扣费后调用（与创建在同一事务中）
+// 写入 task.created 事件，本次扣费使余额跌破订阅阈值时写入 balance.low
+func EmitTasksCreated(tx *gorm.DB, userID uint, tasks ...models.Task) {
+	var webhooks []models.Webhook
+	if err := tx.Where("user_id = ? AND is_active = ?", userID, true).Find(&webhooks).Error; err != nil || len(webhooks) == 0 {
+		return
+	}
+
+	consumed := 0
+	for i := range tasks {
+		consumed += tasks[i].ConsumeJingdou
+		if err := enqueueWebhooks(tx, webhooks, userID, EventTaskCreated, webhookTaskData(&tasks[i])); err != nil {
+			log.Printf("写入Webhook事件失败 (task_id=%d): %v", tasks[i].ID, err)
+		}
+	}
+	if consumed <= 0 {
+		return
+	}
+
+	var balance int
+	if err := tx.Model(&models.User{}).Where("id = ?", userID).Select("jingdou_balance").Scan(&balance).Error; err != nil {
+		return
+	}
+	before := balance + consumed
+	for i := range webhooks {
+		threshold := webhooks[i].LowBalanceThreshold
+		if threshold <= 0 || balance >= threshold || before < threshold {
+			continue
+		}
+		if err := enqueueWebhooks(tx, webhooks[i:i+1], userID, EventBalanceLow, map[string]interface{}{
+			"user_id":   userID,
+			"balance":   balance,
+			"threshold": threshold,
+		}); err != nil {
+			log.Printf("写入Webhook事件失败 (user_id=%d): %v", userID, err)
+		}
+	}
+}
+
+// webhookTaskData 任务事件的数据
+func webhookTaskData(task *models.Task) map[string]interface{} {
+	return map[string]interface{}{
+		"task_id":         task.ID,
+		"task_type":       task.TaskType,
+		"sku":             task.SKU,
+		"status":          task.Status,
+		"execute_count":   task.ExecuteCount,
+		"executed_count":  task.ExecutedCount,
+		"consume_jingdou": task.ConsumeJingdou,
+		"start_time":      task.StartTime,
+		"end_time":        task.EndTime,
+		"chain_id":        task.ChainID,
+		"schedule_id":     task.ScheduleID,
+	}
+}
+
+// WebhookBackoff 第 attempts 次失败后的重试等待时间
+func WebhookBackoff(attempts int) time.Duration {
+	backoff := webhookBaseBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= webhookMaxBackoff {
+			return webhookMaxBackoff
+		}
+	}
+	return backoff
+}
+
+// WebhookService Webhook 推送服务
+// 定时从发件箱取出到期的待推送记录，由多个工作协程并发投递
+type WebhookService struct {
+	db       *gorm.DB
+	client   *http.Client
+	workers  int
+	interval time.Duration
+	stopChan chan struct{}
+}
+
+// NewWebhookService 创建 Webhook 推送服务
+func NewWebhookService(db *gorm.DB) *WebhookService {
+	return &WebhookService{
+		db:       db,
+		client:   newWebhookClient(),
+		workers:  webhookWorkers,
+		interval: 5 * time.Second,
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start 启动 Webhook 推送服务
+func (s *WebhookService) Start() {
+	log.Printf("✓ Webhook推送服务已启动（每%v检查一次）", s.interval)
+	go s.run()
+}
+
+// Stop 停止 Webhook 推送服务
+func (s *WebhookService) Stop() {
+	close(s.stopChan)
+	log.Println("Webhook推送服务已停止")
+}
+
+// run 运行推送循环
+func (s *WebhookService) run() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.DeliverDue()
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// DeliverDue 投递所有到期的待推送记录，返回本轮处理的数量
+func (s *WebhookService) DeliverDue() int {
+	var deliveries []models.WebhookDelivery
+	if err := s.db.Where("status = ? AND next_attempt_at <= ?", DeliveryStatusPending, time.Now()).
+		Order("next_attempt_at ASC").Limit(webhookBatchSize).Find(&deliveries).Error; err != nil {
+		log.Printf("查询待推送Webhook失败: %v", err)
+		return 0
+	}
+
+	queue := make(chan *models.WebhookDelivery)
+	var wg sync.WaitGroup
+	for w := 0; w < min(s.workers, len(deliveries)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for delivery := range queue {
+				s.Deliver(delivery)
+			}
+		}()
+	}
+	for i := range deliveries {
+		queue <- &deliveries[i]
+	}
+	close(queue)
+	wg.Wait()
+	return len(deliveries)
+}
+
+// Deliver 投递一条推送记录并记录结果，失败时按退避策略安排重试
+func (s *WebhookService) Deliver(delivery *models.WebhookDelivery) {
+	// 按尝试次数抢占，避免同一记录被重复投递
+	claim := s.db.Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND attempts = ?", delivery.ID, DeliveryStatusPending, delivery.Attempts).
+		Updates(map[string]interface{}{
+			"attempts":   delivery.Attempts + 1,
+			"updated_at": time.Now(),
+		})
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return
+	}
+	delivery.Attempts++
+
+	var webhook models.Webhook
+	if err := s.db.First(&webhook, delivery.WebhookID).Error; err != nil {
+		s.finish(delivery, 0, "Webhook已删除", 0, true)
+		return
+	}
+	if !webhook.IsActive {
+		s.finish(delivery, 0, "Webhook已停用", 0, true)
+		return
+	}
+
+	start := time.Now()
+	statusCode, err := s.post(&webhook, delivery)
+	duration := time.Since(start).Milliseconds()
+
+	errText := ""
+	switch {
+	case errors.Is(err, ErrWebhookAddress):
+		errText = ErrWebhookAddress.Error()
+	case err != nil:
+		errText = err.Error()
+	case statusCode >= 300 && statusCode < 400:
+		errText = fmt.Sprintf("接收方返回重定向 %d，推送不跟随重定向", statusCode)
+	case statusCode < 200 || statusCode >= 300:
+		errText = fmt.Sprintf("接收方返回状态码 %d", statusCode)
+	}
+	s.finish(delivery, statusCode, errText, duration, false)
+}
+
+// post 发送签名后的推送请求，只返回状态码，响应内容不保存
+func (s *WebhookService) post(webhook *models.Webhook, delivery *models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req, err := http.NewRequest(http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "jd-task-platform-webhook/1.0")
+	req.Header.Set(WebhookHeaderEvent, delivery.Event)
+	req.Header.Set(WebhookHeaderDelivery, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(WebhookHeaderTimestamp, timestamp)
+	req.Header.Set(WebhookHeaderSignature, SignWebhookPayload(webhook.Secret, timestamp, body))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, webhookDrainSize))
+	return resp.StatusCode, nil
+}
+
+// finish 记录本次尝试并更新推送状态
+// giveUp 为 true 时（Webhook已删除或停用）不再重试
+func (s *WebhookService) finish(delivery *models.WebhookDelivery, statusCode int, errText string, duration int64, giveUp bool) {
+	now := time.Now()
+	if err := s.db.Create(&models.WebhookDeliveryLog{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts,
+		StatusCode: statusCode,
+		Error:      errText,
+		DurationMs: duration,
+		CreatedAt:  now,
+	}).Error; err != nil {
+		log.Printf("记录Webhook推送日志失败 (delivery_id=%d): %v", delivery.ID, err)
+	}
+
+	updates := map[string]interface{}{
+		"last_status_code": statusCode,
+		"last_error":       errText,
+		"updated_at":       now,
+	}
+	switch {
+	case errText == "":
+		updates["status"] = DeliveryStatusSuccess
+		updates["delivered_at"] = now
+	case giveUp || delivery.Attempts >= webhookMaxAttempts:
+		updates["status"] = DeliveryStatusFailed
+	default:
+		updates["next_attempt_at"] = now.Add(WebhookBackoff(delivery.Attempts))
+	}
+	if err := s.db.Model(&models.WebhookDelivery{}).Where("id = ?", delivery.ID).Updates(updates).Error; err != nil {
+		log.Printf("更新Webhook推送状态失败 (delivery_id=%d): %v", delivery.ID, err)
+	}
+}
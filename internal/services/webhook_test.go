@@ -0,0 +1,300 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+
+	"jd-task-platform-go/internal/models"
+)
+
+const testWebhookSecret = "whsec_test"
+
+// webhookReceiver 本地接收方，按 status 依次返回状态码（用完后重复最后一个）并记录收到的请求
+type webhookReceiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	status   []int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newWebhookReceiver(t *testing.T, status ...int) *webhookReceiver {
+	r := &webhookReceiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		code := r.status[min(len(r.requests), len(r.status)-1)]
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		r.mu.Unlock()
+		if code >= 300 && code < 400 {
+			w.Header().Set("Location", "http://169.254.169.254/latest/meta-data/")
+		}
+		w.WriteHeader(code)
+		w.Write([]byte("receiver secret response"))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// newWebhookFixture 创建推送服务和一条待推送记录，推送服务使用不限制本机地址的客户端以便投递到本地接收方
+func newWebhookFixture(t *testing.T, url string) (*WebhookService, *gorm.DB, *models.WebhookDelivery) {
+	db := newTestDB(t, &models.Webhook{}, &models.WebhookDelivery{}, &models.WebhookDeliveryLog{})
+	webhook := models.Webhook{UserID: 1, URL: url, Secret: testWebhookSecret, IsActive: true}
+	if err := db.Create(&webhook).Error; err != nil {
+		t.Fatalf("创建Webhook失败: %v", err)
+	}
+	if err := EnqueueWebhookEvent(db, 1, EventTaskCompleted, map[string]interface{}{"task_id": 7}); err != nil {
+		t.Fatalf("写入推送记录失败: %v", err)
+	}
+	var delivery models.WebhookDelivery
+	db.First(&delivery)
+
+	s := NewWebhookService(db)
+	s.client = &http.Client{
+		Timeout:       time.Second,
+		CheckRedirect: newWebhookClient().CheckRedirect,
+	}
+	return s, db, &delivery
+}
+
+func reloadDelivery(t *testing.T, db *gorm.DB, id uint) models.WebhookDelivery {
+	var delivery models.WebhookDelivery
+	if err := db.First(&delivery, id).Error; err != nil {
+		t.Fatalf("查询推送记录失败: %v", err)
+	}
+	return delivery
+}
+
+func TestWebhookDeliverySignature(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	s, db, delivery := newWebhookFixture(t, receiver.URL)
+
+	if n := s.DeliverDue(); n != 1 {
+		t.Fatalf("DeliverDue = %d, 期望 1", n)
+	}
+	if len(receiver.requests) != 1 {
+		t.Fatalf("接收方收到 %d 个请求", len(receiver.requests))
+	}
+
+	req, body := receiver.requests[0], receiver.bodies[0]
+	timestamp := req.Header.Get(WebhookHeaderTimestamp)
+	if got, want := req.Header.Get(WebhookHeaderSignature), SignWebhookPayload(testWebhookSecret, timestamp, body); got != want {
+		t.Errorf("签名 = %s, 期望 %s", got, want)
+	}
+	if SignWebhookPayload("other", timestamp, body) == req.Header.Get(WebhookHeaderSignature) {
+		t.Error("不同密钥的签名不应相同")
+	}
+	if req.Header.Get(WebhookHeaderEvent) != EventTaskCompleted || string(body) != delivery.Payload {
+		t.Errorf("事件 = %s, 请求体 = %s", req.Header.Get(WebhookHeaderEvent), body)
+	}
+
+	saved := reloadDelivery(t, db, delivery.ID)
+	if saved.Status != DeliveryStatusSuccess || saved.Attempts != 1 || saved.DeliveredAt == nil {
+		t.Errorf("推送记录 %+v", saved)
+	}
+}
+
+func TestWebhookRetryBackoff(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusInternalServerError, http.StatusBadGateway, http.StatusNoContent)
+	s, db, delivery := newWebhookFixture(t, receiver.URL)
+
+	for attempt, wantBackoff := range []time.Duration{30 * time.Second, time.Minute} {
+		before := time.Now()
+		s.Deliver(delivery)
+		*delivery = reloadDelivery(t, db, delivery.ID)
+
+		if delivery.Status != DeliveryStatusPending || delivery.Attempts != attempt+1 {
+			t.Fatalf("第%d次失败后 status=%s attempts=%d", attempt+1, delivery.Status, delivery.Attempts)
+		}
+		if wait := delivery.NextAttemptAt.Sub(before); wait < wantBackoff-time.Second || wait > wantBackoff+time.Second {
+			t.Errorf("第%d次失败后等待 %v, 期望 %v", attempt+1, wait, wantBackoff)
+		}
+		// 未到重试时间不投递
+		if n := s.DeliverDue(); n != 0 {
+			t.Fatalf("未到重试时间 DeliverDue = %d", n)
+		}
+		db.Model(delivery).Update("next_attempt_at", time.Now().Add(-time.Second))
+	}
+
+	if n := s.DeliverDue(); n != 1 {
+		t.Fatalf("DeliverDue = %d, 期望 1", n)
+	}
+	saved := reloadDelivery(t, db, delivery.ID)
+	if saved.Status != DeliveryStatusSuccess || saved.Attempts != 3 {
+		t.Errorf("第3次成功后 status=%s attempts=%d", saved.Status, saved.Attempts)
+	}
+
+	var logs []models.WebhookDeliveryLog
+	db.Where("delivery_id = ?", delivery.ID).Order("attempt ASC").Find(&logs)
+	if len(logs) != 3 || logs[0].StatusCode != 500 || logs[1].StatusCode != 502 || logs[2].StatusCode != 204 || logs[2].Error != "" {
+		t.Errorf("推送日志 %+v", logs)
+	}
+}
+
+func TestWebhookDeadLetter(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusServiceUnavailable)
+	s, db, delivery := newWebhookFixture(t, receiver.URL)
+
+	for i := 0; i < webhookMaxAttempts; i++ {
+		db.Model(delivery).Update("next_attempt_at", time.Now().Add(-time.Second))
+		if n := s.DeliverDue(); n != 1 {
+			t.Fatalf("第%d次 DeliverDue = %d", i+1, n)
+		}
+	}
+
+	saved := reloadDelivery(t, db, delivery.ID)
+	if saved.Status != DeliveryStatusFailed || saved.Attempts != webhookMaxAttempts || saved.LastStatusCode != 503 {
+		t.Errorf("重试用尽后 %+v", saved)
+	}
+	db.Model(delivery).Update("next_attempt_at", time.Now().Add(-time.Second))
+	if n := s.DeliverDue(); n != 0 || len(receiver.requests) != webhookMaxAttempts {
+		t.Errorf("失败的记录不应再投递: DeliverDue=%d requests=%d", n, len(receiver.requests))
+	}
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{7, 32 * time.Minute},
+		{20, webhookMaxBackoff},
+	}
+	for _, tt := range tests {
+		if got := WebhookBackoff(tt.attempts); got != tt.want {
+			t.Errorf("WebhookBackoff(%d) = %v, 期望 %v", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+func TestWebhookRedirectNotFollowed(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusFound)
+	s, db, delivery := newWebhookFixture(t, receiver.URL)
+
+	s.Deliver(delivery)
+
+	saved := reloadDelivery(t, db, delivery.ID)
+	if saved.Status != DeliveryStatusPending || saved.LastStatusCode != http.StatusFound || !strings.Contains(saved.LastError, "重定向") {
+		t.Errorf("重定向应记为失败: %+v", saved)
+	}
+	if len(receiver.requests) != 1 {
+		t.Errorf("接收方收到 %d 个请求", len(receiver.requests))
+	}
+}
+
+func TestWebhookDoesNotStoreResponseBody(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusBadRequest)
+	s, db, delivery := newWebhookFixture(t, receiver.URL)
+
+	s.Deliver(delivery)
+
+	var log models.WebhookDeliveryLog
+	db.Where("delivery_id = ?", delivery.ID).First(&log)
+	saved := reloadDelivery(t, db, delivery.ID)
+	if strings.Contains(log.Error, "receiver secret") || strings.Contains(saved.LastError, "receiver secret") {
+		t.Errorf("不应保存接收方的响应内容: %q %q", log.Error, saved.LastError)
+	}
+}
+
+func TestWebhookClientRejectsPrivateAddress(t *testing.T) {
+	receiver := newWebhookReceiver(t, http.StatusOK)
+	s, db, delivery := newWebhookFixture(t, receiver.URL)
+	s.client = newWebhookClient()
+
+	s.Deliver(delivery)
+
+	saved := reloadDelivery(t, db, delivery.ID)
+	if len(receiver.requests) != 0 || saved.LastError != ErrWebhookAddress.Error() {
+		t.Errorf("不应连接本机地址: requests=%d error=%q", len(receiver.requests), saved.LastError)
+	}
+}
+
+func TestWebhookConcurrentDelivery(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		<-release
+	}))
+	defer slow.Close()
+
+	s, db, _ := newWebhookFixture(t, slow.URL)
+	for i := 0; i < 3; i++ {
+		EnqueueWebhookEvent(db, 1, EventTaskCompleted, map[string]interface{}{"task_id": i})
+	}
+
+	done := make(chan int)
+	go func() { done <- s.DeliverDue() }()
+	deadline := time.After(5 * time.Second)
+	for peak.Load() < 4 {
+		select {
+		case <-deadline:
+			close(release)
+			t.Fatalf("并发投递数 = %d, 期望 4", peak.Load())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	close(release)
+	if n := <-done; n != 4 {
+		t.Errorf("DeliverDue = %d, 期望 4", n)
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	tests := []struct {
+		url     string
+		wantErr error
+	}{
+		{"https://8.8.8.8/hook", nil},
+		{"http://1.1.1.1:8080/hook?a=1", nil},
+		{"http://127.0.0.1/hook", ErrWebhookAddress},
+		{"http://localhost:5001/hook", ErrWebhookAddress},
+		{"http://10.0.0.5/hook", ErrWebhookAddress},
+		{"http://192.168.1.1/hook", ErrWebhookAddress},
+		{"http://172.16.0.1/hook", ErrWebhookAddress},
+		{"http://169.254.169.254/latest/meta-data/", ErrWebhookAddress},
+		{"http://0.0.0.0/hook", ErrWebhookAddress},
+		{"http://[::1]/hook", ErrWebhookAddress},
+		{"http://[fe80::1]/hook", ErrWebhookAddress},
+		{"http://[fd00::1]/hook", ErrWebhookAddress},
+		{"http://224.0.0.1/hook", ErrWebhookAddress},
+		{"ftp://8.8.8.8/hook", errAny},
+		{"https:///hook", errAny},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			_, err := ValidateWebhookURL(context.Background(), tt.url)
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("err = %v, 期望通过", err)
+			case tt.wantErr == errAny && err == nil:
+				t.Error("期望返回错误")
+			case tt.wantErr != nil && tt.wantErr != errAny && !errors.Is(err, tt.wantErr):
+				t.Errorf("err = %v, 期望 %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+var errAny = errors.New("any error")
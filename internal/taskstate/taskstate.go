@@ -0,0 +1,358 @@
+// Package taskstate 定义任务状态机：合法的状态转换及其副作用（退款、任务日志、状态历史、通知）
+// 所有任务状态变更都应通过本包执行，不要直接写 tasks.status
+package taskstate
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"jd-task-platform-go/internal/models"
+)
+
+// 任务状态
+const (
+	Waiting          = "waiting"
+	Running          = "running"
+	Paused           = "paused"
+	Completed        = "completed"
+	PartialCompleted = "partial_completed"
+	Failed           = "failed"
+	Cancelled        = "cancelled"
+)
+
+// 状态变更的操作来源
+const (
+	ActorSystem = "system"
+	ActorDevice = "device"
+	ActorUser   = "user"
+	ActorAdmin  = "admin"
+)
+
+// Rule 状态转换规则
+type Rule struct {
+	From   string
+	To     string
+	Refund bool // 按未执行次数退还京豆
+	Notify bool // 通知等待中的设备有新任务可领取
+}
+
+// rules 合法的状态转换表，未列出的转换一律拒绝
+// completed、partial_completed、failed、cancelled 为终态
+var rules = []Rule{
+	// 设备领取任务 / 设备反馈后未完成回到等待
+	{From: Waiting, To: Running},
+	{From: Running, To: Waiting},
+
+	// 设备反馈达到执行次数（执行期间被暂停的任务也可能在反馈时完成）
+	{From: Waiting, To: Completed},
+	{From: Running, To: Completed},
+	{From: Paused, To: Completed},
+
+	// 暂停与恢复
+	{From: Waiting, To: Paused},
+	{From: Running, To: Paused},
+	{From: Paused, To: Waiting, Notify: true},
+
+	// 取消
+	{From: Waiting, To: Cancelled, Refund: true},
+	{From: Running, To: Cancelled, Refund: true},
+	{From: Paused, To: Cancelled, Refund: true},
+
+	// 过期
+	{From: Waiting, To: PartialCompleted, Refund: true},
+	{From: Running, To: PartialCompleted, Refund: true},
+	{From: Paused, To: PartialCompleted, Refund: true},
+
+	// 管理员标记失败
+	{From: Waiting, To: Failed, Refund: true},
+	{From: Running, To: Failed, Refund: true},
+	{From: Paused, To: Failed, Refund: true},
+}
+
+var statusText = map[string]string{
+	Waiting:          "待开始",
+	Running:          "执行中",
+	Paused:           "已暂停",
+	Completed:        "已完成",
+	PartialCompleted: "部分完成",
+	Failed:           "失败",
+	Cancelled:        "已取消",
+}
+
+// Text 状态的中文名称
+func Text(status string) string {
+	if text, ok := statusText[status]; ok {
+		return text
+	}
+	return status
+}
+
+// Valid 是否为已定义的任务状态
+func Valid(status string) bool {
+	_, ok := statusText[status]
+	return ok
+}
+
+// IsTerminal 是否为终态（不能再变更状态）
+func IsTerminal(status string) bool {
+	switch status {
+	case Completed, PartialCompleted, Failed, Cancelled:
+		return true
+	}
+	return false
+}
+
+// Find 查找状态转换规则
+func Find(from, to string) (Rule, bool) {
+	for _, rule := range rules {
+		if rule.From == from && rule.To == to {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Rules 合法的状态转换表（副本）
+func Rules() []Rule {
+	return append([]Rule(nil), rules...)
+}
+
+// CanTransition 是否允许从 from 变更为 to
+func CanTransition(from, to string) bool {
+	_, ok := Find(from, to)
+	return ok
+}
+
+// TransitionError 非法的状态转换
+type TransitionError struct {
+	From string
+	To   string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("任务状态不能从「%s」变更为「%s」", Text(e.From), Text(e.To))
+}
+
+// ErrConflict 任务状态已被其他操作修改
+var ErrConflict = errors.New("任务状态已变化，请刷新后重试")
+
+// Change 一次状态变更
+type Change struct {
+	To           string
+	Actor        string                 // 操作来源：system、device、user、admin
+	ActorID      *uint                  // 操作用户ID（系统和设备操作为空）
+	Reason       string                 // 写入任务日志和状态历史的说明
+	RefundRemark string                 // 退款京豆日志备注，为空时按目标状态生成
+	Updates      map[string]interface{} // 与状态一起更新的其他字段
+}
+
+// Result 状态变更结果
+type Result struct {
+	TaskID  uint
+	ChainID *uint
+	From    string
+	To      string
+	Refund  int // 退还的京豆，无退款时为0
+	Balance int // 退款后用户余额，仅退款类转换有值
+	Notify  bool
+	Task    models.Task // 变更后的任务
+}
+
+// Apply 在事务 tx 中执行状态转换及其副作用：更新任务状态、退款、任务日志和状态历史
+// 按原状态条件更新，原状态已被并发修改时返回 ErrConflict
+// 通知类副作用需在事务提交后调用 Committed 触发
+func Apply(tx *gorm.DB, task *models.Task, change Change) (*Result, error) {
+	rule, ok := Find(task.Status, change.To)
+	if !ok {
+		return nil, &TransitionError{From: task.Status, To: change.To}
+	}
+
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":     change.To,
+		"updated_at": now,
+	}
+	switch {
+	case change.To == Paused:
+		updates["paused_at"] = now
+		updates["paused_by"] = change.Actor
+	case rule.From == Paused:
+		updates["paused_at"] = nil
+		updates["paused_by"] = ""
+	}
+	for column, value := range change.Updates {
+		updates[column] = value
+	}
+
+	// 退款按未执行次数计算，设备反馈会在状态不变的情况下累加 executed_count，
+	// 因此先锁定并重新读取任务，避免按过期的已执行次数多退
+	if rule.Refund {
+		var current models.Task
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "status", "execute_count", "executed_count", "consume_jingdou").
+			First(&current, task.ID).Error; err != nil {
+			return nil, err
+		}
+		if current.Status != rule.From {
+			return nil, ErrConflict
+		}
+		task.ExecuteCount = current.ExecuteCount
+		task.ExecutedCount = current.ExecutedCount
+		task.ConsumeJingdou = current.ConsumeJingdou
+	}
+
+	res := tx.Model(&models.Task{}).Where("id = ? AND status = ?", task.ID, rule.From).Updates(updates)
+	if res.Error != nil {
+		return nil, res.Error
+	}
+	if res.RowsAffected == 0 {
+		return nil, ErrConflict
+	}
+
+	result := &Result{
+		TaskID:  task.ID,
+		ChainID: task.ChainID,
+		From:    rule.From,
+		To:      rule.To,
+		Notify:  rule.Notify,
+	}
+
+	if rule.Refund {
+		if err := refund(tx, task, change, result); err != nil {
+			return nil, err
+		}
+	}
+
+	message := change.Reason
+	if message == "" {
+		message = fmt.Sprintf("任务状态变更：%s → %s", Text(rule.From), Text(rule.To))
+	}
+	if err := tx.Create(&models.TaskLog{
+		TaskID:    task.ID,
+		Status:    rule.To,
+		Message:   message,
+		CreatedAt: now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.Create(&models.TaskStatusHistory{
+		TaskID:     task.ID,
+		FromStatus: rule.From,
+		ToStatus:   rule.To,
+		Actor:      change.Actor,
+		ActorID:    change.ActorID,
+		Reason:     change.Reason,
+		Refund:     result.Refund,
+		CreatedAt:  now,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	task.Status = rule.To
+	task.UpdatedAt = now
+	result.Task = *task
+	for _, fn := range appliedHooks {
+		if err := fn(tx, task, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// Transition 在独立事务中执行状态转换，提交后触发通知
+func Transition(db *gorm.DB, task *models.Task, change Change) (*Result, error) {
+	var result *Result
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		result, err = Apply(tx, task, change)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	Committed(db, result)
+	return result, nil
+}
+
+// refund 按未执行次数比例退还京豆：退款 = 消耗京豆 * 未完成次数 / 总次数
+func refund(tx *gorm.DB, task *models.Task, change Change, result *Result) error {
+	amount := RefundAmount(task)
+	if amount > 0 {
+		if err := tx.Model(&models.User{}).Where("id = ?", task.UserID).
+			Update("jingdou_balance", gorm.Expr("jingdou_balance + ?", amount)).Error; err != nil {
+			return err
+		}
+	}
+	var user models.User
+	if err := tx.Select("id", "jingdou_balance").First(&user, task.UserID).Error; err != nil {
+		return err
+	}
+	result.Balance = user.JingdouBalance
+	if amount <= 0 {
+		return nil
+	}
+
+	remark := change.RefundRemark
+	if remark == "" {
+		remark = fmt.Sprintf("任务%s退款 - SKU:%s (完成%d/%d)", Text(change.To), task.SKU, task.ExecutedCount, task.ExecuteCount)
+	}
+	if err := tx.Create(&models.JingdouLog{
+		UserID:        user.ID,
+		Amount:        amount,
+		Balance:       user.JingdouBalance,
+		OperationType: "refund",
+		RelatedID:     &task.ID,
+		Remark:        remark,
+		CreatedAt:     time.Now(),
+	}).Error; err != nil {
+		return err
+	}
+
+	result.Refund = amount
+	return nil
+}
+
+// RefundAmount 任务终止时应退还的京豆
+func RefundAmount(task *models.Task) int {
+	if task.ConsumeJingdou <= 0 || task.ExecuteCount <= 0 {
+		return 0
+	}
+	remaining := task.ExecuteCount - task.ExecutedCount
+	if remaining <= 0 {
+		return 0
+	}
+	return task.ConsumeJingdou * remaining / task.ExecuteCount
+}
+
+// appliedHooks 状态变更写入后、事务提交前的回调（写入发件箱等需要与状态变更同时提交的操作）
+var appliedHooks []func(tx *gorm.DB, task *models.Task, result *Result) error
+
+// OnApplied 注册状态变更写入后（同一事务内）的回调，回调返回错误时整个状态变更回滚
+func OnApplied(fn func(tx *gorm.DB, task *models.Task, result *Result) error) {
+	appliedHooks = append(appliedHooks, fn)
+}
+
+// committedHooks 状态变更提交后的回调（唤醒设备、同步任务链进度等）
+// 由 services 注册，避免本包依赖 services
+var committedHooks []func(db *gorm.DB, result *Result)
+
+// OnCommitted 注册状态变更提交后的回调
+func OnCommitted(fn func(db *gorm.DB, result *Result)) {
+	committedHooks = append(committedHooks, fn)
+}
+
+// Committed 在包含 Apply 的事务提交后调用，触发已注册的回调
+func Committed(db *gorm.DB, results ...*Result) {
+	for _, result := range results {
+		if result == nil {
+			continue
+		}
+		for _, fn := range committedHooks {
+			fn(db, result)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package taskstate
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"jd-task-platform-go/internal/models"
+)
+
+var allStatuses = []string{Waiting, Running, Paused, Completed, PartialCompleted, Failed, Cancelled}
+
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	if err := db.AutoMigrate(&models.User{}, &models.Task{}, &models.TaskLog{},
+		&models.TaskStatusHistory{}, &models.JingdouLog{}); err != nil {
+		t.Fatalf("创建测试表失败: %v", err)
+	}
+	return db
+}
+
+// seedTask 创建余额为 balance 的用户和一个处于 status 的任务（消耗100京豆，10次中已执行3次）
+func seedTask(t *testing.T, db *gorm.DB, status string, balance int) *models.Task {
+	t.Helper()
+	user := models.User{Username: "u", PasswordHash: "x", ApiKey: "k", JingdouBalance: balance, CreatedAt: time.Now()}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+	task := models.Task{
+		UserID:         user.ID,
+		TaskType:       "browse",
+		SKU:            "100001",
+		StartTime:      time.Now(),
+		ExecuteCount:   10,
+		ExecutedCount:  3,
+		Status:         status,
+		ConsumeJingdou: 100,
+		CreatedAt:      time.Now(),
+		UpdatedAt:      time.Now(),
+	}
+	if err := db.Create(&task).Error; err != nil {
+		t.Fatalf("创建任务失败: %v", err)
+	}
+	return &task
+}
+
+// withHooks 替换已注册的回调，测试结束后恢复
+func withHooks(t *testing.T) {
+	applied, committed := appliedHooks, committedHooks
+	appliedHooks, committedHooks = nil, nil
+	t.Cleanup(func() { appliedHooks, committedHooks = applied, committed })
+}
+
+func TestFind(t *testing.T) {
+	type want struct{ refund, notify bool }
+	allowed := map[[2]string]want{
+		{Waiting, Running}:          {},
+		{Running, Waiting}:          {},
+		{Waiting, Completed}:        {},
+		{Running, Completed}:        {},
+		{Paused, Completed}:         {},
+		{Waiting, Paused}:           {},
+		{Running, Paused}:           {},
+		{Paused, Waiting}:           {notify: true},
+		{Waiting, Cancelled}:        {refund: true},
+		{Running, Cancelled}:        {refund: true},
+		{Paused, Cancelled}:         {refund: true},
+		{Waiting, PartialCompleted}: {refund: true},
+		{Running, PartialCompleted}: {refund: true},
+		{Paused, PartialCompleted}:  {refund: true},
+		{Waiting, Failed}:           {refund: true},
+		{Running, Failed}:           {refund: true},
+		{Paused, Failed}:            {refund: true},
+	}
+	if len(rules) != len(allowed) {
+		t.Errorf("状态转换规则数 = %d, 期望 %d", len(rules), len(allowed))
+	}
+
+	for _, from := range allStatuses {
+		for _, to := range allStatuses {
+			t.Run(from+"->"+to, func(t *testing.T) {
+				expected, ok := allowed[[2]string{from, to}]
+				rule, found := Find(from, to)
+				if found != ok || CanTransition(from, to) != ok {
+					t.Fatalf("Find(%s, %s) found = %v, 期望 %v", from, to, found, ok)
+				}
+				if !ok {
+					return
+				}
+				if rule.Refund != expected.refund || rule.Notify != expected.notify {
+					t.Errorf("规则 %+v, 期望 refund=%v notify=%v", rule, expected.refund, expected.notify)
+				}
+				if IsTerminal(from) {
+					t.Errorf("终态 %s 不应有转换规则", from)
+				}
+			})
+		}
+	}
+}
+
+func TestRulesReturnsCopy(t *testing.T) {
+	copied := Rules()
+	copied[0].To = Cancelled
+	if rules[0].To == Cancelled {
+		t.Fatal("修改 Rules() 的返回值不应影响状态转换表")
+	}
+}
+
+func TestApply(t *testing.T) {
+	for _, rule := range rules {
+		t.Run(rule.From+"->"+rule.To, func(t *testing.T) {
+			db := newTestDB(t)
+			task := seedTask(t, db, rule.From, 50)
+
+			var result *Result
+			err := db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				result, err = Apply(tx, task, Change{To: rule.To, Actor: ActorAdmin, Reason: "测试"})
+				return err
+			})
+			if err != nil {
+				t.Fatalf("Apply 失败: %v", err)
+			}
+
+			var saved models.Task
+			db.First(&saved, task.ID)
+			if saved.Status != rule.To || task.Status != rule.To || result.Task.Status != rule.To {
+				t.Errorf("状态 = %s, 期望 %s", saved.Status, rule.To)
+			}
+			if result.From != rule.From || result.To != rule.To || result.Notify != rule.Notify {
+				t.Errorf("结果 %+v 与规则 %+v 不一致", result, rule)
+			}
+			if rule.To == Paused && (saved.PausedAt == nil || saved.PausedBy != ActorAdmin) {
+				t.Errorf("暂停时应记录暂停时间和来源: %v %q", saved.PausedAt, saved.PausedBy)
+			}
+			if rule.From == Paused && (saved.PausedAt != nil || saved.PausedBy != "") {
+				t.Errorf("离开暂停状态时应清除暂停信息: %v %q", saved.PausedAt, saved.PausedBy)
+			}
+
+			wantRefund, wantBalance := 0, 0
+			if rule.Refund {
+				wantRefund, wantBalance = 70, 120
+			}
+			if result.Refund != wantRefund || result.Balance != wantBalance {
+				t.Errorf("退款 = %d 余额 = %d, 期望 %d %d", result.Refund, result.Balance, wantRefund, wantBalance)
+			}
+			var user models.User
+			db.First(&user, task.UserID)
+			if user.JingdouBalance != 50+wantRefund {
+				t.Errorf("用户余额 = %d, 期望 %d", user.JingdouBalance, 50+wantRefund)
+			}
+			var refundLogs int64
+			db.Model(&models.JingdouLog{}).Where("related_id = ? AND operation_type = ? AND amount = ?", task.ID, "refund", wantRefund).Count(&refundLogs)
+			if rule.Refund != (refundLogs == 1) {
+				t.Errorf("退款日志数 = %d", refundLogs)
+			}
+
+			var history models.TaskStatusHistory
+			if err := db.Where("task_id = ?", task.ID).First(&history).Error; err != nil {
+				t.Fatalf("缺少状态历史: %v", err)
+			}
+			if history.FromStatus != rule.From || history.ToStatus != rule.To || history.Actor != ActorAdmin || history.Refund != wantRefund {
+				t.Errorf("状态历史 %+v", history)
+			}
+			var logs int64
+			db.Model(&models.TaskLog{}).Where("task_id = ? AND status = ? AND message = ?", task.ID, rule.To, "测试").Count(&logs)
+			if logs != 1 {
+				t.Errorf("任务日志数 = %d, 期望 1", logs)
+			}
+		})
+	}
+}
+
+func TestApplyRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		stored  string // 数据库中的状态
+		current string // 调用方持有的状态
+		to      string
+		wantErr func(error) bool
+	}{
+		{"终态不能变更", Completed, Completed, Waiting, isTransitionError},
+		{"已取消不能再取消", Cancelled, Cancelled, Cancelled, isTransitionError},
+		{"部分完成不能失败", PartialCompleted, PartialCompleted, Failed, isTransitionError},
+		{"暂停不能直接执行", Paused, Paused, Running, isTransitionError},
+		{"未定义的状态", Waiting, Waiting, "unknown", isTransitionError},
+		{"状态已被并发修改", Cancelled, Waiting, Running, func(err error) bool { return errors.Is(err, ErrConflict) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newTestDB(t)
+			task := seedTask(t, db, tt.stored, 50)
+			task.Status = tt.current
+
+			_, err := Transition(db, task, Change{To: tt.to, Actor: ActorUser})
+			if err == nil || !tt.wantErr(err) {
+				t.Fatalf("错误 = %v", err)
+			}
+
+			var saved models.Task
+			db.First(&saved, task.ID)
+			var user models.User
+			db.First(&user, task.UserID)
+			var history int64
+			db.Model(&models.TaskStatusHistory{}).Count(&history)
+			if saved.Status != tt.stored || user.JingdouBalance != 50 || history != 0 {
+				t.Errorf("被拒绝的转换不应有副作用: status=%s balance=%d history=%d", saved.Status, user.JingdouBalance, history)
+			}
+		})
+	}
+}
+
+// 读取任务后设备反馈又执行了几次（状态不变），退款应按数据库中最新的已执行次数计算
+func TestApplyRefundUsesCurrentExecutedCount(t *testing.T) {
+	db := newTestDB(t)
+	task := seedTask(t, db, Running, 50)
+	db.Model(&models.Task{}).Where("id = ?", task.ID).UpdateColumn("executed_count", 8)
+
+	result, err := Transition(db, task, Change{To: Cancelled, Actor: ActorUser})
+	if err != nil {
+		t.Fatalf("Transition 失败: %v", err)
+	}
+	// 未执行 2 次：100 * 2 / 10 = 20，而不是按过期的 3 次计算的 70
+	var user models.User
+	db.First(&user, task.UserID)
+	if result.Refund != 20 || user.JingdouBalance != 70 || task.ExecutedCount != 8 {
+		t.Errorf("refund=%d balance=%d executed=%d", result.Refund, user.JingdouBalance, task.ExecutedCount)
+	}
+}
+
+func isTransitionError(err error) bool {
+	var transitionErr *TransitionError
+	return errors.As(err, &transitionErr)
+}
+
+func TestRefundAmount(t *testing.T) {
+	tests := []struct {
+		consume, total, executed int
+		want                     int
+	}{
+		{100, 10, 0, 100},
+		{100, 10, 3, 70},
+		{100, 10, 10, 0},
+		{100, 10, 12, 0}, // 超额执行
+		{10, 3, 1, 6},    // 向下取整
+		{0, 10, 0, 0},    // 管理员任务不消耗京豆
+		{100, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d/%d", tt.consume, tt.total, tt.executed), func(t *testing.T) {
+			task := &models.Task{ConsumeJingdou: tt.consume, ExecuteCount: tt.total, ExecutedCount: tt.executed}
+			if got := RefundAmount(task); got != tt.want {
+				t.Errorf("RefundAmount = %d, 期望 %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHookOrder(t *testing.T) {
+	withHooks(t)
+	db := newTestDB(t)
+	task := seedTask(t, db, Waiting, 0)
+
+	var calls []string
+	OnApplied(func(tx *gorm.DB, task *models.Task, result *Result) error {
+		// 事务内可以看到已写入的状态
+		var status string
+		tx.Model(&models.Task{}).Where("id = ?", task.ID).Select("status").Scan(&status)
+		calls = append(calls, "applied1:"+status)
+		return nil
+	})
+	OnApplied(func(tx *gorm.DB, task *models.Task, result *Result) error {
+		calls = append(calls, "applied2")
+		return nil
+	})
+	OnCommitted(func(db *gorm.DB, result *Result) {
+		calls = append(calls, "committed1:"+result.To)
+	})
+	OnCommitted(func(db *gorm.DB, result *Result) {
+		calls = append(calls, "committed2")
+	})
+
+	if _, err := Transition(db, task, Change{To: Cancelled, Actor: ActorUser}); err != nil {
+		t.Fatalf("Transition 失败: %v", err)
+	}
+	want := []string{"applied1:cancelled", "applied2", "committed1:cancelled", "committed2"}
+	if fmt.Sprint(calls) != fmt.Sprint(want) {
+		t.Errorf("回调顺序 = %v, 期望 %v", calls, want)
+	}
+}
+
+func TestAppliedHookErrorRollsBack(t *testing.T) {
+	withHooks(t)
+	db := newTestDB(t)
+	task := seedTask(t, db, Running, 0)
+
+	committed := false
+	hookErr := errors.New("写入发件箱失败")
+	OnApplied(func(tx *gorm.DB, task *models.Task, result *Result) error { return hookErr })
+	OnCommitted(func(db *gorm.DB, result *Result) { committed = true })
+
+	if _, err := Transition(db, task, Change{To: Cancelled, Actor: ActorUser}); !errors.Is(err, hookErr) {
+		t.Fatalf("错误 = %v, 期望 %v", err, hookErr)
+	}
+	if committed {
+		t.Error("回滚后不应触发提交回调")
+	}
+
+	var saved models.Task
+	db.First(&saved, task.ID)
+	var user models.User
+	db.First(&user, task.UserID)
+	var logs int64
+	db.Model(&models.JingdouLog{}).Count(&logs)
+	if saved.Status != Running || user.JingdouBalance != 0 || logs != 0 {
+		t.Errorf("回调失败后应整体回滚: status=%s balance=%d logs=%d", saved.Status, user.JingdouBalance, logs)
+	}
+}
@@ -16,6 +16,7 @@ import (
 	"jd-task-platform-go/internal/middleware"
 	"jd-task-platform-go/internal/models"
 	"jd-task-platform-go/internal/services"
+	"jd-task-platform-go/pkg/utils"
 )
 
 // @title JD任务平台 API
@@ -43,6 +44,11 @@ import (
 // @description API Key for authentication
 
 func main() {
+	// 代理凭据加密密钥
+	if err := utils.LoadCredentialKeys(); err != nil {
+		log.Fatal("代理凭据加密密钥配置错误:", err)
+	}
+
 	// 数据库连接配置
 	dsn := "jduser:jdpass123@tcp(localhost:3306)/jd?charset=utf8mb4&parseTime=True&loc=Asia%2FShanghai&collation=utf8mb4_unicode_ci"
 
@@ -62,11 +68,24 @@ func main() {
 
 	log.Println("✓ 数据库连接成功")
 
+	// 建立代理 IP+端口唯一索引前合并已有的重复代理
+	services.MergeDuplicateProxies(db)
+
 	// 自动迁移所有表
 	db.AutoMigrate(
 		&models.User{},
 		&models.Task{},
+		&models.TaskSchedule{},
+		&models.TaskChain{},
+		&models.TaskStatusHistory{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+		&models.WebhookDeliveryLog{},
+		&models.TaskImport{},
+		&models.TaskImportRow{},
+		&models.Job{},
 		&models.Device{},
+		&models.DeviceTelemetry{},
 		&models.JingdouLog{},
 		&models.Setting{},
 		&models.APILog{},
@@ -74,9 +93,20 @@ func main() {
 		&models.TaskType{},
 		&models.Proxy{},
 		&models.ProxyUsageLog{},
+		&models.ProxyLease{},
+		&models.ProxySupplier{},
+		&models.ProxyConfigAccessLog{},
+		&models.ProxySubscription{},
+		&models.ProxyGroup{},
 	)
 	log.Println("✓ 数据库表迁移完成")
 
+	// 代理凭据加密
+	services.SecureProxyCredentials(db)
+
+	// 为升级前创建的任务补充截止时间
+	services.BackfillTaskEndTime(db)
+
 	// 测试查询
 	var count int64
 	db.Model(&models.User{}).Count(&count)
@@ -156,17 +186,33 @@ func main() {
 			tasks.GET("", taskHandler.GetTasks)
 			tasks.POST("", taskHandler.CreateTask)
 			tasks.GET("/stats", taskHandler.GetTaskStats)
+			tasks.GET("/export", taskHandler.ExportTasks)
 			tasks.GET("/statistics", taskHandler.GetTaskStatistics)
+			tasks.POST("/chains", taskHandler.CreateTaskChain)
+			tasks.GET("/chains/:id", taskHandler.GetTaskChain)
+			tasks.POST("/chains/:id/cancel", taskHandler.CancelTaskChain)
+			tasks.POST("/import", taskHandler.ImportTasks)
+			tasks.GET("/imports", taskHandler.GetTaskImports)
+			tasks.GET("/imports/:id", taskHandler.GetTaskImport)
+			tasks.POST("/imports/:id/commit", taskHandler.CommitTaskImport)
+			tasks.GET("/imports/:id/result", taskHandler.DownloadTaskImportResult)
 			tasks.GET("/:id", taskHandler.GetTaskByID)
 			tasks.PUT("/:id", taskHandler.UpdateTask)
 			tasks.DELETE("/:id", taskHandler.DeleteTask)
 			tasks.POST("/:id/cancel", taskHandler.CancelTask)
+			tasks.POST("/:id/pause", taskHandler.PauseTask)
+			tasks.POST("/:id/resume", taskHandler.ResumeTask)
+			tasks.GET("/:id/history", taskHandler.GetTaskStatusHistory)
+			tasks.POST("/users/:user_id/pause", middleware.AdminMiddleware(), taskHandler.PauseUserTasks)
+			tasks.POST("/users/:user_id/resume", middleware.AdminMiddleware(), taskHandler.ResumeUserTasks)
 			tasks.PUT("/:id/priority", middleware.AdminMiddleware(), taskHandler.UpdateTaskPriority)
 
 			// 任务类型管理
 			tasks.GET("/types", taskHandler.GetTaskTypes)
 			tasks.POST("/types", middleware.AdminMiddleware(), taskHandler.CreateTaskType)
 			tasks.PUT("/types/:id", middleware.AdminMiddleware(), taskHandler.UpdateTaskType)
+			tasks.POST("/types/:id/pause", middleware.AdminMiddleware(), taskHandler.PauseTaskType)
+			tasks.POST("/types/:id/resume", middleware.AdminMiddleware(), taskHandler.ResumeTaskType)
 		}
 
 		// 任务API Key路由
@@ -190,8 +236,10 @@ func main() {
 			deviceHandler := handlers.NewDeviceHandler(db)
 			devices.GET("", deviceHandler.GetDevices)
 			devices.GET("/statistics", middleware.AdminMiddleware(), deviceHandler.GetDeviceStatistics)
+			devices.PUT("/group", middleware.AdminMiddleware(), deviceHandler.UpdateDeviceGroup)
 			devices.GET("/:id", deviceHandler.GetDeviceByID)
 			devices.PUT("/:id/status", deviceHandler.UpdateDeviceStatus)
+			devices.POST("/:id/control", middleware.AdminMiddleware(), deviceHandler.SendDeviceControl)
 			devices.POST("/clear-all", middleware.AdminMiddleware(), deviceHandler.ClearAllDevices)
 		}
 
@@ -202,6 +250,8 @@ func main() {
 			deviceHandler := handlers.NewDeviceHandler(db)
 			devicesApiKey.POST("/request-task", deviceHandler.RequestTask)
 			devicesApiKey.POST("/task-feedback", deviceHandler.TaskFeedback)
+			devicesApiKey.POST("/stream", deviceHandler.StreamTask)   // 长轮询任务推送
+			devicesApiKey.POST("/heartbeat", deviceHandler.Heartbeat) // 心跳与遥测上报
 			devicesApiKey.GET("/apikey", deviceHandler.GetDevices)
 		}
 
@@ -288,6 +338,53 @@ func main() {
 			adminDashboard.POST("/trigger-cleanup", adminDashboardHandler.TriggerDataCleanup)
 		}
 
+		// 后台作业路由 (仅管理员)
+		jobs := api.Group("/admin/jobs")
+		jobs.Use(middleware.AuthMiddleware(), middleware.AdminMiddleware())
+		{
+			jobHandler := handlers.NewJobHandler(db)
+			jobs.GET("", jobHandler.GetJobs)
+			jobs.POST("", jobHandler.SubmitJob)
+			jobs.GET("/:id", jobHandler.GetJob)
+			jobs.POST("/:id/cancel", jobHandler.CancelJob)
+		}
+
+		// 周期任务路由 (需要认证)
+		schedules := api.Group("/schedules")
+		schedules.Use(middleware.AuthMiddleware())
+		{
+			scheduleHandler := handlers.NewTaskScheduleHandler(db)
+			schedules.GET("", scheduleHandler.GetSchedules)
+			schedules.POST("", scheduleHandler.CreateSchedule)
+			schedules.GET("/:id", scheduleHandler.GetSchedule)
+			schedules.PUT("/:id", scheduleHandler.UpdateSchedule)
+			schedules.DELETE("/:id", scheduleHandler.DeleteSchedule)
+			schedules.POST("/:id/pause", scheduleHandler.PauseSchedule)
+			schedules.POST("/:id/resume", scheduleHandler.ResumeSchedule)
+		}
+
+		// Webhook 订阅（JWT认证）
+		webhooks := api.Group("/webhooks")
+		webhooks.Use(middleware.AuthMiddleware())
+		{
+			webhookHandler := handlers.NewWebhookHandler(db)
+			webhooks.GET("", webhookHandler.GetWebhooks)
+			webhooks.POST("", webhookHandler.CreateWebhook)
+			webhooks.PUT("/:id", webhookHandler.UpdateWebhook)
+			webhooks.DELETE("/:id", webhookHandler.DeleteWebhook)
+			webhooks.POST("/:id/secret", webhookHandler.RotateWebhookSecret)
+			webhooks.GET("/:id/deliveries", webhookHandler.GetWebhookDeliveries)
+			webhooks.POST("/deliveries/:id/redeliver", webhookHandler.RedeliverWebhook)
+		}
+
+		// 实时事件流（SSE，JWT认证，支持 token 查询参数）
+		events := api.Group("/events")
+		events.Use(middleware.QueryTokenMiddleware(), middleware.AuthMiddleware())
+		{
+			eventHandler := handlers.NewEventHandler(db)
+			events.GET("", eventHandler.Stream)
+		}
+
 		// 用户首页路由 (普通用户)
 		userHome := api.Group("/user/home")
 		userHome.Use(middleware.AuthMiddleware())
@@ -308,7 +405,12 @@ func main() {
 			userTaskHandler := handlers.NewUserTaskManageHandler(db)
 			userTasks.GET("", userTaskHandler.GetUserTasks)
 			userTasks.GET("/status-options", userTaskHandler.GetTaskStatusOptions)
+			userTasks.GET("/export", userTaskHandler.ExportUserTasks)
 			userTasks.POST("/:id/cancel", userTaskHandler.CancelUserTask)
+			userTasks.POST("/:id/pause", userTaskHandler.PauseUserTask)
+			userTasks.POST("/:id/resume", userTaskHandler.ResumeUserTask)
+			userTasks.POST("/pause-all", userTaskHandler.PauseAllUserTasks)
+			userTasks.POST("/resume-all", userTaskHandler.ResumeAllUserTasks)
 			userTasks.PUT("/:id", userTaskHandler.UpdateUserTask)
 		}
 
@@ -320,19 +422,40 @@ func main() {
 			proxies.GET("", proxyHandler.GetProxies)
 			proxies.GET("/statistics", proxyHandler.GetProxyStatistics)
 			proxies.GET("/usage-logs", proxyHandler.GetProxyUsageLogs)
+			proxies.GET("/analytics", proxyHandler.GetProxyAnalytics)
+			proxies.GET("/config-access-logs", proxyHandler.GetProxyConfigAccessLogs)
+			proxies.POST("/credentials/reencrypt", proxyHandler.ReencryptProxyCredentials)
+			proxies.GET("/subscriptions", proxyHandler.GetProxySubscriptions)
+			proxies.POST("/subscriptions", proxyHandler.CreateProxySubscription)
+			proxies.PUT("/subscriptions/:id", proxyHandler.UpdateProxySubscription)
+			proxies.DELETE("/subscriptions/:id", proxyHandler.DeleteProxySubscription)
+			proxies.POST("/subscriptions/:id/reset-token", proxyHandler.ResetProxySubscriptionToken)
+			proxies.GET("/suppliers", proxyHandler.GetProxySuppliers)
+			proxies.POST("/suppliers", proxyHandler.CreateProxySupplier)
+			proxies.PUT("/suppliers/:id", proxyHandler.UpdateProxySupplier)
+			proxies.DELETE("/suppliers/:id", proxyHandler.DeleteProxySupplier)
+			proxies.POST("/suppliers/:id/replenish", proxyHandler.ReplenishFromSupplier)
 			proxies.POST("", proxyHandler.CreateProxy)
 			proxies.POST("/batch-import", proxyHandler.BatchImportProxies)
 			proxies.POST("/batch-delete", proxyHandler.BatchDeleteProxies)
+			proxies.POST("/batch-update", proxyHandler.BatchUpdateProxies)
+			proxies.GET("/export", proxyHandler.ExportProxies)
+			proxies.GET("/groups", proxyHandler.GetProxyGroups)
+			proxies.POST("/groups", proxyHandler.CreateProxyGroup)
+			proxies.PUT("/groups/:id", proxyHandler.UpdateProxyGroup)
+			proxies.DELETE("/groups/:id", proxyHandler.DeleteProxyGroup)
 			proxies.GET("/:id", proxyHandler.GetProxyByID)
+			proxies.POST("/:id/check", proxyHandler.CheckProxy)
+			proxies.POST("/:id/config-links", proxyHandler.GetProxyConfigLinks)
 			proxies.PUT("/:id", proxyHandler.UpdateProxy)
 			proxies.DELETE("/:id", proxyHandler.DeleteProxy)
 		}
 
-		// Clash 配置公开接口（无需认证，供 Clash Mi 扫码访问）
+		// 代理配置下载接口（无需登录，凭签名链接访问，供 Clash Mi / v2rayN 扫码导入）
 		proxyHandler := handlers.NewProxyHandler(db)
-		api.GET("/proxies/:id/clash-config", proxyHandler.GetClashConfig)
-		// v2rayN 配置公开接口（无需认证，供 v2rayN 扫码访问）
-		api.GET("/proxies/:id/v2ray-config", proxyHandler.GetV2rayConfig)
+		api.GET("/proxy-config/:token", proxyHandler.GetProxyConfig)
+		// 代理订阅接口（无需登录，凭订阅令牌访问，返回整个代理池或设备分组的客户端配置）
+		api.GET("/proxy-subscription/:token", proxyHandler.GetProxySubscription)
 
 		// 代理分配路由 (设备密钥认证)
 		proxyApiKey := api.Group("/proxy")
@@ -340,6 +463,8 @@ func main() {
 		{
 			proxyHandler := handlers.NewProxyHandler(db)
 			proxyApiKey.POST("/assign", proxyHandler.AssignProxy)
+			proxyApiKey.POST("/renew", proxyHandler.RenewProxyLease)
+			proxyApiKey.POST("/release", proxyHandler.ReleaseProxyLease)
 		}
 
 		// =========================================
@@ -354,12 +479,35 @@ func main() {
 			// 任务管理接口
 			openapi.POST("/tasks", openapiHandler.CreateTask)             // 创建单个任务
 			openapi.POST("/tasks/batch", openapiHandler.BatchCreateTasks) // 批量创建任务
+			openapi.POST("/tasks/chains", openapiHandler.CreateTaskChain) // 创建任务链
+			openapi.GET("/tasks/chains/:id", openapiHandler.GetTaskChain) // 查询任务链详情
 			openapi.GET("/tasks", openapiHandler.GetTasks)                // 查询任务列表
+			openapi.GET("/tasks/export", openapiHandler.ExportTasks)      // 导出任务
 			openapi.GET("/tasks/:id", openapiHandler.GetTaskByID)         // 查询任务详情
 			openapi.PUT("/tasks/:id", openapiHandler.UpdateTask)          // 修改任务
 			openapi.POST("/tasks/:id/cancel", openapiHandler.CancelTask)  // 取消任务
 			openapi.GET("/task-types", openapiHandler.GetTaskTypes)       // 获取任务类型
 
+			// 周期任务接口
+			scheduleHandler := handlers.NewTaskScheduleHandler(db)
+			openapi.GET("/schedules", scheduleHandler.GetSchedules)               // 查询周期任务列表
+			openapi.POST("/schedules", scheduleHandler.CreateSchedule)            // 创建周期任务
+			openapi.GET("/schedules/:id", scheduleHandler.GetSchedule)            // 查询周期任务详情
+			openapi.PUT("/schedules/:id", scheduleHandler.UpdateSchedule)         // 修改周期任务
+			openapi.DELETE("/schedules/:id", scheduleHandler.DeleteSchedule)      // 删除周期任务
+			openapi.POST("/schedules/:id/pause", scheduleHandler.PauseSchedule)   // 暂停周期任务
+			openapi.POST("/schedules/:id/resume", scheduleHandler.ResumeSchedule) // 恢复周期任务
+
+			// Webhook 订阅
+			webhookHandler := handlers.NewWebhookHandler(db)
+			openapi.GET("/webhooks", webhookHandler.GetWebhooks)                                // 查询Webhook列表
+			openapi.POST("/webhooks", webhookHandler.CreateWebhook)                             // 创建Webhook
+			openapi.PUT("/webhooks/:id", webhookHandler.UpdateWebhook)                          // 修改Webhook
+			openapi.DELETE("/webhooks/:id", webhookHandler.DeleteWebhook)                       // 删除Webhook
+			openapi.POST("/webhooks/:id/secret", webhookHandler.RotateWebhookSecret)            // 重新生成签名密钥
+			openapi.GET("/webhooks/:id/deliveries", webhookHandler.GetWebhookDeliveries)        // 查询推送记录
+			openapi.POST("/webhooks/deliveries/:id/redeliver", webhookHandler.RedeliverWebhook) // 重新推送
+
 			// 京豆相关接口
 			openapi.GET("/balance", openapiHandler.GetBalance)                // 查询余额
 			openapi.GET("/jingdou/records", openapiHandler.GetJingdouRecords) // 查询京豆明细
@@ -374,6 +522,30 @@ func main() {
 	dataCleanupService := services.NewDataCleanupService(db, 60, 0)
 	dataCleanupService.Start()
 
+	// 启动周期任务调度服务
+	taskSchedulerService := services.NewTaskSchedulerService(db)
+	taskSchedulerService.Start()
+
+	// 启动Webhook推送服务
+	webhookService := services.NewWebhookService(db)
+	webhookService.Start()
+
+	// 启动后台作业服务（最多同时执行2个作业）
+	jobService := services.NewJobService(db, 2)
+	jobService.Start()
+
+	// 启动代理健康检查服务（连续失败自动停用，恢复后自动启用）
+	proxyHealthService := services.NewProxyHealthService(db)
+	proxyHealthService.Start()
+
+	// 启动代理租约回收服务（每分钟释放到期租约）
+	proxyLeaseService := services.NewProxyLeaseService(db)
+	proxyLeaseService.Start()
+
+	// 启动代理池补充服务（停用到期代理，按目标数量从供应商补充）
+	proxyReplenishService := services.NewProxyReplenishService(db)
+	proxyReplenishService.Start()
+
 	// 启动设备状态监控服务（3分钟无活动设为离线）
 	deviceStatusService := services.NewDeviceStatusService(db)
 	go deviceStatusService.Start()
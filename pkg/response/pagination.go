@@ -0,0 +1,165 @@
+package response
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// 每页数量默认值和常用上限
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+	NoPageSizeLimit = 0 // 不限制每页数量，兼容原来没有上限的接口
+)
+
+// ErrInvalidCursor 分页游标无法解析
+var ErrInvalidCursor = errors.New("分页游标无效")
+
+// Pagination 分页参数
+// 请求带 cursor 参数（首页传空值）时使用游标分页：按（时间, ID）定位，不统计总数，翻页期间数据变化不会导致重复或遗漏；
+// 否则使用页码分页，兼容原有的 page 参数
+type Pagination struct {
+	Page     int
+	PageSize int
+	Cursor   bool  // 是否游标分页
+	Asc      bool  // 升序排列，默认降序
+	Total    int64 // 页码分页时的总数
+
+	after   *cursorKey
+	next    string
+	hasMore bool
+}
+
+// cursorKey 游标位置：上一页最后一条记录的排序时间和ID
+type cursorKey struct {
+	At time.Time
+	ID uint
+}
+
+// ParsePagination 解析分页参数，sizeParam 为每页数量的参数名（page_size 或 per_page）
+// maxSize 为每页数量上限，超过时按上限返回；NoPageSizeLimit 表示不限制
+func ParsePagination(c *gin.Context, sizeParam string, maxSize int) (*Pagination, error) {
+	p := ParsePage(c, sizeParam, maxSize)
+
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		p.Cursor, p.Page = true, 1
+		if cursor != "" {
+			key, err := decodeCursor(cursor)
+			if err != nil {
+				return nil, err
+			}
+			p.after = key
+		}
+	}
+	return p, nil
+}
+
+// ParsePage 只按页码分页，用于不按时间排序、无法使用游标的列表，请求中的 cursor 参数被忽略
+func ParsePage(c *gin.Context, sizeParam string, maxSize int) *Pagination {
+	p := &Pagination{Page: 1, PageSize: DefaultPageSize}
+	if size, err := strconv.Atoi(c.Query(sizeParam)); err == nil && size > 0 {
+		p.PageSize = size
+	}
+	if maxSize > 0 && p.PageSize > maxSize {
+		p.PageSize = maxSize
+	}
+	if page, err := strconv.Atoi(c.Query("page")); err == nil && page > 0 {
+		p.Page = page
+	}
+	return p
+}
+
+// Offset 页码分页时当前页的偏移量
+func (p *Pagination) Offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// Count 页码分页时统计总数，游标分页不统计
+func (p *Pagination) Count(query *gorm.DB) {
+	if !p.Cursor {
+		query.Count(&p.Total)
+	}
+}
+
+// Find 按 column 和 id 排序查询一页数据，key 返回记录的排序时间和ID，用于生成下一页游标
+func Find[T any](p *Pagination, query *gorm.DB, column string, dest *[]T, key func(*T) (time.Time, uint)) error {
+	direction, compare := "DESC", "<"
+	if p.Asc {
+		direction, compare = "ASC", ">"
+	}
+	query = query.Order(column + " " + direction).Order("id " + direction)
+
+	if !p.Cursor {
+		return query.Offset(p.Offset()).Limit(p.PageSize).Find(dest).Error
+	}
+
+	if p.after != nil {
+		query = query.Where(fmt.Sprintf("(%s %s ? OR (%s = ? AND id %s ?))", column, compare, column, compare),
+			p.after.At, p.after.At, p.after.ID)
+	}
+	// 多查一条判断是否还有下一页
+	if err := query.Limit(p.PageSize + 1).Find(dest).Error; err != nil {
+		return err
+	}
+
+	p.hasMore = len(*dest) > p.PageSize
+	if p.hasMore {
+		*dest = (*dest)[:p.PageSize]
+	}
+	p.next = ""
+	if p.hasMore {
+		at, id := key(&(*dest)[len(*dest)-1])
+		p.next = encodeCursor(cursorKey{At: at, ID: id})
+	}
+	return nil
+}
+
+// Meta 分页信息：页码分页返回 page、total、pages，游标分页返回 next_cursor、has_more
+// sizeKey 为响应中每页数量的字段名，与原接口保持一致
+func (p *Pagination) Meta(sizeKey string) gin.H {
+	if p.Cursor {
+		return gin.H{
+			sizeKey:       p.PageSize,
+			"next_cursor": p.next,
+			"has_more":    p.hasMore,
+		}
+	}
+	return gin.H{
+		"page":  p.Page,
+		sizeKey: p.PageSize,
+		"total": p.Total,
+		"pages": (p.Total + int64(p.PageSize) - 1) / int64(p.PageSize),
+	}
+}
+
+func encodeCursor(key cursorKey) string {
+	raw := strconv.FormatInt(key.At.UnixNano(), 10) + ":" + strconv.FormatUint(uint64(key.ID), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(cursor string) (*cursorKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	at, id, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	parsedID, err := strconv.ParseUint(id, 10, 32)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursorKey{At: time.Unix(0, nanos), ID: uint(parsedID)}, nil
+}
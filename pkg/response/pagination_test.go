@@ -0,0 +1,51 @@
+package response
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newQueryContext(query string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/?"+query, nil)
+	return c
+}
+
+func TestParsePaginationPageSize(t *testing.T) {
+	tests := []struct {
+		query    string
+		maxSize  int
+		wantPage int
+		wantSize int
+	}{
+		{"", MaxPageSize, 1, DefaultPageSize},
+		{"page=3&page_size=50", MaxPageSize, 3, 50},
+		{"page_size=500", MaxPageSize, 1, MaxPageSize},
+		{"page_size=500", NoPageSizeLimit, 1, 500},
+		{"page=0&page_size=-1", NoPageSizeLimit, 1, DefaultPageSize},
+		{"page=3&page_size=30&cursor=", MaxPageSize, 1, 30},
+	}
+	for _, tt := range tests {
+		p, err := ParsePagination(newQueryContext(tt.query), "page_size", tt.maxSize)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if p.Page != tt.wantPage || p.PageSize != tt.wantSize {
+			t.Errorf("%q max=%d: page=%d size=%d, 期望 %d %d", tt.query, tt.maxSize, p.Page, p.PageSize, tt.wantPage, tt.wantSize)
+		}
+	}
+}
+
+func TestParsePaginationCursor(t *testing.T) {
+	if _, err := ParsePagination(newQueryContext("cursor=bad!"), "page_size", MaxPageSize); err != ErrInvalidCursor {
+		t.Errorf("err = %v, 期望 ErrInvalidCursor", err)
+	}
+
+	// ParsePage 忽略游标
+	p := ParsePage(newQueryContext("page=2&cursor=bad!"), "per_page", MaxPageSize)
+	if p.Cursor || p.Page != 2 || p.Offset() != 20 {
+		t.Errorf("ParsePage = %+v", p)
+	}
+}
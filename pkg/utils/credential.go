@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+// CredentialKeysEnv 凭据加密密钥的环境变量，格式为 "密钥ID:密钥,密钥ID:密钥"，第一个为当前密钥，密钥ID不能包含 "."。
+// 轮换密钥时把新密钥加在最前面并重启，旧数据重新加密完成后再删除旧密钥
+const CredentialKeysEnv = "PROXY_CREDENTIAL_KEYS"
+
+// 加密后的凭据格式：enc:密钥ID:base64(nonce+密文)
+const credentialPrefix = "enc:"
+
+var (
+	ErrCredentialKeyNotFound = errors.New("凭据加密密钥不存在")
+	ErrCredentialMalformed   = errors.New("凭据密文格式错误")
+)
+
+type credentialKey struct {
+	id      string
+	aead    cipher.AEAD
+	signKey []byte
+}
+
+var (
+	credentialMu      sync.RWMutex
+	credentialKeys    []*credentialKey
+	credentialLoaded  bool
+	ephemeralSignOnce sync.Once
+	ephemeralSignKey  *credentialKey
+)
+
+// SetCredentialKeys 设置凭据加密密钥，spec 格式同 CredentialKeysEnv，为空时不加密
+func SetCredentialKeys(spec string) error {
+	var keys []*credentialKey
+	seen := map[string]bool{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, secret, ok := strings.Cut(item, ":")
+		if !ok || id == "" || secret == "" || strings.Contains(id, ".") {
+			return fmt.Errorf("%s 格式错误，应为 密钥ID:密钥,密钥ID:密钥", CredentialKeysEnv)
+		}
+		if seen[id] {
+			return fmt.Errorf("%s 中密钥ID重复: %s", CredentialKeysEnv, id)
+		}
+		seen[id] = true
+
+		key, err := newCredentialKey(id, secret)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key)
+	}
+
+	credentialMu.Lock()
+	credentialKeys = keys
+	credentialLoaded = true
+	credentialMu.Unlock()
+	return nil
+}
+
+// LoadCredentialKeys 从环境变量读取凭据加密密钥
+func LoadCredentialKeys() error {
+	return SetCredentialKeys(os.Getenv(CredentialKeysEnv))
+}
+
+// newCredentialKey 由密钥派生加密密钥和签名密钥，两者互不相同
+func newCredentialKey(id, secret string) (*credentialKey, error) {
+	encKey := sha256.Sum256([]byte("proxy-credential:" + secret))
+	block, err := aes.NewCipher(encKey[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	signKey := sha256.Sum256([]byte("proxy-config-link:" + secret))
+	return &credentialKey{id: id, aead: aead, signKey: signKey[:]}, nil
+}
+
+func loadedCredentialKeys() []*credentialKey {
+	credentialMu.RLock()
+	loaded := credentialLoaded
+	keys := credentialKeys
+	credentialMu.RUnlock()
+	if loaded {
+		return keys
+	}
+
+	if err := LoadCredentialKeys(); err != nil {
+		log.Printf("读取凭据加密密钥失败: %v", err)
+	}
+	credentialMu.RLock()
+	defer credentialMu.RUnlock()
+	return credentialKeys
+}
+
+func findCredentialKey(id string) *credentialKey {
+	for _, key := range loadedCredentialKeys() {
+		if key.id == id {
+			return key
+		}
+	}
+	return nil
+}
+
+// CredentialEncryptionEnabled 是否配置了凭据加密密钥
+func CredentialEncryptionEnabled() bool {
+	return len(loadedCredentialKeys()) > 0
+}
+
+// CurrentCredentialKeyID 当前加密密钥的ID，未配置时为空
+func CurrentCredentialKeyID() string {
+	keys := loadedCredentialKeys()
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0].id
+}
+
+// EncryptCredential 用当前密钥加密凭据，空值和未配置密钥时原样返回
+func EncryptCredential(plain string) (string, error) {
+	keys := loadedCredentialKeys()
+	if plain == "" || len(keys) == 0 {
+		return plain, nil
+	}
+	key := keys[0]
+
+	nonce := make([]byte, key.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(plain), []byte(key.id))
+	return credentialPrefix + key.id + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// DecryptCredential 解密凭据，未加密的旧数据原样返回
+func DecryptCredential(value string) (string, error) {
+	if !strings.HasPrefix(value, credentialPrefix) {
+		return value, nil
+	}
+	id, data, ok := strings.Cut(strings.TrimPrefix(value, credentialPrefix), ":")
+	if !ok {
+		return "", ErrCredentialMalformed
+	}
+	key := findCredentialKey(id)
+	if key == nil {
+		return "", fmt.Errorf("%w: %s", ErrCredentialKeyNotFound, id)
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil || len(sealed) < key.aead.NonceSize() {
+		return "", ErrCredentialMalformed
+	}
+	nonce, ciphertext := sealed[:key.aead.NonceSize()], sealed[key.aead.NonceSize():]
+	plain, err := key.aead.Open(nil, nonce, ciphertext, []byte(id))
+	if err != nil {
+		return "", fmt.Errorf("凭据解密失败 (key=%s): %w", id, err)
+	}
+	return string(plain), nil
+}
+
+// CredentialKeyID 密文使用的密钥ID，未加密时为空
+func CredentialKeyID(value string) string {
+	if !strings.HasPrefix(value, credentialPrefix) {
+		return ""
+	}
+	id, _, _ := strings.Cut(strings.TrimPrefix(value, credentialPrefix), ":")
+	return id
+}
+
+// CurrentSigningKeyID 当前签名密钥的ID。未配置密钥时使用进程内随机密钥，签名在重启后失效
+func CurrentSigningKeyID() string {
+	return currentSignKey().id
+}
+
+// CredentialSignature 用指定密钥派生的签名密钥计算 HMAC-SHA256，密钥不存在（已被移除）时返回 false
+func CredentialSignature(keyID string, payload []byte) ([]byte, bool) {
+	key := findCredentialKey(keyID)
+	if key == nil {
+		if current := currentSignKey(); current.id == keyID {
+			key = current
+		} else {
+			return nil, false
+		}
+	}
+	mac := hmac.New(sha256.New, key.signKey)
+	mac.Write(payload)
+	return mac.Sum(nil), true
+}
+
+func currentSignKey() *credentialKey {
+	if keys := loadedCredentialKeys(); len(keys) > 0 {
+		return keys[0]
+	}
+	ephemeralSignOnce.Do(func() {
+		secret := make([]byte, 32)
+		rand.Read(secret)
+		key, _ := newCredentialKey("tmp", base64.RawStdEncoding.EncodeToString(secret))
+		ephemeralSignKey = key
+	})
+	return ephemeralSignKey
+}
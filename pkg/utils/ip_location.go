@@ -88,30 +88,6 @@ func QueryIPLocationWithFallback(ip string) (*IPLocationInfo, error) {
 	}, nil
 }
 
-// ParseProxyLine 解析代理行格式：IP|Port|Username|Password
-func ParseProxyLine(line string) (ip string, port int, username string, password string, err error) {
-	parts := strings.Split(strings.TrimSpace(line), "|")
-	if len(parts) != 4 {
-		return "", 0, "", "", fmt.Errorf("代理格式错误，应为：IP|Port|Username|Password")
-	}
-
-	ip = strings.TrimSpace(parts[0])
-	username = strings.TrimSpace(parts[2])
-	password = strings.TrimSpace(parts[3])
-
-	// 解析端口
-	var portNum int
-	if _, err := fmt.Sscanf(parts[1], "%d", &portNum); err != nil {
-		return "", 0, "", "", fmt.Errorf("端口格式错误: %v", err)
-	}
-
-	if portNum < 1 || portNum > 65535 {
-		return "", 0, "", "", fmt.Errorf("端口范围错误，应在1-65535之间")
-	}
-
-	return ip, portNum, username, password, nil
-}
-
 // GetLocationByIP 获取IP地理位置(兼容旧代码)
 func GetLocationByIP(ip string) string {
 	location, err := QueryIPLocationWithFallback(ip)
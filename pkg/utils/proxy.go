@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 代理协议
+const (
+	ProxyProtocolSOCKS5 = "socks5"
+	ProxyProtocolHTTP   = "http"
+	ProxyProtocolHTTPS  = "https"
+)
+
+// ProxyProtocols 支持的代理协议
+var ProxyProtocols = []string{ProxyProtocolSOCKS5, ProxyProtocolHTTP, ProxyProtocolHTTPS}
+
+// ProxyLine 解析出的一条代理
+type ProxyLine struct {
+	Protocol string
+	IP       string
+	Port     int
+	Username string
+	Password string
+}
+
+// ValidProxyProtocol 是否为支持的代理协议
+func ValidProxyProtocol(protocol string) bool {
+	for _, p := range ProxyProtocols {
+		if p == protocol {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseProxyLine 解析一行代理，支持以下格式（用户名密码均可省略）：
+//
+//	socks5://用户名:密码@IP:端口（协议可为 socks5、socks5h、socks、http、https，用户名密码需 URL 编码）
+//	用户名:密码@IP:端口
+//	IP|端口|用户名|密码
+//	IP:端口:用户名:密码
+//	IP:端口
+//
+// 不带协议的格式使用 defaultProtocol（可以为空）
+func ParseProxyLine(line, defaultProtocol string) (*ProxyLine, error) {
+	line = strings.TrimSpace(line)
+
+	if strings.Contains(line, "://") {
+		return parseProxyURL(line)
+	}
+	if strings.Contains(line, "@") {
+		proxy, err := parseProxyURL(ProxyProtocolSOCKS5 + "://" + line)
+		if err != nil {
+			return nil, err
+		}
+		proxy.Protocol = defaultProtocol
+		return proxy, nil
+	}
+
+	var parts []string
+	if strings.Contains(line, "|") {
+		parts = strings.Split(line, "|")
+	} else {
+		parts = strings.Split(line, ":")
+	}
+	if len(parts) != 2 && len(parts) != 4 {
+		return nil, fmt.Errorf("代理格式错误，应为：IP|端口|用户名|密码、IP:端口:用户名:密码 或 协议://用户名:密码@IP:端口")
+	}
+
+	proxy := &ProxyLine{Protocol: defaultProtocol, IP: strings.TrimSpace(parts[0])}
+	if len(parts) == 4 {
+		proxy.Username = strings.TrimSpace(parts[2])
+		proxy.Password = strings.TrimSpace(parts[3])
+	}
+	port, err := parseProxyPort(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	proxy.Port = port
+	if proxy.IP == "" {
+		return nil, fmt.Errorf("代理地址不能为空")
+	}
+	return proxy, nil
+}
+
+// FormatProxyLine 把代理输出为 ParseProxyLine 可以解析的一行：协议://用户名:密码@IP:端口，用户名密码按 URL 编码
+func FormatProxyLine(protocol, ip string, port int, username, password string) string {
+	if protocol == "" {
+		protocol = ProxyProtocolSOCKS5
+	}
+	u := url.URL{Scheme: protocol, Host: net.JoinHostPort(ip, strconv.Itoa(port))}
+	if username != "" {
+		u.User = url.UserPassword(username, password)
+	}
+	return u.String()
+}
+
+func parseProxyURL(raw string) (*ProxyLine, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("代理地址格式错误: %v", err)
+	}
+
+	protocol := strings.ToLower(u.Scheme)
+	switch protocol {
+	case "socks", "socks5h":
+		protocol = ProxyProtocolSOCKS5
+	}
+	if !ValidProxyProtocol(protocol) {
+		return nil, fmt.Errorf("不支持的代理协议: %s", u.Scheme)
+	}
+
+	host, portStr, err := net.SplitHostPort(u.Host)
+	if err != nil || host == "" {
+		return nil, fmt.Errorf("代理地址格式错误，应为 协议://用户名:密码@IP:端口")
+	}
+	port, err := parseProxyPort(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := &ProxyLine{Protocol: protocol, IP: host, Port: port}
+	if u.User != nil {
+		proxy.Username = u.User.Username()
+		proxy.Password, _ = u.User.Password()
+	}
+	return proxy, nil
+}
+
+func parseProxyPort(value string) (int, error) {
+	port, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("端口格式错误: %v", err)
+	}
+	if port < 1 || port > 65535 {
+		return 0, fmt.Errorf("端口范围错误，应在1-65535之间")
+	}
+	return port, nil
+}
+
+// ProxyShareURL 生成代理分享链接
+// SOCKS5：socks://base64(用户名:密码@IP:端口)#节点名称，无认证时为 socks://base64(IP:端口)#节点名称
+// HTTP/HTTPS：http(s)://用户名:密码@IP:端口#节点名称
+func ProxyShareURL(protocol, ip string, port int, username, password string) string {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	switch protocol {
+	case ProxyProtocolHTTP, ProxyProtocolHTTPS:
+		u := url.URL{Scheme: protocol, Host: address}
+		if username != "" {
+			u.User = url.UserPassword(username, password)
+		}
+		u.Fragment = strings.ToUpper(protocol) + "-" + ip
+		return u.String()
+	default:
+		authInfo := address
+		if username != "" {
+			authInfo = username + ":" + password + "@" + address
+		}
+		nodeName := fmt.Sprintf("SOCKS5-%s", ip)
+		return fmt.Sprintf("socks://%s#%s", base64.StdEncoding.EncodeToString([]byte(authInfo)), url.QueryEscape(nodeName))
+	}
+}